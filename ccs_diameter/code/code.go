@@ -39,4 +39,13 @@ const (
 	VendorSpecificAppId
 	ABResponse
 	AcctBalanceId
+	AcctBalance
+)
+
+// Result-Code values of the Credit Control Application, RFC 4006 section 9.1
+const (
+	EndUserServiceDenied = 4010
+	CreditLimitReached   = 4012
+	UserUnknown          = 5030
+	RatingFailed         = 5031
 )
@@ -18,7 +18,7 @@ type AccountDebitResponse struct {
 	LowBalanceIndication          LowBalanceIndication           `avp:"Low-Balance-Indication"`
 	EventTimestamp                diam_datatype.Time             `avp:"Event-Timestamp"`
 	RemainingBalance              *RemainingBalance              `avp:"Remaining-Balance"`
-	ABResponse                    *ABResponse                    `avp:"AB-Response"`
+	ABResponse                    []*ABResponse                  `avp:"AB-Response"`
 	ProxyInfo                     diam_datatype.Grouped          `avp:"Proxy-Info"`
 	MultipleServicesCreditControl *MultipleServicesCreditControl `avp:"Multiple-Services-Credit-Control"`
 }
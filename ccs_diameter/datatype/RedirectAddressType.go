@@ -0,0 +1,14 @@
+package datatype
+
+import (
+	diam_datatype "github.com/fiorix/go-diameter/diam/datatype"
+)
+
+const (
+	IPV4_ADDRESS RedirectAddressType = 0
+	IPV6_ADDRESS RedirectAddressType = 1
+	URL          RedirectAddressType = 2
+	SIP_URI      RedirectAddressType = 3
+)
+
+type RedirectAddressType diam_datatype.Enumerated
@@ -0,0 +1,10 @@
+package datatype
+
+import (
+	diam_datatype "github.com/fiorix/go-diameter/diam/datatype"
+)
+
+type RedirectServer struct {
+	RedirectAddressType   RedirectAddressType      `avp:"Redirect-Address-Type"`
+	RedirectServerAddress diam_datatype.UTF8String `avp:"Redirect-Server-Address"`
+}
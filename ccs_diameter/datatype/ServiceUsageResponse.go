@@ -6,6 +6,7 @@ import (
 
 type ServiceUsageResponse struct {
 	SessionId           diam_datatype.UTF8String       `avp:"Session-Id"`
+	ResultCode          diam_datatype.Unsigned32       `avp:"Result-Code"`
 	OriginHost          diam_datatype.DiameterIdentity `avp:"Origin-Host"`
 	OriginRealm         diam_datatype.DiameterIdentity `avp:"Origin-Realm"`
 	VendorSpecificAppId diam_datatype.Grouped          `avp:"Vendor-Specific-Application-Id"`
@@ -24,6 +24,7 @@ const (
 		</request>
 		<answer>
 			<rule avp="Session-Id" required="true" max="1"/>
+			<rule avp="Result-Code" required="true" max="1"/>
 			<rule avp="Origin-Host" required="true" max="1"/>
 			<rule avp="Origin-Realm" required="true" max="1"/>
 			<rule avp="Vendor-Specific-Application-Id" required="false" max="1"/>
@@ -341,7 +342,7 @@ const (
 				<rule avp="Cost-Information" required="false" max="1"/>
 				<rule avp="Low-Balance-Indication" required="false" max="1"/>
 				<rule avp="Remaining-Balance" required="false" max="1"/>
-				<rule avp="AB-Response" required="false" max="1"/>
+				<rule avp="AB-Response" required="false"/>
 				<rule avp="Credit-Control-Failure-Handling" required="false" max="1"/>
 				<rule avp="Direct-Debiting-Failure-Handling" required="false" max="1"/>
 				<rule avp="Validity-Time" required="false" max="1"/>
@@ -781,7 +782,7 @@ const (
 			</data>
 		</avp>
 
-		<avp name="Acct-Balance" code="7028">
+		<avp name="Acct-Balance" code="7030">
 			<data type="Grouped">
 				<rule avp="Acct-Balance-Id" required="true" max="1"/>
 				<rule avp="Unit-Value" required="true" max="1"/>
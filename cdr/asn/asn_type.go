@@ -1,6 +1,7 @@
 package asn
 
 import (
+	"fmt"
 	"reflect"
 )
 
@@ -27,6 +28,34 @@ type ObjectIdentifier []byte
 // An Enumerated is represented as a plain int64.
 type Enumerated int64
 
+// enumeratedIdentifiers are the identifiers of the values of the ENUMERATED types, see RegisterEnumerated
+var enumeratedIdentifiers = make(map[reflect.Type][]string)
+
+// RegisterEnumerated gives the identifiers of the ENUMERATED type of val, a struct with an Enumerated Value, in the
+// order of the values from 0. XER and JER encode the values of the type by their identifiers. The types register in
+// their package init, it is not safe to call concurrently with the encodings.
+func RegisterEnumerated(val interface{}, identifiers ...string) {
+	enumeratedIdentifiers[reflect.TypeOf(val)] = identifiers
+}
+
+// enumeratedIdentifier is the identifier of the value of the ENUMERATED type t
+func enumeratedIdentifier(t reflect.Type, identifiers []string, value int64) (string, error) {
+	if value < 0 || value >= int64(len(identifiers)) {
+		return "", fmt.Errorf("ENUMERATED value %d of %s has no identifier", value, t)
+	}
+	return identifiers[value], nil
+}
+
+// enumeratedValue is the value of the identifier of the ENUMERATED type t
+func enumeratedValue(t reflect.Type, identifiers []string, identifier string) (int64, error) {
+	for value, known := range identifiers {
+		if known == identifier {
+			return int64(value), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown ENUMERATED identifier %q of %s", identifier, t)
+}
+
 // UTF8String
 type UTF8String string
 
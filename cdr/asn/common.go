@@ -50,6 +50,9 @@ type fieldParameters struct {
 	sizeUpperBound      *int64  // a sizeUpperBound is the maximum size of type constraint(maybe nil).
 	valueLowerBound     *int64  // a valueLowerBound is the minimum value of type constraint(maybe nil).
 	valueUpperBound     *int64  // a valueUpperBound is the maximum value of type constraint(maybe nil).
+	sizeExtensible      bool    // true iff the size constraint is extensible.
+	valueExtensible     bool    // true iff the value constraint or the SEQUENCE type is extensible.
+	choiceExtensible    bool    // true iff the CHOICE type is extensible.
 	defaultValue        *int64  // a default value for INTEGER and ENUMERATED typed fields (maybe nil).
 	openType            bool    // true iff this type is opentype.
 	referenceFieldName  string  // the field to get to get the corresrponding value of this type(maybe nil).
@@ -94,6 +97,12 @@ func parseFieldParameters(str string) (params fieldParameters) {
 				params.valueUpperBound = new(int64)
 				*params.valueUpperBound = i
 			}
+		case part == "sizeExt":
+			params.sizeExtensible = true
+		case part == "valueExt":
+			params.valueExtensible = true
+		case part == "choiceExt":
+			params.choiceExtensible = true
 		case strings.HasPrefix(part, "default:"):
 			i, err := strconv.ParseInt(part[8:], 10, 64)
 			if err == nil {
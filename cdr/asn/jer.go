@@ -0,0 +1,257 @@
+package asn
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// jerBitString is the JER encoding of a BIT STRING of variable size
+type jerBitString struct {
+	Value  string `json:"value"`
+	Length uint64 `json:"length"`
+}
+
+// JerMarshal returns the JER (X.697) encoding of val. ENUMERATED values are encoded as their identifier, or as
+// their number if their type has no registered identifiers.
+func JerMarshal(val interface{}) ([]byte, error) {
+	value, err := jerValue(reflect.ValueOf(val))
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(value)
+}
+
+func jerValue(v reflect.Value) (interface{}, error) {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil, fmt.Errorf("jer: cannot marshal nil value")
+		}
+		v = v.Elem()
+	}
+
+	switch v.Type() {
+	case BitStringType:
+		bitString := v.Interface().(BitString)
+		return jerBitString{
+			Value:  strings.ToUpper(hex.EncodeToString(bitString.Bytes)),
+			Length: bitString.BitLength,
+		}, nil
+	case OctetStringType:
+		return strings.ToUpper(hex.EncodeToString(v.Bytes())), nil
+	case ObjectIdentifierType:
+		return nil, fmt.Errorf("jer: unsupported ObjectIdentifier type")
+	case EnumeratedType:
+		return v.Int(), nil
+	case NullType:
+		return nil, nil
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		return v.Bool(), nil
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		return v.Int(), nil
+	case reflect.String:
+		return v.String(), nil
+	case reflect.Slice:
+		items := make([]interface{}, v.Len())
+		for i := range items {
+			item, err := jerValue(v.Index(i))
+			if err != nil {
+				return nil, err
+			}
+			items[i] = item
+		}
+		return items, nil
+	case reflect.Struct:
+		structType := v.Type()
+		switch structType.Field(0).Name {
+		case "Value", "List":
+			if identifiers, ok := enumeratedIdentifiers[structType]; ok {
+				enumerated, err := enumeratedIdentifier(structType, identifiers, v.Field(0).Int())
+				if err != nil {
+					return nil, fmt.Errorf("jer: %w", err)
+				}
+				return enumerated, nil
+			}
+			return jerValue(v.Field(0))
+		case "Present":
+			present := int(v.Field(0).Int())
+			if present <= 0 || present >= structType.NumField() {
+				return nil, fmt.Errorf("jer: CHOICE present %d of %s out of range", present, structType)
+			}
+			alternative, err := jerValue(v.Field(present))
+			if err != nil {
+				return nil, err
+			}
+			return map[string]interface{}{identifier(structType.Field(present).Name): alternative}, nil
+		}
+
+		members := make(map[string]interface{})
+		for i := 0; i < structType.NumField(); i++ {
+			if absent(structType.Field(i), v.Field(i)) {
+				continue
+			}
+			member, err := jerValue(v.Field(i))
+			if err != nil {
+				return nil, err
+			}
+			members[identifier(structType.Field(i).Name)] = member
+		}
+		return members, nil
+	}
+	return nil, fmt.Errorf("jer: unsupported type %s", v.Type())
+}
+
+// JerUnmarshal parses the JER encoding b into the value pointed at by value
+func JerUnmarshal(b []byte, value interface{}) error {
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	var decoded interface{}
+	if err := d.Decode(&decoded); err != nil {
+		return fmt.Errorf("jer: %w", err)
+	}
+
+	v := reflect.ValueOf(value)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return fmt.Errorf("jer: cannot unmarshal into non pointer %T", value)
+	}
+	return jerDecode(decoded, v.Elem())
+}
+
+func jerDecode(decoded interface{}, v reflect.Value) error {
+	if v.Kind() == reflect.Ptr {
+		ptr := reflect.New(v.Type().Elem())
+		if err := jerDecode(decoded, ptr.Elem()); err != nil {
+			return err
+		}
+		v.Set(ptr)
+		return nil
+	}
+	if v.Type() == NullType {
+		if decoded != nil {
+			return fmt.Errorf("jer: NULL expected, got %T", decoded)
+		}
+		v.SetBool(true)
+		return nil
+	}
+	if identifiers, ok := enumeratedIdentifiers[v.Type()]; ok {
+		enumerated, isString := decoded.(string)
+		if !isString {
+			return fmt.Errorf("jer: ENUMERATED identifier expected for %s, got %T", v.Type(), decoded)
+		}
+		value, err := enumeratedValue(v.Type(), identifiers, enumerated)
+		if err != nil {
+			return fmt.Errorf("jer: %w", err)
+		}
+		v.Field(0).SetInt(value)
+		return nil
+	}
+
+	switch decoded := decoded.(type) {
+	case string:
+		switch {
+		case v.Type() == OctetStringType:
+			octets, err := hex.DecodeString(decoded)
+			if err != nil {
+				return fmt.Errorf("jer: invalid octet string: %w", err)
+			}
+			v.SetBytes(octets)
+			return nil
+		case v.Kind() == reflect.String:
+			v.SetString(decoded)
+			return nil
+		}
+	case bool:
+		if v.Kind() == reflect.Bool {
+			v.SetBool(decoded)
+			return nil
+		}
+	case json.Number:
+		switch v.Kind() {
+		case reflect.Int, reflect.Int32, reflect.Int64:
+			i, err := decoded.Int64()
+			if err != nil || v.OverflowInt(i) {
+				return fmt.Errorf("jer: invalid integer %s", decoded)
+			}
+			v.SetInt(i)
+			return nil
+		}
+	case []interface{}:
+		if v.Kind() == reflect.Slice {
+			slice := reflect.MakeSlice(v.Type(), len(decoded), len(decoded))
+			for i, item := range decoded {
+				if err := jerDecode(item, slice.Index(i)); err != nil {
+					return err
+				}
+			}
+			v.Set(slice)
+			return nil
+		}
+		if v.Kind() == reflect.Struct && v.Type().Field(0).Name == "List" {
+			return jerDecode(decoded, v.Field(0))
+		}
+	case map[string]interface{}:
+		if v.Kind() == reflect.Struct {
+			return jerDecodeStruct(decoded, v)
+		}
+	}
+
+	if v.Kind() == reflect.Struct && v.Type().Field(0).Name == "Value" {
+		return jerDecode(decoded, v.Field(0))
+	}
+	return fmt.Errorf("jer: cannot unmarshal %T into %s", decoded, v.Type())
+}
+
+func jerDecodeStruct(members map[string]interface{}, v reflect.Value) error {
+	structType := v.Type()
+	switch {
+	case structType == BitStringType:
+		encoded, err := json.Marshal(members)
+		if err != nil {
+			return err
+		}
+		var jerBits jerBitString
+		if err = json.Unmarshal(encoded, &jerBits); err != nil {
+			return fmt.Errorf("jer: invalid bit string: %w", err)
+		}
+		octets, err := hex.DecodeString(jerBits.Value)
+		if err != nil || jerBits.Length > uint64(len(octets))*8 {
+			return fmt.Errorf("jer: invalid bit string %q of length %d", jerBits.Value, jerBits.Length)
+		}
+		v.Set(reflect.ValueOf(BitString{Bytes: octets, BitLength: jerBits.Length}))
+		return nil
+	case structType.Field(0).Name == "Value" || structType.Field(0).Name == "List":
+		return jerDecode(members, v.Field(0))
+	case structType.Field(0).Name == "Present":
+		if len(members) != 1 {
+			return fmt.Errorf("jer: CHOICE %s with %d alternatives", structType, len(members))
+		}
+		for name, alternative := range members {
+			for i := 1; i < structType.NumField(); i++ {
+				if identifier(structType.Field(i).Name) == name {
+					v.Field(0).SetInt(int64(i))
+					return jerDecode(alternative, v.Field(i))
+				}
+			}
+			return fmt.Errorf("jer: unknown alternative %q of %s", name, structType)
+		}
+	}
+
+	for name, member := range members {
+		field, ok := structType.FieldByNameFunc(func(fieldName string) bool {
+			return identifier(fieldName) == name
+		})
+		if !ok {
+			return fmt.Errorf("jer: unknown member %q of %s", name, structType)
+		}
+		if err := jerDecode(member, v.FieldByIndex(field.Index)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
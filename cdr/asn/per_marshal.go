@@ -0,0 +1,434 @@
+package asn
+
+import (
+	"fmt"
+	"math/bits"
+	"reflect"
+	"slices"
+	"sort"
+)
+
+// perMaxConstrainedLength bounds the lengths encoded as constrained whole numbers, X.691 10.9.3.3
+const perMaxConstrainedLength = 65536
+
+// perFragmentSize is the number of items of a fragment, X.691 10.9.3.8
+const perFragmentSize = 16384
+
+type perEncoder struct {
+	aligned bool
+	buf     []byte
+	// bitLen is the number of bits written
+	bitLen uint64
+}
+
+func (e *perEncoder) putBits(value uint64, n uint) {
+	for i := n; i > 0; i-- {
+		if e.bitLen%8 == 0 {
+			e.buf = append(e.buf, 0)
+		}
+		if value>>(i-1)&1 == 1 {
+			e.buf[len(e.buf)-1] |= 0x80 >> (e.bitLen % 8)
+		}
+		e.bitLen++
+	}
+}
+
+func (e *perEncoder) putBool(b bool) {
+	if b {
+		e.putBits(1, 1)
+	} else {
+		e.putBits(0, 1)
+	}
+}
+
+func (e *perEncoder) putBytes(b []byte) {
+	if e.bitLen%8 == 0 {
+		e.buf = append(e.buf, b...)
+		e.bitLen += uint64(len(b)) * 8
+		return
+	}
+	for _, octet := range b {
+		e.putBits(uint64(octet), 8)
+	}
+}
+
+// align pads to an octet boundary in the ALIGNED variant
+func (e *perEncoder) align() {
+	if e.aligned && e.bitLen%8 != 0 {
+		e.bitLen += 8 - e.bitLen%8
+	}
+}
+
+// bitsFor is the number of bits of the offsets from 0 to rangeSize-1, a zero rangeSize standing for 2^64
+func bitsFor(rangeSize uint64) uint {
+	return uint(bits.Len64(rangeSize - 1))
+}
+
+// octetsFor is the number of octets of the non-negative binary integer encoding of value
+func octetsFor(value uint64) uint {
+	return max(1, uint(bits.Len64(value)+7)/8)
+}
+
+// putConstrainedWholeNumber writes the offset from the lower bound of a range of rangeSize values, X.691 10.5
+func (e *perEncoder) putConstrainedWholeNumber(offset, rangeSize uint64) {
+	switch {
+	case rangeSize == 1:
+	case !e.aligned || (rangeSize != 0 && rangeSize < 256):
+		e.putBits(offset, bitsFor(rangeSize))
+	case rangeSize == 256:
+		e.align()
+		e.putBits(offset, 8)
+	case rangeSize != 0 && rangeSize <= 65536:
+		e.align()
+		e.putBits(offset, 16)
+	default:
+		n := octetsFor(offset)
+		e.putConstrainedWholeNumber(uint64(n-1), uint64(octetsFor(rangeSize-1)))
+		e.align()
+		e.putBits(offset, n*8)
+	}
+}
+
+// putLength writes the length determinant of n items, X.691 10.9. It returns the number of items to write
+// before the next length determinant, a multiple of the fragment size if less than n.
+func (e *perEncoder) putLength(n uint64, lb uint64, ub int64) uint64 {
+	if ub >= 0 && ub < perMaxConstrainedLength {
+		e.putConstrainedWholeNumber(n-lb, uint64(ub)-lb+1)
+		return n
+	}
+	e.align()
+	switch {
+	case n < 128:
+		e.putBits(n, 8)
+		return n
+	case n < perFragmentSize:
+		e.putBits(0x8000|n, 16)
+		return n
+	}
+	fragments := min(n/perFragmentSize, 4)
+	e.putBits(0xc0|fragments, 8)
+	return fragments * perFragmentSize
+}
+
+// putNormallySmall writes a normally small non-negative whole number, X.691 10.6
+func (e *perEncoder) putNormallySmall(n uint64) {
+	if n <= 63 {
+		e.putBits(n, 7)
+		return
+	}
+	e.putBits(1, 1)
+	e.putLength(uint64(octetsFor(n)), 0, -1)
+	e.putBits(n, octetsFor(n)*8)
+}
+
+// putUnconstrainedWholeNumber writes the 2's complement encoding of value after its length, X.691 10.8
+func (e *perEncoder) putUnconstrainedWholeNumber(value int64) {
+	n := uint(1)
+	for ; n < 8; n++ {
+		if value >= -1<<(8*n-1) && value < 1<<(8*n-1) {
+			break
+		}
+	}
+	e.putLength(uint64(n), 0, -1)
+	e.putBits(uint64(value), n*8)
+}
+
+// putInteger writes an INTEGER per its value constraint, X.691 13
+func (e *perEncoder) putInteger(value int64, params fieldParameters) error {
+	lb, ub := params.valueLowerBound, params.valueUpperBound
+	inRoot := (lb == nil || value >= *lb) && (ub == nil || value <= *ub)
+	if params.valueExtensible {
+		e.putBool(!inRoot)
+		if !inRoot {
+			e.putUnconstrainedWholeNumber(value)
+			return nil
+		}
+	}
+	if !inRoot {
+		return fmt.Errorf("per: INTEGER %d out of its constraint", value)
+	}
+
+	switch {
+	case lb != nil && ub != nil:
+		e.putConstrainedWholeNumber(uint64(value-*lb), uint64(*ub-*lb)+1)
+	case lb != nil:
+		offset := uint64(value - *lb)
+		e.putLength(uint64(octetsFor(offset)), 0, -1)
+		e.putBits(offset, octetsFor(offset)*8)
+	default:
+		e.putUnconstrainedWholeNumber(value)
+	}
+	return nil
+}
+
+// putEnumerated writes the index of the value among the root values valueLB to valueUB, X.691 14
+func (e *perEncoder) putEnumerated(value int64, params fieldParameters) error {
+	lb, ub := params.valueLowerBound, params.valueUpperBound
+	if lb == nil || ub == nil {
+		return fmt.Errorf("per: ENUMERATED without valueLB and valueUB")
+	}
+	inRoot := value >= *lb && value <= *ub
+	if params.valueExtensible {
+		e.putBool(!inRoot)
+		if !inRoot {
+			if value < *lb {
+				return fmt.Errorf("per: ENUMERATED %d out of its constraint", value)
+			}
+			e.putNormallySmall(uint64(value - *ub - 1))
+			return nil
+		}
+	}
+	if !inRoot {
+		return fmt.Errorf("per: ENUMERATED %d out of its constraint", value)
+	}
+	e.putConstrainedWholeNumber(uint64(value-*lb), uint64(*ub-*lb)+1)
+	return nil
+}
+
+// sizeBounds are the bounds of the size constraint, ub is negative without upper bound
+func sizeBounds(params fieldParameters) (lb uint64, ub int64) {
+	ub = -1
+	if params.sizeLowerBound != nil {
+		lb = uint64(*params.sizeLowerBound)
+	}
+	if params.sizeUpperBound != nil {
+		ub = *params.sizeUpperBound
+	}
+	return lb, ub
+}
+
+// putSized writes the n items of a string or a SEQUENCE OF after their length determinant, per the size
+// constraint. itemBits is the size of the items of a string, 0 for the components of a SEQUENCE OF.
+// alignVariable tells whether the items of a string of variable size are octet-aligned.
+func (e *perEncoder) putSized(n uint64, params fieldParameters, itemBits uint64, alignVariable bool,
+	put func(from, to uint64) error,
+) error {
+	lb, ub := sizeBounds(params)
+	inRoot := n >= lb && (ub < 0 || n <= uint64(ub))
+	if params.sizeExtensible {
+		e.putBool(!inRoot)
+		if !inRoot {
+			lb, ub = 0, -1
+		}
+	} else if !inRoot {
+		return fmt.Errorf("per: size %d out of its constraint", n)
+	}
+
+	if ub >= 0 && lb == uint64(ub) && ub < perMaxConstrainedLength {
+		// fixed size, without length determinant
+		if itemBits*uint64(ub) > 16 {
+			e.align()
+		}
+		return put(0, n)
+	}
+	for from := uint64(0); ; {
+		count := e.putLength(n-from, lb, ub)
+		if alignVariable {
+			e.align()
+		}
+		if err := put(from, from+count); err != nil {
+			return err
+		}
+		from += count
+		if count < perFragmentSize || (ub >= 0 && ub < perMaxConstrainedLength) {
+			return nil
+		}
+	}
+}
+
+// knownMultiplier tells whether the string type has a fixed number of bits per character, X.691 30
+func knownMultiplier(t reflect.Type, params fieldParameters) bool {
+	return t == IA5StringType || (t.Kind() == reflect.String && params.stringType == TagIA5String)
+}
+
+// charBits is the number of bits of an IA5String character
+func (e *perEncoder) charBits() uint {
+	if e.aligned {
+		return 8
+	}
+	return 7
+}
+
+// perConstraints adds the constraints of the tag of a Value or List field to those of the field of its type
+func perConstraints(params fieldParameters, tag string) fieldParameters {
+	inner := parseFieldParameters(tag)
+	if params.sizeLowerBound == nil && params.sizeUpperBound == nil {
+		params.sizeLowerBound, params.sizeUpperBound = inner.sizeLowerBound, inner.sizeUpperBound
+	}
+	if params.valueLowerBound == nil && params.valueUpperBound == nil {
+		params.valueLowerBound, params.valueUpperBound = inner.valueLowerBound, inner.valueUpperBound
+	}
+	params.sizeExtensible = params.sizeExtensible || inner.sizeExtensible
+	params.valueExtensible = params.valueExtensible || inner.valueExtensible
+	if params.stringType == 0 {
+		params.stringType = inner.stringType
+	}
+	return params
+}
+
+// perComponents returns the fields of a SEQUENCE, SET or CHOICE from the first one, with their parameters.
+// The components of a SET and the alternatives of a CHOICE are sorted by tag number, X.691 8.6.
+func perComponents(structType reflect.Type, first int, canonical bool) ([]int, []fieldParameters) {
+	var fields []int
+	params := make([]fieldParameters, structType.NumField())
+	tagged := true
+	for i := first; i < structType.NumField(); i++ {
+		fields = append(fields, i)
+		params[i] = parseFieldParameters(structType.Field(i).Tag.Get("ber"))
+		tagged = tagged && params[i].tagNumber != nil
+	}
+	if canonical && tagged {
+		sort.SliceStable(fields, func(a, b int) bool {
+			return *params[fields[a]].tagNumber < *params[fields[b]].tagNumber
+		})
+	}
+	return fields, params
+}
+
+func (e *perEncoder) encode(v reflect.Value, params fieldParameters) error {
+	if !v.IsValid() {
+		return fmt.Errorf("per: cannot marshal nil value")
+	}
+	if v.Kind() == reflect.Interface || v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return fmt.Errorf("per: cannot marshal nil value of %s", v.Type())
+		}
+		return e.encode(v.Elem(), params)
+	}
+
+	switch v.Type() {
+	case BitStringType:
+		bitString := v.Interface().(BitString)
+		if uint64(len(bitString.Bytes))*8 < bitString.BitLength {
+			return fmt.Errorf("per: BIT STRING of %d bits in %d octets", bitString.BitLength, len(bitString.Bytes))
+		}
+		return e.putSized(bitString.BitLength, params, 1, true, func(from, to uint64) error {
+			for i := from; i < to; i++ {
+				e.putBits(uint64(bitString.Bytes[i/8]>>(7-i%8)), 1)
+			}
+			return nil
+		})
+	case OctetStringType:
+		octets := v.Bytes()
+		return e.putSized(uint64(len(octets)), params, 8, true, func(from, to uint64) error {
+			e.putBytes(octets[from:to])
+			return nil
+		})
+	case ObjectIdentifierType:
+		return fmt.Errorf("per: unsupported ObjectIdentifier type")
+	case EnumeratedType:
+		return e.putEnumerated(v.Int(), params)
+	case NullType:
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		e.putBool(v.Bool())
+		return nil
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		return e.putInteger(v.Int(), params)
+	case reflect.String:
+		s := v.String()
+		if !knownMultiplier(v.Type(), params) {
+			return e.putSized(uint64(len(s)), params, 8, true, func(from, to uint64) error {
+				e.putBytes([]byte(s[from:to]))
+				return nil
+			})
+		}
+		charBits := e.charBits()
+		_, ub := sizeBounds(params)
+		return e.putSized(uint64(len(s)), params, uint64(charBits), ub < 0 || uint64(ub)*uint64(charBits) > 16,
+			func(from, to uint64) error {
+				for i := from; i < to; i++ {
+					if s[i] > 0x7f {
+						return fmt.Errorf("per: invalid IA5String character %#x", s[i])
+					}
+					e.putBits(uint64(s[i]), charBits)
+				}
+				return nil
+			})
+	case reflect.Slice:
+		return e.putSized(uint64(v.Len()), params, 0, false, func(from, to uint64) error {
+			for i := from; i < to; i++ {
+				if err := e.encode(v.Index(int(i)), fieldParameters{}); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	case reflect.Struct:
+		structType := v.Type()
+		switch structType.Field(0).Name {
+		case "Value", "List":
+			return e.encode(v.Field(0), perConstraints(params, structType.Field(0).Tag.Get("ber")))
+		case "Present":
+			return e.encodeChoice(v, params)
+		}
+		return e.encodeSequence(v, params)
+	}
+	return fmt.Errorf("per: unsupported type %s", v.Type())
+}
+
+// encodeChoice writes the index of the alternative present, then the alternative, X.691 23
+func (e *perEncoder) encodeChoice(v reflect.Value, params fieldParameters) error {
+	structType := v.Type()
+	present := int(v.Field(0).Int())
+	if present <= 0 || present >= structType.NumField() {
+		return fmt.Errorf("per: CHOICE present %d of %s out of range", present, structType)
+	}
+	alternatives, alternativeParams := perComponents(structType, 1, true)
+	index := slices.Index(alternatives, present)
+
+	if params.choiceExtensible {
+		e.putBits(0, 1)
+	}
+	e.putConstrainedWholeNumber(uint64(index), uint64(len(alternatives)))
+	return e.encode(v.Field(present), alternativeParams[present])
+}
+
+// encodeSequence writes the bitmap of the OPTIONAL components present, then the components, X.691 19
+func (e *perEncoder) encodeSequence(v reflect.Value, params fieldParameters) error {
+	structType := v.Type()
+	components, componentParams := perComponents(structType, 0, params.set)
+
+	if params.valueExtensible {
+		// no extension addition is known
+		e.putBits(0, 1)
+	}
+	for _, i := range components {
+		if componentParams[i].optional {
+			e.putBool(!absent(structType.Field(i), v.Field(i)))
+		}
+	}
+	for _, i := range components {
+		if absent(structType.Field(i), v.Field(i)) {
+			continue
+		}
+		if err := e.encode(v.Field(i), componentParams[i]); err != nil {
+			return fmt.Errorf("%s: %w", structType.Field(i).Name, err)
+		}
+	}
+	return nil
+}
+
+// PerMarshal returns the PER encoding of val, in the ALIGNED or the UNALIGNED variant.
+func PerMarshal(val interface{}, aligned bool) ([]byte, error) {
+	return PerMarshalWithParams(val, aligned, "")
+}
+
+// PerMarshalWithParams allows field parameters to be specified for the top-level element. The constraints
+// sizeLB, sizeUB, valueLB and valueUB of the field tags apply, sizeExt, valueExt and choiceExt marking the
+// extensible constraints, SEQUENCE and CHOICE types. The tag of the Value or List field of a type carries the
+// constraints of the type. ENUMERATED values are encoded as their index from valueLB.
+func PerMarshalWithParams(val interface{}, aligned bool, params string) ([]byte, error) {
+	e := &perEncoder{aligned: aligned}
+	if err := e.encode(reflect.ValueOf(val), parseFieldParameters(params)); err != nil {
+		return nil, err
+	}
+	if len(e.buf) == 0 {
+		// X.691 11.1, a complete encoding is at least one octet
+		return []byte{0}, nil
+	}
+	return e.buf, nil
+}
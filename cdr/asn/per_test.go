@@ -0,0 +1,129 @@
+package asn
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type perSmallInt struct {
+	Value int64 `ber:"valueLB:0,valueUB:7"`
+}
+
+type perOptionalStruct struct {
+	A perSmallInt `ber:"tagNum:0"`
+	B *bool       `ber:"tagNum:1,optional"`
+}
+
+type perFixedOctetsStruct struct {
+	A bool        `ber:"tagNum:0"`
+	B OctetString `ber:"tagNum:1,sizeLB:3,sizeUB:3"`
+}
+
+type perChoice struct {
+	Present int
+	A       *bool        `ber:"tagNum:0"`
+	B       *perSmallInt `ber:"tagNum:1"`
+}
+
+type perExtensibleStruct struct {
+	A int64 `ber:"tagNum:0,valueLB:0,valueUB:7,valueExt"`
+}
+
+type perRecord struct {
+	Int       int64              `ber:"tagNum:0"`
+	Uint32    int64              `ber:"tagNum:1,valueLB:0,valueUB:4294967295"`
+	Enum      Enumerated         `ber:"tagNum:2,valueLB:0,valueUB:4,valueExt"`
+	Bits      BitString          `ber:"tagNum:3"`
+	Octets    OctetString        `ber:"tagNum:4,sizeLB:0,sizeUB:7"`
+	IA5       IA5String          `ber:"tagNum:5"`
+	UTF8      UTF8String         `ber:"tagNum:6"`
+	Null      *NULL              `ber:"tagNum:7,optional"`
+	Choice    *perChoice         `ber:"tagNum:8,optional,choiceExt"`
+	List      []perSmallInt      `ber:"tagNum:9,optional,sizeLB:1,sizeUB:3,sizeExt"`
+	Optional  *perOptionalStruct `ber:"tagNum:10,optional,valueExt"`
+	Fragments OctetString        `ber:"tagNum:11"`
+}
+
+func TestPerMarshal(t *testing.T) {
+	t.Parallel()
+
+	b := true
+	testCases := []struct {
+		name      string
+		in        interface{}
+		params    string
+		unaligned string
+		aligned   string
+	}{
+		{"constrainedInt", 1, "valueLB:0,valueUB:255", "01", "01"},
+		{"smallInt", 5, "valueLB:0,valueUB:7", "a0", "a0"},
+		{"unconstrainedInt", 128, "", "020080", "020080"},
+		{"negativeInt", -129, "", "02ff7f", "02ff7f"},
+		{"semiConstrainedInt", 256, "valueLB:1", "01ff", "01ff"},
+		{"bool", true, "", "80", "80"},
+		{"sequence", perOptionalStruct{A: perSmallInt{Value: 3}, B: &b}, "", "b8", "b8"},
+		{"fixedOctets", perFixedOctetsStruct{A: true, B: OctetString{1, 2, 3}}, "", "80810180", "80010203"},
+		{"ia5String", IA5String("ab"), "", "02c388", "026162"},
+		{"choice", perChoice{Present: 2, B: &perSmallInt{Value: 2}}, "", "a0", "a0"},
+		{"enumerated", Enumerated(1), "valueLB:0,valueUB:4", "20", "20"},
+		{"extendedInt", perExtensibleStruct{A: 9}, "", "808480", "800109"},
+		{"variableOctets", OctetString{1, 2}, "sizeLB:0,sizeUB:7", "402040", "400102"},
+		{"emptySequence", perOptionalStruct{A: perSmallInt{Value: 0}}, "", "00", "00"},
+	}
+	for _, tc := range testCases {
+		for _, aligned := range []bool{false, true} {
+			expected := tc.unaligned
+			if aligned {
+				expected = tc.aligned
+			}
+			out, err := PerMarshalWithParams(tc.in, aligned, tc.params)
+			require.NoError(t, err, "%s aligned %t", tc.name, aligned)
+			require.Equal(t, expected, hex.EncodeToString(out), "%s aligned %t", tc.name, aligned)
+		}
+	}
+
+	_, err := PerMarshalWithParams(8, false, "valueLB:0,valueUB:7")
+	require.Error(t, err)
+	_, err = PerMarshalWithParams(Enumerated(1), false, "")
+	require.Error(t, err)
+}
+
+func TestPerUnmarshal(t *testing.T) {
+	t.Parallel()
+
+	b := true
+	null := NULL(true)
+	record := perRecord{
+		Int:       -1 << 40,
+		Uint32:    4294967295,
+		Enum:      6,
+		Bits:      BitString{Bytes: []byte{0xa5, 0x80}, BitLength: 9},
+		Octets:    OctetString{1, 2, 3},
+		IA5:       "imsi-208930000000001",
+		UTF8:      "é",
+		Null:      &null,
+		Choice:    &perChoice{Present: 1, A: &b},
+		List:      []perSmallInt{{1}, {2}, {3}, {4}},
+		Optional:  &perOptionalStruct{A: perSmallInt{Value: 7}},
+		Fragments: bytes.Repeat([]byte{0x5a}, 3*perFragmentSize+1),
+	}
+
+	for _, aligned := range []bool{false, true} {
+		out, err := PerMarshal(&record, aligned)
+		require.NoError(t, err)
+
+		var decoded perRecord
+		require.NoError(t, PerUnmarshal(out, &decoded, aligned))
+		require.Equal(t, record, decoded)
+
+		require.Error(t, PerUnmarshal(out[:len(out)/2], &decoded, aligned))
+	}
+
+	// the extension additions of an extensible SEQUENCE are skipped
+	var decoded perExtensibleStruct
+	require.NoError(t, PerUnmarshalWithParams([]byte{0x90, 0x08, 0x08, 0x00}, &decoded, false, "valueExt"))
+	require.Equal(t, int64(2), decoded.A)
+}
@@ -0,0 +1,492 @@
+package asn
+
+import (
+	"fmt"
+	"reflect"
+)
+
+type perDecoder struct {
+	aligned bool
+	buf     []byte
+	// pos is the number of bits read
+	pos uint64
+}
+
+func (d *perDecoder) getBits(n uint) (uint64, error) {
+	if d.pos+uint64(n) > uint64(len(d.buf))*8 {
+		return 0, fmt.Errorf("per: %d bits to read beyond the %d octets", n, len(d.buf))
+	}
+	var value uint64
+	for i := uint(0); i < n; i++ {
+		value = value<<1 | uint64(d.buf[d.pos/8]>>(7-d.pos%8)&1)
+		d.pos++
+	}
+	return value, nil
+}
+
+func (d *perDecoder) getBool() (bool, error) {
+	bit, err := d.getBits(1)
+	return bit == 1, err
+}
+
+func (d *perDecoder) getBytes(n uint64) ([]byte, error) {
+	if d.pos+n*8 > uint64(len(d.buf))*8 {
+		return nil, fmt.Errorf("per: %d octets to read beyond the %d octets", n, len(d.buf))
+	}
+	b := make([]byte, n)
+	if d.pos%8 == 0 {
+		copy(b, d.buf[d.pos/8:])
+		d.pos += n * 8
+		return b, nil
+	}
+	for i := range b {
+		octet, err := d.getBits(8)
+		if err != nil {
+			return nil, err
+		}
+		b[i] = byte(octet)
+	}
+	return b, nil
+}
+
+// align skips the padding to an octet boundary in the ALIGNED variant
+func (d *perDecoder) align() {
+	if d.aligned && d.pos%8 != 0 {
+		d.pos += 8 - d.pos%8
+	}
+}
+
+// getConstrainedWholeNumber reads the offset from the lower bound of a range of rangeSize values, X.691 10.5
+func (d *perDecoder) getConstrainedWholeNumber(rangeSize uint64) (uint64, error) {
+	var offset uint64
+	var err error
+	switch {
+	case rangeSize == 1:
+		return 0, nil
+	case !d.aligned || (rangeSize != 0 && rangeSize < 256):
+		offset, err = d.getBits(bitsFor(rangeSize))
+	case rangeSize == 256:
+		d.align()
+		offset, err = d.getBits(8)
+	case rangeSize != 0 && rangeSize <= 65536:
+		d.align()
+		offset, err = d.getBits(16)
+	default:
+		var n uint64
+		if n, err = d.getConstrainedWholeNumber(uint64(octetsFor(rangeSize - 1))); err != nil {
+			return 0, err
+		}
+		d.align()
+		offset, err = d.getBits(uint(n+1) * 8)
+	}
+	if err != nil {
+		return 0, err
+	}
+	if rangeSize != 0 && offset >= rangeSize {
+		return 0, fmt.Errorf("per: constrained whole number %d out of its range of %d", offset, rangeSize)
+	}
+	return offset, nil
+}
+
+// getLength reads the length determinant, X.691 10.9. It returns the number of items to read before the next
+// length determinant, and whether it is a fragment.
+func (d *perDecoder) getLength(lb uint64, ub int64) (uint64, bool, error) {
+	if ub >= 0 && ub < perMaxConstrainedLength {
+		offset, err := d.getConstrainedWholeNumber(uint64(ub) - lb + 1)
+		return lb + offset, false, err
+	}
+	d.align()
+	first, err := d.getBits(8)
+	if err != nil {
+		return 0, false, err
+	}
+	switch {
+	case first&0x80 == 0:
+		return first, false, nil
+	case first&0xc0 == 0x80:
+		second, errBits := d.getBits(8)
+		return (first&0x3f)<<8 | second, false, errBits
+	}
+	fragments := first & 0x3f
+	if fragments < 1 || fragments > 4 {
+		return 0, false, fmt.Errorf("per: invalid number of fragments %d", fragments)
+	}
+	return fragments * perFragmentSize, true, nil
+}
+
+// getNormallySmall reads a normally small non-negative whole number, X.691 10.6
+func (d *perDecoder) getNormallySmall() (uint64, error) {
+	large, err := d.getBool()
+	if err != nil {
+		return 0, err
+	}
+	if !large {
+		return d.getBits(6)
+	}
+	n, _, err := d.getLength(0, -1)
+	if err != nil {
+		return 0, err
+	}
+	if n < 1 || n > 8 {
+		return 0, fmt.Errorf("per: normally small number of %d octets", n)
+	}
+	return d.getBits(uint(n) * 8)
+}
+
+// getUnconstrainedWholeNumber reads a 2's complement encoding after its length, X.691 10.8
+func (d *perDecoder) getUnconstrainedWholeNumber() (int64, error) {
+	n, _, err := d.getLength(0, -1)
+	if err != nil {
+		return 0, err
+	}
+	if n < 1 || n > 8 {
+		return 0, fmt.Errorf("per: INTEGER of %d octets", n)
+	}
+	value, err := d.getBits(uint(n) * 8)
+	if err != nil {
+		return 0, err
+	}
+	// sign extension
+	shift := 64 - n*8
+	return int64(value<<shift) >> shift, nil
+}
+
+// getInteger reads an INTEGER per its value constraint, X.691 13
+func (d *perDecoder) getInteger(params fieldParameters) (int64, error) {
+	if params.valueExtensible {
+		extension, err := d.getBool()
+		if err != nil {
+			return 0, err
+		}
+		if extension {
+			return d.getUnconstrainedWholeNumber()
+		}
+	}
+
+	lb, ub := params.valueLowerBound, params.valueUpperBound
+	switch {
+	case lb != nil && ub != nil:
+		offset, err := d.getConstrainedWholeNumber(uint64(*ub-*lb) + 1)
+		return *lb + int64(offset), err
+	case lb != nil:
+		n, _, err := d.getLength(0, -1)
+		if err != nil {
+			return 0, err
+		}
+		if n < 1 || n > 8 {
+			return 0, fmt.Errorf("per: INTEGER of %d octets", n)
+		}
+		offset, err := d.getBits(uint(n) * 8)
+		return *lb + int64(offset), err
+	}
+	return d.getUnconstrainedWholeNumber()
+}
+
+// getEnumerated reads the index of the value among the root values valueLB to valueUB, X.691 14
+func (d *perDecoder) getEnumerated(params fieldParameters) (int64, error) {
+	lb, ub := params.valueLowerBound, params.valueUpperBound
+	if lb == nil || ub == nil {
+		return 0, fmt.Errorf("per: ENUMERATED without valueLB and valueUB")
+	}
+	if params.valueExtensible {
+		extension, err := d.getBool()
+		if err != nil {
+			return 0, err
+		}
+		if extension {
+			index, errIndex := d.getNormallySmall()
+			return *ub + 1 + int64(index), errIndex
+		}
+	}
+	index, err := d.getConstrainedWholeNumber(uint64(*ub-*lb) + 1)
+	return *lb + int64(index), err
+}
+
+// getSized reads the items of a string or a SEQUENCE OF after their length determinant, the reverse of putSized
+func (d *perDecoder) getSized(params fieldParameters, itemBits uint64, alignVariable bool,
+	get func(count uint64) error,
+) error {
+	lb, ub := sizeBounds(params)
+	if params.sizeExtensible {
+		extension, err := d.getBool()
+		if err != nil {
+			return err
+		}
+		if extension {
+			lb, ub = 0, -1
+		}
+	}
+
+	if ub >= 0 && lb == uint64(ub) && ub < perMaxConstrainedLength {
+		if itemBits*uint64(ub) > 16 {
+			d.align()
+		}
+		return get(lb)
+	}
+	for {
+		count, fragment, err := d.getLength(lb, ub)
+		if err != nil {
+			return err
+		}
+		if alignVariable {
+			d.align()
+		}
+		if count*itemBits > uint64(len(d.buf))*8-min(d.pos, uint64(len(d.buf))*8) {
+			return fmt.Errorf("per: %d items to read beyond the %d octets", count, len(d.buf))
+		}
+		if err = get(count); err != nil {
+			return err
+		}
+		if !fragment {
+			return nil
+		}
+	}
+}
+
+// charBits is the number of bits of an IA5String character
+func (d *perDecoder) charBits() uint {
+	if d.aligned {
+		return 8
+	}
+	return 7
+}
+
+func (d *perDecoder) decode(v reflect.Value, params fieldParameters) error {
+	if v.Kind() == reflect.Ptr {
+		ptr := reflect.New(v.Type().Elem())
+		if err := d.decode(ptr.Elem(), params); err != nil {
+			return err
+		}
+		v.Set(ptr)
+		return nil
+	}
+
+	switch v.Type() {
+	case BitStringType:
+		var bitString BitString
+		err := d.getSized(params, 1, true, func(count uint64) error {
+			for i := uint64(0); i < count; i++ {
+				bit, errBits := d.getBits(1)
+				if errBits != nil {
+					return errBits
+				}
+				if bitString.BitLength%8 == 0 {
+					bitString.Bytes = append(bitString.Bytes, 0)
+				}
+				bitString.Bytes[bitString.BitLength/8] |= byte(bit) << (7 - bitString.BitLength%8)
+				bitString.BitLength++
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		v.Set(reflect.ValueOf(bitString))
+		return nil
+	case OctetStringType:
+		octets := OctetString{}
+		err := d.getSized(params, 8, true, func(count uint64) error {
+			b, errBytes := d.getBytes(count)
+			octets = append(octets, b...)
+			return errBytes
+		})
+		if err != nil {
+			return err
+		}
+		v.SetBytes(octets)
+		return nil
+	case ObjectIdentifierType:
+		return fmt.Errorf("per: unsupported ObjectIdentifier type")
+	case EnumeratedType:
+		value, err := d.getEnumerated(params)
+		if err != nil {
+			return err
+		}
+		v.SetInt(value)
+		return nil
+	case NullType:
+		v.SetBool(true)
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		b, err := d.getBool()
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+		return nil
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		value, err := d.getInteger(params)
+		if err != nil {
+			return err
+		}
+		if v.OverflowInt(value) {
+			return fmt.Errorf("per: INTEGER %d overflows %s", value, v.Type())
+		}
+		v.SetInt(value)
+		return nil
+	case reflect.String:
+		var s []byte
+		var err error
+		if !knownMultiplier(v.Type(), params) {
+			err = d.getSized(params, 8, true, func(count uint64) error {
+				b, errBytes := d.getBytes(count)
+				s = append(s, b...)
+				return errBytes
+			})
+		} else {
+			charBits := d.charBits()
+			_, ub := sizeBounds(params)
+			err = d.getSized(params, uint64(charBits), ub < 0 || uint64(ub)*uint64(charBits) > 16,
+				func(count uint64) error {
+					for i := uint64(0); i < count; i++ {
+						char, errBits := d.getBits(charBits)
+						if errBits != nil {
+							return errBits
+						}
+						if char > 0x7f {
+							return fmt.Errorf("per: invalid IA5String character %#x", char)
+						}
+						s = append(s, byte(char))
+					}
+					return nil
+				})
+		}
+		if err != nil {
+			return err
+		}
+		v.SetString(string(s))
+		return nil
+	case reflect.Slice:
+		slice := reflect.MakeSlice(v.Type(), 0, 0)
+		err := d.getSized(params, 0, false, func(count uint64) error {
+			for i := uint64(0); i < count; i++ {
+				item := reflect.New(v.Type().Elem()).Elem()
+				if err := d.decode(item, fieldParameters{}); err != nil {
+					return err
+				}
+				slice = reflect.Append(slice, item)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		v.Set(slice)
+		return nil
+	case reflect.Struct:
+		structType := v.Type()
+		switch structType.Field(0).Name {
+		case "Value", "List":
+			return d.decode(v.Field(0), perConstraints(params, structType.Field(0).Tag.Get("ber")))
+		case "Present":
+			return d.decodeChoice(v, params)
+		}
+		return d.decodeSequence(v, params)
+	}
+	return fmt.Errorf("per: unsupported type %s", v.Type())
+}
+
+// decodeChoice reads the alternative of a CHOICE after its index, X.691 23
+func (d *perDecoder) decodeChoice(v reflect.Value, params fieldParameters) error {
+	structType := v.Type()
+	alternatives, alternativeParams := perComponents(structType, 1, true)
+	if params.choiceExtensible {
+		extension, err := d.getBool()
+		if err != nil {
+			return err
+		}
+		if extension {
+			return fmt.Errorf("per: unknown extension alternative of %s", structType)
+		}
+	}
+	index, err := d.getConstrainedWholeNumber(uint64(len(alternatives)))
+	if err != nil {
+		return err
+	}
+	present := alternatives[index]
+	v.Field(0).SetInt(int64(present))
+	return d.decode(v.Field(present), alternativeParams[present])
+}
+
+// decodeSequence reads the components of a SEQUENCE after the bitmap of the OPTIONAL ones present, X.691 19.
+// The extension additions are skipped.
+func (d *perDecoder) decodeSequence(v reflect.Value, params fieldParameters) error {
+	structType := v.Type()
+	components, componentParams := perComponents(structType, 0, params.set)
+
+	extension := false
+	if params.valueExtensible {
+		var err error
+		if extension, err = d.getBool(); err != nil {
+			return err
+		}
+	}
+	present := make([]bool, structType.NumField())
+	for _, i := range components {
+		present[i] = true
+		if componentParams[i].optional {
+			var err error
+			if present[i], err = d.getBool(); err != nil {
+				return err
+			}
+		}
+	}
+	for _, i := range components {
+		if !present[i] {
+			continue
+		}
+		if err := d.decode(v.Field(i), componentParams[i]); err != nil {
+			return fmt.Errorf("%s: %w", structType.Field(i).Name, err)
+		}
+	}
+
+	if extension {
+		return d.skipExtensionAdditions()
+	}
+	return nil
+}
+
+// skipExtensionAdditions skips the open types of the extension additions present, X.691 19.8
+func (d *perDecoder) skipExtensionAdditions() error {
+	n, err := d.getNormallySmall()
+	if err != nil {
+		return err
+	}
+	var additions uint64
+	for i := uint64(0); i <= n; i++ {
+		bit, errBits := d.getBits(1)
+		if errBits != nil {
+			return errBits
+		}
+		additions += bit
+	}
+	for ; additions > 0; additions-- {
+		if err = d.getSized(fieldParameters{}, 8, true, func(count uint64) error {
+			_, errBytes := d.getBytes(count)
+			return errBytes
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PerUnmarshal parses the PER encoding b, in the ALIGNED or the UNALIGNED variant, into the value pointed at
+// by value.
+func PerUnmarshal(b []byte, value interface{}, aligned bool) error {
+	return PerUnmarshalWithParams(b, value, aligned, "")
+}
+
+// PerUnmarshalWithParams allows field parameters to be specified for the top-level element, as
+// PerMarshalWithParams.
+func PerUnmarshalWithParams(b []byte, value interface{}, aligned bool, params string) error {
+	v := reflect.ValueOf(value)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return fmt.Errorf("per: cannot unmarshal into non pointer %T", value)
+	}
+	d := &perDecoder{aligned: aligned, buf: b}
+	return d.decode(v.Elem(), parseFieldParameters(params))
+}
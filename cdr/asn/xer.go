@@ -0,0 +1,356 @@
+package asn
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// identifier is the ASN.1 identifier of a struct field: its name with the first letter lowered
+func identifier(fieldName string) string {
+	r, size := utf8.DecodeRuneInString(fieldName)
+	return string(unicode.ToLower(r)) + fieldName[size:]
+}
+
+// absent tells whether the field of a SEQUENCE or SET is an OPTIONAL one not present
+func absent(field reflect.StructField, v reflect.Value) bool {
+	if !parseFieldParameters(field.Tag.Get("ber")).optional {
+		return false
+	}
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Slice, reflect.Interface, reflect.Map:
+		return v.IsNil()
+	}
+	return false
+}
+
+// xerTypeName is the XML tag of a value of the type outside of a SEQUENCE, e.g. an item of a SEQUENCE OF
+func xerTypeName(t reflect.Type) string {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t {
+	case BitStringType:
+		return "BIT_STRING"
+	case OctetStringType:
+		return "OCTET_STRING"
+	case EnumeratedType:
+		return "ENUMERATED"
+	case NullType:
+		return "NULL"
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return "BOOLEAN"
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		return "INTEGER"
+	case reflect.Slice:
+		if t.Name() == "" {
+			return "SEQUENCE_OF"
+		}
+	}
+	return t.Name()
+}
+
+// XerMarshal returns the basic XER (X.693) encoding of val, named after its type. ENUMERATED values are
+// encoded as their identifier element, or as their number if their type has no registered identifiers.
+func XerMarshal(val interface{}) ([]byte, error) {
+	v := reflect.ValueOf(val)
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil, fmt.Errorf("xer: cannot marshal nil value")
+		}
+		v = v.Elem()
+	}
+
+	var buf bytes.Buffer
+	e := xml.NewEncoder(&buf)
+	if err := xerEncode(e, xerTypeName(v.Type()), v); err != nil {
+		return nil, err
+	}
+	if err := e.Flush(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func xerEncode(e *xml.Encoder, name string, v reflect.Value) error {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return fmt.Errorf("xer: cannot marshal nil value of %s", name)
+		}
+		v = v.Elem()
+	}
+	start := xml.StartElement{Name: xml.Name{Local: name}}
+
+	switch v.Type() {
+	case BitStringType:
+		bitString := v.Interface().(BitString)
+		bits := make([]byte, bitString.BitLength)
+		for i := range bits {
+			bits[i] = '0' + bitString.Bytes[i/8]>>(7-i%8)&1
+		}
+		return xerText(e, start, string(bits))
+	case OctetStringType:
+		return xerText(e, start, strings.ToUpper(hex.EncodeToString(v.Bytes())))
+	case ObjectIdentifierType:
+		return fmt.Errorf("xer: unsupported ObjectIdentifier type")
+	case EnumeratedType:
+		return xerText(e, start, strconv.FormatInt(v.Int(), 10))
+	case NullType:
+		return xerText(e, start, "")
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		if err := e.EncodeToken(start); err != nil {
+			return err
+		}
+		if err := xerText(e, xml.StartElement{Name: xml.Name{Local: strconv.FormatBool(v.Bool())}}, ""); err != nil {
+			return err
+		}
+		return e.EncodeToken(start.End())
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		return xerText(e, start, strconv.FormatInt(v.Int(), 10))
+	case reflect.String:
+		return xerText(e, start, v.String())
+	case reflect.Slice:
+		if err := e.EncodeToken(start); err != nil {
+			return err
+		}
+		itemName := xerTypeName(v.Type().Elem())
+		for i := 0; i < v.Len(); i++ {
+			if err := xerEncode(e, itemName, v.Index(i)); err != nil {
+				return err
+			}
+		}
+		return e.EncodeToken(start.End())
+	case reflect.Struct:
+		structType := v.Type()
+		switch structType.Field(0).Name {
+		case "Value", "List":
+			if identifiers, ok := enumeratedIdentifiers[structType]; ok {
+				enumerated, err := enumeratedIdentifier(structType, identifiers, v.Field(0).Int())
+				if err != nil {
+					return fmt.Errorf("xer: %w", err)
+				}
+				if err = e.EncodeToken(start); err != nil {
+					return err
+				}
+				if err = xerText(e, xml.StartElement{Name: xml.Name{Local: enumerated}}, ""); err != nil {
+					return err
+				}
+				return e.EncodeToken(start.End())
+			}
+			return xerEncode(e, name, v.Field(0))
+		case "Present":
+			present := int(v.Field(0).Int())
+			if present <= 0 || present >= structType.NumField() {
+				return fmt.Errorf("xer: CHOICE present %d of %s out of range", present, structType)
+			}
+			if err := e.EncodeToken(start); err != nil {
+				return err
+			}
+			if err := xerEncode(e, identifier(structType.Field(present).Name), v.Field(present)); err != nil {
+				return err
+			}
+			return e.EncodeToken(start.End())
+		}
+
+		if err := e.EncodeToken(start); err != nil {
+			return err
+		}
+		for i := 0; i < structType.NumField(); i++ {
+			if absent(structType.Field(i), v.Field(i)) {
+				continue
+			}
+			if err := xerEncode(e, identifier(structType.Field(i).Name), v.Field(i)); err != nil {
+				return err
+			}
+		}
+		return e.EncodeToken(start.End())
+	}
+	return fmt.Errorf("xer: unsupported type %s", v.Type())
+}
+
+func xerText(e *xml.Encoder, start xml.StartElement, text string) error {
+	if err := e.EncodeToken(start); err != nil {
+		return err
+	}
+	if text != "" {
+		if err := e.EncodeToken(xml.CharData(text)); err != nil {
+			return err
+		}
+	}
+	return e.EncodeToken(start.End())
+}
+
+// xerNode is an element of a XER encoding
+type xerNode struct {
+	name     string
+	text     string
+	children []*xerNode
+}
+
+// XerUnmarshal parses the basic XER encoding b into the value pointed at by value
+func XerUnmarshal(b []byte, value interface{}) error {
+	d := xml.NewDecoder(bytes.NewReader(b))
+	var root *xerNode
+	var stack []*xerNode
+	for {
+		token, err := d.Token()
+		if err != nil {
+			if root != nil && len(stack) == 0 {
+				break
+			}
+			return fmt.Errorf("xer: %w", err)
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			node := &xerNode{name: t.Name.Local}
+			if len(stack) > 0 {
+				parent := stack[len(stack)-1]
+				parent.children = append(parent.children, node)
+			} else if root != nil {
+				return fmt.Errorf("xer: more than one root element")
+			} else {
+				root = node
+			}
+			stack = append(stack, node)
+		case xml.CharData:
+			if len(stack) > 0 {
+				stack[len(stack)-1].text += string(t)
+			}
+		case xml.EndElement:
+			stack = stack[:len(stack)-1]
+		}
+	}
+
+	v := reflect.ValueOf(value)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return fmt.Errorf("xer: cannot unmarshal into non pointer %T", value)
+	}
+	return xerDecode(root, v.Elem())
+}
+
+func xerDecode(node *xerNode, v reflect.Value) error {
+	if v.Kind() == reflect.Ptr {
+		ptr := reflect.New(v.Type().Elem())
+		if err := xerDecode(node, ptr.Elem()); err != nil {
+			return err
+		}
+		v.Set(ptr)
+		return nil
+	}
+	text := strings.TrimSpace(node.text)
+
+	switch v.Type() {
+	case BitStringType:
+		bits := strings.Join(strings.Fields(text), "")
+		bitString := BitString{Bytes: make([]byte, (len(bits)+7)/8), BitLength: uint64(len(bits))}
+		for i, bit := range bits {
+			switch bit {
+			case '1':
+				bitString.Bytes[i/8] |= 0x80 >> (i % 8)
+			case '0':
+			default:
+				return fmt.Errorf("xer: invalid bit %q in <%s>", bit, node.name)
+			}
+		}
+		v.Set(reflect.ValueOf(bitString))
+		return nil
+	case OctetStringType:
+		octets, err := hex.DecodeString(strings.Join(strings.Fields(text), ""))
+		if err != nil {
+			return fmt.Errorf("xer: invalid octet string in <%s>: %w", node.name, err)
+		}
+		v.SetBytes(octets)
+		return nil
+	case ObjectIdentifierType:
+		return fmt.Errorf("xer: unsupported ObjectIdentifier type")
+	case NullType:
+		v.SetBool(true)
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		if len(node.children) == 1 {
+			text = node.children[0].name
+		}
+		b, err := strconv.ParseBool(text)
+		if err != nil {
+			return fmt.Errorf("xer: invalid boolean in <%s>: %w", node.name, err)
+		}
+		v.SetBool(b)
+		return nil
+	case reflect.Int, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(text, 10, 64)
+		if err != nil || v.OverflowInt(i) {
+			return fmt.Errorf("xer: invalid integer %q in <%s>", text, node.name)
+		}
+		v.SetInt(i)
+		return nil
+	case reflect.String:
+		v.SetString(node.text)
+		return nil
+	case reflect.Slice:
+		slice := reflect.MakeSlice(v.Type(), len(node.children), len(node.children))
+		for i, child := range node.children {
+			if err := xerDecode(child, slice.Index(i)); err != nil {
+				return err
+			}
+		}
+		v.Set(slice)
+		return nil
+	case reflect.Struct:
+		structType := v.Type()
+		switch structType.Field(0).Name {
+		case "Value", "List":
+			if identifiers, ok := enumeratedIdentifiers[structType]; ok {
+				if len(node.children) != 1 || text != "" {
+					return fmt.Errorf("xer: ENUMERATED <%s> without an identifier element", node.name)
+				}
+				value, err := enumeratedValue(structType, identifiers, node.children[0].name)
+				if err != nil {
+					return fmt.Errorf("xer: %w", err)
+				}
+				v.Field(0).SetInt(value)
+				return nil
+			}
+			return xerDecode(node, v.Field(0))
+		case "Present":
+			if len(node.children) != 1 {
+				return fmt.Errorf("xer: CHOICE <%s> with %d alternatives", node.name, len(node.children))
+			}
+			child := node.children[0]
+			for i := 1; i < structType.NumField(); i++ {
+				if identifier(structType.Field(i).Name) == child.name {
+					v.Field(0).SetInt(int64(i))
+					return xerDecode(child, v.Field(i))
+				}
+			}
+			return fmt.Errorf("xer: unknown alternative <%s> of <%s>", child.name, node.name)
+		}
+
+		for _, child := range node.children {
+			field, ok := structType.FieldByNameFunc(func(fieldName string) bool {
+				return identifier(fieldName) == child.name
+			})
+			if !ok {
+				return fmt.Errorf("xer: unknown element <%s> in <%s>", child.name, node.name)
+			}
+			if err := xerDecode(child, v.FieldByIndex(field.Index)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("xer: unsupported type %s", v.Type())
+}
@@ -0,0 +1,39 @@
+package cdrConvert
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/free5gc/chf/cdr/cdrType"
+)
+
+// CdrToTimeStamp decodes the BCD encoded YYMMDDhhmmssShhmm timestamp
+func CdrToTimeStamp(ts cdrType.TimeStamp) (time.Time, error) {
+	if len(ts.Value) != 9 {
+		return time.Time{}, fmt.Errorf("timestamp of %d octets instead of 9", len(ts.Value))
+	}
+
+	// YY MM DD hh mm ss, then the hh mm deviation from UTC after the sign octet
+	values := make([]int, 0, 8)
+	for i, octet := range ts.Value {
+		if i == 6 {
+			continue
+		}
+		tens, units := int(octet>>4), int(octet&0x0f)
+		if tens > 9 || units > 9 {
+			return time.Time{}, fmt.Errorf("timestamp octet %d is not BCD", i)
+		}
+		values = append(values, tens*10+units)
+	}
+
+	offset := (values[6]*60 + values[7]) * 60
+	switch ts.Value[6] {
+	case '+':
+	case '-':
+		offset = -offset
+	default:
+		return time.Time{}, fmt.Errorf("timestamp sign %q is neither + nor -", ts.Value[6])
+	}
+	return time.Date(2000+values[0], time.Month(values[1]), values[2], values[3], values[4], values[5], 0,
+		time.FixedZone("", offset)), nil
+}
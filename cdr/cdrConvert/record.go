@@ -0,0 +1,50 @@
+package cdrConvert
+
+import (
+	"fmt"
+
+	"github.com/free5gc/chf/cdr/asn"
+	"github.com/free5gc/chf/cdr/cdrFile"
+	"github.com/free5gc/chf/cdr/cdrType"
+)
+
+// EncodeRecord encodes a CHF record, or a part of it, in the data record format
+func EncodeRecord(format cdrFile.DataRecordFormatType, val interface{}) ([]byte, error) {
+	switch format {
+	case cdrFile.BasicEncodingRules:
+		return asn.BerMarshalWithParams(val, "explicit,choice")
+	case cdrFile.UnalignedPackedEncodingRules:
+		return asn.PerMarshal(val, false)
+	case cdrFile.AlignedPackedEncodingRules1:
+		return asn.PerMarshal(val, true)
+	case cdrFile.XMLEncodingRules:
+		return asn.XerMarshal(val)
+	case cdrFile.JSONEncodingRules:
+		return asn.JerMarshal(val)
+	}
+	return nil, fmt.Errorf("data record format %d not supported", format)
+}
+
+// DecodeRecord decodes the CHF record encoded in the data record format
+func DecodeRecord(format cdrFile.DataRecordFormatType, b []byte) (*cdrType.CHFRecord, error) {
+	var record cdrType.CHFRecord
+	var err error
+	switch format {
+	case cdrFile.BasicEncodingRules:
+		err = asn.UnmarshalWithParams(b, &record, "explicit,choice")
+	case cdrFile.UnalignedPackedEncodingRules:
+		err = asn.PerUnmarshal(b, &record, false)
+	case cdrFile.AlignedPackedEncodingRules1:
+		err = asn.PerUnmarshal(b, &record, true)
+	case cdrFile.XMLEncodingRules:
+		err = asn.XerUnmarshal(b, &record)
+	case cdrFile.JSONEncodingRules:
+		err = asn.JerUnmarshal(b, &record)
+	default:
+		err = fmt.Errorf("data record format %d not supported", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
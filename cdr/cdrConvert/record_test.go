@@ -0,0 +1,166 @@
+package cdrConvert
+
+import (
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/free5gc/chf/cdr/asn"
+	"github.com/free5gc/chf/cdr/cdrFile"
+	"github.com/free5gc/chf/cdr/cdrType"
+)
+
+func TestEncodeRecord(t *testing.T) {
+	t.Parallel()
+
+	opening := time.Date(2026, 10, 19, 8, 30, 0, 0, time.UTC)
+	sequenceNumber := int64(3)
+	initialLost := true
+	ipv4 := asn.IA5String("10.60.0.1")
+	record := &cdrType.CHFRecord{
+		Present: cdrType.CHFRecordPresentChargingFunctionRecord,
+		ChargingFunctionRecord: &cdrType.ChargingRecord{
+			RecordType:                 cdrType.RecordType{Value: 200},
+			RecordingNetworkFunctionID: cdrType.NetworkFunctionName{Value: "CHF <&>"},
+			SubscriberIdentifier: &cdrType.SubscriptionID{
+				SubscriptionIDType: cdrType.SubscriptionIDType{Value: cdrType.SubscriptionIDTypePresentENDUSERIMSI},
+				SubscriptionIDData: asn.UTF8String("208930000000001"),
+			},
+			NFunctionConsumerInformation: cdrType.NetworkFunctionInformation{
+				NetworkFunctionality: cdrType.NetworkFunctionality{Value: 1},
+				NetworkFunctionIPv4Address: &cdrType.IPAddress{
+					Present:         3,
+					IPTextV4Address: &ipv4,
+				},
+				NetworkFunctionPLMNIdentifier: &cdrType.PLMNId{Value: asn.OctetString{0x02, 0xf8, 0x39}},
+			},
+			ListOfMultipleUnitUsage: []cdrType.MultipleUnitUsage{{
+				RatingGroup: cdrType.RatingGroupId{Value: 1},
+				UsedUnitContainers: []cdrType.UsedUnitContainer{{
+					DataTotalVolume: &cdrType.DataVolumeOctets{Value: 1 << 40},
+				}},
+			}},
+			RecordOpeningTime:       TimeStampToCdr(&opening),
+			Duration:                cdrType.CallDuration{Value: 60},
+			RecordSequenceNumber:    &sequenceNumber,
+			CauseForRecClosing:      cdrType.CauseForRecClosing{Value: 16},
+			IncompleteCDRIndication: &cdrType.IncompleteCDRIndication{InitialLost: &initialLost},
+			ChargingID:              &cdrType.ChargingID{Value: 4294967295},
+		},
+	}
+
+	for _, format := range []cdrFile.DataRecordFormatType{
+		cdrFile.BasicEncodingRules, cdrFile.UnalignedPackedEncodingRules, cdrFile.AlignedPackedEncodingRules1,
+		cdrFile.XMLEncodingRules, cdrFile.JSONEncodingRules,
+	} {
+		encoded, err := EncodeRecord(format, &record)
+		require.NoError(t, err, "format %d", format)
+		decoded, err := DecodeRecord(format, encoded)
+		require.NoError(t, err, "format %d", format)
+		require.Equal(t, record, decoded, "format %d", format)
+	}
+
+	encoded, err := EncodeRecord(cdrFile.XMLEncodingRules, &record)
+	require.NoError(t, err)
+	require.Contains(t, string(encoded), "<CHFRecord><chargingFunctionRecord><recordType>200</recordType>"+
+		"<recordingNetworkFunctionID>CHF &lt;&amp;&gt;</recordingNetworkFunctionID>")
+	require.Contains(t, string(encoded), "<networkFunctionIPv4Address><iPTextV4Address>10.60.0.1</iPTextV4Address>")
+	require.Contains(t, string(encoded), "<listOfMultipleUnitUsage><MultipleUnitUsage><ratingGroup>1</ratingGroup>")
+	require.Contains(t, string(encoded), "<initialLost><true></true></initialLost>")
+	require.Contains(t, string(encoded), "<subscriptionIDType><eND-USER-IMSI></eND-USER-IMSI></subscriptionIDType>")
+	require.Contains(t, string(encoded), "<networkFunctionality><sMF></sMF></networkFunctionality>")
+
+	encoded, err = EncodeRecord(cdrFile.JSONEncodingRules, &record)
+	require.NoError(t, err)
+	require.Contains(t, string(encoded), `"networkFunctionPLMNIdentifier":"02F839"`)
+	require.Contains(t, string(encoded), `"networkFunctionIPv4Address":{"iPTextV4Address":"10.60.0.1"}`)
+	require.Contains(t, string(encoded), `"subscriptionIDType":"eND-USER-IMSI"`)
+	require.Contains(t, string(encoded), `"networkFunctionality":"sMF"`)
+
+	// an ENUMERATED value without identifier, and an unknown identifier
+	accessType := cdrType.AccessType{Value: 2}
+	_, err = EncodeRecord(cdrFile.XMLEncodingRules, &accessType)
+	require.Error(t, err)
+	_, err = EncodeRecord(cdrFile.JSONEncodingRules, &accessType)
+	require.Error(t, err)
+	require.Error(t, asn.XerUnmarshal([]byte("<AccessType><fiveGAccess/></AccessType>"), &accessType))
+	require.NoError(t, asn.XerUnmarshal([]byte("<AccessType><nonThreeGPPAccess/></AccessType>"), &accessType))
+	require.Equal(t, cdrType.AccessTypePresentNonThreeGPPAccess, accessType.Value)
+	require.Error(t, asn.JerUnmarshal([]byte(`1`), &accessType))
+	require.NoError(t, asn.JerUnmarshal([]byte(`"threeGPPAccess"`), &accessType))
+	require.Equal(t, cdrType.AccessTypePresentThreeGPPAccess, accessType.Value)
+
+	ber, err := EncodeRecord(cdrFile.BasicEncodingRules, &record)
+	require.NoError(t, err)
+	per, err := EncodeRecord(cdrFile.UnalignedPackedEncodingRules, &record)
+	require.NoError(t, err)
+	require.Less(t, len(per), len(ber))
+
+	_, err = EncodeRecord(cdrFile.DataRecordFormatType(0), &record)
+	require.Error(t, err)
+}
+
+// TestEncodeRecordVector checks the PER encodings of a ChargingRecord against vectors derived by hand per X.691
+// from the TS 32.298 module, no ASN.1 compiler being at hand. The CHFRecord CHOICE has one alternative, without
+// index. The ChargingRecord SET starts with the bitmap of its 22 OPTIONAL components, then:
+//   - recordType 200 and the other unconstrained INTEGERs: a length octet and the 2's complement octets
+//   - recordingNetworkFunctionID "CHF": a length octet and 7 bit (UPER) or 8 bit (APER) characters
+//   - subscriberIdentifier: the ENUMERATED eND-USER-IMSI in 3 bits, the UTF8String length and octets
+//   - nFunctionConsumerInformation: the bitmap of its 5 OPTIONAL components, the ENUMERATED sMF in 4 bits
+//   - recordOpeningTime: the 9 octets, without length
+//   - diagnostics: the alternative index 6 in 3 bits, the UnauthorizedLCSClient-Diagnostic extension bit and
+//     the normally small index 1 of the extension addition unauthorizedCallSessionUnrelatedExternalClient
+//   - chargingID (0..4294967295): 32 bits (UPER), or the number of octets less one in 2 bits then the octets
+//
+// APER aligns the lengths, the octets and the characters of the unbounded strings on octets.
+func TestEncodeRecordVector(t *testing.T) {
+	t.Parallel()
+
+	unauthorizedLCSClientCause := cdrType.UnauthorizedLCSClientDiagnostic{
+		Value: cdrType.UnauthorizedLCSClientDiagnosticPresentUnauthorizedCallSessionUnrelatedExternalClient,
+	}
+	record := &cdrType.CHFRecord{
+		Present: cdrType.CHFRecordPresentChargingFunctionRecord,
+		ChargingFunctionRecord: &cdrType.ChargingRecord{
+			RecordType:                 cdrType.RecordType{Value: 200},
+			RecordingNetworkFunctionID: cdrType.NetworkFunctionName{Value: "CHF"},
+			SubscriberIdentifier: &cdrType.SubscriptionID{
+				SubscriptionIDType: cdrType.SubscriptionIDType{Value: cdrType.SubscriptionIDTypePresentENDUSERIMSI},
+				SubscriptionIDData: asn.UTF8String("208930000000001"),
+			},
+			NFunctionConsumerInformation: cdrType.NetworkFunctionInformation{
+				NetworkFunctionality: cdrType.NetworkFunctionality{Value: cdrType.NetworkFunctionalityPresentSMF},
+			},
+			RecordOpeningTime:  cdrType.TimeStamp{Value: asn.OctetString{0x26, 0x10, 0x19, 0x08, 0x30, 0x00, '+', 0, 0}},
+			Duration:           cdrType.CallDuration{Value: 60},
+			CauseForRecClosing: cdrType.CauseForRecClosing{Value: 0},
+			Diagnostics: &cdrType.Diagnostics{
+				Present:                    cdrType.DiagnosticsPresentUnauthorizedLCSClientCause,
+				UnauthorizedLCSClientCause: &unauthorizedLCSClientCause,
+			},
+			ChargingID: &cdrType.ChargingID{Value: 1},
+		},
+	}
+
+	for _, tc := range []struct {
+		format  cdrFile.DataRecordFormatType
+		encoded string
+	}{
+		{cdrFile.UnalignedPackedEncodingRules, "8800040803200e1c88c43cc8c0e0e4ccc0c0c0c0c0c0c0c0c0c4024c203210600056" +
+			"000002780201a04000000040"},
+		{cdrFile.AlignedPackedEncodingRules1, "8800040200c803434846200f3230383933303030303030303030310080261019" +
+			"0830002b0000013c0100d02001"},
+	} {
+		encoded, err := EncodeRecord(tc.format, &record)
+		require.NoError(t, err, "format %d", tc.format)
+		require.Equal(t, tc.encoded, hex.EncodeToString(encoded), "format %d", tc.format)
+
+		vector, err := hex.DecodeString(tc.encoded)
+		require.NoError(t, err)
+		decoded, err := DecodeRecord(tc.format, vector)
+		require.NoError(t, err, "format %d", tc.format)
+		require.Equal(t, record, decoded, "format %d", tc.format)
+	}
+}
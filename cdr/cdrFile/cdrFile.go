@@ -1,10 +1,13 @@
 package cdrFile
 
 import (
-	"bytes"
+	"bufio"
 	"encoding/binary"
-	"fmt"
+	"errors"
+	"io"
+	"net"
 	"os"
+	"time"
 )
 
 type CDRFile struct {
@@ -89,6 +92,15 @@ const (
 	BeyondRel9
 )
 
+// ReleaseIdentifiers returns the release identifier of the 3GPP release from Rel-4 on, with its extension
+// beyond Rel-9
+func ReleaseIdentifiers(release uint8) (ReleaseIdentifierType, uint8) {
+	if release < 10 {
+		return Rel4 + ReleaseIdentifierType(release-4), 0
+	}
+	return BeyondRel9, release - 10
+}
+
 type DataRecordFormatType uint8
 
 const (
@@ -96,6 +108,8 @@ const (
 	UnalignedPackedEncodingRules
 	AlignedPackedEncodingRules1
 	XMLEncodingRules
+	// JSONEncodingRules is not defined by TS 32.297, the records are encoded per X.697
+	JSONEncodingRules
 )
 
 type TsNumberIdentifier uint8
@@ -125,301 +139,64 @@ const (
 	TS32256 TsNumberIdentifier = 22
 	TS28201 TsNumberIdentifier = 23
 	TS28202 TsNumberIdentifier = 24
+	TS32298 TsNumberIdentifier = 31
 )
 
-func (cdrf CdrFileHeader) Encoding() []byte {
-	buf := new(bytes.Buffer)
-
-	// File length
-	if err := binary.Write(buf, binary.BigEndian, cdrf.FileLength); err != nil {
-		fmt.Println("CdrFileHeader File length failed:", err)
-	}
-
-	// Header length
-	if err := binary.Write(buf, binary.BigEndian, cdrf.HeaderLength); err != nil {
-		fmt.Println("CdrFileHeader Header length failed:", err)
-	}
-
-	// High release / version identifier
-
-	// if cdrf.HighReleaseIdentifier == 7 {
-	// var highIdentifier uint8 =
-	//  (cdrf.HighReleaseIdentifier+cdrf.HighReleaseIdentifierExtension+1)*100 + cdrf.HighVersionIdentifier
-	// 	if err := binary.Write(buf, binary.BigEndian, highIdentifier); err != nil {
-	// 		fmt.Println("binary.Write failed:", err)
-	// 	}
-	// } else {
-	// 	var highIdentifier uint8 = cdrf.HighReleaseIdentifier*100 + cdrf.HighVersionIdentifier
-	// 	if err := binary.Write(buf, binary.BigEndian, highIdentifier); err != nil {
-	// 		fmt.Println("binary.Write failed:", err)
-	// 	}
-	// }
-
-	var highIdentifier uint8 = (cdrf.HighReleaseIdentifier << 5) | cdrf.HighVersionIdentifier
-
-	if err := binary.Write(buf, binary.BigEndian, highIdentifier); err != nil {
-		fmt.Println("CdrFileHeader highIdentifier failed:", err)
-	}
-
-	// Low release / version identifier
-
-	// if cdrf.LowReleaseIdentifier == 7 {
-	// 	var lowIdentifier uint8 =
-	//   (cdrf.LowReleaseIdentifier+cdrf.LowReleaseIdentifierExtension+1)*100 + cdrf.LowVersionIdentifier
-	// 	if err := binary.Write(buf, binary.BigEndian, lowIdentifier); err != nil {
-	// 		fmt.Println("binary.Write failed:", err)
-	// 	}
-	// } else {
-	// 	var lowIdentifier uint8 = cdrf.LowReleaseIdentifier*100 + cdrf.LowVersionIdentifier
-	// 	if err := binary.Write(buf, binary.BigEndian, lowIdentifier); err != nil {
-	// 		fmt.Println("binary.Write failed:", err)
-	// 	}
-	// }
-
-	var lowIdentifier uint8 = (cdrf.LowReleaseIdentifier << 5) | cdrf.LowVersionIdentifier
-
-	if err := binary.Write(buf, binary.BigEndian, lowIdentifier); err != nil {
-		fmt.Println("CdrFileHeader lowIdentifier failed:", err)
-	}
-
-	// File opening timestamp
-	// _, offset := cdrf.FileOpeningTimestamp.Zone()
-	// sign := 0
-	// offsetHour := -offset/3600
-	// offsetMin := -offset/60%60
-
-	// if offset >= 0 {
-	// 	sign = 1
-	// 	offsetHour = offset/3600
-	// 	offsetMin = offset/60%60
-	// }
-
-	// var ts uint32 = uint32(cdrf.FileOpeningTimestamp.Month())<<28 |
-	// 	uint32(cdrf.FileOpeningTimestamp.Day())<<23 |
-	// 	uint32(cdrf.FileOpeningTimestamp.Hour())<<18 |
-	// 	uint32(cdrf.FileOpeningTimestamp.Minute())<<12 |
-	// 	uint32(sign)<<11 |
-	// 	uint32(offsetHour)<<6 |
-	// 	uint32(offsetMin)
-
-	var ts uint32 = uint32(cdrf.FileOpeningTimestamp.MonthLocal)<<28 |
-		uint32(cdrf.FileOpeningTimestamp.DateLocal)<<23 |
-		uint32(cdrf.FileOpeningTimestamp.HourLocal)<<18 |
-		uint32(cdrf.FileOpeningTimestamp.MinuteLocal)<<12 |
-		uint32(cdrf.FileOpeningTimestamp.SignOfTheLocalTimeDifferentialFromUtc)<<11 |
-		uint32(cdrf.FileOpeningTimestamp.HourDeviation)<<6 |
-		uint32(cdrf.FileOpeningTimestamp.MinuteDeviation)
-
-	if err := binary.Write(buf, binary.BigEndian, ts); err != nil {
-		fmt.Println("CdrFileHeader File opening timestamp failed:", err)
-	}
-
-	// Timestamp when last CDR was appended to file
-	// _, offset = cdrf.TimestampWhenLastCdrWasAppendedToFIle.Zone()
-	// sign = 0
-	// offsetHour = -offset/3600
-	// offsetMin = -offset/60%60
-
-	// if offset >= 0 {
-	// 	sign = 1
-	// 	offsetHour = offset/3600
-	// 	offsetMin = offset/60%60
-	// }
-
-	// ts  = uint32(cdrf.TimestampWhenLastCdrWasAppendedToFIle.Month())<<28 |
-	// 	uint32(cdrf.TimestampWhenLastCdrWasAppendedToFIle.Day())<<23 |
-	// 	uint32(cdrf.TimestampWhenLastCdrWasAppendedToFIle.Hour())<<18 |
-	// 	uint32(cdrf.TimestampWhenLastCdrWasAppendedToFIle.Minute())<<12 |
-	// 	uint32(sign)<<11 |
-	// 	uint32(offsetHour)<<6 |
-	// 	uint32(offsetMin)
-	ts = uint32(cdrf.TimestampWhenLastCdrWasAppendedToFIle.MonthLocal)<<28 |
-		uint32(cdrf.TimestampWhenLastCdrWasAppendedToFIle.DateLocal)<<23 |
-		uint32(cdrf.TimestampWhenLastCdrWasAppendedToFIle.HourLocal)<<18 |
-		uint32(cdrf.TimestampWhenLastCdrWasAppendedToFIle.MinuteLocal)<<12 |
-		uint32(cdrf.TimestampWhenLastCdrWasAppendedToFIle.SignOfTheLocalTimeDifferentialFromUtc)<<11 |
-		uint32(cdrf.TimestampWhenLastCdrWasAppendedToFIle.HourDeviation)<<6 |
-		uint32(cdrf.TimestampWhenLastCdrWasAppendedToFIle.MinuteDeviation)
-
-	if err := binary.Write(buf, binary.BigEndian, ts); err != nil {
-		fmt.Println("CdrFileHeader failed:", err)
-	}
-
-	// Number of CDRs in file
-	if err := binary.Write(buf, binary.BigEndian, cdrf.NumberOfCdrsInFile); err != nil {
-		fmt.Println("CdrFileHeader failed:", err)
-	}
-
-	// File sequence number
-	if err := binary.Write(buf, binary.BigEndian, cdrf.FileSequenceNumber); err != nil {
-		fmt.Println("CdrFileHeader failed:", err)
-	}
-
-	// File closure trigger reason
-	if err := binary.Write(buf, binary.BigEndian, cdrf.FileClosureTriggerReason); err != nil {
-		fmt.Println("CdrFileHeader failed:", err)
-	}
-
-	// Node IP address
-	if err := binary.Write(buf, binary.BigEndian, cdrf.IpAddressOfNodeThatGeneratedFile); err != nil {
-		fmt.Println("CdrFileHeader failed:", err)
-	}
-
-	// Lost CDR indicator
-	if err := binary.Write(buf, binary.BigEndian, cdrf.LostCdrIndicator); err != nil {
-		fmt.Println("CdrFileHeader failed:", err)
-	}
-
-	// Length of CDR routeing filter
-	if err := binary.Write(buf, binary.BigEndian, cdrf.LengthOfCdrRouteingFilter); err != nil {
-		fmt.Println("CdrFileHeader failed:", err)
-	}
-
-	// CDR routeing filter
-	if err := binary.Write(buf, binary.BigEndian, cdrf.CDRRouteingFilter); err != nil {
-		fmt.Println("CdrFileHeader failed:", err)
-	}
-
-	// Length of private extension
-	if err := binary.Write(buf, binary.BigEndian, cdrf.LengthOfPrivateExtension); err != nil {
-		fmt.Println("CdrFileHeader failed:", err)
-	}
-
-	// Private extension
-	if err := binary.Write(buf, binary.BigEndian, cdrf.PrivateExtension); err != nil {
-		fmt.Println("CdrFileHeader failed:", err)
-	}
-
-	// "High Release Identifier" extension
-	if cdrf.HighReleaseIdentifier == 7 {
-		if err := binary.Write(buf, binary.BigEndian, cdrf.HighReleaseIdentifierExtension); err != nil {
-			fmt.Println("CdrFileHeader failed:", err)
-		}
-	}
-
-	// "Low Release Identifier" extension
-	if cdrf.LowReleaseIdentifier == 7 {
-		if err := binary.Write(buf, binary.BigEndian, cdrf.LowReleaseIdentifierExtension); err != nil {
-			fmt.Println("CdrFileHeader failed:", err)
-		}
-	}
-
-	// fmt.Printf("Encoded: % b\n", buf.Bytes())
-	// fmt.Printf("%#v\n", sign)
-	// fmt.Printf("%#v\n", offsetHour)
-	// fmt.Printf("%#v\n", offsetMin)
-	// fmt.Printf("%#v\n", cdrf.FileOpeningTimestamp)
-	// fmt.Printf("%#v", cdrf.FileOpeningTimestamp.UTC().Sub(*cdrf.FileOpeningTimestamp).Hours())
-
-	// Check
-	if cdrf.HeaderLength != uint32(len(buf.Bytes())) && cdrf.HeaderLength != 0xffffffff {
-		fmt.Println("[Encoding Warning]HeaderLength field of CdfFile Header not equals to the length of CdfFile Header.")
-		fmt.Println("\tExpected", uint32(len(buf.Bytes())), "Get", cdrf.HeaderLength)
-	}
-
-	return buf.Bytes()
+// NewCdrHdrTimeStamp returns the header timestamp of t, in the local time of t
+func NewCdrHdrTimeStamp(t time.Time) CdrHdrTimeStamp {
+	_, offset := t.Zone()
+	ts := CdrHdrTimeStamp{
+		MonthLocal:  uint8(t.Month()),
+		DateLocal:   uint8(t.Day()),
+		HourLocal:   uint8(t.Hour()),
+		MinuteLocal: uint8(t.Minute()),
+	}
+	if offset >= 0 {
+		ts.SignOfTheLocalTimeDifferentialFromUtc = 1
+	} else {
+		offset = -offset
+	}
+	ts.HourDeviation = uint8(offset / 3600)
+	ts.MinuteDeviation = uint8(offset / 60 % 60)
+	return ts
 }
 
-func (header CdrHeader) Encoding() []byte {
-	buf := new(bytes.Buffer)
-
-	// CDR length
-	if err := binary.Write(buf, binary.BigEndian, header.CdrLength); err != nil {
-		fmt.Println("CdrHeader failed:", err)
+// NodeAddress returns the header address of the node, IPv4 addresses being mapped to IPv6.
+// The octets beyond the IPv6 address are set to 0xFF.
+func NodeAddress(ip net.IP) [20]byte {
+	address := [20]byte{16: 0xff, 17: 0xff, 18: 0xff, 19: 0xff}
+	if ip16 := ip.To16(); ip16 != nil {
+		copy(address[:], ip16)
 	}
-
-	// Release/Version Identifier
-	var identifier uint8 = uint8(header.ReleaseIdentifier)<<5 |
-		uint8(header.VersionIdentifier)
-
-	if err := binary.Write(buf, binary.BigEndian, identifier); err != nil {
-		fmt.Println("CdrHeader failed:", err)
-	}
-
-	// Data Record Format / TS number
-	var oct4 uint8 = uint8(header.DataRecordFormat)<<5 | uint8(header.TsNumber)
-
-	if err := binary.Write(buf, binary.BigEndian, oct4); err != nil {
-		fmt.Println("CdrHeader failed:", err)
-	}
-
-	// Release Identifier extension
-	if header.ReleaseIdentifier == 7 {
-		if err := binary.Write(buf, binary.BigEndian, header.ReleaseIdentifierExtension); err != nil {
-			fmt.Println("CdrHeader failed:", err)
-		}
-	}
-
-	// fmt.Printf("Encoded: % b\n", buf.Bytes())
-	return buf.Bytes()
+	return address
 }
 
-func (cdfFile CDRFile) Encoding(fileName string) {
-	buf := new(bytes.Buffer)
-
-	// Cdr File Header
-	bufCdrFileHeader := cdfFile.Hdr.Encoding()
-	if err := binary.Write(buf, binary.BigEndian, bufCdrFileHeader); err != nil {
-		fmt.Println("CDRFile failed:", err)
-	}
-
-	for i, cdr := range cdfFile.CdrList {
-		bufCdrHeader := cdr.Hdr.Encoding()
-		if err := binary.Write(buf, binary.BigEndian, bufCdrHeader); err != nil {
-			fmt.Println("CDRFile failed:", err)
-		}
-
-		if err := binary.Write(buf, binary.BigEndian, cdr.CdrByte); err != nil {
-			fmt.Println("CDRFile failed:", err)
-		}
-
-		if len(cdr.CdrByte) != int(cdr.Hdr.CdrLength) {
-			fmt.Println("[Encoding Warning]CdrLength field of cdr", i, "header not equals to the length of encoding cdr", i)
-			fmt.Println("\tExpected", len(cdr.CdrByte), "Get", int(cdr.Hdr.CdrLength))
-		}
-	}
-
-	// Check
-	if cdfFile.Hdr.FileLength != uint32(len(buf.Bytes())) && cdfFile.Hdr.FileLength != 0xffffffff {
-		fmt.Println("[Encoding Warning]FileLength field of CdfFile Header not equals to the length of encoding file.")
-		fmt.Println("\tExpected", uint32(len(buf.Bytes())), "Get", cdfFile.Hdr.FileLength)
-	}
-
-	// fmt.Printf("Encoded: %b\n", buf.Bytes())
-	err := os.WriteFile(fileName, buf.Bytes(), 0o666)
-	if err != nil {
-		panic(err)
+// NewLostCdrIndicator returns the lost CDR indicator of the number of CDRs lost, saturated at 127
+func NewLostCdrIndicator(lost uint32) uint8 {
+	if lost == 0 {
+		return 0
 	}
+	return 0x80 | uint8(min(lost, 0x7f))
 }
 
-func (cdfFile *CDRFile) Decoding(fileName string) {
-	data, err := os.ReadFile(fileName)
-	if err != nil {
-		panic(err)
-	}
+// fileHeaderFixedLength is the length of the file header up to the CDR routeing filter
+const fileHeaderFixedLength = 50
 
-	// fileLength := binary.BigEndian.Uint32(data[0:4])
+// UnknownFileLength is the file length of a file being written
+const UnknownFileLength = 0xffffffff
 
-	// File opening timestamp
-	ts := binary.BigEndian.Uint32(data[10:14])
-	// month := ts >> 28
-	// date := int((ts >> 23) & 0b11111)
-	// hour := int((ts >> 18) & 0b11111)
-	// minute := int((ts >> 12) & 0b111111)
-	// sign := (ts >> 11) & 0b1
-	// hourDeviation := (ts >> 6) & 0b11111
-	// minuteDeviation := ts & 0b111111
+func (ts CdrHdrTimeStamp) encoding() uint32 {
+	return uint32(ts.MonthLocal)<<28 |
+		uint32(ts.DateLocal)<<23 |
+		uint32(ts.HourLocal)<<18 |
+		uint32(ts.MinuteLocal)<<12 |
+		uint32(ts.SignOfTheLocalTimeDifferentialFromUtc)<<11 |
+		uint32(ts.HourDeviation)<<6 |
+		uint32(ts.MinuteDeviation)
+}
 
-	// var offset int
-	// if sign == 1 {
-	// 	offset = int(hourDeviation*3600 + minuteDeviation*60)
-	// } else if sign == 0 {
-	// 	offset = int(hourDeviation*3600 + minuteDeviation*60) * -1
-	// }
-	// loc := time.FixedZone("", offset)
-	// // The year is temporarily set to the current year
-	// fileOpeningTimestamp := time.Date(time.Now().Year(), time.Month(month), date, hour, minute, 0, 0, loc)
-	fileOpeningTimestamp := CdrHdrTimeStamp{
+func decodeCdrHdrTimeStamp(ts uint32) CdrHdrTimeStamp {
+	return CdrHdrTimeStamp{
 		MonthLocal:                            uint8(ts >> 28),
 		DateLocal:                             uint8((ts >> 23) & 0b11111),
 		HourLocal:                             uint8((ts >> 18) & 0b11111),
@@ -428,158 +205,102 @@ func (cdfFile *CDRFile) Decoding(fileName string) {
 		HourDeviation:                         uint8((ts >> 6) & 0b11111),
 		MinuteDeviation:                       uint8(ts & 0b111111),
 	}
+}
 
-	// Last CDR append timestamp
-	ts = binary.BigEndian.Uint32(data[14:18])
-	// month = ts >> 28
-	// date = int((ts >> 23) & 0b11111)
-	// hour = int((ts >> 18) & 0b11111)
-	// minute = int((ts >> 12) & 0b111111)
-	// sign = (ts >> 11) & 0b1
-	// hourDeviation = (ts >> 6) & 0b11111
-	// minuteDeviation = ts & 0b111111
+// Encoding returns the TS 32.297 6.1.1 encoding of the file header, its lengths are not checked
+func (cdrf CdrFileHeader) Encoding() []byte {
+	buf := make([]byte, 0, fileHeaderFixedLength+len(cdrf.CDRRouteingFilter)+len(cdrf.PrivateExtension)+4)
+	buf = binary.BigEndian.AppendUint32(buf, cdrf.FileLength)
+	buf = binary.BigEndian.AppendUint32(buf, cdrf.HeaderLength)
+	buf = append(buf,
+		cdrf.HighReleaseIdentifier<<5|cdrf.HighVersionIdentifier,
+		cdrf.LowReleaseIdentifier<<5|cdrf.LowVersionIdentifier)
+	buf = binary.BigEndian.AppendUint32(buf, cdrf.FileOpeningTimestamp.encoding())
+	buf = binary.BigEndian.AppendUint32(buf, cdrf.TimestampWhenLastCdrWasAppendedToFIle.encoding())
+	buf = binary.BigEndian.AppendUint32(buf, cdrf.NumberOfCdrsInFile)
+	buf = binary.BigEndian.AppendUint32(buf, cdrf.FileSequenceNumber)
+	buf = append(buf, uint8(cdrf.FileClosureTriggerReason))
+	buf = append(buf, cdrf.IpAddressOfNodeThatGeneratedFile[:]...)
+	buf = append(buf, cdrf.LostCdrIndicator)
+	buf = binary.BigEndian.AppendUint16(buf, cdrf.LengthOfCdrRouteingFilter)
+	buf = append(buf, cdrf.CDRRouteingFilter...)
+	buf = binary.BigEndian.AppendUint16(buf, cdrf.LengthOfPrivateExtension)
+	buf = append(buf, cdrf.PrivateExtension...)
+	if cdrf.HighReleaseIdentifier == uint8(BeyondRel9) {
+		buf = append(buf, cdrf.HighReleaseIdentifierExtension)
+	}
+	if cdrf.LowReleaseIdentifier == uint8(BeyondRel9) {
+		buf = append(buf, cdrf.LowReleaseIdentifierExtension)
+	}
+	return buf
+}
 
-	// if sign == 1 {
-	// 	offset = int(hourDeviation*3600 + minuteDeviation*60)
-	// } else if sign == 0 {
-	// 	offset = int(hourDeviation*3600 + minuteDeviation*60) * -1
-	// }
-	// loc = time.FixedZone("", offset)
-	// // The year is temporarily set to the current year
-	// lastCDRAppendTimestamp := time.Date(time.Now().Year(), time.Month(month), date, hour, minute, 0, 0, loc)
-	// fmt.Println(fileLength)
+// Encoding returns the TS 32.297 6.1.2 encoding of the CDR header, its length is not checked
+func (header CdrHeader) Encoding() []byte {
+	buf := binary.BigEndian.AppendUint16(make([]byte, 0, 5), header.CdrLength)
+	buf = append(buf,
+		uint8(header.ReleaseIdentifier)<<5|header.VersionIdentifier,
+		uint8(header.DataRecordFormat)<<5|uint8(header.TsNumber))
+	if header.ReleaseIdentifier == BeyondRel9 {
+		buf = append(buf, header.ReleaseIdentifierExtension)
+	}
+	return buf
+}
 
-	lastCDRAppendTimestamp := CdrHdrTimeStamp{
-		MonthLocal:                            uint8(ts >> 28),
-		DateLocal:                             uint8((ts >> 23) & 0b11111),
-		HourLocal:                             uint8((ts >> 18) & 0b11111),
-		MinuteLocal:                           uint8((ts >> 12) & 0b111111),
-		SignOfTheLocalTimeDifferentialFromUtc: uint8((ts >> 11) & 0b1),
-		HourDeviation:                         uint8((ts >> 6) & 0b11111),
-		MinuteDeviation:                       uint8(ts & 0b111111),
+// Encoding writes the CDR file to fileName, once its header and CDR lengths are checked
+func (cdfFile CDRFile) Encoding(fileName string) (err error) {
+	file, err := os.Create(fileName)
+	if err != nil {
+		return err
 	}
+	defer func() {
+		if errClose := file.Close(); err == nil {
+			err = errClose
+		}
+	}()
 
-	// Length
-	numberOfCdrsInFile := binary.BigEndian.Uint32(data[18:22])
-	lengthOfCdrRouteingFilter := binary.BigEndian.Uint16(data[48:50])
-	xy := 50 + lengthOfCdrRouteingFilter
-	LengthOfPrivateExtension := binary.BigEndian.Uint16(data[xy : xy+2])
-	n := xy + 2 + LengthOfPrivateExtension
-
-	// ip
-	var IpAddressOfNodeThatGeneratedFile [20]byte
-	copy(IpAddressOfNodeThatGeneratedFile[:], data[27:47])
-
-	cdfFile.Hdr = CdrFileHeader{
-		FileLength:                            binary.BigEndian.Uint32(data[0:4]),
-		HeaderLength:                          binary.BigEndian.Uint32(data[4:8]),
-		HighReleaseIdentifier:                 data[8] >> 5,
-		HighVersionIdentifier:                 data[8] & 0b11111,
-		LowReleaseIdentifier:                  data[9] >> 5,
-		LowVersionIdentifier:                  data[9] & 0b11111,
-		FileOpeningTimestamp:                  fileOpeningTimestamp,
-		TimestampWhenLastCdrWasAppendedToFIle: lastCDRAppendTimestamp,
-		NumberOfCdrsInFile:                    numberOfCdrsInFile,
-		FileSequenceNumber:                    binary.BigEndian.Uint32(data[22:26]),
-		FileClosureTriggerReason:              FileClosureTriggerReasonType(data[26]),
-		IpAddressOfNodeThatGeneratedFile:      IpAddressOfNodeThatGeneratedFile,
-		LostCdrIndicator:                      data[47],
-		LengthOfCdrRouteingFilter:             lengthOfCdrRouteingFilter,
-		CDRRouteingFilter:                     data[50:xy],
-		LengthOfPrivateExtension:              LengthOfPrivateExtension,
-		PrivateExtension:                      data[xy+2 : n],
+	buf := bufio.NewWriter(file)
+	w, err := NewWriter(buf, cdfFile.Hdr)
+	if err != nil {
+		return err
 	}
-
-	tail := uint32(n)
-
-	if cdfFile.Hdr.HighReleaseIdentifier == 7 {
-		cdfFile.Hdr.HighReleaseIdentifierExtension = data[n]
-		tail++
+	for _, cdr := range cdfFile.CdrList {
+		if err = w.WriteCdr(cdr); err != nil {
+			return err
+		}
 	}
-	if cdfFile.Hdr.LowReleaseIdentifier == 7 {
-		cdfFile.Hdr.LowReleaseIdentifierExtension = data[n+1]
-		tail++
+	if err = w.Close(); err != nil {
+		return err
 	}
+	return buf.Flush()
+}
 
-	// fmt.Println("[Decode]cdrfileheader:\n", cdfFile.Hdr)
-
-	for i := 1; i <= int(numberOfCdrsInFile); i++ {
-		cdrLength := binary.BigEndian.Uint16(data[tail : tail+2])
-		releaseIdentifier := ReleaseIdentifierType(data[tail+2] >> 5)
-		expectedLength := int(tail) + 4 + int(cdrLength)
-		if releaseIdentifier == 7 {
-			expectedLength += 1
-		}
-		if len(data) < expectedLength {
-			fmt.Println("[Decoding Error]Length of cdrfile is wrong. cdr:", i)
-		}
-
-		cdrHeader := CdrHeader{
-			CdrLength:         cdrLength,
-			ReleaseIdentifier: ReleaseIdentifierType(data[tail+2] >> 5),
-			VersionIdentifier: data[tail+2] & 0b11111,
-			DataRecordFormat:  DataRecordFormatType(data[tail+3] >> 5),
-			TsNumber:          TsNumberIdentifier(data[tail+3] & 0b11111),
+// Decoding reads the CDR file fileName
+func (cdfFile *CDRFile) Decoding(fileName string) (err error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if errClose := file.Close(); err == nil {
+			err = errClose
 		}
+	}()
 
-		i := uint32(4)
-		if cdrHeader.ReleaseIdentifier == 7 {
-			cdrHeader.ReleaseIdentifierExtension = data[tail+4]
-			i++
+	r, err := NewReader(bufio.NewReader(file))
+	if err != nil {
+		return err
+	}
+	cdfFile.Hdr = r.Header()
+	cdfFile.CdrList = nil
+	for {
+		cdr, errNext := r.Next()
+		if errors.Is(errNext, io.EOF) {
+			return nil
 		}
-
-		cdr := CDR{
-			Hdr:     cdrHeader,
-			CdrByte: data[tail+i : tail+i+uint32(cdrLength)],
+		if errNext != nil {
+			return errNext
 		}
-		cdfFile.CdrList = append(cdfFile.CdrList, cdr)
-		tail += i + uint32(cdrLength)
+		cdfFile.CdrList = append(cdfFile.CdrList, *cdr)
 	}
-	// fmt.Println("[Decode]cdrfile:\n", cdfFile)
-	// fmt.Printf("%#v\n", cdfFile)
 }
-
-// func main() {
-// 	loc, _ := time.LoadLocation("Asia/Kolkata")
-// 	timeNow := time.Now().In(loc)
-// 	// timeNow := time.Now()
-// 	cdrf := CdrFileHeader{
-// 		FileLength:                            5,
-// 		HeaderLength:                          6,
-// 		HighReleaseIdentifier:                 2,
-// 		HighVersionIdentifier:                 3,
-// 		LowReleaseIdentifier:                  4,
-// 		LowVersionIdentifier:                  5,
-// 		FileOpeningTimestamp:                  &timeNow,
-// 		TimestampWhenLastCdrWasAppendedToFIle: &timeNow,
-// 		NumberOfCdrsInFile:                    1,
-// 		FileSequenceNumber:                    11,
-// 		FileClosureTriggerReason:              4,
-// 		//IpAddressOfNodeThatGeneratedFile      [20]byte(),
-// 		LostCdrIndicator:          4,
-// 		LengthOfCdrRouteingFilter: 4,
-// 		CDRRouteingFilter:                     []byte("abcd"),
-// 		LengthOfPrivateExtension: 5,
-// 		PrivateExtension:                      []byte("fghjk"), // vendor specific
-// 		HighReleaseIdentifierExtension: 2,
-// 		LowReleaseIdentifierExtension:  3,
-// 	}
-
-// 	cdrHeader := CdrHeader {
-// 		CdrLength                  :3,
-// 		ReleaseIdentifier          :Rel6, // octet 3 bit 6..8
-// 		VersionIdentifier          :3,                // otcet 3 bit 1..5
-// 		DataRecordFormat           :UnalignedPackedEncodingRules,  // octet 4 bit 6..8
-// 		TsNumber                   : TS32253,   // octet 4 bit 1..5
-// 		ReleaseIdentifierExtension :4,
-// 	}
-
-// 	cdrFile := CDRFile{
-// 		hdr: cdrf,
-// 		cdrList: []CDR{{hdr:cdrHeader, cdrByte:[]byte("abc")},},
-// 	}
-
-// 	cdrFile.Encoding()
-// 	cdrFile = CDRFile{}
-// 	cdrFile.Decoding("encoding.txt")
-// }
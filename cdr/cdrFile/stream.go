@@ -0,0 +1,249 @@
+package cdrFile
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrTruncated is returned when a CDR file ends before the lengths of its headers
+var ErrTruncated = errors.New("truncated CDR file")
+
+// LengthError reports a length field of a header inconsistent with the content of the file
+type LengthError struct {
+	Field  string
+	Value  uint64
+	Actual uint64
+}
+
+func (e *LengthError) Error() string {
+	return fmt.Sprintf("inconsistent %s: %d in header, %d actually", e.Field, e.Value, e.Actual)
+}
+
+// CdrError locates the error in a CDR of the file, counted from 1
+type CdrError struct {
+	Index uint32
+	Err   error
+}
+
+func (e *CdrError) Error() string {
+	return fmt.Sprintf("CDR %d: %v", e.Index, e.Err)
+}
+
+func (e *CdrError) Unwrap() error {
+	return e.Err
+}
+
+// Reader reads a CDR file one CDR at a time
+type Reader struct {
+	r      io.Reader
+	hdr    CdrFileHeader
+	read   uint32
+	offset uint64
+}
+
+// NewReader reads the file header from r and checks its lengths
+func NewReader(r io.Reader) (*Reader, error) {
+	reader := &Reader{r: r}
+
+	fixed, err := reader.readFull(fileHeaderFixedLength)
+	if err != nil {
+		return nil, err
+	}
+	hdr := CdrFileHeader{
+		FileLength:                            binary.BigEndian.Uint32(fixed[0:4]),
+		HeaderLength:                          binary.BigEndian.Uint32(fixed[4:8]),
+		HighReleaseIdentifier:                 fixed[8] >> 5,
+		HighVersionIdentifier:                 fixed[8] & 0b11111,
+		LowReleaseIdentifier:                  fixed[9] >> 5,
+		LowVersionIdentifier:                  fixed[9] & 0b11111,
+		FileOpeningTimestamp:                  decodeCdrHdrTimeStamp(binary.BigEndian.Uint32(fixed[10:14])),
+		TimestampWhenLastCdrWasAppendedToFIle: decodeCdrHdrTimeStamp(binary.BigEndian.Uint32(fixed[14:18])),
+		NumberOfCdrsInFile:                    binary.BigEndian.Uint32(fixed[18:22]),
+		FileSequenceNumber:                    binary.BigEndian.Uint32(fixed[22:26]),
+		FileClosureTriggerReason:              FileClosureTriggerReasonType(fixed[26]),
+		LostCdrIndicator:                      fixed[47],
+		LengthOfCdrRouteingFilter:             binary.BigEndian.Uint16(fixed[48:50]),
+	}
+	copy(hdr.IpAddressOfNodeThatGeneratedFile[:], fixed[27:47])
+
+	if hdr.CDRRouteingFilter, err = reader.readFull(int(hdr.LengthOfCdrRouteingFilter)); err != nil {
+		return nil, err
+	}
+	length, err := reader.readFull(2)
+	if err != nil {
+		return nil, err
+	}
+	hdr.LengthOfPrivateExtension = binary.BigEndian.Uint16(length)
+	if hdr.PrivateExtension, err = reader.readFull(int(hdr.LengthOfPrivateExtension)); err != nil {
+		return nil, err
+	}
+	if hdr.HighReleaseIdentifier == uint8(BeyondRel9) {
+		if hdr.HighReleaseIdentifierExtension, err = reader.readByte(); err != nil {
+			return nil, err
+		}
+	}
+	if hdr.LowReleaseIdentifier == uint8(BeyondRel9) {
+		if hdr.LowReleaseIdentifierExtension, err = reader.readByte(); err != nil {
+			return nil, err
+		}
+	}
+
+	if uint64(hdr.HeaderLength) != reader.offset {
+		return nil, &LengthError{Field: "HeaderLength", Value: uint64(hdr.HeaderLength), Actual: reader.offset}
+	}
+	if hdr.FileLength != UnknownFileLength && hdr.FileLength < hdr.HeaderLength {
+		return nil, &LengthError{Field: "FileLength", Value: uint64(hdr.FileLength), Actual: reader.offset}
+	}
+	reader.hdr = hdr
+	return reader, nil
+}
+
+// Header is the file header
+func (r *Reader) Header() CdrFileHeader {
+	return r.hdr
+}
+
+// Next returns the next CDR, or io.EOF once all the CDRs of the header are read and the file length checked
+func (r *Reader) Next() (*CDR, error) {
+	if r.read == r.hdr.NumberOfCdrsInFile {
+		if r.hdr.FileLength != UnknownFileLength && uint64(r.hdr.FileLength) != r.offset {
+			return nil, &LengthError{Field: "FileLength", Value: uint64(r.hdr.FileLength), Actual: r.offset}
+		}
+		return nil, io.EOF
+	}
+
+	cdr, err := r.next()
+	if err != nil {
+		return nil, &CdrError{Index: r.read + 1, Err: err}
+	}
+	r.read++
+	if r.hdr.FileLength != UnknownFileLength && r.offset > uint64(r.hdr.FileLength) {
+		return nil, &CdrError{Index: r.read, Err: &LengthError{
+			Field: "FileLength", Value: uint64(r.hdr.FileLength), Actual: r.offset,
+		}}
+	}
+	return cdr, nil
+}
+
+func (r *Reader) next() (*CDR, error) {
+	fixed, err := r.readFull(4)
+	if err != nil {
+		return nil, err
+	}
+	hdr := CdrHeader{
+		CdrLength:         binary.BigEndian.Uint16(fixed[0:2]),
+		ReleaseIdentifier: ReleaseIdentifierType(fixed[2] >> 5),
+		VersionIdentifier: fixed[2] & 0b11111,
+		DataRecordFormat:  DataRecordFormatType(fixed[3] >> 5),
+		TsNumber:          TsNumberIdentifier(fixed[3] & 0b11111),
+	}
+	if hdr.ReleaseIdentifier == BeyondRel9 {
+		if hdr.ReleaseIdentifierExtension, err = r.readByte(); err != nil {
+			return nil, err
+		}
+	}
+
+	cdrBytes, err := r.readFull(int(hdr.CdrLength))
+	if err != nil {
+		return nil, err
+	}
+	return &CDR{Hdr: hdr, CdrByte: cdrBytes}, nil
+}
+
+func (r *Reader) readFull(length int) ([]byte, error) {
+	buf := make([]byte, length)
+	n, err := io.ReadFull(r.r, buf)
+	r.offset += uint64(n)
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, ErrTruncated
+	}
+	return buf, err
+}
+
+func (r *Reader) readByte() (byte, error) {
+	buf, err := r.readFull(1)
+	if err != nil {
+		return 0, err
+	}
+	return buf[0], nil
+}
+
+// Writer writes a CDR file one CDR at a time, checking the lengths of the headers
+type Writer struct {
+	w       io.Writer
+	hdr     CdrFileHeader
+	written uint32
+	offset  uint64
+}
+
+// NewWriter writes the file header to w
+func NewWriter(w io.Writer, hdr CdrFileHeader) (*Writer, error) {
+	if int(hdr.LengthOfCdrRouteingFilter) != len(hdr.CDRRouteingFilter) {
+		return nil, &LengthError{
+			Field:  "LengthOfCdrRouteingFilter",
+			Value:  uint64(hdr.LengthOfCdrRouteingFilter),
+			Actual: uint64(len(hdr.CDRRouteingFilter)),
+		}
+	}
+	if int(hdr.LengthOfPrivateExtension) != len(hdr.PrivateExtension) {
+		return nil, &LengthError{
+			Field:  "LengthOfPrivateExtension",
+			Value:  uint64(hdr.LengthOfPrivateExtension),
+			Actual: uint64(len(hdr.PrivateExtension)),
+		}
+	}
+	encoded := hdr.Encoding()
+	if int(hdr.HeaderLength) != len(encoded) {
+		return nil, &LengthError{Field: "HeaderLength", Value: uint64(hdr.HeaderLength), Actual: uint64(len(encoded))}
+	}
+
+	writer := &Writer{w: w, hdr: hdr}
+	if err := writer.write(encoded); err != nil {
+		return nil, err
+	}
+	return writer, nil
+}
+
+// WriteCdr writes the CDR after its header
+func (w *Writer) WriteCdr(cdr CDR) error {
+	if int(cdr.Hdr.CdrLength) != len(cdr.CdrByte) {
+		return &CdrError{Index: w.written + 1, Err: &LengthError{
+			Field: "CdrLength", Value: uint64(cdr.Hdr.CdrLength), Actual: uint64(len(cdr.CdrByte)),
+		}}
+	}
+	if w.written == w.hdr.NumberOfCdrsInFile {
+		return &CdrError{Index: w.written + 1, Err: &LengthError{
+			Field: "NumberOfCdrsInFile", Value: uint64(w.hdr.NumberOfCdrsInFile), Actual: uint64(w.written + 1),
+		}}
+	}
+
+	if err := w.write(cdr.Hdr.Encoding()); err != nil {
+		return err
+	}
+	if err := w.write(cdr.CdrByte); err != nil {
+		return err
+	}
+	w.written++
+	return nil
+}
+
+// Close checks the CDRs written against the file header, the underlying writer is not closed
+func (w *Writer) Close() error {
+	if w.written != w.hdr.NumberOfCdrsInFile {
+		return &LengthError{
+			Field: "NumberOfCdrsInFile", Value: uint64(w.hdr.NumberOfCdrsInFile), Actual: uint64(w.written),
+		}
+	}
+	if w.hdr.FileLength != UnknownFileLength && uint64(w.hdr.FileLength) != w.offset {
+		return &LengthError{Field: "FileLength", Value: uint64(w.hdr.FileLength), Actual: w.offset}
+	}
+	return nil
+}
+
+func (w *Writer) write(buf []byte) error {
+	n, err := w.w.Write(buf)
+	w.offset += uint64(n)
+	return err
+}
@@ -0,0 +1,68 @@
+package cdrFile
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestStream(t *testing.T) {
+	t.Parallel()
+
+	hdr := CdrFileHeader{
+		FileLength:         59,
+		HeaderLength:       52,
+		NumberOfCdrsInFile: 1,
+	}
+	cdr := CDR{
+		Hdr:     CdrHeader{CdrLength: 3, DataRecordFormat: BasicEncodingRules, TsNumber: TS32298},
+		CdrByte: []byte("abc"),
+	}
+
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, hdr)
+	require.NoError(t, err)
+	require.NoError(t, w.WriteCdr(cdr))
+	require.NoError(t, w.Close())
+	file := buf.Bytes()
+
+	r, err := NewReader(bytes.NewReader(file))
+	require.NoError(t, err)
+	require.Equal(t, uint32(1), r.Header().NumberOfCdrsInFile)
+	read, err := r.Next()
+	require.NoError(t, err)
+	require.Equal(t, cdr, *read)
+	_, err = r.Next()
+	require.ErrorIs(t, err, io.EOF)
+
+	// truncated CDR
+	r, err = NewReader(bytes.NewReader(file[:len(file)-1]))
+	require.NoError(t, err)
+	_, err = r.Next()
+	require.ErrorIs(t, err, ErrTruncated)
+	var cdrErr *CdrError
+	require.True(t, errors.As(err, &cdrErr))
+	require.Equal(t, uint32(1), cdrErr.Index)
+
+	// truncated header
+	_, err = NewReader(bytes.NewReader(file[:20]))
+	require.ErrorIs(t, err, ErrTruncated)
+
+	// header length inconsistent with the header
+	inconsistent := bytes.Clone(file)
+	inconsistent[7] = 60
+	_, err = NewReader(bytes.NewReader(inconsistent))
+	var lengthErr *LengthError
+	require.True(t, errors.As(err, &lengthErr))
+	require.Equal(t, "HeaderLength", lengthErr.Field)
+
+	// CDR length inconsistent with the CDR
+	w, err = NewWriter(io.Discard, hdr)
+	require.NoError(t, err)
+	cdr.Hdr.CdrLength = 4
+	require.True(t, errors.As(w.WriteCdr(cdr), &lengthErr))
+	require.Equal(t, "CdrLength", lengthErr.Field)
+}
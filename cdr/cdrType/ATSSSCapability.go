@@ -13,5 +13,5 @@ const ( /* Enum Type */
 )
 
 type ATSSSCapability struct {
-	Value asn.Enumerated
+	Value asn.Enumerated `ber:"valueLB:0,valueUB:4"`
 }
@@ -11,5 +11,5 @@ const ( /* Enum Type */
 )
 
 type AdministrativeState struct {
-	Value asn.Enumerated
+	Value asn.Enumerated `ber:"valueLB:0,valueUB:2"`
 }
@@ -15,5 +15,5 @@ const ( /* Enum Type */
 )
 
 type ChChSelectionMode struct {
-	Value asn.Enumerated
+	Value asn.Enumerated `ber:"valueLB:0,valueUB:6"`
 }
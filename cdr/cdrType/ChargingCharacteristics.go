@@ -5,5 +5,5 @@ import "github.com/free5gc/chf/cdr/asn"
 // Need to import "gofree5gc/lib/aper" if it uses "aper"
 
 type ChargingCharacteristics struct {
-	Value asn.OctetString
+	Value asn.OctetString `ber:"sizeLB:2,sizeUB:2"`
 }
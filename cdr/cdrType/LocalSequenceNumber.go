@@ -3,5 +3,5 @@ package cdrType
 // Need to import "gofree5gc/lib/aper" if it uses "aper"
 
 type LocalSequenceNumber struct {
-	Value int64
+	Value int64 `ber:"valueLB:0,valueUB:4294967295"`
 }
@@ -19,5 +19,5 @@ const ( /* Enum Type */
 )
 
 type NetworkFunctionality struct {
-	Value asn.Enumerated
+	Value asn.Enumerated `ber:"valueLB:0,valueUB:10"`
 }
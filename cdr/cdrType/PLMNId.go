@@ -5,5 +5,5 @@ import "github.com/free5gc/chf/cdr/asn"
 // Need to import "gofree5gc/lib/aper" if it uses "aper"
 
 type PLMNId struct {
-	Value asn.OctetString
+	Value asn.OctetString `ber:"sizeLB:3,sizeUB:3"`
 }
@@ -16,6 +16,7 @@ const ( /* Enum Type */
 	PositionMethodFailureDiagnosticPresentPositionMethodNotAvailableInLocationArea asn.Enumerated = 8
 )
 
+// TS 29.002 PositionMethodFailure-Diagnostic, extensible
 type PositionMethodFailureDiagnostic struct {
-	Value asn.Enumerated
+	Value asn.Enumerated `ber:"valueLB:0,valueUB:8,valueExt"`
 }
@@ -18,5 +18,5 @@ const ( /* Enum Type */
 )
 
 type SMAddressType struct {
-	Value asn.Enumerated
+	Value asn.Enumerated `ber:"valueLB:0,valueUB:9"`
 }
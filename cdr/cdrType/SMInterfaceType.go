@@ -14,5 +14,5 @@ const ( /* Enum Type */
 )
 
 type SMInterfaceType struct {
-	Value asn.Enumerated
+	Value asn.Enumerated `ber:"valueLB:0,valueUB:5"`
 }
@@ -14,5 +14,5 @@ const ( /* Enum Type */
 )
 
 type SMMessageType struct {
-	Value asn.Enumerated
+	Value asn.Enumerated `ber:"valueLB:0,valueUB:5"`
 }
@@ -12,5 +12,5 @@ const ( /* Enum Type */
 )
 
 type SteerModeValue struct {
-	Value asn.Enumerated
+	Value asn.Enumerated `ber:"valueLB:0,valueUB:3"`
 }
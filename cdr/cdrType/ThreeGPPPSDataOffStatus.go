@@ -10,5 +10,5 @@ const ( /* Enum Type */
 )
 
 type ThreeGPPPSDataOffStatus struct {
-	Value asn.Enumerated
+	Value asn.Enumerated `ber:"valueLB:0,valueUB:1"`
 }
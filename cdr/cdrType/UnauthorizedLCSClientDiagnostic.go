@@ -15,6 +15,7 @@ const ( /* Enum Type */
 	UnauthorizedLCSClientDiagnosticPresentUnauthorizedCallSessionRelatedExternalClient   asn.Enumerated = 7
 )
 
+// TS 29.002 UnauthorizedLCSClient-Diagnostic, the values 5 to 7 are extension additions
 type UnauthorizedLCSClientDiagnostic struct {
-	Value asn.Enumerated
+	Value asn.Enumerated `ber:"valueLB:0,valueUB:4,valueExt"`
 }
@@ -0,0 +1,66 @@
+package cdrType
+
+import "github.com/free5gc/chf/cdr/asn"
+
+// The identifiers of the ENUMERATED types of TS 32.298, in the order of their values, for XER and JER
+func init() {
+	asn.RegisterEnumerated(APIDirection{}, "invocation", "notification")
+	asn.RegisterEnumerated(ATSSSCapability{}, "aTSSS-LL", "mPTCP-ATSSS-LL", "mPTCP-ATSSS-LL-ASModeUL",
+		"mPTCP-ATSSS-LL-ExSDModeUL", "mPTCP-ATSSS-LL-ASModeDLUL")
+	asn.RegisterEnumerated(AccessType{}, "threeGPPAccess", "nonThreeGPPAccess")
+	asn.RegisterEnumerated(AdministrativeState{}, "lOCKED", "uNLOCKED", "sHUTTING-DOWN")
+	asn.RegisterEnumerated(ChChSelectionMode{}, "servingNodeSupplied", "subscriptionSpecific", "aPNSpecific",
+		"homeDefault", "roamingDefault", "visitingDefault", "fixedDefault")
+	asn.RegisterEnumerated(CoreNetworkType{}, "fiveGC", "ePC")
+	asn.RegisterEnumerated(DNNSelectionMode{}, "uEorNetworkProvidedSubscriptionVerified",
+		"uEProvidedSubscriptionNotVerified", "networkProvidedSubscriptionNotVerified")
+	asn.RegisterEnumerated(DelayToleranceIndicator{}, "dTSupported", "dTNotSupported")
+	asn.RegisterEnumerated(LineType{}, "dSL", "pON")
+	asn.RegisterEnumerated(MAPDUSessionIndicator{}, "mAPDURequest", "mAPDUNetworkUpgradeAllowed")
+	asn.RegisterEnumerated(MAPDUSteeringFunctionality{}, "mPTCP", "aTSSS-LL")
+	asn.RegisterEnumerated(MICOModeIndication{}, "mICOMode", "noMICOMode")
+	asn.RegisterEnumerated(ManagementOperation{}, "createMOI", "modifyMOIAttributes", "deleteMOI")
+	asn.RegisterEnumerated(ManagementOperationStatus{}, "oPERATION-SUCCEEDED", "oPERATION-FAILED")
+	asn.RegisterEnumerated(MessageClass{}, "personal", "advertisement", "informationService", "auto")
+	asn.RegisterEnumerated(MobilityLevel{}, "stationary", "nomadic", "restrictedMobility", "fullyMobility")
+	asn.RegisterEnumerated(NetworkFunctionality{}, "cHF", "sMF", "aMF", "sMSF", "sGW", "iSMF", "ePDG", "cEF",
+		"nEF", "pGWCSMF", "mnSProducer")
+	asn.RegisterEnumerated(OperationalState{}, "eNABLED", "dISABLED")
+	asn.RegisterEnumerated(PDUSessionType{}, "iPv4v6", "iPv4", "iPv6", "unstructured", "ethernet")
+	asn.RegisterEnumerated(PartialRecordMethod{}, "default", "individual")
+	asn.RegisterEnumerated(PositionMethodFailureDiagnostic{}, "congestion", "insufficientResources",
+		"insufficientMeasurementData", "inconsistentMeasurementData", "locationProcedureNotCompleted",
+		"locationProcedureNotSupportedByTargetMS", "qoSNotAttainable", "positionMethodNotAvailableInNetwork",
+		"positionMethodNotAvailableInLocationArea")
+	asn.RegisterEnumerated(PreemptionCapability{}, "nOT-PREEMPT", "mAY-PREEMPT")
+	asn.RegisterEnumerated(PreemptionVulnerability{}, "nOT-PREEMPTABLE", "pREEMPTABLE")
+	asn.RegisterEnumerated(PresenceReportingAreaStatus{}, "insideArea", "outsideArea", "inactive", "unknown")
+	asn.RegisterEnumerated(PriorityType{}, "low", "normal", "high")
+	asn.RegisterEnumerated(QuotaManagementIndicator{}, "onlineCharging", "offlineCharging",
+		"quotaManagementSuspended")
+	asn.RegisterEnumerated(RegistrationMessageType{}, "initial", "mobility", "periodic", "emergency",
+		"deregistration")
+	asn.RegisterEnumerated(RestrictionType{}, "allowedAreas", "notAllowedAreas")
+	asn.RegisterEnumerated(RoamerInOut{}, "roamerInBound", "roamerOutBound")
+	asn.RegisterEnumerated(SMAddressType{}, "emailAddress", "mSISDN", "iPv4Address", "iPv6Address",
+		"numericShortCode", "alphanumericShortCode", "other", "iMSI", "nAI", "externalId")
+	asn.RegisterEnumerated(SMInterfaceType{}, "unkown", "mobileOriginating", "mobileTerminating",
+		"applicationOriginating", "applicationTerminating", "deviceTrigger")
+	asn.RegisterEnumerated(SMMessageType{}, "submission", "deliveryReport", "sMServiceRequest", "delivery",
+		"t4DeviceTrigger", "sMDeviceTrigger")
+	asn.RegisterEnumerated(SMReplyPathRequested{}, "noReplyPathSet", "replyPathSet")
+	asn.RegisterEnumerated(SMdeliveryReportRequested{}, "yes", "no")
+	asn.RegisterEnumerated(SharingLevel{}, "sHARED", "nON-SHARED")
+	asn.RegisterEnumerated(SmsIndication{}, "sMSSupported", "sMSNotSupported")
+	asn.RegisterEnumerated(SteerModeValue{}, "activeStandby", "loadBalancing", "smallestDelay", "priorityBased")
+	asn.RegisterEnumerated(SubscriberEquipmentType{}, "iMEISV", "mAC", "eUI64", "modifiedEUI64")
+	asn.RegisterEnumerated(SubscriptionIDType{}, "eND-USER-E164", "eND-USER-IMSI", "eND-USER-SIP-URI",
+		"eND-USER-NAI", "eND-USER-PRIVATE")
+	asn.RegisterEnumerated(ThreeGPPPSDataOffStatus{}, "active", "inactive")
+	asn.RegisterEnumerated(TriggerCategory{}, "immediateReport", "deferredReport")
+	asn.RegisterEnumerated(UnauthorizedLCSClientDiagnostic{}, "noAdditionalInformation",
+		"clientNotInMSPrivacyExceptionList", "callToClientNotSetup", "privacyOverrideNotApplicable",
+		"disallowedByLocalRegulatoryRequirements", "unauthorizedPrivacyClass",
+		"unauthorizedCallSessionUnrelatedExternalClient", "unauthorizedCallSessionRelatedExternalClient")
+	asn.RegisterEnumerated(V2XCommunicationModeIndicator{}, "v2XComSupported", "v2XComNotSupported")
+}
@@ -0,0 +1,124 @@
+// cdrtool inspects the CDR files written by the CHF
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/urfave/cli"
+)
+
+func main() {
+	app := cli.NewApp()
+	app.Name = "cdrtool"
+	app.Usage = "Inspect and validate the CDR files of the CHF"
+	app.Commands = []cli.Command{
+		{
+			Name:      "show",
+			Usage:     "Print the headers and the CHF records of CDR files",
+			ArgsUsage: "FILE...",
+			Action:    show,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "format, f",
+					Value: formatText,
+					Usage: "Output `FORMAT`: text or json",
+				},
+				cli.BoolFlag{
+					Name:  "header",
+					Usage: "Print the file headers only",
+				},
+				cli.StringFlag{
+					Name:  "supi",
+					Usage: "Print the records of `SUPI` only",
+				},
+				cli.Int64Flag{
+					Name:  "charging-id",
+					Usage: "Print the records of charging `ID` only",
+				},
+				cli.StringFlag{
+					Name:  "from",
+					Usage: "Print the records opened at `TIME` (RFC 3339) or later only",
+				},
+				cli.StringFlag{
+					Name:  "to",
+					Usage: "Print the records opened before `TIME` (RFC 3339) only",
+				},
+			},
+		},
+		{
+			Name:      "validate",
+			Usage:     "Check the header and length fields of CDR files, and the encoding of their records",
+			ArgsUsage: "FILE...",
+			Action:    validate,
+		},
+	}
+	if err := app.Run(os.Args); err != nil {
+		fmt.Fprintf(os.Stderr, "cdrtool: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func show(cliCtx *cli.Context) error {
+	if cliCtx.NArg() == 0 {
+		return fmt.Errorf("no CDR file given")
+	}
+	format := cliCtx.String("format")
+	if format != formatText && format != formatJSON {
+		return fmt.Errorf("unknown format %q", format)
+	}
+
+	filter := recordFilter{
+		supi:       cliCtx.String("supi"),
+		chargingId: cliCtx.Int64("charging-id"),
+		anyId:      !cliCtx.IsSet("charging-id"),
+	}
+	var err error
+	if filter.from, err = parseTime(cliCtx.String("from")); err != nil {
+		return err
+	}
+	if filter.to, err = parseTime(cliCtx.String("to")); err != nil {
+		return err
+	}
+
+	p := newPrinter(cliCtx.App.Writer, format)
+	for _, fileName := range cliCtx.Args() {
+		if err = showFile(p, fileName, cliCtx.Bool("header"), filter); err != nil {
+			return fmt.Errorf("%s: %w", fileName, err)
+		}
+	}
+	return nil
+}
+
+func validate(cliCtx *cli.Context) error {
+	if cliCtx.NArg() == 0 {
+		return fmt.Errorf("no CDR file given")
+	}
+
+	invalid := 0
+	for _, fileName := range cliCtx.Args() {
+		count, err := validateFile(fileName)
+		if err != nil {
+			invalid++
+			fmt.Fprintf(cliCtx.App.Writer, "%s: invalid: %v\n", fileName, err)
+			continue
+		}
+		fmt.Fprintf(cliCtx.App.Writer, "%s: valid, %d CDRs\n", fileName, count)
+	}
+	if invalid > 0 {
+		return fmt.Errorf("%d of %d files invalid", invalid, cliCtx.NArg())
+	}
+	return nil
+}
+
+func parseTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q: %w", value, err)
+	}
+	return t, nil
+}
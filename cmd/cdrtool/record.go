@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/free5gc/chf/cdr/asn"
+	"github.com/free5gc/chf/cdr/cdrConvert"
+	"github.com/free5gc/chf/cdr/cdrFile"
+	"github.com/free5gc/chf/cdr/cdrType"
+)
+
+const (
+	formatText = "text"
+	formatJSON = "json"
+)
+
+// recordFilter selects the records to print, the zero value of a criterion selects any record
+type recordFilter struct {
+	supi       string
+	chargingId int64
+	anyId      bool
+	from, to   time.Time
+}
+
+func (f recordFilter) match(record *cdrType.CHFRecord) bool {
+	chargingRecord := record.ChargingFunctionRecord
+	if chargingRecord == nil {
+		return false
+	}
+	if f.supi != "" {
+		supi := recordSupi(chargingRecord)
+		if supi == "" || (supi != f.supi && !strings.HasSuffix(supi, "-"+f.supi)) {
+			return false
+		}
+	}
+	if !f.anyId && (chargingRecord.ChargingID == nil || chargingRecord.ChargingID.Value != f.chargingId) {
+		return false
+	}
+	if !f.from.IsZero() || !f.to.IsZero() {
+		opening, err := cdrConvert.CdrToTimeStamp(chargingRecord.RecordOpeningTime)
+		if err != nil {
+			return false
+		}
+		if (!f.from.IsZero() && opening.Before(f.from)) || (!f.to.IsZero() && !opening.Before(f.to)) {
+			return false
+		}
+	}
+	return true
+}
+
+// recordSupi is the SUPI of the subscriber of the record, with its type prefix
+func recordSupi(record *cdrType.ChargingRecord) string {
+	if record.SubscriberIdentifier == nil {
+		return ""
+	}
+	data := string(record.SubscriberIdentifier.SubscriptionIDData)
+	switch record.SubscriberIdentifier.SubscriptionIDType.Value {
+	case cdrType.SubscriptionIDTypePresentENDUSERIMSI:
+		return "imsi-" + data
+	case cdrType.SubscriptionIDTypePresentENDUSERNAI:
+		return "nai-" + data
+	default:
+		return data
+	}
+}
+
+func decodeRecord(cdr *cdrFile.CDR) (*cdrType.CHFRecord, error) {
+	return cdrConvert.DecodeRecord(cdr.Hdr.DataRecordFormat, cdr.CdrByte)
+}
+
+func showFile(p *printer, fileName string, headerOnly bool, filter recordFilter) (err error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if errClose := file.Close(); err == nil {
+			err = errClose
+		}
+	}()
+
+	r, err := cdrFile.NewReader(bufio.NewReader(file))
+	if err != nil {
+		return err
+	}
+	if err = p.header(fileName, r.Header()); err != nil || headerOnly {
+		return err
+	}
+
+	for index := uint32(1); ; index++ {
+		cdr, errNext := r.Next()
+		if errors.Is(errNext, io.EOF) {
+			return nil
+		}
+		if errNext != nil {
+			return errNext
+		}
+		record, errDecode := decodeRecord(cdr)
+		if errDecode != nil {
+			return &cdrFile.CdrError{Index: index, Err: errDecode}
+		}
+		if !filter.match(record) {
+			continue
+		}
+		if err = p.record(fileName, index, cdr.Hdr, record); err != nil {
+			return err
+		}
+	}
+}
+
+// validateFile reads the whole file and decodes its records, it returns the number of CDRs
+func validateFile(fileName string) (count uint32, err error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if errClose := file.Close(); err == nil {
+			err = errClose
+		}
+	}()
+
+	r, err := cdrFile.NewReader(bufio.NewReader(file))
+	if err != nil {
+		return 0, err
+	}
+	for {
+		cdr, errNext := r.Next()
+		if errors.Is(errNext, io.EOF) {
+			return count, nil
+		}
+		if errNext != nil {
+			return count, errNext
+		}
+		count++
+		if _, err = decodeRecord(cdr); err != nil {
+			return count, &cdrFile.CdrError{Index: count, Err: err}
+		}
+	}
+}
+
+type printer struct {
+	w       io.Writer
+	format  string
+	encoder *json.Encoder
+}
+
+func newPrinter(w io.Writer, format string) *printer {
+	return &printer{
+		w:       w,
+		format:  format,
+		encoder: json.NewEncoder(w),
+	}
+}
+
+func (p *printer) header(fileName string, hdr cdrFile.CdrFileHeader) error {
+	if p.format == formatJSON {
+		return p.encoder.Encode(struct {
+			File   string                `json:"file"`
+			Header cdrFile.CdrFileHeader `json:"header"`
+		}{fileName, hdr})
+	}
+
+	_, err := fmt.Fprintf(p.w, "%s\n"+
+		"  file length %d, header length %d\n"+
+		"  release %d.%d (extension %d) to %d.%d (extension %d)\n"+
+		"  opened %s, last CDR appended %s\n"+
+		"  %d CDRs, sequence number %d, closure reason %d, lost CDR indicator %#02x\n",
+		fileName, hdr.FileLength, hdr.HeaderLength,
+		hdr.LowReleaseIdentifier, hdr.LowVersionIdentifier, hdr.LowReleaseIdentifierExtension,
+		hdr.HighReleaseIdentifier, hdr.HighVersionIdentifier, hdr.HighReleaseIdentifierExtension,
+		hdrTimeStamp(hdr.FileOpeningTimestamp), hdrTimeStamp(hdr.TimestampWhenLastCdrWasAppendedToFIle),
+		hdr.NumberOfCdrsInFile, hdr.FileSequenceNumber, hdr.FileClosureTriggerReason, hdr.LostCdrIndicator)
+	return err
+}
+
+func (p *printer) record(fileName string, index uint32, hdr cdrFile.CdrHeader, record *cdrType.CHFRecord) error {
+	if p.format == formatJSON {
+		// the record is printed in the JER encoding of the CHF JSON CDRs
+		jerRecord, err := asn.JerMarshal(record)
+		if err != nil {
+			return fmt.Errorf("CDR %d of %s: %w", index, fileName, err)
+		}
+		return p.encoder.Encode(struct {
+			File   string            `json:"file"`
+			Index  uint32            `json:"index"`
+			Header cdrFile.CdrHeader `json:"header"`
+			Record json.RawMessage   `json:"record"`
+		}{fileName, index, hdr, jerRecord})
+	}
+
+	chargingRecord := record.ChargingFunctionRecord
+	var fields []string
+	if supi := recordSupi(chargingRecord); supi != "" {
+		fields = append(fields, "SUPI "+supi)
+	}
+	if chargingRecord.ChargingID != nil {
+		fields = append(fields, fmt.Sprintf("charging ID %d", chargingRecord.ChargingID.Value))
+	}
+	if chargingRecord.ChargingSessionIdentifier != nil {
+		fields = append(fields, "session "+string(chargingRecord.ChargingSessionIdentifier.Value))
+	}
+	if opening, err := cdrConvert.CdrToTimeStamp(chargingRecord.RecordOpeningTime); err == nil {
+		fields = append(fields, "opened "+opening.Format(time.RFC3339))
+	}
+	fields = append(fields, fmt.Sprintf("duration %ds", chargingRecord.Duration.Value))
+	if chargingRecord.RecordSequenceNumber != nil {
+		fields = append(fields, fmt.Sprintf("sequence %d", *chargingRecord.RecordSequenceNumber))
+	}
+	fields = append(fields,
+		fmt.Sprintf("cause for closing %d", chargingRecord.CauseForRecClosing.Value),
+		fmt.Sprintf("%d unit usages", len(chargingRecord.ListOfMultipleUnitUsage)))
+
+	_, err := fmt.Fprintf(p.w, "  CDR %d: %s\n", index, strings.Join(fields, ", "))
+	return err
+}
+
+func hdrTimeStamp(ts cdrFile.CdrHdrTimeStamp) string {
+	sign := "-"
+	if ts.SignOfTheLocalTimeDifferentialFromUtc == 1 {
+		sign = "+"
+	}
+	return fmt.Sprintf("%02d-%02d %02d:%02d %s%02d:%02d",
+		ts.MonthLocal, ts.DateLocal, ts.HourLocal, ts.MinuteLocal, sign, ts.HourDeviation, ts.MinuteDeviation)
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/free5gc/chf/cdr/asn"
+	"github.com/free5gc/chf/cdr/cdrConvert"
+	"github.com/free5gc/chf/cdr/cdrFile"
+	"github.com/free5gc/chf/cdr/cdrType"
+)
+
+func TestShowFile(t *testing.T) {
+	t.Parallel()
+
+	opening := time.Date(2026, 10, 19, 8, 30, 0, 0, time.UTC)
+	var cdrs []cdrFile.CDR
+	fileLength := uint32(52)
+	for _, chargingId := range []int64{1, 2} {
+		record := &cdrType.CHFRecord{
+			Present: cdrType.CHFRecordPresentChargingFunctionRecord,
+			ChargingFunctionRecord: &cdrType.ChargingRecord{
+				SubscriberIdentifier: &cdrType.SubscriptionID{
+					SubscriptionIDType: cdrType.SubscriptionIDType{Value: cdrType.SubscriptionIDTypePresentENDUSERIMSI},
+					SubscriptionIDData: asn.UTF8String("208930000000001"),
+				},
+				RecordOpeningTime: cdrConvert.TimeStampToCdr(&opening),
+				Duration:          cdrType.CallDuration{Value: 60},
+				ChargingID:        &cdrType.ChargingID{Value: chargingId},
+			},
+		}
+		cdrBytes, err := asn.BerMarshalWithParams(&record, "explicit,choice")
+		require.NoError(t, err)
+		cdrs = append(cdrs, cdrFile.CDR{
+			Hdr:     cdrFile.CdrHeader{CdrLength: uint16(len(cdrBytes)), DataRecordFormat: cdrFile.BasicEncodingRules},
+			CdrByte: cdrBytes,
+		})
+		fileLength += 4 + uint32(len(cdrBytes))
+	}
+	fileName := filepath.Join(t.TempDir(), "CHF_-_202610190830+0000.1_-_0")
+	require.NoError(t, cdrFile.CDRFile{
+		Hdr: cdrFile.CdrFileHeader{
+			FileLength:         fileLength,
+			HeaderLength:       52,
+			NumberOfCdrsInFile: 2,
+			FileSequenceNumber: 1,
+		},
+		CdrList: cdrs,
+	}.Encoding(fileName))
+
+	count, err := validateFile(fileName)
+	require.NoError(t, err)
+	require.Equal(t, uint32(2), count)
+
+	var out bytes.Buffer
+	require.NoError(t, showFile(newPrinter(&out, formatText), fileName, false, recordFilter{
+		supi:       "imsi-208930000000001",
+		chargingId: 2,
+		from:       opening,
+	}))
+	require.Contains(t, out.String(), "2 CDRs, sequence number 1")
+	require.NotContains(t, out.String(), "CDR 1:")
+	require.Contains(t, out.String(), "CDR 2: SUPI imsi-208930000000001, charging ID 2, opened 2026-10-19T08:30:00Z")
+
+	out.Reset()
+	require.NoError(t, showFile(newPrinter(&out, formatText), fileName, false, recordFilter{
+		anyId: true,
+		to:    opening,
+	}))
+	require.NotContains(t, out.String(), "CDR 1:")
+
+	// the JSON records are in the JER encoding of the CHF
+	out.Reset()
+	require.NoError(t, showFile(newPrinter(&out, formatJSON), fileName, false, recordFilter{chargingId: 2}))
+	decoder := json.NewDecoder(&out)
+	var fileLine struct {
+		File string `json:"file"`
+	}
+	require.NoError(t, decoder.Decode(&fileLine))
+	require.Equal(t, fileName, fileLine.File)
+	var recordLine struct {
+		Index  uint32          `json:"index"`
+		Record json.RawMessage `json:"record"`
+	}
+	require.NoError(t, decoder.Decode(&recordLine))
+	require.Equal(t, uint32(2), recordLine.Index)
+	record, err := cdrConvert.DecodeRecord(cdrFile.JSONEncodingRules, recordLine.Record)
+	require.NoError(t, err)
+	require.Equal(t, int64(2), record.ChargingFunctionRecord.ChargingID.Value)
+	require.Equal(t, cdrType.SubscriptionIDTypePresentENDUSERIMSI,
+		record.ChargingFunctionRecord.SubscriberIdentifier.SubscriptionIDType.Value)
+
+	// a truncated file is invalid
+	content, err := os.ReadFile(fileName)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(fileName, content[:len(content)-1], 0o600))
+	_, err = validateFile(fileName)
+	require.ErrorIs(t, err, cdrFile.ErrTruncated)
+}
@@ -89,6 +89,18 @@ func action(cliCtx *cli.Context) error {
 	}
 	CHF = chf
 
+	// reload the configuration on SIGHUP
+	hupCh := make(chan os.Signal, 1)
+	signal.Notify(hupCh, syscall.SIGHUP)
+	go func() {
+		for range hupCh {
+			logger.MainLog.Infof("Reload configuration on SIGHUP")
+			if _, reloadErr := chf.ReloadConfig(); reloadErr != nil {
+				logger.MainLog.Errorf("Keep the current configuration: %+v", reloadErr)
+			}
+		}
+	}()
+
 	chf.Start()
 
 	return nil
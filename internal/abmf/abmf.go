@@ -1,6 +1,7 @@
 package abmf
 
 import (
+	"context"
 	"fmt"
 	"strconv"
 	"time"
@@ -9,18 +10,48 @@ import (
 	"github.com/fiorix/go-diameter/diam/datatype"
 	"github.com/fiorix/go-diameter/diam/dict"
 	"github.com/fiorix/go-diameter/diam/sm/smpeer"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 
 	charging_code "github.com/free5gc/chf/ccs_diameter/code"
 	charging_datatype "github.com/free5gc/chf/ccs_diameter/datatype"
 	chf_context "github.com/free5gc/chf/internal/context"
+	"github.com/free5gc/chf/internal/health"
 	"github.com/free5gc/chf/internal/logger"
+	"github.com/free5gc/chf/internal/metrics"
+	"github.com/free5gc/chf/internal/tracing"
 	"github.com/free5gc/chf/pkg/factory"
 )
 
+// SendAccountDebitRequest sends the CCR to the ABMF and waits for its answer
 func SendAccountDebitRequest(
+	ctx context.Context,
 	ue *chf_context.ChfUe,
 	ccr *charging_datatype.AccountDebitRequest,
 ) (*charging_datatype.AccountDebitResponse, error) {
+	// the ABMF does not get the trace context, the session id ties its spans to this one
+	_, span := tracing.StartKind(ctx, "ABMF CCR", trace.SpanKindClient,
+		attribute.String("chf.supi", ue.Supi),
+		attribute.String("diameter.session_id", string(ccr.SessionId)),
+		attribute.Int64("diameter.cc_request_number", int64(ccr.CcRequestNumber)))
+	cca, err := sendAccountDebitRequest(ue, ccr)
+	if err != nil {
+		health.Down(health.AbmfPeer, err)
+	} else {
+		health.Up(health.AbmfPeer)
+	}
+	if cca != nil {
+		span.SetAttributes(attribute.Int64("diameter.result_code", int64(cca.ResultCode)))
+	}
+	tracing.End(span, err)
+	return cca, err
+}
+
+func sendAccountDebitRequest(
+	ue *chf_context.ChfUe,
+	ccr *charging_datatype.AccountDebitRequest,
+) (*charging_datatype.AccountDebitResponse, error) {
+	defer metrics.ObserveDiameterRequest(metrics.InterfaceAbmf, time.Now())
 	ue.AbmfMux.Handle("CCA", HandleCCA(ue.AcctChan))
 	abmfDiameter := factory.ChfConfig.Configuration.AbmfDiameter
 	addr := abmfDiameter.HostIPv4 + ":" + strconv.Itoa(abmfDiameter.Port)
@@ -53,13 +84,14 @@ func SendAccountDebitRequest(
 	select {
 	case m := <-ue.AcctChan:
 		var cca charging_datatype.AccountDebitResponse
-		if errMarshal := m.Unmarshal(&cca); err != nil {
+		if errMarshal := m.Unmarshal(&cca); errMarshal != nil {
 			return nil, fmt.Errorf("Failed to parse message from %v", errMarshal)
 		}
 
 		return &cca, nil
 	case <-time.After(5 * time.Second):
-		return nil, fmt.Errorf("timeout: no rate answer received")
+		metrics.DiameterTimeout(metrics.InterfaceAbmf)
+		return nil, fmt.Errorf("timeout: no account answer received")
 	}
 }
 
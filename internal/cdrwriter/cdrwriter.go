@@ -0,0 +1,368 @@
+// Package cdrwriter writes the CDRs of all the charging sessions into shared TS 32.297 CDR files
+package cdrwriter
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/free5gc/chf/cdr/cdrFile"
+	"github.com/free5gc/chf/internal/logger"
+	"github.com/free5gc/chf/internal/metrics"
+)
+
+// sequenceFileName keeps the sequence number and the running count of the last file opened, across restarts
+const sequenceFileName = ".cdr_sequence"
+
+// closureReasonOffset is the offset of the file closure trigger reason in the file header
+const closureReasonOffset = 26
+
+// The CDRs are the CHF records of TS 32.298 V17.4.0
+const (
+	recordRelease = 17
+	recordVersion = 4
+)
+
+// Node generating the CDR files
+type Node struct {
+	Id      string
+	Address net.IP
+}
+
+// Dirs of the CDR files
+type Dirs struct {
+	// Working holds the file being written
+	Working string
+	// Ready holds the closed files, to be transferred
+	Ready string
+}
+
+// Limits of a CDR file, the file is closed once one of them is reached. A zero limit is not enforced.
+type Limits struct {
+	MaxSize     uint32
+	MaxOpenTime time.Duration
+	MaxCdrs     uint32
+}
+
+// Writer appends CDRs to the open CDR file. The file is opened in the working directory with the first CDR
+// appended, moved to the ready directory once closed and handed over to the closed function.
+type Writer struct {
+	mu     sync.Mutex
+	dirs   Dirs
+	node   Node
+	limits Limits
+	closed func(path string)
+
+	// sequence number of the last file opened, running count of the wraparounds of the sequence number
+	sequence     uint32
+	runningCount uint32
+	// lost CDRs since the last file closed
+	lost  uint32
+	name  string
+	file  *os.File
+	hdr   cdrFile.CdrFileHeader
+	timer *time.Timer
+}
+
+// New returns a writer of the CDR files of the node, numbered from the sequence number saved in the working
+// directory. The files left open in the working directory, e.g. by a crash, are moved to the ready directory.
+func New(dirs Dirs, node Node, limits Limits, closed func(path string)) (*Writer, error) {
+	for _, dir := range []string{dirs.Working, dirs.Ready} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, err
+		}
+	}
+
+	w := &Writer{
+		dirs:   dirs,
+		node:   node,
+		limits: limits,
+		closed: closed,
+	}
+	content, err := os.ReadFile(filepath.Join(dirs.Working, sequenceFileName))
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		return nil, err
+	default:
+		if err = w.parseSequence(string(content)); err != nil {
+			return nil, err
+		}
+	}
+
+	if err = w.recover(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// parseSequence reads "<sequence number> <running count>", the running count is absent from former saves
+func (w *Writer) parseSequence(content string) error {
+	fields := strings.Fields(content)
+	if len(fields) == 0 || len(fields) > 2 {
+		return fmt.Errorf("invalid CDR file sequence %q", content)
+	}
+	sequence, err := strconv.ParseUint(fields[0], 10, 32)
+	if err != nil {
+		return fmt.Errorf("invalid CDR file sequence number: %w", err)
+	}
+	w.sequence = uint32(sequence)
+	if len(fields) == 2 {
+		runningCount, errParse := strconv.ParseUint(fields[1], 10, 32)
+		if errParse != nil {
+			return fmt.Errorf("invalid CDR file running count: %w", errParse)
+		}
+		w.runningCount = uint32(runningCount)
+	}
+	return nil
+}
+
+// recover moves the files left in the working directory to the ready directory, closed abnormally
+func (w *Writer) recover() error {
+	entries, err := os.ReadDir(w.dirs.Working)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || entry.Name() == sequenceFileName {
+			continue
+		}
+		path := filepath.Join(w.dirs.Working, entry.Name())
+		if err = markAbnormalClosure(path); err != nil {
+			logger.CdrLog.Warnf("Mark CDR file %s closed abnormally err: %+v", path, err)
+		}
+		if err = os.Rename(path, filepath.Join(w.dirs.Ready, entry.Name())); err != nil {
+			return err
+		}
+		logger.CdrLog.Warnf("CDR file %s was left open, moved to %s", entry.Name(), w.dirs.Ready)
+	}
+	return nil
+}
+
+func markAbnormalClosure(path string) error {
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	_, err = file.WriteAt([]byte{byte(cdrFile.AbnormalFileClosure)}, closureReasonOffset)
+	if errClose := file.Close(); err == nil {
+		err = errClose
+	}
+	return err
+}
+
+// Append writes the CDRs encoded in the data record format at the end of the open file, then closes the file if
+// a limit is reached
+func (w *Writer) Append(format cdrFile.DataRecordFormatType, cdrs [][]byte) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for i, cdr := range cdrs {
+		if len(cdr) > math.MaxUint16 {
+			w.lost += uint32(len(cdrs))
+			return &cdrFile.CdrError{Index: uint32(i + 1), Err: &cdrFile.LengthError{
+				Field: "CdrLength", Value: math.MaxUint16, Actual: uint64(len(cdr)),
+			}}
+		}
+	}
+
+	if w.file == nil {
+		if err := w.open(); err != nil {
+			w.lost += uint32(len(cdrs))
+			return err
+		}
+	}
+
+	releaseIdentifier, releaseExtension := cdrFile.ReleaseIdentifiers(recordRelease)
+	for i, cdr := range cdrs {
+		cdrHdr := cdrFile.CdrHeader{
+			CdrLength:                  uint16(len(cdr)),
+			ReleaseIdentifier:          releaseIdentifier,
+			VersionIdentifier:          recordVersion,
+			DataRecordFormat:           format,
+			TsNumber:                   cdrFile.TS32298,
+			ReleaseIdentifierExtension: releaseExtension,
+		}
+		record := append(cdrHdr.Encoding(), cdr...)
+		if _, err := w.file.WriteAt(record, int64(w.hdr.FileLength)); err != nil {
+			w.lost += uint32(len(cdrs) - i)
+			w.abort(err)
+			return err
+		}
+		w.hdr.FileLength += uint32(len(record))
+		w.hdr.NumberOfCdrsInFile++
+	}
+	w.hdr.TimestampWhenLastCdrWasAppendedToFIle = cdrFile.NewCdrHdrTimeStamp(time.Now())
+	if err := w.writeHeader(); err != nil {
+		w.abort(err)
+		return err
+	}
+
+	switch {
+	case w.limits.MaxSize > 0 && w.hdr.FileLength >= w.limits.MaxSize:
+		return w.close(cdrFile.FileSizeLimitReached)
+	case w.limits.MaxCdrs > 0 && w.hdr.NumberOfCdrsInFile >= w.limits.MaxCdrs:
+		return w.close(cdrFile.MaximumNumberOfCdrsInFileReached)
+	}
+	return nil
+}
+
+// Lost counts CDRs which could not be appended, e.g. as they could not be encoded
+func (w *Writer) Lost(count int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.lost += uint32(count)
+}
+
+// Close closes the open file, if any, for the reason
+func (w *Writer) Close(reason cdrFile.FileClosureTriggerReasonType) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil {
+		return nil
+	}
+	return w.close(reason)
+}
+
+// open creates the file of the next sequence number, which wraps around from the maximum to 1.
+// The lock must be held.
+func (w *Writer) open() error {
+	sequence, runningCount := w.sequence+1, w.runningCount
+	if sequence == 0 {
+		sequence, runningCount = 1, runningCount+1
+	}
+	if err := os.WriteFile(filepath.Join(w.dirs.Working, sequenceFileName),
+		[]byte(fmt.Sprintf("%d %d", sequence, runningCount)), 0o644); err != nil {
+		return err
+	}
+	w.sequence, w.runningCount = sequence, runningCount
+
+	opening := time.Now()
+	releaseIdentifier, releaseExtension := cdrFile.ReleaseIdentifiers(recordRelease)
+	w.hdr = cdrFile.CdrFileHeader{
+		HighReleaseIdentifier:                 uint8(releaseIdentifier),
+		HighVersionIdentifier:                 recordVersion,
+		LowReleaseIdentifier:                  uint8(releaseIdentifier),
+		LowVersionIdentifier:                  recordVersion,
+		FileOpeningTimestamp:                  cdrFile.NewCdrHdrTimeStamp(opening),
+		TimestampWhenLastCdrWasAppendedToFIle: cdrFile.NewCdrHdrTimeStamp(opening),
+		FileSequenceNumber:                    sequence,
+		IpAddressOfNodeThatGeneratedFile:      cdrFile.NodeAddress(w.node.Address),
+		HighReleaseIdentifierExtension:        releaseExtension,
+		LowReleaseIdentifierExtension:         releaseExtension,
+	}
+	w.hdr.HeaderLength = uint32(52 + w.hdr.LengthOfCdrRouteingFilter + w.hdr.LengthOfPrivateExtension)
+	if releaseIdentifier == cdrFile.BeyondRel9 {
+		// high and low release identifier extensions
+		w.hdr.HeaderLength += 2
+	}
+	w.hdr.FileLength = w.hdr.HeaderLength
+	w.name = FileName(w.node.Id, opening, sequence, runningCount)
+
+	file, err := os.OpenFile(w.path(), os.O_RDWR|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return err
+	}
+	w.file = file
+	if err = w.writeHeader(); err != nil {
+		w.abort(err)
+		return err
+	}
+
+	if w.limits.MaxOpenTime > 0 {
+		w.timer = time.AfterFunc(w.limits.MaxOpenTime, func() {
+			w.closeOnOpenTime(sequence)
+		})
+	}
+	logger.CdrLog.Infof("Open CDR file %s", w.path())
+	return nil
+}
+
+// closeOnOpenTime closes the file of the sequence number if it is still open
+func (w *Writer) closeOnOpenTime(sequence uint32) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil || w.hdr.FileSequenceNumber != sequence {
+		return
+	}
+	if err := w.close(cdrFile.FileOpentimeLimitedReached); err != nil {
+		logger.CdrLog.Errorf("Close CDR file on open time limit err: %+v", err)
+	}
+}
+
+// close writes the closure reason in the header, then hands the file over. The lock must be held.
+func (w *Writer) close(reason cdrFile.FileClosureTriggerReasonType) error {
+	if w.timer != nil {
+		w.timer.Stop()
+		w.timer = nil
+	}
+
+	w.hdr.FileClosureTriggerReason = reason
+	if err := w.writeHeader(); err != nil {
+		w.abort(err)
+		return err
+	}
+	w.lost = 0
+	if err := w.file.Sync(); err != nil {
+		w.abort(err)
+		return err
+	}
+	if err := w.file.Close(); err != nil {
+		w.file = nil
+		return err
+	}
+	w.file = nil
+
+	path := filepath.Join(w.dirs.Ready, w.name)
+	if err := os.Rename(w.path(), path); err != nil {
+		return err
+	}
+
+	metrics.ObserveCdrFileSize(w.hdr.FileLength)
+	logger.CdrLog.Infof("Close CDR file %s with %d CDRs, reason %d", w.name, w.hdr.NumberOfCdrsInFile, reason)
+	if w.closed != nil {
+		w.closed(path)
+	}
+	return nil
+}
+
+// abort gives up the open file after a file system error, it is not handed over
+func (w *Writer) abort(err error) {
+	logger.CdrLog.Errorf("Abort CDR file %s: %+v", w.path(), err)
+	if w.timer != nil {
+		w.timer.Stop()
+		w.timer = nil
+	}
+	if errClose := w.file.Close(); errClose != nil {
+		logger.CdrLog.Warnf("Close CDR file err: %+v", errClose)
+	}
+	w.file = nil
+}
+
+func (w *Writer) writeHeader() error {
+	w.hdr.LostCdrIndicator = cdrFile.NewLostCdrIndicator(w.lost)
+	_, err := w.file.WriteAt(w.hdr.Encoding(), 0)
+	return err
+}
+
+// path of the file being written
+func (w *Writer) path() string {
+	return filepath.Join(w.dirs.Working, w.name)
+}
+
+// FileName is the TS 32.297 name of a CDR file:
+// <node ID>_-_<timestamp>.<file sequence number>_-_<running count>, the timestamp being
+// YYYYMMDDhhmm+hhmm at the opening of the file
+func FileName(nodeId string, opening time.Time, sequence, runningCount uint32) string {
+	return fmt.Sprintf("%s_-_%s.%d_-_%d", nodeId, opening.Format("200601021504-0700"), sequence, runningCount)
+}
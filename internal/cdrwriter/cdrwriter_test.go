@@ -0,0 +1,80 @@
+package cdrwriter
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/free5gc/chf/cdr/cdrFile"
+)
+
+func TestWriter(t *testing.T) {
+	t.Parallel()
+
+	root := t.TempDir()
+	dirs := Dirs{
+		Working: filepath.Join(root, "working"),
+		Ready:   filepath.Join(root, "ready"),
+	}
+	node := Node{Id: "CHF1", Address: net.ParseIP("10.0.0.1")}
+	var closed []string
+	onClosed := func(path string) {
+		closed = append(closed, path)
+	}
+	w, err := New(dirs, node, Limits{MaxCdrs: 3}, onClosed)
+	require.NoError(t, err)
+
+	require.NoError(t, w.Append(cdrFile.BasicEncodingRules, [][]byte{{0x01}, {0x02, 0x03}}))
+	require.Empty(t, closed)
+	require.NoError(t, w.Append(cdrFile.BasicEncodingRules, [][]byte{{0x04}}))
+	require.Len(t, closed, 1)
+	require.Equal(t, dirs.Ready, filepath.Dir(closed[0]))
+	require.Regexp(t, `^CHF1_-_\d{12}[+-]\d{4}\.1_-_0$`, filepath.Base(closed[0]))
+
+	var file cdrFile.CDRFile
+	require.NoError(t, file.Decoding(closed[0]))
+	require.Equal(t, uint32(1), file.Hdr.FileSequenceNumber)
+	require.Equal(t, uint32(3), file.Hdr.NumberOfCdrsInFile)
+	require.Equal(t, cdrFile.MaximumNumberOfCdrsInFileReached, file.Hdr.FileClosureTriggerReason)
+	require.Equal(t, []byte{0x02, 0x03}, file.CdrList[1].CdrByte)
+	require.Equal(t, uint8(cdrFile.BeyondRel9), file.Hdr.HighReleaseIdentifier)
+	require.Equal(t, uint8(7), file.Hdr.HighReleaseIdentifierExtension)
+	require.Equal(t, uint8(7), file.Hdr.LowReleaseIdentifierExtension)
+	require.Equal(t, cdrFile.NewCdrHdrTimeStamp(time.Now()).MonthLocal, file.Hdr.FileOpeningTimestamp.MonthLocal)
+	require.Equal(t, net.ParseIP("10.0.0.1").To16(), net.IP(file.Hdr.IpAddressOfNodeThatGeneratedFile[:16]))
+	require.Equal(t, uint8(0), file.Hdr.LostCdrIndicator)
+	require.Equal(t, cdrFile.TS32298, file.CdrList[0].Hdr.TsNumber)
+	require.Equal(t, uint8(7), file.CdrList[0].Hdr.ReleaseIdentifierExtension)
+
+	// after a crash, the sequence goes on and the file left open is moved to the ready directory
+	require.NoError(t, w.Append(cdrFile.BasicEncodingRules, [][]byte{{0x05}}))
+	w, err = New(dirs, node, Limits{}, onClosed)
+	require.NoError(t, err)
+	ready, err := os.ReadDir(dirs.Ready)
+	require.NoError(t, err)
+	require.Len(t, ready, 2)
+	left := ready[0].Name()
+	if left == filepath.Base(closed[0]) {
+		left = ready[1].Name()
+	}
+
+	file = cdrFile.CDRFile{}
+	require.NoError(t, file.Decoding(filepath.Join(dirs.Ready, left)))
+	require.Equal(t, uint32(2), file.Hdr.FileSequenceNumber)
+	require.Equal(t, cdrFile.AbnormalFileClosure, file.Hdr.FileClosureTriggerReason)
+
+	w.Lost(2)
+	require.NoError(t, w.Append(cdrFile.BasicEncodingRules, [][]byte{{0x06}}))
+	require.NoError(t, w.Close(cdrFile.NormalClosure))
+	require.Len(t, closed, 2)
+
+	file = cdrFile.CDRFile{}
+	require.NoError(t, file.Decoding(closed[1]))
+	require.Equal(t, uint32(3), file.Hdr.FileSequenceNumber)
+	require.Equal(t, cdrFile.NormalClosure, file.Hdr.FileClosureTriggerReason)
+	require.Equal(t, uint8(0x82), file.Hdr.LostCdrIndicator)
+}
@@ -5,7 +5,9 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"runtime/debug"
@@ -17,8 +19,12 @@ import (
 	"github.com/fclairamb/ftpserver/server"
 	ftpserver "github.com/fclairamb/ftpserverlib"
 	"github.com/jlaffaye/ftp"
+	"go.opentelemetry.io/otel/attribute"
 
+	"github.com/free5gc/chf/internal/health"
 	"github.com/free5gc/chf/internal/logger"
+	"github.com/free5gc/chf/internal/metrics"
+	"github.com/free5gc/chf/internal/tracing"
 	"github.com/free5gc/chf/pkg/factory"
 )
 
@@ -54,6 +60,9 @@ type FtpConfig struct {
 
 var cgf *Cgf
 
+// transferring holds the CDR files being transferred, so a file is not sent again before its transfer ends
+var transferring sync.Map
+
 var CGFEnable bool = false
 
 func OpenServer(ctx context.Context, wg *sync.WaitGroup) *Cgf {
@@ -167,13 +176,37 @@ func Login() error {
 	return err
 }
 
-func SendCDR(supi string) error {
-	logger.CfgLog.Debugln("SendCDR:", supi)
+// SendCDR transfers the closed CDR file to the billing domain
+func SendCDR(ctx context.Context, path string) error {
+	logger.CfgLog.Debugln("SendCDR:", path)
 	if !CGFEnable {
 		logger.CfgLog.Warningln("CGF Not enable: SendCDR() didn't do anything.")
 		return nil
 	}
 
+	if _, busy := transferring.LoadOrStore(path, struct{}{}); busy {
+		logger.CgfLog.Debugf("CDR file %s is already being transferred", path)
+		return nil
+	}
+	defer transferring.Delete(path)
+	// the file is archived once transferred, it may have been while it was listed
+	if _, err := os.Stat(path); errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+
+	_, span := tracing.Start(ctx, "CGF transfer", attribute.String("chf.cdr.file", path))
+	err := sendCDR(path)
+	tracing.End(span, err)
+	metrics.CgfTransfer(err)
+	if err != nil {
+		health.Down(health.Cgf, err)
+	} else {
+		health.Up(health.Cgf)
+	}
+	return err
+}
+
+func sendCDR(path string) error {
 	if cgf.conn == nil {
 		err := Login()
 		if err != nil {
@@ -194,8 +227,8 @@ func SendCDR(supi string) error {
 	cgf.connMutex.Lock()
 	defer cgf.connMutex.Unlock()
 
-	fileName := supi + ".cdr"
-	cdrByte, err := os.ReadFile("/tmp/" + fileName)
+	fileName := filepath.Base(path)
+	cdrByte, err := os.ReadFile(path)
 	if err != nil {
 		return err
 	}
@@ -203,7 +236,7 @@ func SendCDR(supi string) error {
 	cdrReader := bytes.NewReader(cdrByte)
 	stor_err := cgf.conn.Stor(fileName, cdrReader)
 	if stor_err != nil {
-		return err
+		return stor_err
 	}
 
 	// check file exist and verify size
@@ -230,16 +263,93 @@ func SendCDR(supi string) error {
 		logger.CgfLog.Warningln("File upload failed.")
 		return fmt.Errorf("sendCDR failed: %+v", err)
 	}
-	logger.CgfLog.Infof("SendCDR success: %+v", supi)
+	logger.CgfLog.Infof("SendCDR success: %+v", fileName)
+	if err = archiveCDR(path); err != nil {
+		logger.CgfLog.Warnf("Archive transferred CDR file %s err: %+v", fileName, err)
+	}
 	return nil
 }
 
+// archiveCDR moves the CDR file out of the ready directory, charging data is never deleted
+func archiveCDR(path string) error {
+	archiveDir := factory.ChfConfig.GetCdrDirs().Archive
+	if err := os.MkdirAll(archiveDir, 0o755); err != nil {
+		return err
+	}
+	return os.Rename(path, filepath.Join(archiveDir, filepath.Base(path)))
+}
+
+// readyCDRs lists the closed CDR files waiting for their transfer
+func readyCDRs() []string {
+	readyDir := factory.ChfConfig.GetCdrDirs().Ready
+	entries, err := os.ReadDir(readyDir)
+	if err != nil {
+		logger.CgfLog.Warnf("Read CDR ready directory err: %+v", err)
+		return nil
+	}
+	var paths []string
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			paths = append(paths, filepath.Join(readyDir, entry.Name()))
+		}
+	}
+	return paths
+}
+
+// sendReadyCDRs transfers the CDR files closed while the billing domain could not be reached
+func sendReadyCDRs(ctx context.Context) {
+	for _, path := range readyCDRs() {
+		if ctx.Err() != nil {
+			return
+		}
+		if err := SendCDR(ctx, path); err != nil {
+			logger.CgfLog.Errorf("Charging gateway fail to send CDR file %s to billing domain %v", path, err)
+			return
+		}
+	}
+}
+
+// retryReadyCDRs transfers the files of the ready directory at start, then every interval until ctx is done
+func retryReadyCDRs(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		sendReadyCDRs(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
 const (
 	FTP_LOGIN_RETRY_NUMBER       = 3
 	FTP_LOGIN_RETRY_WAITING_TIME = 1 * time.Second // second
 )
 
 func (f *Cgf) Serve(ctx context.Context, wg *sync.WaitGroup) {
+	var errLogin error
+	for i := 0; i < FTP_LOGIN_RETRY_NUMBER; i++ {
+		if errLogin = Login(); errLogin != nil {
+			logger.CgfLog.Warnf("Login to Webconsole FTP fail: %s, retrying [%d]\n", errLogin, i+1)
+			time.Sleep(FTP_LOGIN_RETRY_WAITING_TIME)
+		} else {
+			break
+		}
+	}
+	if errLogin != nil {
+		health.Down(health.Cgf, errLogin)
+	} else {
+		health.Up(health.Cgf)
+	}
+
+	// the ready files are retried until the CGF terminates, a file left by an outage is sent once it is over
+	retries := make(chan struct{})
+	go func() {
+		defer close(retries)
+		retryReadyCDRs(ctx, time.Duration(factory.ChfConfig.GetCgfRetryInterval())*time.Second)
+	}()
 	go func() {
 		defer func() {
 			if p := recover(); p != nil {
@@ -248,19 +358,11 @@ func (f *Cgf) Serve(ctx context.Context, wg *sync.WaitGroup) {
 			}
 		}()
 		<-ctx.Done()
+		<-retries
 		f.Terminate()
 		wg.Done()
 	}()
 
-	for i := 0; i < FTP_LOGIN_RETRY_NUMBER; i++ {
-		if err := Login(); err != nil {
-			logger.CgfLog.Warnf("Login to Webconsole FTP fail: %s, retrying [%d]\n", err, i+1)
-			time.Sleep(FTP_LOGIN_RETRY_WAITING_TIME)
-		} else {
-			break
-		}
-	}
-
 	if err := f.ftpServer.ListenAndServe(); err != nil {
 		logger.CgfLog.Error("Problem listening", "err", err)
 	}
@@ -287,24 +389,9 @@ func (f *Cgf) Terminate() {
 		}
 	}
 
-	var cdrFilePath string
-	if factory.ChfConfig.Configuration.Cgf.CdrFilePath == "" {
-		cdrFilePath = factory.CgfDefaultCdrFilePath
-	} else {
-		cdrFilePath = factory.ChfConfig.Configuration.Cgf.CdrFilePath
-	}
-	files, err := filepath.Glob(cdrFilePath + "/*.cdr")
-	if err != nil {
-		logger.CgfLog.Warnln("no CDR file")
-	}
-
-	for _, file := range files {
-		if _, errStat := os.Stat(file); errStat == nil {
-			logger.CgfLog.Infof("Remove CDR file: " + file)
-			if errRemove := os.Remove(file); errRemove != nil {
-				logger.CgfLog.Warnf("Failed to remove CDR file: %s\n", file)
-			}
-		}
+	// the files not transferred stay ready, they are retried once the CGF starts again
+	if paths := readyCDRs(); len(paths) > 0 {
+		logger.CgfLog.Warnf("%d CDR files not transferred, kept in the ready directory", len(paths))
 	}
 	logger.CgfLog.Infoln("CGF terminated")
 }
@@ -0,0 +1,146 @@
+package cgf
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fclairamb/ftpserver/config"
+	"github.com/fclairamb/ftpserver/config/confpar"
+	"github.com/fclairamb/ftpserver/server"
+	ftpserver "github.com/fclairamb/ftpserverlib"
+	"github.com/stretchr/testify/require"
+
+	"github.com/free5gc/chf/internal/logger"
+	"github.com/free5gc/chf/pkg/factory"
+)
+
+// startTestCgf serves the billing domain over FTP from basePath, and points the CGF at it. The returned
+// channel is closed once the server stopped serving.
+func startTestCgf(t *testing.T, basePath string, readOnly bool) <-chan struct{} {
+	conf, err := config.FromContent(&confpar.Content{
+		Version:       1,
+		ListenAddress: "127.0.0.1:0",
+		Accesses: []*confpar.Access{{
+			User:     "admin",
+			Pass:     "free5gc",
+			Fs:       "os",
+			Params:   map[string]string{"basePath": basePath},
+			ReadOnly: readOnly,
+		}},
+	}, "", logger.FtpServerLog)
+	require.NoError(t, err)
+	driver, err := server.NewServer(conf, logger.FtpServerLog)
+	require.NoError(t, err)
+
+	ftpServer := ftpserver.NewFtpServer(driver)
+	require.NoError(t, ftpServer.Listen())
+	served := make(chan struct{})
+	go func() {
+		defer close(served)
+		_ = ftpServer.Serve()
+	}()
+
+	cgf = &Cgf{
+		ftpServer: ftpServer,
+		driver:    driver,
+		addr:      ftpServer.Addr(),
+		ftpConfig: FtpConfig{Accesses: []Access{{User: "admin", Pass: "free5gc"}}},
+	}
+	t.Cleanup(func() {
+		if cgf.conn != nil {
+			_ = cgf.conn.Quit()
+		}
+		select {
+		case <-served:
+		default:
+			_ = ftpServer.Stop()
+			<-served
+		}
+	})
+	return served
+}
+
+// useReadyDir configures the CDR directories under a temporary path and enables the transfers
+func useReadyDir(t *testing.T) string {
+	factory.ChfConfig = &factory.Config{Configuration: &factory.Configuration{
+		Cgf: &factory.Cgf{CdrFilePath: t.TempDir()},
+	}}
+	readyDir := factory.ChfConfig.GetCdrDirs().Ready
+	require.NoError(t, os.MkdirAll(readyDir, 0o755))
+	CGFEnable = true
+	t.Cleanup(func() { CGFEnable = false })
+	return readyDir
+}
+
+// The tests share the CGF of the package, they do not run in parallel
+func TestSendCDRStoreError(t *testing.T) {
+	startTestCgf(t, t.TempDir(), true)
+
+	path := filepath.Join(t.TempDir(), "chf_-_20240309170459+0800_-_1_1.cdr")
+	require.NoError(t, os.WriteFile(path, []byte("cdr"), 0o600))
+
+	// the refused upload is reported, and the file is kept for a later transfer
+	require.Error(t, sendCDR(path))
+	require.FileExists(t, path)
+}
+
+func TestTerminateKeepsReadyCDRs(t *testing.T) {
+	served := startTestCgf(t, t.TempDir(), false)
+	readyDir := useReadyDir(t)
+	path := filepath.Join(readyDir, "chf_-_20240309170459+0800_-_2_1.cdr")
+	require.NoError(t, os.WriteFile(path, []byte("cdr"), 0o600))
+
+	// the file not transferred is left for the transfer of the ready files at startup
+	cgf.Terminate()
+	<-served
+	require.Equal(t, []string{path}, readyCDRs())
+}
+
+func TestSendCDROnce(t *testing.T) {
+	billingDir := t.TempDir()
+	startTestCgf(t, billingDir, false)
+	readyDir := useReadyDir(t)
+	path := filepath.Join(readyDir, "chf_-_20240309170459+0800_-_3_1.cdr")
+	require.NoError(t, os.WriteFile(path, []byte("cdr"), 0o600))
+
+	// a file being transferred is not sent again by another transfer
+	transferring.Store(path, struct{}{})
+	require.NoError(t, SendCDR(context.Background(), path))
+	require.NoFileExists(t, filepath.Join(billingDir, filepath.Base(path)))
+	transferring.Delete(path)
+
+	require.NoError(t, SendCDR(context.Background(), path))
+	require.FileExists(t, filepath.Join(billingDir, filepath.Base(path)))
+	require.NoFileExists(t, path)
+
+	// nor is a file transferred and archived since it was listed
+	require.NoError(t, SendCDR(context.Background(), path))
+}
+
+func TestRetryReadyCDRs(t *testing.T) {
+	billingDir := t.TempDir()
+	startTestCgf(t, billingDir, false)
+	readyDir := useReadyDir(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	retries := make(chan struct{})
+	go func() {
+		defer close(retries)
+		retryReadyCDRs(ctx, 10*time.Millisecond)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-retries
+	})
+
+	// a file left in the ready directory by a failed transfer is sent by the next retry
+	path := filepath.Join(readyDir, "chf_-_20240309170459+0800_-_4_1.cdr")
+	require.NoError(t, os.WriteFile(path, []byte("cdr"), 0o600))
+	require.Eventually(t, func() bool {
+		_, err := os.Stat(filepath.Join(billingDir, filepath.Base(path)))
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond)
+}
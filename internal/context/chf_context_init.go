@@ -89,6 +89,47 @@ func InitChfContext(context *CHFContext) {
 
 	context.NfService = make(map[models.ServiceName]models.NrfNfManagementNfService)
 	AddNfServices(&context.NfService, config, context)
+	context.SetChfInfo(configuration.ChfInfo)
+
+	context.HeartbeatTimer = factory.NrfDefaultHeartbeatTimer
+	if nfProfile := configuration.NfProfile; nfProfile != nil {
+		if nfProfile.HeartbeatTimer != 0 {
+			context.HeartbeatTimer = nfProfile.HeartbeatTimer
+		}
+		context.Capacity = nfProfile.Capacity
+		context.MaxSessions = nfProfile.MaxSessions
+	}
+}
+
+// SetChfInfo sets the ChfInfo of the NRF profile from the configuration
+func (c *CHFContext) SetChfInfo(info *factory.ChfInfo) {
+	chfInfo := models.ChfInfo{}
+	if info != nil {
+		for _, r := range info.SupiRanges {
+			chfInfo.SupiRangeList = append(chfInfo.SupiRangeList,
+				models.SupiRange{Start: r.Start, End: r.End, Pattern: r.Pattern})
+		}
+		for _, r := range info.GpsiRanges {
+			chfInfo.GpsiRangeList = append(chfInfo.GpsiRangeList,
+				models.IdentityRange{Start: r.Start, End: r.End, Pattern: r.Pattern})
+		}
+		for _, r := range info.PlmnRanges {
+			chfInfo.PlmnRangeList = append(chfInfo.PlmnRangeList,
+				models.PlmnRange{Start: r.Start, End: r.End, Pattern: r.Pattern})
+		}
+		chfInfo.GroupId = info.GroupId
+		// TS 29.510: a primary CHF advertises its secondary one, and conversely
+		switch info.Role {
+		case "primary":
+			chfInfo.SecondaryChfInstance = info.PeerInstance
+		case "secondary":
+			chfInfo.PrimaryChfInstance = info.PeerInstance
+		}
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	c.ChfInfo = chfInfo
 }
 
 func AddNfServices(
@@ -41,6 +41,12 @@ type CHFContext struct {
 	NrfCertPem                string
 	UePool                    sync.Map
 	OAuth2Required            bool
+	// ChfInfo of the NRF profile, guarded by the context lock as it is reloadable
+	ChfInfo models.ChfInfo
+	// HeartbeatTimer in seconds, proposed to the NRF then the one it imposes
+	HeartbeatTimer int32
+	Capacity       int32
+	MaxSessions    int32
 
 	RatingCfg *sm.Settings
 	AbmfCfg   *sm.Settings
@@ -94,6 +100,56 @@ func (context *CHFContext) ChfUeFindBySupi(supi string) (*ChfUe, bool) {
 	return nil, false
 }
 
+// ActiveSessions returns the number of charging sessions open in the UE pool
+func (context *CHFContext) ActiveSessions() int {
+	sessions := 0
+	context.UePool.Range(func(key, value interface{}) bool {
+		ue := value.(*ChfUe)
+		ue.CULock.Lock()
+		for chargingSessionId := range ue.Cdr {
+			// one-time events have no charging session
+			if chargingSessionId != "" {
+				sessions++
+			}
+		}
+		ue.CULock.Unlock()
+		return true
+	})
+	return sessions
+}
+
+// NextRecordSequenceNumber numbers the next partial record of the charging session
+func (c *CHFContext) NextRecordSequenceNumber(sessionId string) int64 {
+	c.Lock()
+	defer c.Unlock()
+	if c.RecordSequenceNumber == nil {
+		c.RecordSequenceNumber = make(map[string]int64)
+	}
+	c.RecordSequenceNumber[sessionId]++
+	return c.RecordSequenceNumber[sessionId]
+}
+
+// ReleaseRecordSequenceNumber forgets the numbering of the partial records of the terminated charging session
+func (c *CHFContext) ReleaseRecordSequenceNumber(sessionId string) {
+	c.Lock()
+	defer c.Unlock()
+	delete(c.RecordSequenceNumber, sessionId)
+}
+
+func (c *CHFContext) GetChfInfo() models.ChfInfo {
+	c.Lock()
+	defer c.Unlock()
+	return c.ChfInfo
+}
+
+// Load is the percentage of MaxSessions active, 0 when the CHF is not sized
+func (context *CHFContext) Load() int32 {
+	if context.MaxSessions <= 0 {
+		return 0
+	}
+	return int32(min(100, context.ActiveSessions()*100/int(context.MaxSessions)))
+}
+
 func GenerateRatingSessionId() uint32 {
 	if id, err := chfContext.RatingSessionIdGenerator.Allocate(); err == nil {
 		return uint32(id)
@@ -113,9 +169,18 @@ func GetSelf() *CHFContext {
 }
 
 func (c *CHFContext) GetSelfID() string {
+	c.Lock()
+	defer c.Unlock()
 	return c.NfId
 }
 
+// SetNfId replaces the NF instance id by the one the NRF assigned on registration
+func (c *CHFContext) SetNfId(nfId string) {
+	c.Lock()
+	defer c.Unlock()
+	c.NfId = nfId
+}
+
 func (c *CHFContext) GetTokenCtx(serviceName models.ServiceName, targetNF models.NrfNfManagementNfType) (
 	context.Context, *models.ProblemDetails, error,
 ) {
@@ -123,5 +188,5 @@ func (c *CHFContext) GetTokenCtx(serviceName models.ServiceName, targetNF models
 		return context.TODO(), nil, nil
 	}
 	return oauth.GetTokenCtx(models.NrfNfManagementNfType_CHF, targetNF,
-		c.NfId, c.NrfUri, string(serviceName))
+		c.GetSelfID(), c.NrfUri, string(serviceName))
 }
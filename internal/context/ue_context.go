@@ -12,28 +12,27 @@ import (
 
 	charging_datatype "github.com/free5gc/chf/ccs_diameter/datatype"
 	"github.com/free5gc/chf/cdr/cdrType"
-	"github.com/free5gc/chf/pkg/factory"
 )
 
 type ChfUe struct {
 	Supi         string
 	RatingGroups []int32
 
-	QuotaValidityTime    int32
-	VolumeLimit          int32
-	VolumeLimitPDU       int32
-	VolumeThresholdRate  float32
-	NotifyUri            string
+	// NotifyUri of the consumer of each charging session
+	NotifyUri            map[string]string
 	RecordSequenceNumber int64
 
 	// ABMF
 	ReservedQuota  map[int32]int64
+	UsedQuota      map[int32]int64 // usage not committed to the ABMF reservation yet
 	UnitCost       map[int32]uint32
 	AcctRequestNum map[int32]uint32
 	AbmfClient     *sm.Client
 	AbmfMux        *sm.StateMachine
 	AcctChan       chan *diam.Message
 	AcctSessionId  uint32
+	// Rating groups for which the subscriber has been notified of a low balance
+	LowBalance map[int32]bool
 
 	// Rating
 	RatingClient  *sm.Client
@@ -41,7 +40,6 @@ type ChfUe struct {
 	RatingChan    chan *diam.Message
 	RatingType    map[int32]charging_datatype.RequestSubType
 	RateSessionId uint32
-	Records       []*cdrType.CHFRecord
 
 	// lock
 	Cdr    map[string]*cdrType.CHFRecord
@@ -58,14 +56,8 @@ func (ue *ChfUe) FindRatingGroup(ratingGroup int32) bool {
 }
 
 func (ue *ChfUe) init() {
-	config := factory.ChfConfig
-	ue.Records = []*cdrType.CHFRecord{}
 	ue.Cdr = make(map[string]*cdrType.CHFRecord)
-	ue.Records = []*cdrType.CHFRecord{}
-	ue.VolumeLimit = config.Configuration.VolumeLimit
-	ue.VolumeLimitPDU = config.Configuration.VolumeLimitPDU
-	ue.QuotaValidityTime = config.Configuration.QuotaValidityTime
-	ue.VolumeThresholdRate = config.Configuration.VolumeThresholdRate
+	ue.NotifyUri = make(map[string]string)
 	ue.AcctRequestNum = make(map[int32]uint32)
 	// This needed to be added if rating server do not locate in the same machine
 	// err := dict.Default.Load(bytes.NewReader([]byte(charging_dict.RateDictionary)))
@@ -73,7 +65,9 @@ func (ue *ChfUe) init() {
 	// 	log.Fatal(err)
 	// }
 	ue.ReservedQuota = make(map[int32]int64)
+	ue.UsedQuota = make(map[int32]int64)
 	ue.UnitCost = make(map[int32]uint32)
+	ue.LowBalance = make(map[int32]bool)
 
 	ue.RatingChan = make(chan *diam.Message)
 	ue.AcctChan = make(chan *diam.Message)
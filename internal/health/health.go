@@ -0,0 +1,176 @@
+// Package health tracks the state of the dependencies of the CHF, the CHF is ready once its critical ones are up
+package health
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/free5gc/chf/internal/logger"
+)
+
+type Status string
+
+const (
+	StatusUp      Status = "UP"
+	StatusDown    Status = "DOWN"
+	StatusUnknown Status = "UNKNOWN"
+)
+
+// Components of the CHF
+const (
+	Nrf            = "nrf"
+	MongoDB        = "mongodb"
+	RatingFunction = "ratingFunction"
+	Abmf           = "abmf"
+	// Diameter peers as seen by the requests of the CHF
+	RatingPeer = "ratingPeer"
+	AbmfPeer   = "abmfPeer"
+	Cgf        = "cgf"
+)
+
+const probeTimeout = 2 * time.Second
+
+type Component struct {
+	Name   string `json:"name"`
+	Status Status `json:"status"`
+	Detail string `json:"detail,omitempty"`
+	// Since when the component is in its status
+	Since time.Time `json:"since"`
+	// Critical components must be up for the CHF to be ready
+	Critical bool `json:"critical"`
+
+	probe func(context.Context) error
+}
+
+type Report struct {
+	Status     Status      `json:"status"`
+	Components []Component `json:"components"`
+}
+
+var (
+	mu         sync.RWMutex
+	components = make(map[string]*Component)
+)
+
+// Register adds a component in an unknown status. The state of a component with a probe is refreshed by
+// calling it, the others are set by Up and Down.
+func Register(name string, critical bool, probe func(context.Context) error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	components[name] = &Component{
+		Name:     name,
+		Status:   StatusUnknown,
+		Since:    time.Now(),
+		Critical: critical,
+		probe:    probe,
+	}
+}
+
+func Up(name string) {
+	set(name, StatusUp, "")
+}
+
+func Down(name string, err error) {
+	detail := ""
+	if err != nil {
+		detail = err.Error()
+	}
+	set(name, StatusDown, detail)
+}
+
+// set changes the status of the component, an unregistered one is added as not critical
+func set(name string, status Status, detail string) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	c, ok := components[name]
+	if !ok {
+		c = &Component{Name: name}
+		components[name] = c
+	}
+	if c.Status != status {
+		if status == StatusDown {
+			logger.MainLog.Warnf("%s is down: %s", name, detail)
+		} else {
+			logger.MainLog.Infof("%s is %s", name, status)
+		}
+		c.Since = time.Now()
+	}
+	c.Status = status
+	c.Detail = detail
+}
+
+// Ready reports whether all the critical components are up
+func Ready() bool {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	for _, c := range components {
+		if c.Critical && c.Status != StatusUp {
+			return false
+		}
+	}
+	return true
+}
+
+// Probe refreshes the state of the components with a probe
+func Probe(ctx context.Context) {
+	probes := make(map[string]func(context.Context) error)
+	mu.RLock()
+	for name, c := range components {
+		if c.probe != nil {
+			probes[name] = c.probe
+		}
+	}
+	mu.RUnlock()
+
+	for name, probe := range probes {
+		probeCtx, cancel := context.WithTimeout(ctx, probeTimeout)
+		if err := probe(probeCtx); err != nil {
+			Down(name, err)
+		} else {
+			Up(name)
+		}
+		cancel()
+	}
+}
+
+// Check probes the components and reports the state of all of them
+func Check(ctx context.Context) Report {
+	Probe(ctx)
+
+	report := Report{Status: StatusDown}
+	if Ready() {
+		report.Status = StatusUp
+	}
+
+	mu.RLock()
+	for _, c := range components {
+		report.Components = append(report.Components, *c)
+	}
+	mu.RUnlock()
+
+	sort.Slice(report.Components, func(i, j int) bool {
+		return report.Components[i].Name < report.Components[j].Name
+	})
+	return report
+}
+
+// Run probes the components every interval until ctx is done, so that Ready follows their state
+func Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	Probe(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			Probe(ctx)
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadiness(t *testing.T) {
+	t.Parallel()
+
+	probeErr := errors.New("no server available")
+	Register(Nrf, true, nil)
+	Register(MongoDB, true, func(context.Context) error { return probeErr })
+	require.False(t, Ready())
+
+	Up(Nrf)
+	report := Check(context.Background())
+	require.Equal(t, StatusDown, report.Status)
+	require.Len(t, report.Components, 2)
+	require.Equal(t, MongoDB, report.Components[0].Name)
+	require.Equal(t, StatusDown, report.Components[0].Status)
+	require.Equal(t, probeErr.Error(), report.Components[0].Detail)
+
+	// a component down which is not critical does not make the CHF unready
+	probeErr = nil
+	Down(Cgf, errors.New("login failed"))
+	report = Check(context.Background())
+	require.Equal(t, StatusUp, report.Status)
+	require.Len(t, report.Components, 3)
+	require.True(t, Ready())
+}
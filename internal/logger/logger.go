@@ -25,7 +25,9 @@ var (
 	RatingLog           *logrus.Entry
 	AcctLog             *logrus.Entry
 	CgfLog              *logrus.Entry
+	CdrLog              *logrus.Entry
 	UtilLog             *logrus.Entry
+	MgmtLog             *logrus.Entry
 	FtpServerLog        golog.Logger
 )
 
@@ -50,8 +52,10 @@ func init() {
 	NotifyEventLog = NfLog.WithField(logger_util.FieldCategory, "NotifyEvent")
 	RechargingLog = NfLog.WithField(logger_util.FieldCategory, "Recharge")
 	CgfLog = NfLog.WithField(logger_util.FieldCategory, "CGF")
+	CdrLog = NfLog.WithField(logger_util.FieldCategory, "CDR")
 	RatingLog = NfLog.WithField(logger_util.FieldCategory, "Rating")
 	AcctLog = NfLog.WithField(logger_util.FieldCategory, "Acct")
 	UtilLog = NfLog.WithField(logger_util.FieldCategory, "Util")
+	MgmtLog = NfLog.WithField(logger_util.FieldCategory, "Mgmt")
 	FtpServerLog = adapter.NewWrap(CgfLog.Logger).With("component", "CHF", "category", "FTP")
 }
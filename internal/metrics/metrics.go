@@ -0,0 +1,164 @@
+// Package metrics holds the Prometheus collectors of the CHF, served on the /metrics endpoint of the SBI server
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "chf"
+
+// Nchf converged charging operations
+const (
+	OperationCreate  = "create"
+	OperationUpdate  = "update"
+	OperationRelease = "release"
+)
+
+// Diameter interfaces of the CHF
+const (
+	InterfaceRating = "rating"
+	InterfaceAbmf   = "abmf"
+)
+
+var (
+	registry           = prometheus.NewRegistry()
+	activeSessionsOnce sync.Once
+)
+
+var (
+	nchfRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "nchf_requests_total",
+		Help:      "Nchf converged charging requests by operation and HTTP status.",
+	}, []string{"operation", "status"})
+
+	nchfDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "nchf_request_duration_seconds",
+		Help:      "Time to answer Nchf converged charging requests.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"operation"})
+
+	diameterDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "diameter_request_duration_seconds",
+		Help:      "Time to get the answer of Diameter requests to the rating function and the ABMF.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"interface"})
+
+	diameterTimeouts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "diameter_timeouts_total",
+		Help:      "Diameter requests to the rating function and the ABMF left without answer.",
+	}, []string{"interface"})
+
+	grantedUnits = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "granted_units_total",
+		Help:      "Volume granted to consumers, in octets, by rating group.",
+	}, []string{"rating_group"})
+
+	usedUnits = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "used_units_total",
+		Help:      "Volume reported as used by consumers, in octets, by rating group.",
+	}, []string{"rating_group"})
+
+	cdrsGenerated = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cdrs_generated_total",
+		Help:      "CDRs closed by the CHF, by cause for record closing.",
+	}, []string{"cause"})
+
+	cdrFileSize = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "cdr_file_size_bytes",
+		Help:      "Size of the CDR files written.",
+		Buckets:   prometheus.ExponentialBuckets(256, 4, 8),
+	})
+
+	cgfTransfers = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cgf_transfers_total",
+		Help:      "CDR file transfers to the CGF by result.",
+	}, []string{"result"})
+)
+
+func init() {
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		nchfRequests,
+		nchfDuration,
+		diameterDuration,
+		diameterTimeouts,
+		grantedUnits,
+		usedUnits,
+		cdrsGenerated,
+		cdrFileSize,
+		cgfTransfers,
+	)
+}
+
+// Handler serves the metrics in the Prometheus exposition format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
+
+// RegisterActiveSessions exposes the number of open charging sessions, counted by sessions when scraped.
+// Only the first registration is kept.
+func RegisterActiveSessions(sessions func() int) {
+	activeSessionsOnce.Do(func() {
+		registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "active_sessions",
+			Help:      "Charging sessions currently open.",
+		}, func() float64 {
+			return float64(sessions())
+		}))
+	})
+}
+
+func ObserveNchfRequest(operation string, status int, start time.Time) {
+	nchfRequests.WithLabelValues(operation, strconv.Itoa(status)).Inc()
+	nchfDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+}
+
+func ObserveDiameterRequest(diameterInterface string, start time.Time) {
+	diameterDuration.WithLabelValues(diameterInterface).Observe(time.Since(start).Seconds())
+}
+
+func DiameterTimeout(diameterInterface string) {
+	diameterTimeouts.WithLabelValues(diameterInterface).Inc()
+}
+
+func AddGrantedUnits(ratingGroup int32, units uint32) {
+	grantedUnits.WithLabelValues(strconv.Itoa(int(ratingGroup))).Add(float64(units))
+}
+
+func AddUsedUnits(ratingGroup int32, units uint32) {
+	usedUnits.WithLabelValues(strconv.Itoa(int(ratingGroup))).Add(float64(units))
+}
+
+func CdrGenerated(causeForRecClosing int64) {
+	cdrsGenerated.WithLabelValues(strconv.FormatInt(causeForRecClosing, 10)).Inc()
+}
+
+func ObserveCdrFileSize(size uint32) {
+	cdrFileSize.Observe(float64(size))
+}
+
+func CgfTransfer(err error) {
+	if err != nil {
+		cgfTransfers.WithLabelValues("failure").Inc()
+		return
+	}
+	cgfTransfers.WithLabelValues("success").Inc()
+}
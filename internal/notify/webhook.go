@@ -0,0 +1,82 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/free5gc/chf/internal/logger"
+	"github.com/free5gc/chf/pkg/factory"
+)
+
+const defaultWebhookTimeout = 3 * time.Second
+
+type EventType string
+
+const (
+	EventTypeLowBalance EventType = "LOW_BALANCE"
+)
+
+// SubscriberEvent is the body posted to the notification webhook
+type SubscriberEvent struct {
+	EventType   EventType `json:"eventType"`
+	Supi        string    `json:"supi"`
+	RatingGroup int32     `json:"ratingGroup"`
+	// Remaining balance of the subscriber for the rating group, in currency units
+	RemainingBalance int64     `json:"remainingBalance"`
+	Timestamp        time.Time `json:"timestamp"`
+}
+
+// SendLowBalanceNotification posts a low balance event to the notification webhook in the background,
+// it does nothing if no webhook is configured
+func SendLowBalanceNotification(supi string, rg int32, remainingBalance int64) {
+	event := &SubscriberEvent{
+		EventType:        EventTypeLowBalance,
+		Supi:             supi,
+		RatingGroup:      rg,
+		RemainingBalance: remainingBalance,
+		Timestamp:        time.Now(),
+	}
+
+	go func() {
+		if err := sendEvent(event); err != nil {
+			logger.NotifyEventLog.Warnf("Send %s notification of UE[%s] failed: %+v", event.EventType, supi, err)
+		}
+	}()
+}
+
+func sendEvent(event *SubscriberEvent) error {
+	webhook := factory.ChfConfig.GetNotificationWebhook()
+	if webhook == nil {
+		return nil
+	}
+
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	timeout := defaultWebhookTimeout
+	if webhook.Timeout > 0 {
+		timeout = time.Duration(webhook.Timeout) * time.Second
+	}
+	client := &http.Client{Timeout: timeout}
+
+	rsp, err := client.Post(webhook.Uri, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if rspCloseErr := rsp.Body.Close(); rspCloseErr != nil {
+			logger.NotifyEventLog.Errorf("Webhook response body cannot close: %+v", rspCloseErr)
+		}
+	}()
+
+	if rsp.StatusCode < 200 || rsp.StatusCode >= 300 {
+		return fmt.Errorf("webhook answered %s", rsp.Status)
+	}
+	logger.NotifyEventLog.Infof("Sent %s notification of UE[%s]", event.EventType, event.Supi)
+	return nil
+}
@@ -1,6 +1,7 @@
 package rating
 
 import (
+	"context"
 	"fmt"
 	"strconv"
 	"time"
@@ -9,17 +10,44 @@ import (
 	"github.com/fiorix/go-diameter/diam/datatype"
 	"github.com/fiorix/go-diameter/diam/dict"
 	"github.com/fiorix/go-diameter/diam/sm/smpeer"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 
 	charging_code "github.com/free5gc/chf/ccs_diameter/code"
 	charging_datatype "github.com/free5gc/chf/ccs_diameter/datatype"
 	chf_context "github.com/free5gc/chf/internal/context"
+	"github.com/free5gc/chf/internal/health"
 	"github.com/free5gc/chf/internal/logger"
+	"github.com/free5gc/chf/internal/metrics"
+	"github.com/free5gc/chf/internal/tracing"
 	"github.com/free5gc/chf/pkg/factory"
 )
 
+// SendServiceUsageRequest sends the SUR to the rating function and waits for its answer
 func SendServiceUsageRequest(
+	ctx context.Context, ue *chf_context.ChfUe, sur *charging_datatype.ServiceUsageRequest,
+) (*charging_datatype.ServiceUsageResponse, error) {
+	// the rating function does not get the trace context, the session id ties its spans to this one
+	_, span := tracing.StartKind(ctx, "Rating SUR", trace.SpanKindClient,
+		attribute.String("chf.supi", ue.Supi),
+		attribute.String("diameter.session_id", string(sur.SessionId)))
+	sua, err := sendServiceUsageRequest(ue, sur)
+	if err != nil {
+		health.Down(health.RatingPeer, err)
+	} else {
+		health.Up(health.RatingPeer)
+	}
+	if sua != nil {
+		span.SetAttributes(attribute.Int64("diameter.result_code", int64(sua.ResultCode)))
+	}
+	tracing.End(span, err)
+	return sua, err
+}
+
+func sendServiceUsageRequest(
 	ue *chf_context.ChfUe, sur *charging_datatype.ServiceUsageRequest,
 ) (*charging_datatype.ServiceUsageResponse, error) {
+	defer metrics.ObserveDiameterRequest(metrics.InterfaceRating, time.Now())
 	ue.RatingMux.Handle("SUA", HandleSUA(ue.RatingChan))
 	rfDiameter := factory.ChfConfig.Configuration.RfDiameter
 	addr := rfDiameter.HostIPv4 + ":" + strconv.Itoa(rfDiameter.Port)
@@ -56,6 +84,7 @@ func SendServiceUsageRequest(
 		}
 		return &sua, nil
 	case <-time.After(5 * time.Second):
+		metrics.DiameterTimeout(metrics.InterfaceRating)
 		return nil, fmt.Errorf("timeout: no rate answer received")
 	}
 }
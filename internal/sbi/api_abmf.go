@@ -0,0 +1,158 @@
+package sbi
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/free5gc/chf/internal/logger"
+	"github.com/free5gc/chf/pkg/abmf"
+	"github.com/free5gc/openapi/models"
+)
+
+func (s *Server) getAbmfRoutes() []Route {
+	return []Route{
+		{
+			Name:    "LedgerGet",
+			Method:  http.MethodGet,
+			Pattern: "/ledger/:supi",
+			APIFunc: s.LedgerGet,
+		},
+		{
+			Name:    "ReconciliationPost",
+			Method:  http.MethodPost,
+			Pattern: "/reconciliation",
+			APIFunc: s.ReconciliationPost,
+		},
+		{
+			Name:    "TopUpPost",
+			Method:  http.MethodPost,
+			Pattern: "/top-up",
+			APIFunc: s.TopUpPost,
+		},
+		{
+			Name:    "VoucherBatchPost",
+			Method:  http.MethodPost,
+			Pattern: "/vouchers",
+			APIFunc: s.VoucherBatchPost,
+		},
+		{
+			Name:    "VouchersGet",
+			Method:  http.MethodGet,
+			Pattern: "/vouchers",
+			APIFunc: s.VouchersGet,
+		},
+		{
+			Name:    "VoucherGet",
+			Method:  http.MethodGet,
+			Pattern: "/vouchers/:code",
+			APIFunc: s.VoucherGet,
+		},
+		{
+			Name:    "VoucherPatch",
+			Method:  http.MethodPatch,
+			Pattern: "/vouchers/:code",
+			APIFunc: s.VoucherPatch,
+		},
+	}
+}
+
+// LedgerGet returns the ledger entries of a subscriber, optionally restricted to [from, to) given in RFC 3339
+func (s *Server) LedgerGet(c *gin.Context) {
+	var from, to time.Time
+	var err error
+
+	if fromStr := c.Query("from"); fromStr != "" {
+		if from, err = time.Parse(time.RFC3339, fromStr); err != nil {
+			s.abmfBadRequest(c, "[Query] from: "+err.Error())
+			return
+		}
+	}
+	if toStr := c.Query("to"); toStr != "" {
+		if to, err = time.Parse(time.RFC3339, toStr); err != nil {
+			s.abmfBadRequest(c, "[Query] to: "+err.Error())
+			return
+		}
+	}
+
+	s.Processor().HandleLedgerGet(c, c.Param("supi"), from, to)
+}
+
+// ReconciliationPost replays the ledger of the account given by the accountKey query parameter, or of all accounts
+func (s *Server) ReconciliationPost(c *gin.Context) {
+	s.Processor().HandleReconciliationPost(c, c.Query("accountKey"))
+}
+
+// TopUpPost credits a balance of a subscriber, then re-authorizes the rating groups blocked by its exhausted balance
+func (s *Server) TopUpPost(c *gin.Context) {
+	var topUp abmf.TopUp
+	if !s.bindAbmfBody(c, &topUp) {
+		return
+	}
+
+	s.Processor().HandleTopUpPost(c, &topUp)
+}
+
+// VoucherBatchPost generates a batch of vouchers, the answer is the only place their codes are returned in bulk
+func (s *Server) VoucherBatchPost(c *gin.Context) {
+	var batch abmf.VoucherBatch
+	if !s.bindAbmfBody(c, &batch) {
+		return
+	}
+
+	s.Processor().HandleVoucherBatchPost(c, &batch)
+}
+
+// VouchersGet lists the vouchers, optionally filtered by the batchId and state query parameters
+func (s *Server) VouchersGet(c *gin.Context) {
+	s.Processor().HandleVouchersGet(c, c.Query("batchId"), abmf.VoucherState(c.Query("state")))
+}
+
+func (s *Server) VoucherGet(c *gin.Context) {
+	s.Processor().HandleVoucherGet(c, c.Param("code"))
+}
+
+// VoucherPatch blocks or unblocks a voucher, the body only carries its new state
+func (s *Server) VoucherPatch(c *gin.Context) {
+	var patch struct {
+		State abmf.VoucherState `json:"state"`
+	}
+	if !s.bindAbmfBody(c, &patch) {
+		return
+	}
+
+	s.Processor().HandleVoucherPatch(c, c.Param("code"), patch.State)
+}
+
+// bindAbmfBody decodes the JSON request body into v, it answers the request itself on failure
+func (s *Server) bindAbmfBody(c *gin.Context, v interface{}) bool {
+	requestBody, err := c.GetRawData()
+	if err != nil {
+		logger.AcctLog.Errorf("Get Request Body error: %+v", err)
+		c.JSON(http.StatusInternalServerError, models.ProblemDetails{
+			Title:  "System failure",
+			Status: http.StatusInternalServerError,
+			Detail: err.Error(),
+			Cause:  "SYSTEM_FAILURE",
+		})
+		return false
+	}
+
+	if err = json.Unmarshal(requestBody, v); err != nil {
+		s.abmfBadRequest(c, "[Request Body] "+err.Error())
+		return false
+	}
+	return true
+}
+
+func (s *Server) abmfBadRequest(c *gin.Context, detail string) {
+	rsp := models.ProblemDetails{
+		Title:  "Malformed request syntax",
+		Status: http.StatusBadRequest,
+		Detail: detail,
+	}
+	logger.AcctLog.Errorln(detail)
+	c.JSON(http.StatusBadRequest, rsp)
+}
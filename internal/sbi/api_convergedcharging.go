@@ -12,8 +12,6 @@ package sbi
 
 import (
 	"net/http"
-	"strconv"
-	"strings"
 
 	"github.com/gin-gonic/gin"
 
@@ -46,16 +44,6 @@ func (s *Server) getConvergenChargingRoutes() []Route {
 			Pattern: "/chargingdata",
 			APIFunc: s.ChargingdataPost,
 		},
-		{
-			Method:  http.MethodGet,
-			Pattern: "/recharging",
-			APIFunc: s.RechargeGet,
-		},
-		{
-			Method:  http.MethodPut,
-			Pattern: "/recharging/:rechargingInfo",
-			APIFunc: s.RechargePut,
-		},
 	}
 }
 
@@ -159,24 +147,3 @@ func (s *Server) ChargingdataPost(c *gin.Context) {
 
 	s.Processor().HandleChargingdataInitial(c, chargingDataReq)
 }
-
-func (s *Server) RechargeGet(c *gin.Context) {
-	c.String(http.StatusOK, "recharge")
-}
-
-func (s *Server) RechargePut(c *gin.Context) {
-	rechargingInfo := c.Param("rechargingInfo")
-	ueIdRatingGroup := strings.Split(rechargingInfo, "_")
-	ueId := ueIdRatingGroup[0]
-	rgStr := ueIdRatingGroup[1]
-	rg, err := strconv.Atoi(rgStr)
-	if err != nil {
-		logger.RechargingLog.Errorf("UE[%s] fail to recharge for rating group %s", ueId, rgStr)
-	}
-
-	logger.RechargingLog.Warnf("UE[%s] Recharg for rating group %d", ueId, rg)
-
-	s.Processor().NotifyRecharge(ueId, int32(rg))
-
-	c.JSON(http.StatusNoContent, gin.H{})
-}
@@ -0,0 +1,59 @@
+package sbi
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/free5gc/chf/internal/health"
+	"github.com/free5gc/openapi/models"
+)
+
+// retryAfter is the delay, in seconds, advertised to the consumers while the CHF is not ready
+const retryAfter = 5
+
+func (s *Server) getHealthRoutes() []Route {
+	return []Route{
+		{
+			Name:    "HealthLiveGet",
+			Method:  http.MethodGet,
+			Pattern: "/live",
+			APIFunc: s.HealthLiveGet,
+		},
+		{
+			Name:    "HealthReadyGet",
+			Method:  http.MethodGet,
+			Pattern: "/ready",
+			APIFunc: s.HealthReadyGet,
+		},
+	}
+}
+
+// HealthLiveGet answers as long as the SBI server is serving
+func (s *Server) HealthLiveGet(c *gin.Context) {
+	c.JSON(http.StatusOK, health.Report{Status: health.StatusUp})
+}
+
+// HealthReadyGet reports the status of the dependencies, with 503 until the critical ones are up
+func (s *Server) HealthReadyGet(c *gin.Context) {
+	report := health.Check(c.Request.Context())
+	if report.Status != health.StatusUp {
+		c.JSON(http.StatusServiceUnavailable, report)
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
+
+// readinessGate refuses the requests of the Nchf services until the CHF is ready
+func readinessGate(c *gin.Context) {
+	if health.Ready() {
+		return
+	}
+	c.Header("Retry-After", strconv.Itoa(retryAfter))
+	c.AbortWithStatusJSON(http.StatusServiceUnavailable, &models.ProblemDetails{
+		Title:  "Service unavailable",
+		Status: http.StatusServiceUnavailable,
+		Detail: "CHF dependencies are not ready",
+	})
+}
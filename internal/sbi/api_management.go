@@ -0,0 +1,110 @@
+package sbi
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/free5gc/chf/internal/logger"
+	"github.com/free5gc/chf/internal/sbi/processor"
+	"github.com/free5gc/openapi/models"
+)
+
+func (s *Server) getManagementRoutes() []Route {
+	return []Route{
+		{
+			Name:    "SessionsGet",
+			Method:  http.MethodGet,
+			Pattern: "/sessions",
+			APIFunc: s.SessionsGet,
+		},
+		{
+			Name:    "SessionGet",
+			Method:  http.MethodGet,
+			Pattern: "/sessions/:chargingDataRef",
+			APIFunc: s.SessionGet,
+		},
+		{
+			Name:    "SessionClosePost",
+			Method:  http.MethodPost,
+			Pattern: "/sessions/:chargingDataRef/close",
+			APIFunc: s.SessionClosePost,
+		},
+		{
+			Name:    "SessionAbortPost",
+			Method:  http.MethodPost,
+			Pattern: "/sessions/:chargingDataRef/abort",
+			APIFunc: s.SessionAbortPost,
+		},
+		{
+			Name:    "ConfigReloadPost",
+			Method:  http.MethodPost,
+			Pattern: "/config/reload",
+			APIFunc: s.ConfigReloadPost,
+		},
+	}
+}
+
+// SessionsGet lists the active charging sessions, optionally filtered by the supi, dnn, sst, sd
+// and consumerNf (name or type) query parameters
+func (s *Server) SessionsGet(c *gin.Context) {
+	filter := processor.SessionFilter{
+		Supi:       c.Query("supi"),
+		Dnn:        c.Query("dnn"),
+		ConsumerNf: c.Query("consumerNf"),
+	}
+
+	if sstStr := c.Query("sst"); sstStr != "" {
+		sst, err := strconv.ParseUint(sstStr, 10, 8)
+		if err != nil {
+			s.managementBadRequest(c, "[Query] sst: "+err.Error())
+			return
+		}
+		filter.Snssai = &models.Snssai{Sst: int32(sst), Sd: c.Query("sd")}
+	} else if c.Query("sd") != "" {
+		s.managementBadRequest(c, "[Query] sd without sst")
+		return
+	}
+
+	s.Processor().HandleSessionsGet(c, &filter)
+}
+
+// SessionGet returns a charging session with its rating groups and open CDR
+func (s *Server) SessionGet(c *gin.Context) {
+	s.Processor().HandleSessionGet(c, c.Param("chargingDataRef"))
+}
+
+// SessionClosePost force-closes a charging session
+func (s *Server) SessionClosePost(c *gin.Context) {
+	s.Processor().HandleSessionClose(c, c.Param("chargingDataRef"))
+}
+
+// SessionAbortPost asks the consumer of a charging session to abort it
+func (s *Server) SessionAbortPost(c *gin.Context) {
+	s.Processor().HandleSessionAbort(c, c.Param("chargingDataRef"))
+}
+
+// ConfigReloadPost reloads the configuration file, the same as a SIGHUP, and reports the settings changed
+func (s *Server) ConfigReloadPost(c *gin.Context) {
+	report, err := s.ReloadConfig()
+	if err != nil {
+		c.JSON(http.StatusUnprocessableEntity, models.ProblemDetails{
+			Title:  "Invalid configuration",
+			Status: http.StatusUnprocessableEntity,
+			Detail: err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
+
+func (s *Server) managementBadRequest(c *gin.Context, detail string) {
+	rsp := models.ProblemDetails{
+		Title:  "Malformed request syntax",
+		Status: http.StatusBadRequest,
+		Detail: detail,
+	}
+	logger.MgmtLog.Errorln(detail)
+	c.JSON(http.StatusBadRequest, rsp)
+}
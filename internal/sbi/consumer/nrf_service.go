@@ -2,6 +2,7 @@ package consumer
 
 import (
 	"context"
+	"net/http"
 	"strings"
 	"sync"
 	"time"
@@ -9,6 +10,7 @@ import (
 	"github.com/pkg/errors"
 
 	chf_context "github.com/free5gc/chf/internal/context"
+	"github.com/free5gc/chf/internal/health"
 	"github.com/free5gc/chf/internal/logger"
 	"github.com/free5gc/openapi"
 	"github.com/free5gc/openapi/models"
@@ -106,8 +108,9 @@ func (s *nnrfService) SendDeregisterNFInstance() (*models.ProblemDetails, error)
 
 	chfContext := s.consumer.Context()
 	client := s.getNFManagementClient(chfContext.NrfUri)
+	nfId := chfContext.GetSelfID()
 	request := &Nnrf_NFManagement.DeregisterNFInstanceRequest{
-		NfInstanceID: &chfContext.NfId,
+		NfInstanceID: &nfId,
 	}
 
 	_, err = client.NFInstanceIDDocumentApi.DeregisterNFInstance(ctx, request)
@@ -134,7 +137,7 @@ func (s *nnrfService) RegisterNFInstance(ctx context.Context) (
 	var nf models.NrfNfManagementNfProfile
 	var res *Nnrf_NFManagement.RegisterNFInstanceResponse
 	registerNFInstanceRequest := &Nnrf_NFManagement.RegisterNFInstanceRequest{
-		NfInstanceID:             &chfContext.NfId,
+		NfInstanceID:             &nfProfile.NfInstanceId,
 		NrfNfManagementNfProfile: &nfProfile,
 	}
 	for {
@@ -150,6 +153,10 @@ func (s *nnrfService) RegisterNFInstance(ctx context.Context) (
 			continue
 		}
 		nf = res.NrfNfManagementNfProfile
+		// the NRF imposes the heartbeat timer
+		if nf.HeartBeatTimer > 0 {
+			chfContext.HeartbeatTimer = nf.HeartBeatTimer
+		}
 
 		// http.StatusOK
 		if res.Location == "" {
@@ -183,7 +190,7 @@ func (s *nnrfService) RegisterNFInstance(ctx context.Context) (
 func (s *nnrfService) buildNfProfile(
 	chfContext *chf_context.CHFContext,
 ) (profile models.NrfNfManagementNfProfile, err error) {
-	profile.NfInstanceId = chfContext.NfId
+	profile.NfInstanceId = chfContext.GetSelfID()
 	profile.NfType = models.NrfNfManagementNfType_CHF
 	profile.NfStatus = models.NrfNfManagementNfStatus_REGISTERED
 	profile.Ipv4Addresses = append(profile.Ipv4Addresses, chfContext.RegisterIPv4)
@@ -194,17 +201,105 @@ func (s *nnrfService) buildNfProfile(
 	if len(services) > 0 {
 		profile.NfServices = services
 	}
-	profile.ChfInfo = &models.ChfInfo{
-		// Todo
-		// SupiRanges: &[]models.SupiRange{
-		// 	{
-		// 		// from TS 29.510 6.1.6.2.9 example2
-		//		// no need to set supirange in this moment 2019/10/4
-		// 		Start:   "123456789040000",
-		// 		End:     "123456789059999",
-		// 		Pattern: "^imsi-12345678904[0-9]{4}$",
-		// 	},
-		// },
-	}
+	chfInfo := chfContext.GetChfInfo()
+	profile.ChfInfo = &chfInfo
+	profile.HeartBeatTimer = chfContext.HeartbeatTimer
+	profile.Capacity = chfContext.Capacity
+	profile.Load = chfContext.Load()
 	return
 }
+
+// SendHeartbeat tells the NRF the CHF is still registered, with its current load
+func (s *nnrfService) SendHeartbeat() (*models.ProblemDetails, error) {
+	chfContext := s.consumer.Context()
+	patchItems := []models.PatchItem{
+		{
+			Op:    models.PatchOperation_REPLACE,
+			Path:  "/nfStatus",
+			Value: models.NrfNfManagementNfStatus_REGISTERED,
+		},
+	}
+	if chfContext.MaxSessions > 0 {
+		patchItems = append(patchItems, models.PatchItem{
+			Op:    models.PatchOperation_REPLACE,
+			Path:  "/load",
+			Value: chfContext.Load(),
+		})
+	}
+	return s.SendUpdateNFInstance(patchItems)
+}
+
+// RunHeartbeat sends the heartbeats until ctx is done. The CHF registers again when the NRF does not know
+// its profile anymore, e.g. after a restart of the NRF.
+func (s *nnrfService) RunHeartbeat(ctx context.Context) {
+	for {
+		interval := time.Duration(s.consumer.Context().HeartbeatTimer) * time.Second
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+
+		problemDetails, err := s.SendHeartbeat()
+		if err == nil {
+			health.Up(health.Nrf)
+			continue
+		}
+		health.Down(health.Nrf, err)
+
+		if apiErr, ok := err.(openapi.GenericOpenAPIError); !ok || apiErr.ErrorStatus != http.StatusNotFound {
+			logger.ConsumerLog.Errorf("CHF heartbeat to NRF Error[%v] Problem[%+v]", err, problemDetails)
+			continue
+		}
+
+		logger.ConsumerLog.Warnf("CHF profile not found in NRF, register again")
+		_, nfId, err := s.RegisterNFInstance(ctx)
+		if err != nil {
+			logger.ConsumerLog.Errorf("CHF register to NRF Error[%v]", err)
+			continue
+		}
+		if nfId != "" {
+			s.consumer.Context().SetNfId(nfId)
+		}
+		health.Up(health.Nrf)
+	}
+}
+
+// SendUpdateChfInfo replaces the ChfInfo of the NRF profile by the one of the context
+func (s *nnrfService) SendUpdateChfInfo() (*models.ProblemDetails, error) {
+	chfContext := s.consumer.Context()
+	return s.SendUpdateNFInstance([]models.PatchItem{
+		{
+			Op:    models.PatchOperation_REPLACE,
+			Path:  "/chfInfo",
+			Value: chfContext.GetChfInfo(),
+		},
+	})
+}
+
+func (s *nnrfService) SendUpdateNFInstance(patchItems []models.PatchItem) (*models.ProblemDetails, error) {
+	logger.ConsumerLog.Debugf("Send Update NFInstance")
+
+	ctx, pd, err := chf_context.GetSelf().GetTokenCtx(models.ServiceName_NNRF_NFM, models.NrfNfManagementNfType_NRF)
+	if err != nil {
+		return pd, err
+	}
+
+	chfContext := s.consumer.Context()
+	client := s.getNFManagementClient(chfContext.NrfUri)
+	nfId := chfContext.GetSelfID()
+	request := &Nnrf_NFManagement.UpdateNFInstanceRequest{
+		NfInstanceID: &nfId,
+		PatchItem:    patchItems,
+	}
+
+	_, err = client.NFInstanceIDDocumentApi.UpdateNFInstance(ctx, request)
+	if apiErr, ok := err.(openapi.GenericOpenAPIError); ok {
+		// API error
+		if updateNfError, okUpdate := apiErr.Model().(Nnrf_NFManagement.UpdateNFInstanceError); okUpdate {
+			return &updateNfError.ProblemDetails, err
+		}
+		return nil, err
+	}
+	return nil, err
+}
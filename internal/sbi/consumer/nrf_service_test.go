@@ -10,6 +10,7 @@ import (
 
 	chf_context "github.com/free5gc/chf/internal/context"
 	"github.com/free5gc/chf/pkg/app"
+	"github.com/free5gc/chf/pkg/factory"
 	"github.com/free5gc/openapi"
 )
 
@@ -68,3 +69,64 @@ func Test_nnrfService_SendDeregisterNFInstance(t *testing.T) {
 	_, err = consumer.SendDeregisterNFInstance()
 	require.NoError(t, err)
 }
+
+func Test_nnrfService_SendUpdateChfInfo(t *testing.T) {
+	defer gock.Off() // Flush pending mocks after test execution
+
+	openapi.InterceptH2CClient()
+	defer openapi.RestoreH2CClient()
+
+	gock.New("http://127.0.0.10:8000").
+		Patch("/nnrf-nfm/v1/nf-instances/1").
+		BodyString(`"path":"/chfInfo","value":\{"supiRangeList":\[\{"start":"208930000000001","end":"208930000000099"\}\]`).
+		Reply(204)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockApp := app.NewMockApp(ctrl)
+	consumer, err := NewConsumer(mockApp)
+	require.NoError(t, err)
+
+	chfContext := &chf_context.CHFContext{
+		NrfUri: "http://127.0.0.10:8000",
+		NfId:   "1",
+	}
+	chfContext.SetChfInfo(&factory.ChfInfo{
+		SupiRanges: []factory.IdentityRange{{Start: "208930000000001", End: "208930000000099"}},
+	})
+	mockApp.EXPECT().Context().Times(2).Return(chfContext)
+
+	_, err = consumer.SendUpdateChfInfo()
+	require.NoError(t, err)
+	require.True(t, gock.IsDone())
+}
+
+func Test_nnrfService_SendHeartbeat(t *testing.T) {
+	defer gock.Off() // Flush pending mocks after test execution
+
+	openapi.InterceptH2CClient()
+	defer openapi.RestoreH2CClient()
+
+	gock.New("http://127.0.0.10:8000").
+		Patch("/nnrf-nfm/v1/nf-instances/1").
+		BodyString(`"path":"/nfStatus","value":"REGISTERED"\},\{"op":"replace","path":"/load"`).
+		Reply(204)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+	mockApp := app.NewMockApp(ctrl)
+	consumer, err := NewConsumer(mockApp)
+	require.NoError(t, err)
+
+	mockApp.EXPECT().Context().Times(2).Return(
+		&chf_context.CHFContext{
+			NrfUri:      "http://127.0.0.10:8000",
+			NfId:        "1",
+			MaxSessions: 1000,
+		},
+	)
+
+	_, err = consumer.SendHeartbeat()
+	require.NoError(t, err)
+	require.True(t, gock.IsDone())
+}
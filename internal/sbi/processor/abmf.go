@@ -0,0 +1,134 @@
+package processor
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/free5gc/chf/internal/logger"
+	"github.com/free5gc/chf/pkg/abmf"
+	"github.com/free5gc/openapi/models"
+)
+
+func (p *Processor) HandleLedgerGet(c *gin.Context, supi string, from, to time.Time) {
+	entries, err := abmf.QueryLedger(supi, from, to)
+	if err != nil {
+		logger.AcctLog.Errorf("Query ledger of UE[%s] err: %+v", supi, err)
+		c.JSON(http.StatusInternalServerError, systemFailure(err))
+		return
+	}
+	c.JSON(http.StatusOK, entries)
+}
+
+func (p *Processor) HandleReconciliationPost(c *gin.Context, accountKey string) {
+	mismatches, err := abmf.Reconcile(accountKey)
+	if err != nil {
+		logger.AcctLog.Errorf("Reconciliation err: %+v", err)
+		c.JSON(http.StatusInternalServerError, systemFailure(err))
+		return
+	}
+	c.JSON(http.StatusOK, mismatches)
+}
+
+func (p *Processor) HandleTopUpPost(c *gin.Context, topUp *abmf.TopUp) {
+	result, err := abmf.TopUpAccount(topUp)
+	if err != nil {
+		logger.RechargingLog.Errorf("Top-up of UE[%s] failed: %+v", topUp.Supi, err)
+		c.JSON(abmfProblem(err))
+		return
+	}
+
+	// only a new credit can unblock rating groups, a replayed one already did
+	if !result.Replayed {
+		p.NotifyRecharge(topUp.Supi)
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+func (p *Processor) HandleVoucherBatchPost(c *gin.Context, batch *abmf.VoucherBatch) {
+	vouchers, err := abmf.GenerateVouchers(batch)
+	if err != nil {
+		logger.AcctLog.Errorf("Generate vouchers err: %+v", err)
+		c.JSON(abmfProblem(err))
+		return
+	}
+	c.JSON(http.StatusCreated, vouchers)
+}
+
+func (p *Processor) HandleVouchersGet(c *gin.Context, batchId string, state abmf.VoucherState) {
+	vouchers, err := abmf.QueryVouchers(batchId, state)
+	if err != nil {
+		logger.AcctLog.Errorf("Query vouchers err: %+v", err)
+		c.JSON(abmfProblem(err))
+		return
+	}
+	c.JSON(http.StatusOK, vouchers)
+}
+
+func (p *Processor) HandleVoucherGet(c *gin.Context, code string) {
+	voucher, err := abmf.GetVoucher(code)
+	if err != nil {
+		logger.AcctLog.Errorf("Get voucher err: %+v", err)
+		c.JSON(abmfProblem(err))
+		return
+	}
+	c.JSON(http.StatusOK, voucher)
+}
+
+func (p *Processor) HandleVoucherPatch(c *gin.Context, code string, state abmf.VoucherState) {
+	voucher, err := abmf.SetVoucherState(code, state)
+	if err != nil {
+		logger.AcctLog.Errorf("Set state of voucher [%s] err: %+v", code, err)
+		c.JSON(abmfProblem(err))
+		return
+	}
+	c.JSON(http.StatusOK, voucher)
+}
+
+// abmfProblem maps the errors of the ABMF balance management to the status and ProblemDetails of the answer
+func abmfProblem(err error) (int, *models.ProblemDetails) {
+	problemDetails := &models.ProblemDetails{
+		Detail: err.Error(),
+	}
+	switch {
+	case errors.Is(err, abmf.ErrInvalidTopUp), errors.Is(err, abmf.ErrInvalidVoucherBatch):
+		problemDetails.Title = "Malformed request syntax"
+		problemDetails.Status = http.StatusBadRequest
+		problemDetails.Cause = "MANDATORY_IE_INCORRECT"
+	case errors.Is(err, abmf.ErrUserUnknown):
+		problemDetails.Status = http.StatusNotFound
+		problemDetails.Cause = "USER_UNKNOWN"
+	case errors.Is(err, abmf.ErrBalanceNotFound), errors.Is(err, abmf.ErrCurrencyMismatch):
+		problemDetails.Status = http.StatusBadRequest
+		problemDetails.Cause = "REQUESTED_BALANCE_NOT_APPLICABLE"
+	case errors.Is(err, abmf.ErrIdempotencyConflict):
+		problemDetails.Status = http.StatusConflict
+		problemDetails.Cause = "IDEMPOTENCY_KEY_CONFLICT"
+	case errors.Is(err, abmf.ErrVoucherNotFound):
+		problemDetails.Status = http.StatusNotFound
+		problemDetails.Cause = "VOUCHER_NOT_FOUND"
+	case errors.Is(err, abmf.ErrVoucherUsed):
+		problemDetails.Status = http.StatusConflict
+		problemDetails.Cause = "VOUCHER_ALREADY_REDEEMED"
+	case errors.Is(err, abmf.ErrVoucherUnavailable):
+		problemDetails.Status = http.StatusForbidden
+		problemDetails.Cause = "VOUCHER_NOT_REDEEMABLE"
+	case errors.Is(err, abmf.ErrInvalidVoucherState):
+		problemDetails.Status = http.StatusConflict
+		problemDetails.Cause = "INVALID_VOUCHER_STATE"
+	default:
+		problemDetails = systemFailure(err)
+	}
+	return int(problemDetails.Status), problemDetails
+}
+
+func systemFailure(err error) *models.ProblemDetails {
+	return &models.ProblemDetails{
+		Title:  "System failure",
+		Status: http.StatusInternalServerError,
+		Detail: err.Error(),
+		Cause:  "SYSTEM_FAILURE",
+	}
+}
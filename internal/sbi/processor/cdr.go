@@ -1,19 +1,32 @@
 package processor
 
 import (
+	"context"
 	"fmt"
 	"strings"
 	"time"
 
+	"go.opentelemetry.io/otel/attribute"
+
 	"github.com/free5gc/chf/cdr/asn"
 	"github.com/free5gc/chf/cdr/cdrConvert"
 	"github.com/free5gc/chf/cdr/cdrFile"
 	"github.com/free5gc/chf/cdr/cdrType"
 	chf_context "github.com/free5gc/chf/internal/context"
 	"github.com/free5gc/chf/internal/logger"
+	"github.com/free5gc/chf/internal/metrics"
+	"github.com/free5gc/chf/internal/tracing"
 	"github.com/free5gc/openapi/models"
 )
 
+// Cause for record closing set by the CHF, the full list is in CloseCDR
+const (
+	causeNormalRelease          = 0
+	causePartialRecord          = 1
+	causeAbnormalRelease        = 4
+	causeManagementIntervention = 20
+)
+
 func (p *Processor) OpenCDR(
 	chargingData models.ChfConvergedChargingChargingDataRequest,
 	ue *chf_context.ChfUe,
@@ -29,13 +42,27 @@ func (p *Processor) OpenCDR(
 	// Record Sequence Number(Conditional IE): Partial record sequence number, only present in case of partial records.
 	// Partial CDR: Fragments of CDR, for long session charging
 	if partialRecord {
-		// TODO partial record
-		cdr := ue.Cdr[sessionId]
-		partialRecordSeqNum := self.RecordSequenceNumber[sessionId]
-		partialRecordSeqNum++
-		cdr.ChargingFunctionRecord.RecordSequenceNumber = &(partialRecordSeqNum)
+		// the session goes on in a copy of its record closed as partial, without the usage and triggers already
+		// written in it
+		chfCdr = *ue.Cdr[sessionId].ChargingFunctionRecord
+		chfCdr.ListOfMultipleUnitUsage = nil
+		chfCdr.Triggers = nil
+		chfCdr.CauseForRecClosing = cdrType.CauseForRecClosing{}
+		t := time.Now()
+		chfCdr.RecordOpeningTime = cdrConvert.TimeStampToCdr(&t)
+		recordSequenceNumber := self.NextRecordSequenceNumber(sessionId)
+		chfCdr.RecordSequenceNumber = &recordSequenceNumber
+		self.Lock()
+		self.LocalRecordSequenceNumber++
+		chfCdr.LocalRecordSequenceNumber = &cdrType.LocalSequenceNumber{
+			Value: int64(self.LocalRecordSequenceNumber),
+		}
+		self.Unlock()
 
-		return cdr, nil
+		return &cdrType.CHFRecord{
+			Present:                1,
+			ChargingFunctionRecord: &chfCdr,
+		}, nil
 	}
 
 	chfCdr.RecordType = cdrType.RecordType{
@@ -44,7 +71,7 @@ func (p *Processor) OpenCDR(
 
 	// TODO IA5 string coversion
 	chfCdr.RecordingNetworkFunctionID = cdrType.NetworkFunctionName{
-		Value: asn.IA5String(self.NfId),
+		Value: asn.IA5String(self.GetSelfID()),
 	}
 
 	// RecordOpeningTime:
@@ -227,10 +254,6 @@ func (p *Processor) UpdateCDR(
 }
 
 func (p *Processor) CloseCDR(record *cdrType.CHFRecord, partial bool) error {
-	logger.ChargingdataPostLog.Infof("Close CDR")
-
-	chfCdr := record.ChargingFunctionRecord
-
 	// Initial Cause for record closing
 	// 	normalRelease  (0),
 	// partialRecord  (1),
@@ -254,51 +277,57 @@ func (p *Processor) CloseCDR(record *cdrType.CHFRecord, partial bool) error {
 	// unknownOrUnreachableLCSClient	 (58),
 	// listofDownstreamNodeChange	 (59)
 	if partial {
-		chfCdr.CauseForRecClosing = cdrType.CauseForRecClosing{Value: 1}
-	} else {
-		chfCdr.CauseForRecClosing = cdrType.CauseForRecClosing{Value: 0}
+		return closeCdr(record, causePartialRecord)
+	}
+	return closeCdr(record, causeNormalRelease)
+}
+
+func closeCdr(record *cdrType.CHFRecord, cause int64) error {
+	logger.ChargingdataPostLog.Infof("Close CDR")
+	if record == nil || record.ChargingFunctionRecord == nil {
+		return fmt.Errorf("CHFRecord is nil")
 	}
 
+	record.ChargingFunctionRecord.CauseForRecClosing = cdrType.CauseForRecClosing{Value: cause}
+	metrics.CdrGenerated(cause)
 	return nil
 }
 
-func dumpCdrFile(ueid string, records []*cdrType.CHFRecord) error {
-	var cdrfile cdrFile.CDRFile
-	cdrfile.Hdr.LengthOfCdrRouteingFilter = 0
-	cdrfile.Hdr.LengthOfPrivateExtension = 0
-	cdrfile.Hdr.HeaderLength = uint32(52 + cdrfile.Hdr.LengthOfCdrRouteingFilter + cdrfile.Hdr.LengthOfPrivateExtension)
-	if cdrfile.Hdr.HighReleaseIdentifier == 7 {
-		cdrfile.Hdr.HeaderLength++
+// writePartialCdr closes the record of the charging session as partial and writes it, the first partial record
+// of the session is numbered here, the next ones when they are opened
+func (p *Processor) writePartialCdr(ctx context.Context, sessionId string, record *cdrType.CHFRecord) error {
+	if err := closeCdr(record, causePartialRecord); err != nil {
+		return err
 	}
-	if cdrfile.Hdr.LowReleaseIdentifier == 7 {
-		cdrfile.Hdr.HeaderLength++
+	if record.ChargingFunctionRecord.RecordSequenceNumber == nil {
+		recordSequenceNumber := chf_context.GetSelf().NextRecordSequenceNumber(sessionId)
+		record.ChargingFunctionRecord.RecordSequenceNumber = &recordSequenceNumber
 	}
-	cdrfile.Hdr.NumberOfCdrsInFile = uint32(len(records))
-	cdrfile.Hdr.FileLength = cdrfile.Hdr.HeaderLength
-	logger.ChargingdataPostLog.Traceln("cdrfile.Hdr.NumberOfCdrsInFile:", uint32(len(records)))
+	return p.writeCdrs(ctx, record)
+}
 
+// writeCdrs appends the closed records to the CDR file being written
+func (p *Processor) writeCdrs(ctx context.Context, records ...*cdrType.CHFRecord) error {
+	_, span := tracing.Start(ctx, "CDR encoding", attribute.Int("chf.cdr.count", len(records)))
+	defer span.End()
+
+	cdrs := make([][]byte, 0, len(records))
 	for _, record := range records {
-		cdrBytes, err := asn.BerMarshalWithParams(&record, "explicit,choice")
+		cdrBytes, err := cdrConvert.EncodeRecord(p.cdrFormat, &record)
 		if err != nil {
-			logger.ChargingdataPostLog.Errorln(err)
-		}
-
-		var cdrHdr cdrFile.CdrHeader
-		cdrHdr.CdrLength = uint16(len(cdrBytes))
-		cdrHdr.DataRecordFormat = cdrFile.BasicEncodingRules
-		tmpCdr := cdrFile.CDR{
-			Hdr:     cdrHdr,
-			CdrByte: cdrBytes,
-		}
-		cdrfile.CdrList = append(cdrfile.CdrList, tmpCdr)
-
-		cdrfile.Hdr.FileLength += uint32(len(cdrBytes)) + 4
-		if cdrHdr.ReleaseIdentifier == 7 {
-			cdrfile.Hdr.FileLength++
+			p.cdrWriter.Lost(len(records))
+			return err
 		}
+		cdrs = append(cdrs, cdrBytes)
 	}
 
-	cdrfile.Encoding("/tmp/" + ueid + ".cdr")
+	return p.cdrWriter.Append(p.cdrFormat, cdrs)
+}
 
-	return nil
+// CloseCdrFile closes the CDR file being written on shutdown, and waits for the closed files to be transferred
+func (p *Processor) CloseCdrFile() {
+	if err := p.cdrWriter.Close(cdrFile.NormalClosure); err != nil {
+		logger.CdrLog.Errorf("Close CDR file err: %+v", err)
+	}
+	p.transfers.Wait()
 }
@@ -2,28 +2,29 @@ package processor
 
 import (
 	"context"
-	"encoding/json"
+	"fmt"
 	"math"
 	"net/http"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/fiorix/go-diameter/diam"
 	"github.com/fiorix/go-diameter/diam/datatype"
 	"github.com/gin-gonic/gin"
 	"golang.org/x/exp/constraints"
 
+	charging_code "github.com/free5gc/chf/ccs_diameter/code"
 	charging_datatype "github.com/free5gc/chf/ccs_diameter/datatype"
-	"github.com/free5gc/chf/cdr/asn"
 	"github.com/free5gc/chf/cdr/cdrConvert"
 	"github.com/free5gc/chf/cdr/cdrType"
 	"github.com/free5gc/chf/internal/abmf"
-	"github.com/free5gc/chf/internal/cgf"
 	chf_context "github.com/free5gc/chf/internal/context"
 	"github.com/free5gc/chf/internal/logger"
+	"github.com/free5gc/chf/internal/metrics"
+	"github.com/free5gc/chf/internal/notify"
 	"github.com/free5gc/chf/internal/rating"
-	"github.com/free5gc/chf/internal/util"
-	Nchf_ConvergedCharging "github.com/free5gc/openapi/chf/ConvergedCharging"
+	"github.com/free5gc/chf/pkg/factory"
 	"github.com/free5gc/openapi/models"
 )
 
@@ -34,41 +35,51 @@ func min[T constraints.Ordered](a, b T) T {
 	return b
 }
 
-func (p *Processor) NotifyRecharge(ueId string, rg int32) {
+// NotifyRecharge re-authorizes the rating groups of the UE which were blocked by an exhausted balance,
+// once the balance has been topped up
+func (p *Processor) NotifyRecharge(ueId string) {
 	var reauthorizationDetails []models.ReauthorizationDetails
 
 	self := chf_context.GetSelf()
 	ue, ok := self.ChfUeFindBySupi(ueId)
 	if !ok {
-		logger.NotifyEventLog.Errorf("Do not find charging data for UE: %s", ueId)
+		logger.NotifyEventLog.Debugf("No charging session for UE: %s", ueId)
 		return
 	}
 
-	// If it is previosly set to debit mode due to quota exhausted, need to reverse to the reserve mode
-	ue.RatingType[rg] = charging_datatype.REQ_SUBTYPE_RESERVE
-	reauthorizationDetails = append(reauthorizationDetails, models.ReauthorizationDetails{
-		RatingGroup: rg,
-	})
-
-	notifyRequest := models.ChargingNotifyRequest{
-		ReauthorizationDetails: reauthorizationDetails,
+	ue.CULock.Lock()
+	for _, rg := range ue.RatingGroups {
+		// If it is previosly set to debit mode due to quota exhausted, need to reverse to the reserve mode
+		if ue.RatingType[rg] != charging_datatype.REQ_SUBTYPE_DEBIT {
+			continue
+		}
+		ue.RatingType[rg] = charging_datatype.REQ_SUBTYPE_RESERVE
+		reauthorizationDetails = append(reauthorizationDetails, models.ReauthorizationDetails{
+			RatingGroup: rg,
+		})
+	}
+
+	// the quota is shared by the sessions of the UE, all their consumers are notified
+	var notifications []*ChargingNotification
+	if len(reauthorizationDetails) > 0 {
+		notifyRequest := models.ChargingNotifyRequest{
+			NotificationType:       models.ChfConvergedChargingNotificationType_REAUTHORIZATION,
+			ReauthorizationDetails: reauthorizationDetails,
+		}
+		notified := make(map[string]bool)
+		for chargingDataRef, notifyUri := range ue.NotifyUri {
+			if notifyUri == "" || notified[notifyUri] {
+				continue
+			}
+			notified[notifyUri] = true
+			notifications = append(notifications, newChargingNotification(ue, chargingDataRef, notifyRequest))
+		}
 	}
+	ue.CULock.Unlock()
 
-	p.SendChargingNotification(ue.NotifyUri, notifyRequest)
-}
-
-func (p *Processor) SendChargingNotification(notifyUri string, notifyRequest models.ChargingNotifyRequest) {
-	client := util.GetNchfChargingNotificationCallbackClient()
-	logger.NotifyEventLog.Warn("Send Charging Notification  to SMF: uri: ", notifyUri)
-	chargingNotifyRequest := Nchf_ConvergedCharging.NewPostChargingNotificationRequest()
-	chargingNotifyRequest.SetChargingNotifyRequest(notifyRequest)
-	_, err := client.DefaultApi.PostChargingNotification(context.Background(), notifyUri, chargingNotifyRequest)
-	if err != nil {
-		logger.NotifyEventLog.Warnf("Charging Notification Failed[%s]", err.Error())
-		return
+	for _, notification := range notifications {
+		p.SendChargingNotification(notification)
 	}
-
-	logger.NotifyEventLog.Tracef("Charging Notification Success")
 }
 
 func (p *Processor) HandleChargingdataInitial(
@@ -76,7 +87,8 @@ func (p *Processor) HandleChargingdataInitial(
 	chargingdata models.ChfConvergedChargingChargingDataRequest,
 ) {
 	logger.ChargingdataPostLog.Infof("HandleChargingdataInitial")
-	response, locationURI, problemDetails := p.ChargingDataCreate(chargingdata)
+	defer observeNchfRequest(c, metrics.OperationCreate, time.Now())
+	response, locationURI, problemDetails := p.ChargingDataCreate(c.Request.Context(), chargingdata)
 
 	if response != nil {
 		c.Header("Location", locationURI)
@@ -99,7 +111,8 @@ func (p *Processor) HandleChargingdataUpdate(
 	chargingSessionId string,
 ) {
 	logger.ChargingdataPostLog.Infof("HandleChargingdataUpdate")
-	response, problemDetails := p.ChargingDataUpdate(chargingdata, chargingSessionId)
+	defer observeNchfRequest(c, metrics.OperationUpdate, time.Now())
+	response, problemDetails := p.ChargingDataUpdate(c.Request.Context(), chargingdata, chargingSessionId)
 
 	if response != nil {
 		c.JSON(http.StatusOK, response)
@@ -121,17 +134,22 @@ func (p *Processor) HandleChargingdataRelease(
 	chargingSessionId string,
 ) {
 	logger.ChargingdataPostLog.Infof("HandleChargingdateRelease")
+	defer observeNchfRequest(c, metrics.OperationRelease, time.Now())
 
-	problemDetails := p.ChargingDataRelease(chargingdata, chargingSessionId)
+	problemDetails := p.ChargingDataRelease(c.Request.Context(), chargingdata, chargingSessionId)
 	if problemDetails == nil {
-		c.Status(http.StatusBadRequest)
+		c.Status(http.StatusNoContent)
 		return
 	}
 	c.JSON(int(problemDetails.Status), problemDetails)
 }
 
+func observeNchfRequest(c *gin.Context, operation string, start time.Time) {
+	metrics.ObserveNchfRequest(operation, c.Writer.Status(), start)
+}
+
 func (p *Processor) ChargingDataCreate(
-	chargingData models.ChfConvergedChargingChargingDataRequest,
+	ctx context.Context, chargingData models.ChfConvergedChargingChargingDataRequest,
 ) (
 	*models.ChfConvergedChargingChargingDataResponse,
 	string, *models.ProblemDetails,
@@ -156,11 +174,11 @@ func (p *Processor) ChargingDataCreate(
 	}
 
 	ue.CULock.Lock()
-	ue.NotifyUri = chargingData.NotifyUri
 
 	consumerId := chargingData.NfConsumerIdentification.NFName
 	if !chargingData.OneTimeEvent {
 		chargingSessionId = ueId + consumerId + strconv.Itoa(int(self.LocalRecordSequenceNumber))
+		ue.NotifyUri[chargingSessionId] = chargingData.NotifyUri
 	}
 	cdr, err := p.OpenCDR(chargingData, ue, chargingSessionId, false)
 	if err != nil {
@@ -183,7 +201,6 @@ func (p *Processor) ChargingDataCreate(
 	}
 
 	ue.Cdr[chargingSessionId] = cdr
-	ue.Records = append(ue.Records, ue.Cdr[chargingSessionId])
 	ue.CULock.Unlock()
 
 	if chargingData.OneTimeEvent {
@@ -194,12 +211,10 @@ func (p *Processor) ChargingDataCreate(
 			}
 			return nil, "", problemDetails
 		}
-	}
-
-	// CDR Transfer
-	err = cgf.SendCDR(chargingData.SubscriberIdentifier)
-	if err != nil {
-		logger.ChargingdataPostLog.Errorf("Charging gateway fail to send CDR to billing domain %v", err)
+		if err = p.writeCdrs(ctx, cdr); err != nil {
+			logger.ChargingdataPostLog.Errorf("Write CDR of UE[%s] err: %+v", ueId, err)
+			return nil, "", systemFailure(err)
+		}
 	}
 
 	logger.ChargingdataPostLog.Infof("Open CDR for UE %s", ueId)
@@ -216,7 +231,7 @@ func (p *Processor) ChargingDataCreate(
 }
 
 func (p *Processor) ChargingDataUpdate(
-	chargingData models.ChfConvergedChargingChargingDataRequest, chargingSessionId string,
+	ctx context.Context, chargingData models.ChfConvergedChargingChargingDataRequest, chargingSessionId string,
 ) (*models.ChfConvergedChargingChargingDataResponse, *models.ProblemDetails) {
 	self := chf_context.GetSelf()
 	ueId := chargingData.SubscriberIdentifier
@@ -232,58 +247,56 @@ func (p *Processor) ChargingDataUpdate(
 	ue.CULock.Lock()
 	defer ue.CULock.Unlock()
 
-	// Online charging: Rate, Account, Reservation
-	responseBody, partialRecord := p.BuildConvergedChargingDataUpdateResopone(chargingData)
-
-	cdr := ue.Cdr[chargingSessionId]
+	if _, ok = ue.Cdr[chargingSessionId]; !ok {
+		logger.ChargingdataPostLog.Errorf("Charging session [%s] of UE[%s] not found", chargingSessionId, ueId)
+		return nil, contextNotFound(chargingSessionId)
+	}
+	// the consumer may move the session to another notification endpoint
+	if chargingData.NotifyUri != "" {
+		ue.NotifyUri[chargingSessionId] = chargingData.NotifyUri
+	}
 
-	if len(ue.Records) > 1 {
-		cdr = ue.Records[len(ue.Records)-1]
+	// Online charging: Rate, Account, Reservation
+	responseBody, partialRecord, problemDetails := p.BuildConvergedChargingDataUpdateResopone(ctx, chargingData)
+	if problemDetails != nil {
+		return nil, problemDetails
 	}
 
-	cdrBytes, errCdrBer := asn.BerMarshalWithParams(&cdr, "explicit,choice")
-	if errCdrBer != nil {
-		logger.ChargingdataPostLog.Error(errCdrBer)
+	cdr := ue.Cdr[chargingSessionId]
+	cdrBytes, errCdrEncode := cdrConvert.EncodeRecord(p.cdrFormat, &cdr)
+	if errCdrEncode != nil {
+		logger.ChargingdataPostLog.Error(errCdrEncode)
 		problemDetails := &models.ProblemDetails{
 			Status: http.StatusBadRequest,
-			Detail: errCdrBer.Error(),
+			Detail: errCdrEncode.Error(),
 		}
 		return nil, problemDetails
 	}
 
 	var chgDataBytes []byte
-	var errChgDataBer error
+	var errChgDataEncode error
 	if chargingData.MultipleUnitUsage != nil && len(chargingData.MultipleUnitUsage) != 0 {
 		cdrMultiUnitUsage := cdrConvert.MultiUnitUsageToCdr(chargingData.MultipleUnitUsage)
-		chgDataBytes, errChgDataBer = asn.BerMarshalWithParams(&cdrMultiUnitUsage, "explicit,choice")
-		if errChgDataBer != nil {
-			logger.ChargingdataPostLog.Error(errChgDataBer)
+		chgDataBytes, errChgDataEncode = cdrConvert.EncodeRecord(p.cdrFormat, &cdrMultiUnitUsage)
+		if errChgDataEncode != nil {
+			logger.ChargingdataPostLog.Error(errChgDataEncode)
 			problemDetails := &models.ProblemDetails{
 				Status: http.StatusBadRequest,
-				Detail: errChgDataBer.Error(),
+				Detail: errChgDataEncode.Error(),
 			}
 			return nil, problemDetails
 		}
 	}
 
+	var err error
 	if len(cdrBytes)+len(chgDataBytes) > math.MaxUint16 {
-		var newRecord *cdrType.CHFRecord
-		cdrJson, err := json.Marshal(cdr)
-		if err != nil {
-			logger.ChargingdataPostLog.Error(err)
+		// the full record is closed as partial, the session goes on in a new record
+		if cdr, err = p.continueCdr(ctx, chargingData, ue, chargingSessionId); err != nil {
+			return nil, systemFailure(err)
 		}
-		err = json.Unmarshal(cdrJson, &newRecord)
-		if err != nil {
-			logger.ChargingdataPostLog.Error(err)
-		}
-
-		newRecord.ChargingFunctionRecord.ListOfMultipleUnitUsage = []cdrType.MultipleUnitUsage{}
-		cdr = newRecord
-		ue.Records = append(ue.Records, cdr)
 	}
 
-	err := p.UpdateCDR(cdr, chargingData)
-	if err != nil {
+	if err = p.UpdateCDR(cdr, chargingData); err != nil {
 		problemDetails := &models.ProblemDetails{
 			Status: http.StatusBadRequest,
 		}
@@ -291,41 +304,13 @@ func (p *Processor) ChargingDataUpdate(
 	}
 
 	if partialRecord {
-		ueId = chargingData.SubscriberIdentifier
-
-		close_err := p.CloseCDR(cdr, partialRecord)
-		if close_err != nil {
-			logger.ChargingdataPostLog.Error("CloseCDR error:", close_err)
-		}
-		err = dumpCdrFile(ueId, []*cdrType.CHFRecord{cdr})
-		if err != nil {
-			problemDetails := &models.ProblemDetails{
-				Status: http.StatusBadRequest,
-			}
-			return nil, problemDetails
-		}
-
-		_, oper_err := p.OpenCDR(chargingData, ue, chargingSessionId, partialRecord)
-		if oper_err != nil {
-			logger.ChargingdataPostLog.Error("OpenCDR error:", oper_err)
+		if cdr, err = p.continueCdr(ctx, chargingData, ue, chargingSessionId); err != nil {
+			return nil, systemFailure(err)
 		}
 		logger.ChargingdataPostLog.Tracef(
 			"CDR Record Sequence Number after Reopen %+v", *cdr.ChargingFunctionRecord.RecordSequenceNumber)
 	}
 
-	err = dumpCdrFile(ueId, ue.Records)
-	if err != nil {
-		problemDetails := &models.ProblemDetails{
-			Status: http.StatusBadRequest,
-		}
-		return nil, problemDetails
-	}
-
-	err = cgf.SendCDR(chargingData.SubscriberIdentifier)
-	if err != nil {
-		logger.ChargingdataPostLog.Errorf("Charging gateway fail to send CDR to billing domain %v", err)
-	}
-
 	timeStamp := time.Now()
 	responseBody.InvocationTimeStamp = &timeStamp
 	responseBody.InvocationSequenceNumber = chargingData.InvocationSequenceNumber
@@ -333,8 +318,25 @@ func (p *Processor) ChargingDataUpdate(
 	return &responseBody, nil
 }
 
+// continueCdr writes the record of the charging session as partial, then opens the record the session goes on in
+func (p *Processor) continueCdr(
+	ctx context.Context, chargingData models.ChfConvergedChargingChargingDataRequest,
+	ue *chf_context.ChfUe, chargingSessionId string,
+) (*cdrType.CHFRecord, error) {
+	if err := p.writePartialCdr(ctx, chargingSessionId, ue.Cdr[chargingSessionId]); err != nil {
+		logger.ChargingdataPostLog.Errorf("Write partial CDR of UE[%s] err: %+v", ue.Supi, err)
+		return nil, err
+	}
+	cdr, err := p.OpenCDR(chargingData, ue, chargingSessionId, true)
+	if err != nil {
+		return nil, err
+	}
+	ue.Cdr[chargingSessionId] = cdr
+	return cdr, nil
+}
+
 func (p *Processor) ChargingDataRelease(
-	chargingData models.ChfConvergedChargingChargingDataRequest, chargingSessionId string,
+	ctx context.Context, chargingData models.ChfConvergedChargingChargingDataRequest, chargingSessionId string,
 ) *models.ProblemDetails {
 	self := chf_context.GetSelf()
 	ueId := chargingData.SubscriberIdentifier
@@ -350,9 +352,16 @@ func (p *Processor) ChargingDataRelease(
 	ue.CULock.Lock()
 	defer ue.CULock.Unlock()
 
-	sessionChargingReservation(chargingData)
+	cdr, ok := ue.Cdr[chargingSessionId]
+	if !ok {
+		logger.ChargingdataPostLog.Errorf("Charging session [%s] of UE[%s] not found", chargingSessionId, ueId)
+		return contextNotFound(chargingSessionId)
+	}
 
-	cdr := ue.Cdr[chargingSessionId]
+	// the CDR is closed even if the final usage could not be charged
+	if _, _, problemDetails := sessionChargingReservation(ctx, chargingData); problemDetails != nil {
+		logger.ChargingdataPostLog.Warnf("Final credit control of UE[%s] failed: %s", ueId, problemDetails.Cause)
+	}
 
 	err := p.UpdateCDR(cdr, chargingData)
 	if err != nil {
@@ -371,52 +380,48 @@ func (p *Processor) ChargingDataRelease(
 		return problemDetails
 	}
 
-	err = dumpCdrFile(ueId, []*cdrType.CHFRecord{cdr})
+	err = p.writeCdrs(ctx, cdr)
 	if err != nil {
-		problemDetails := &models.ProblemDetails{
-			Status: http.StatusBadRequest,
-		}
-		return problemDetails
+		logger.ChargingdataPostLog.Errorf("Write CDR of UE[%s] err: %+v", ueId, err)
+		return systemFailure(err)
 	}
+	delete(ue.Cdr, chargingSessionId)
+	delete(ue.NotifyUri, chargingSessionId)
+	self.ReleaseRecordSequenceNumber(chargingSessionId)
 
 	return nil
 }
 
-func (p *Processor) BuildOnlineChargingDataCreateResopone(
-	ue *chf_context.ChfUe, chargingData models.ChfConvergedChargingChargingDataRequest,
-) models.ChfConvergedChargingChargingDataResponse {
-	logger.ChargingdataPostLog.Info("In Build Online Charging Data Create Resopone")
-	ue.NotifyUri = chargingData.NotifyUri
-
-	multipleUnitInformation, _ := sessionChargingReservation(chargingData)
-
-	responseBody := models.ChfConvergedChargingChargingDataResponse{
-		MultipleUnitInformation: multipleUnitInformation,
+func contextNotFound(chargingSessionId string) *models.ProblemDetails {
+	return &models.ProblemDetails{
+		Title:  "Charging data not found",
+		Status: http.StatusNotFound,
+		Detail: "No charging session " + chargingSessionId,
+		Cause:  "CONTEXT_NOT_FOUND",
 	}
-
-	return responseBody
 }
 
 func (p *Processor) BuildConvergedChargingDataUpdateResopone(
-	chargingData models.ChfConvergedChargingChargingDataRequest,
-) (models.ChfConvergedChargingChargingDataResponse, bool) {
-	var partialRecord bool
-
+	ctx context.Context, chargingData models.ChfConvergedChargingChargingDataRequest,
+) (models.ChfConvergedChargingChargingDataResponse, bool, *models.ProblemDetails) {
 	logger.ChargingdataPostLog.Info("In BuildConvergedChargingDataUpdateResopone")
 
-	multipleUnitInformation, partialRecord := sessionChargingReservation(chargingData)
+	multipleUnitInformation, partialRecord, problemDetails := sessionChargingReservation(ctx, chargingData)
 
 	responseBody := models.ChfConvergedChargingChargingDataResponse{
 		MultipleUnitInformation: multipleUnitInformation,
 	}
 
-	return responseBody, partialRecord
+	return responseBody, partialRecord, problemDetails
 }
 
-func getUnitCost(ue *chf_context.ChfUe, rg int32, sur *charging_datatype.ServiceUsageRequest) uint32 {
+// getUnitCost retrieves the unit cost of the rating group, it also returns the Result-Code of the rating function
+func getUnitCost(
+	ctx context.Context, ue *chf_context.ChfUe, rg int32, sur *charging_datatype.ServiceUsageRequest,
+) (uint32, uint32) {
 	if sur == nil {
 		logger.ChargingdataPostLog.Errorln("ServiceUsageRequest is nil, set unitCost to 1")
-		return 1
+		return 1, diam.Success
 	}
 
 	sur.ServiceRating = &charging_datatype.ServiceRating{
@@ -425,33 +430,105 @@ func getUnitCost(ue *chf_context.ChfUe, rg int32, sur *charging_datatype.Service
 		RequestSubType:    charging_datatype.REQ_SUBTYPE_RESERVE,
 	}
 
-	serviceUsageRsp, err := rating.SendServiceUsageRequest(ue, sur)
+	serviceUsageRsp, err := rating.SendServiceUsageRequest(ctx, ue, sur)
 	if err != nil {
 		logger.ChargingdataPostLog.Errorf("err: %+v", err)
 		logger.ChargingdataPostLog.Errorln("cannot get unitCost by SendServiceUsageRequest, set unitCost to 1")
-		return 1
+		return 1, diam.Success
+	}
+	if serviceUsageRsp.ResultCode != diam.Success {
+		return 0, uint32(serviceUsageRsp.ResultCode)
 	}
 
+	return tariffUnitCost(serviceUsageRsp), diam.Success
+}
+
+func tariffUnitCost(serviceUsageRsp *charging_datatype.ServiceUsageResponse) uint32 {
 	return uint32(serviceUsageRsp.ServiceRating.MonetaryTariff.RateElement.UnitCost.ValueDigits) *
 		uint32(math.Pow10(int(serviceUsageRsp.ServiceRating.MonetaryTariff.RateElement.UnitCost.Exponent)))
 }
 
-// 32.296 6.2.2.3.1: Service usage request method with reservation
-func sessionChargingReservation(
-	chargingData models.ChfConvergedChargingChargingDataRequest,
-) ([]models.MultipleUnitInformation, bool) {
-	var multipleUnitInformation []models.MultipleUnitInformation
-	var partialRecord bool
-	var subscriberIdentifier *charging_datatype.SubscriptionId
+// creditControlResult maps the Result-Code of the ABMF or the rating function to the result of the
+// rating group, or to the ProblemDetails rejecting the whole request
+func creditControlResult(resultCode uint32) (models.ChfConvergedChargingResultCode, *models.ProblemDetails) {
+	switch resultCode {
+	case diam.Success:
+		return models.ChfConvergedChargingResultCode_SUCCESS, nil
+	case charging_code.CreditLimitReached:
+		return models.ChfConvergedChargingResultCode_QUOTA_LIMIT_REACHED, nil
+	case charging_code.EndUserServiceDenied:
+		return models.ChfConvergedChargingResultCode_END_USER_SERVICE_DENIED, nil
+	case charging_code.RatingFailed:
+		return models.ChfConvergedChargingResultCode_RATING_FAILED, nil
+	case charging_code.UserUnknown:
+		return models.ChfConvergedChargingResultCode_USER_UNKNOWN, &models.ProblemDetails{
+			Status: http.StatusNotFound,
+			Cause:  "USER_UNKNOWN",
+		}
+	default:
+		return "", &models.ProblemDetails{
+			Status: http.StatusBadRequest,
+			Cause:  "CHARGING_FAILED",
+			Detail: fmt.Sprintf("credit control failed with Result-Code %d", resultCode),
+		}
+	}
+}
 
-	self := chf_context.GetSelf()
-	supi := chargingData.SubscriberIdentifier
+// checkLowBalance notifies the subscriber once when the ABMF starts reporting a low balance for the rating group
+func checkLowBalance(ue *chf_context.ChfUe, rg int32, acctDebitRsp *charging_datatype.AccountDebitResponse) {
+	remainingBalance := acctDebitRsp.RemainingBalance
+	if remainingBalance == nil || remainingBalance.UnitValue == nil {
+		return
+	}
 
-	ue, ok := self.ChfUeFindBySupi(supi)
-	if !ok {
-		logger.ChargingdataPostLog.Warnf("Do not find UE[%s]", supi)
-		return nil, false
+	lowBalance := acctDebitRsp.LowBalanceIndication == charging_datatype.YES
+	if lowBalance && !ue.LowBalance[rg] {
+		remaining := int64(remainingBalance.UnitValue.ValueDigits) *
+			int64(math.Pow10(int(remainingBalance.UnitValue.Exponent)))
+		logger.ChargingdataPostLog.Infof("UE[%s] balance is low for rating group %d: %d", ue.Supi, rg, remaining)
+		notify.SendLowBalanceNotification(ue.Supi, rg, remaining)
 	}
+	ue.LowBalance[rg] = lowBalance
+}
+
+// buildFinalUnitIndication converts the Final-Unit-Indication of the ABMF into the one of the SBI
+func buildFinalUnitIndication(fui *charging_datatype.FinalUnitIndication) models.FinalUnitIndication {
+	switch fui.FinalUnitAction {
+	case charging_datatype.REDIRECT:
+		finalUnitIndication := models.FinalUnitIndication{
+			FinalUnitAction: models.FinalUnitAction_REDIRECT,
+		}
+		if fui.RedirectServer != nil {
+			addressType := models.ChfConvergedChargingRedirectAddressType_URL
+			switch fui.RedirectServer.RedirectAddressType {
+			case charging_datatype.IPV4_ADDRESS:
+				addressType = models.ChfConvergedChargingRedirectAddressType_IPV4
+			case charging_datatype.IPV6_ADDRESS:
+				addressType = models.ChfConvergedChargingRedirectAddressType_IPV6
+			case charging_datatype.SIP_URI:
+				addressType = models.ChfConvergedChargingRedirectAddressType_URI
+			}
+			finalUnitIndication.RedirectServer = &models.RedirectServer{
+				RedirectAddressType:   addressType,
+				RedirectServerAddress: string(fui.RedirectServer.RedirectServerAddress),
+			}
+		}
+		return finalUnitIndication
+	case charging_datatype.RESTRICT_ACCESS:
+		return models.FinalUnitIndication{
+			FinalUnitAction: models.FinalUnitAction_RESTRICT_ACCESS,
+			FilterId:        string(fui.FilterId),
+		}
+	default:
+		return models.FinalUnitIndication{
+			FinalUnitAction: models.FinalUnitAction_TERMINATE,
+		}
+	}
+}
+
+// buildSubscriptionId converts the SUPI into the Subscription-Id of the ABMF and rating function requests
+func buildSubscriptionId(supi string) *charging_datatype.SubscriptionId {
+	var subscriberIdentifier *charging_datatype.SubscriptionId
 
 	supiType := strings.Split(supi, "-")[0]
 	switch supiType {
@@ -476,6 +553,27 @@ func sessionChargingReservation(
 			SubscriptionIdData: datatype.UTF8String(supi[4:]),
 		}
 	}
+	return subscriberIdentifier
+}
+
+// 32.296 6.2.2.3.1: Service usage request method with reservation
+func sessionChargingReservation(
+	ctx context.Context, chargingData models.ChfConvergedChargingChargingDataRequest,
+) ([]models.MultipleUnitInformation, bool, *models.ProblemDetails) {
+	var multipleUnitInformation []models.MultipleUnitInformation
+	var partialRecord bool
+
+	self := chf_context.GetSelf()
+	cfg := factory.ChfConfig
+	supi := chargingData.SubscriberIdentifier
+
+	ue, ok := self.ChfUeFindBySupi(supi)
+	if !ok {
+		logger.ChargingdataPostLog.Warnf("Do not find UE[%s]", supi)
+		return nil, false, nil
+	}
+
+	subscriberIdentifier := buildSubscriptionId(supi)
 
 	for unitUsageNum, unitUsage := range chargingData.MultipleUnitUsage {
 		var totalUsedUnit uint32
@@ -534,6 +632,7 @@ func sessionChargingReservation(
 			logger.ChargingdataPostLog.Infof("Credit Control are not required for rating group: %d", rg)
 			continue
 		}
+		metrics.AddUsedUnits(rg, totalUsedUnit)
 		// Only online charging with request unit or used unit need to perform credit control
 
 		ccr := &charging_datatype.AccountDebitRequest{
@@ -558,43 +657,84 @@ func sessionChargingReservation(
 		switch ue.RatingType[rg] {
 		case charging_datatype.REQ_SUBTYPE_RESERVE:
 			var requestedQuota uint64
+			var ratingResult uint32
 
-			ue.UnitCost[rg] = getUnitCost(ue, rg, sur)
+			ue.UnitCost[rg], ratingResult = getUnitCost(ctx, ue, rg, sur)
+			if ratingResult != diam.Success {
+				resultCode, problemDetails := creditControlResult(ratingResult)
+				if problemDetails != nil {
+					return nil, false, problemDetails
+				}
+				logger.ChargingdataPostLog.Warnf("Rating of UE[%s] rating group %d failed: %s", supi, rg, resultCode)
+				unitInformation.ResultCode = resultCode
+				unitInformation.GrantedUnit = &models.GrantedUnit{}
+				break
+			}
 
 			usedQuota := uint64(totalUsedUnit * ue.UnitCost[rg])
 			requestedQuota = uint64(uint32(unitUsage.RequestedUnit.TotalVolume) * ue.UnitCost[rg])
 			ue.ReservedQuota[rg] -= int64(usedQuota)
+			ue.UsedQuota[rg] += int64(usedQuota)
 			NeedReserveQuota := !(ue.ReservedQuota[rg] > 0)
 
 			if NeedReserveQuota {
-				reserveQuota := -uint64(ue.ReservedQuota[rg]) + requestedQuota
+				// Commit the usage against the current reservation and replace it by a new one
 				ccr.CcRequestType = charging_datatype.UPDATE_REQUEST
-				ccr.RequestedAction = charging_datatype.DIRECT_DEBITING
 				ccr.MultipleServicesCreditControl = &charging_datatype.MultipleServicesCreditControl{
 					RatingGroup: datatype.Unsigned32(rg),
 					RequestedServiceUnit: &charging_datatype.RequestedServiceUnit{
-						CCTotalOctets: datatype.Unsigned64(reserveQuota),
+						CCTotalOctets: datatype.Unsigned64(requestedQuota),
+					},
+					UsedServiceUnit: &charging_datatype.UsedServiceUnit{
+						CCTotalOctets: datatype.Unsigned64(ue.UsedQuota[rg]),
 					},
 				}
 
-				acctDebitRsp, err := abmf.SendAccountDebitRequest(ue, ccr)
+				acctDebitRsp, err := abmf.SendAccountDebitRequest(ctx, ue, ccr)
 				if err != nil {
 					logger.ChargingdataPostLog.Errorf("SendAccountDebitRequest err: %+v", err)
 					continue
 				}
+				resultCode, problemDetails := creditControlResult(uint32(acctDebitRsp.ResultCode))
+				if problemDetails != nil {
+					return nil, false, problemDetails
+				}
+				unitInformation.ResultCode = resultCode
+
+				// the usage is committed, even when nothing more could be reserved
+				ue.ReservedQuota[rg] = 0
+				ue.UsedQuota[rg] = 0
+				creditControl := acctDebitRsp.MultipleServicesCreditControl
+				if creditControl != nil && creditControl.GrantedServiceUnit != nil {
+					ue.ReservedQuota[rg] = int64(creditControl.GrantedServiceUnit.CCTotalOctets)
+				}
 
-				ue.ReservedQuota[rg] += int64(acctDebitRsp.MultipleServicesCreditControl.GrantedServiceUnit.CCTotalOctets)
+				for _, abResponse := range acctDebitRsp.ABResponse {
+					if abResponse.AcctBalance == nil || abResponse.AcctBalance.UnitValue == nil {
+						continue
+					}
+					logger.ChargingdataPostLog.Debugf("UE[%s] balance[%d]: %de%d", supi,
+						abResponse.AcctBalance.AcctBalanceId,
+						abResponse.AcctBalance.UnitValue.ValueDigits,
+						abResponse.AcctBalance.UnitValue.Exponent)
+				}
+
+				checkLowBalance(ue, rg, acctDebitRsp)
 
 				// Deduct the reserved quota from the account
-				if acctDebitRsp.MultipleServicesCreditControl.FinalUnitIndication != nil {
-					switch acctDebitRsp.MultipleServicesCreditControl.FinalUnitIndication.FinalUnitAction {
-					case charging_datatype.TERMINATE:
-						logger.ChargingdataPostLog.Tracef("Last granted quota")
-						finalUnitIndication = models.FinalUnitIndication{
-							FinalUnitAction: models.FinalUnitAction_TERMINATE,
-						}
-						ue.RatingType[rg] = charging_datatype.REQ_SUBTYPE_DEBIT
+				if creditControl != nil && creditControl.FinalUnitIndication != nil {
+					logger.ChargingdataPostLog.Tracef("Last granted quota")
+					finalUnitIndication = buildFinalUnitIndication(creditControl.FinalUnitIndication)
+					ue.RatingType[rg] = charging_datatype.REQ_SUBTYPE_DEBIT
+				}
+				if resultCode != models.ChfConvergedChargingResultCode_SUCCESS {
+					logger.ChargingdataPostLog.Warnf("Credit control of UE[%s] rating group %d: %s", supi, rg, resultCode)
+					if finalUnitIndication.FinalUnitAction == "" {
+						finalUnitIndication.FinalUnitAction = models.FinalUnitAction_TERMINATE
 					}
+					ue.RatingType[rg] = charging_datatype.REQ_SUBTYPE_DEBIT
+					unitInformation.GrantedUnit = &models.GrantedUnit{}
+					break
 				}
 			}
 
@@ -605,13 +745,23 @@ func sessionChargingReservation(
 			}
 
 			// Retrieve and save the tarrif for pricing the next usage
-			serviceUsageRsp, err := rating.SendServiceUsageRequest(ue, sur)
+			serviceUsageRsp, err := rating.SendServiceUsageRequest(ctx, ue, sur)
 			if err != nil {
 				logger.ChargingdataPostLog.Errorf("SendServiceUsageRequest err: %+v", err)
 				continue
 			}
+			if serviceUsageRsp.ResultCode != diam.Success {
+				resultCode, problemDetails := creditControlResult(uint32(serviceUsageRsp.ResultCode))
+				if problemDetails != nil {
+					return nil, false, problemDetails
+				}
+				logger.ChargingdataPostLog.Warnf("Rating of UE[%s] rating group %d failed: %s", supi, rg, resultCode)
+				unitInformation.ResultCode = resultCode
+				unitInformation.GrantedUnit = &models.GrantedUnit{}
+				break
+			}
 
-			ue.UnitCost[rg] = getUnitCost(ue, rg, sur)
+			ue.UnitCost[rg] = tariffUnitCost(serviceUsageRsp)
 
 			grantedUnit := min(uint32(serviceUsageRsp.ServiceRating.AllowedUnits), uint32(unitUsage.RequestedUnit.TotalVolume))
 
@@ -623,7 +773,7 @@ func sessionChargingReservation(
 					},
 				)
 
-				unitInformation.VolumeQuotaThreshold = int32(float32(grantedUnit) * ue.VolumeThresholdRate)
+				unitInformation.VolumeQuotaThreshold = int32(float32(grantedUnit) * cfg.GetVolumeThresholdRate())
 			}
 
 			unitInformation.Triggers = append(unitInformation.Triggers,
@@ -639,38 +789,39 @@ func sessionChargingReservation(
 				UplinkVolume:   int32(grantedUnit),
 			}
 			logger.ChargingdataPostLog.Tracef("granted Unit: %d", unitInformation.GrantedUnit.TotalVolume)
+			metrics.AddGrantedUnits(rg, grantedUnit)
 
 			// The timer of VolumeLimit is remain in SMF
-			if ue.VolumeLimit != 0 {
+			if volumeLimit := cfg.GetVolumeLimit(); volumeLimit != 0 {
 				unitInformation.Triggers = append(unitInformation.Triggers,
 					models.ChfConvergedChargingTrigger{
 						TriggerType:     models.ChfConvergedChargingTriggerType_VOLUME_LIMIT,
 						TriggerCategory: models.TriggerCategory_DEFERRED_REPORT,
-						VolumeLimit:     ue.VolumeLimit,
+						VolumeLimit:     volumeLimit,
 					},
 				)
 			}
 
 			// VolumeLimit for PDU session only need to add once
-			if ue.VolumeLimitPDU != 0 && unitUsageNum == 0 {
+			if volumeLimitPDU := cfg.GetVolumeLimitPDU(); volumeLimitPDU != 0 && unitUsageNum == 0 {
 				unitInformation.Triggers = append(unitInformation.Triggers,
 					models.ChfConvergedChargingTrigger{
 						TriggerType:     models.ChfConvergedChargingTriggerType_VOLUME_LIMIT,
 						TriggerCategory: models.TriggerCategory_IMMEDIATE_REPORT,
-						VolumeLimit:     ue.VolumeLimitPDU,
+						VolumeLimit:     volumeLimitPDU,
 					},
 				)
 			}
 
 			// The timer of QuotaValidityTime is remain in UPF
-			if ue.QuotaValidityTime != 0 {
+			if validityTime := cfg.GetQuotaValidityTime(); validityTime != 0 {
 				unitInformation.Triggers = append(unitInformation.Triggers,
 					models.ChfConvergedChargingTrigger{
 						TriggerType:     models.ChfConvergedChargingTriggerType_VALIDITY_TIME,
 						TriggerCategory: models.TriggerCategory_IMMEDIATE_REPORT,
 					},
 				)
-				unitInformation.ValidityTime = ue.QuotaValidityTime
+				unitInformation.ValidityTime = validityTime
 			}
 
 		case charging_datatype.REQ_SUBTYPE_DEBIT:
@@ -682,50 +833,57 @@ func sessionChargingReservation(
 				RequestSubType:    charging_datatype.REQ_SUBTYPE_DEBIT,
 			}
 
-			serviceUsageRsp, err := rating.SendServiceUsageRequest(ue, sur)
+			serviceUsageRsp, err := rating.SendServiceUsageRequest(ctx, ue, sur)
 			if err != nil {
 				logger.ChargingdataPostLog.Errorf("SendServiceUsageRequest err: %+v", err)
 				continue
 			}
+			if serviceUsageRsp.ResultCode != diam.Success {
+				// the reservation is released by the ABMF once it expires
+				resultCode, problemDetails := creditControlResult(uint32(serviceUsageRsp.ResultCode))
+				if problemDetails != nil {
+					return nil, false, problemDetails
+				}
+				logger.ChargingdataPostLog.Warnf("Rating of UE[%s] rating group %d failed: %s", supi, rg, resultCode)
+				unitInformation.ResultCode = resultCode
+				unitInformation.GrantedUnit = &models.GrantedUnit{}
+				break
+			}
 			logger.ChargingdataPostLog.Tracef(
 				"price %+v, ue.ReservedQuota[rg]: %+v", serviceUsageRsp.ServiceRating.Price, ue.ReservedQuota[rg])
 
 			if int64(serviceUsageRsp.ServiceRating.Price) < ue.ReservedQuota[rg] {
-				// The final consumed quota is smaller than the reserved quota
-				// Therefore, return the extra reserved quota back to the user account
-				reservedRemained := ue.ReservedQuota[rg] - int64(serviceUsageRsp.ServiceRating.Price)
-				ccr.RequestedAction = charging_datatype.REFUND_ACCOUNT
-				ccr.MultipleServicesCreditControl = &charging_datatype.MultipleServicesCreditControl{
-					RatingGroup: datatype.Unsigned32(rg),
-					RequestedServiceUnit: &charging_datatype.RequestedServiceUnit{
-						CCTotalOctets: datatype.Unsigned64(reservedRemained),
-					},
-				}
 				// Typically, the reserved quota will be exhausted for the flow (or PDU session)
 				// However, for the case the flow quota  and PDU session's quota is both last granted quota
 				// and the PDU session's quota is larger than the flow's quota
-				// PDU session's quota should be refund and set to reserved mode in order to reserve the quota for other flow
+				// PDU session's quota should be released and set to reserved mode in order to reserve the quota
+				// for other flow
 				ue.RatingType[rg] = charging_datatype.REQ_SUBTYPE_RESERVE
-			} else {
-				// The final consumed quota exceed the reserved quota
-				// Deduct the extra consumed quota from the user account
-				extraConsumed := int64(serviceUsageRsp.ServiceRating.Price) - ue.ReservedQuota[rg]
-				ccr.RequestedAction = charging_datatype.DIRECT_DEBITING
-				ccr.CcRequestType = charging_datatype.TERMINATION_REQUEST
-				ccr.MultipleServicesCreditControl = &charging_datatype.MultipleServicesCreditControl{
-					RatingGroup: datatype.Unsigned32(rg),
-					UsedServiceUnit: &charging_datatype.UsedServiceUnit{
-						CCTotalOctets: datatype.Unsigned64(extraConsumed),
-					},
-				}
 			}
 
-			_, err = abmf.SendAccountDebitRequest(ue, ccr)
+			// Commit the final usage, the ABMF releases what is left of the reservation
+			// or debits the usage exceeding it from the account
+			ccr.CcRequestType = charging_datatype.TERMINATION_REQUEST
+			ccr.MultipleServicesCreditControl = &charging_datatype.MultipleServicesCreditControl{
+				RatingGroup: datatype.Unsigned32(rg),
+				UsedServiceUnit: &charging_datatype.UsedServiceUnit{
+					CCTotalOctets: datatype.Unsigned64(ue.UsedQuota[rg] + int64(serviceUsageRsp.ServiceRating.Price)),
+				},
+			}
+
+			acctDebitRsp, err := abmf.SendAccountDebitRequest(ctx, ue, ccr)
 			if err != nil {
 				logger.ChargingdataPostLog.Errorf("SendAccountDebitRequest err: %+v", err)
 				continue
 			}
+			resultCode, problemDetails := creditControlResult(uint32(acctDebitRsp.ResultCode))
+			if problemDetails != nil {
+				return nil, false, problemDetails
+			}
+			unitInformation.ResultCode = resultCode
 			ue.ReservedQuota[rg] = 0
+			ue.UsedQuota[rg] = 0
+			checkLowBalance(ue, rg, acctDebitRsp)
 
 			unitInformation.Triggers = append(unitInformation.Triggers,
 				models.ChfConvergedChargingTrigger{
@@ -744,5 +902,5 @@ func sessionChargingReservation(
 		ue.AcctRequestNum[rg]++
 	}
 
-	return multipleUnitInformation, partialRecord
+	return multipleUnitInformation, partialRecord, nil
 }
@@ -0,0 +1,201 @@
+package processor
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/fiorix/go-diameter/diam"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+
+	charging_code "github.com/free5gc/chf/ccs_diameter/code"
+	charging_datatype "github.com/free5gc/chf/ccs_diameter/datatype"
+	"github.com/free5gc/chf/cdr/cdrConvert"
+	"github.com/free5gc/chf/cdr/cdrFile"
+	"github.com/free5gc/chf/cdr/cdrType"
+	"github.com/free5gc/chf/internal/cdrwriter"
+	chf_context "github.com/free5gc/chf/internal/context"
+	"github.com/free5gc/openapi/models"
+)
+
+// newTestProcessor returns a processor writing its CDR files in the temporary directory it also returns
+func newTestProcessor(t *testing.T) (*Processor, string) {
+	dir := t.TempDir()
+	cdrWriter, err := cdrwriter.New(cdrwriter.Dirs{Working: dir, Ready: dir}, cdrwriter.Node{Id: "chf"},
+		cdrwriter.Limits{}, func(string) {})
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		require.NoError(t, cdrWriter.Close(cdrFile.NormalClosure))
+	})
+	return &Processor{cdrWriter: cdrWriter, cdrFormat: cdrFile.BasicEncodingRules}, dir
+}
+
+// readCdrs closes the CDR file being written by p and decodes the CDRs of the files in dir
+func readCdrs(t *testing.T, p *Processor, dir string) []*cdrType.ChargingRecord {
+	require.NoError(t, p.cdrWriter.Close(cdrFile.NormalClosure))
+	paths, err := filepath.Glob(filepath.Join(dir, "chf_-_*"))
+	require.NoError(t, err)
+
+	var records []*cdrType.ChargingRecord
+	for _, path := range paths {
+		f, errOpen := os.Open(path)
+		require.NoError(t, errOpen)
+		r, errReader := cdrFile.NewReader(f)
+		require.NoError(t, errReader)
+		for {
+			cdr, errNext := r.Next()
+			if errors.Is(errNext, io.EOF) {
+				break
+			}
+			require.NoError(t, errNext)
+			record, errDecode := cdrConvert.DecodeRecord(cdrFile.BasicEncodingRules, cdr.CdrByte)
+			require.NoError(t, errDecode)
+			records = append(records, record.ChargingFunctionRecord)
+		}
+		require.NoError(t, f.Close())
+	}
+	return records
+}
+
+func TestHandleChargingdataRelease(t *testing.T) {
+	t.Parallel()
+
+	p, _ := newTestProcessor(t)
+	supi := "imsi-208930000000037"
+	ue := &chf_context.ChfUe{
+		Cdr: map[string]*cdrType.CHFRecord{"1": {
+			Present:                1,
+			ChargingFunctionRecord: &cdrType.ChargingRecord{},
+		}},
+		NotifyUri: map[string]string{},
+	}
+	chf_context.GetSelf().AddChfUeToUePool(ue, supi)
+
+	release := func(chargingSessionId string) int {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodPost, "/", nil)
+		p.HandleChargingdataRelease(c, models.ChfConvergedChargingChargingDataRequest{
+			SubscriberIdentifier: supi,
+		}, chargingSessionId)
+		c.Writer.WriteHeaderNow()
+		return w.Code
+	}
+
+	// a successful release has no content, releasing the session again finds nothing
+	require.Equal(t, http.StatusNoContent, release("1"))
+	require.Empty(t, ue.Cdr)
+	require.Equal(t, http.StatusNotFound, release("1"))
+}
+
+func TestCreditControlResult(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		resultCode uint32
+		expected   models.ChfConvergedChargingResultCode
+		status     int32
+	}{
+		{diam.Success, models.ChfConvergedChargingResultCode_SUCCESS, 0},
+		{charging_code.CreditLimitReached, models.ChfConvergedChargingResultCode_QUOTA_LIMIT_REACHED, 0},
+		{charging_code.EndUserServiceDenied, models.ChfConvergedChargingResultCode_END_USER_SERVICE_DENIED, 0},
+		{charging_code.RatingFailed, models.ChfConvergedChargingResultCode_RATING_FAILED, 0},
+		{charging_code.UserUnknown, models.ChfConvergedChargingResultCode_USER_UNKNOWN, http.StatusNotFound},
+		{diam.UnableToComply, "", http.StatusBadRequest},
+	}
+	for _, tc := range testCases {
+		resultCode, problemDetails := creditControlResult(tc.resultCode)
+		require.Equal(t, tc.expected, resultCode)
+		if tc.status == 0 {
+			require.Nil(t, problemDetails)
+			continue
+		}
+		require.NotNil(t, problemDetails)
+		require.Equal(t, tc.status, problemDetails.Status)
+	}
+}
+
+func TestBuildFinalUnitIndication(t *testing.T) {
+	t.Parallel()
+
+	fui := buildFinalUnitIndication(&charging_datatype.FinalUnitIndication{
+		FinalUnitAction: charging_datatype.REDIRECT,
+		RedirectServer: &charging_datatype.RedirectServer{
+			RedirectAddressType:   charging_datatype.SIP_URI,
+			RedirectServerAddress: "sip:topup@example.com",
+		},
+	})
+	require.Equal(t, models.FinalUnitAction_REDIRECT, fui.FinalUnitAction)
+	require.NotNil(t, fui.RedirectServer)
+	require.Equal(t, models.ChfConvergedChargingRedirectAddressType_URI, fui.RedirectServer.RedirectAddressType)
+	require.Equal(t, "sip:topup@example.com", fui.RedirectServer.RedirectServerAddress)
+
+	fui = buildFinalUnitIndication(&charging_datatype.FinalUnitIndication{
+		FinalUnitAction: charging_datatype.RESTRICT_ACCESS,
+		FilterId:        "topup-only",
+	})
+	require.Equal(t, models.FinalUnitAction_RESTRICT_ACCESS, fui.FinalUnitAction)
+	require.Equal(t, "topup-only", fui.FilterId)
+
+	fui = buildFinalUnitIndication(&charging_datatype.FinalUnitIndication{
+		FinalUnitAction: charging_datatype.TERMINATE,
+	})
+	require.Equal(t, models.FinalUnitAction_TERMINATE, fui.FinalUnitAction)
+	require.Nil(t, fui.RedirectServer)
+}
+
+func TestChargingDataPartialRecords(t *testing.T) {
+	t.Parallel()
+
+	p, dir := newTestProcessor(t)
+	supi := "imsi-208930000000044"
+	chargingSessionId := supi + "SMF"
+	ue := &chf_context.ChfUe{
+		Cdr: map[string]*cdrType.CHFRecord{chargingSessionId: {
+			Present:                1,
+			ChargingFunctionRecord: &cdrType.ChargingRecord{},
+		}},
+		NotifyUri:  map[string]string{},
+		RatingType: map[int32]charging_datatype.RequestSubType{},
+	}
+	chf_context.GetSelf().AddChfUeToUePool(ue, supi)
+
+	usage := func(volume int32) models.ChfConvergedChargingChargingDataRequest {
+		return models.ChfConvergedChargingChargingDataRequest{
+			SubscriberIdentifier: supi,
+			MultipleUnitUsage: []models.ChfConvergedChargingMultipleUnitUsage{{
+				RatingGroup:       1,
+				UsedUnitContainer: []models.ChfConvergedChargingUsedUnitContainer{{TotalVolume: volume}},
+			}},
+		}
+	}
+
+	// two updates closing partial records, as ChargingDataUpdate does once the usage is added, then the release
+	ctx := context.Background()
+	for _, volume := range []int32{100, 200} {
+		require.NoError(t, p.UpdateCDR(ue.Cdr[chargingSessionId], usage(volume)))
+		_, err := p.continueCdr(ctx, usage(volume), ue, chargingSessionId)
+		require.NoError(t, err)
+	}
+	require.Nil(t, p.ChargingDataRelease(ctx, usage(300), chargingSessionId))
+
+	// each record has only the usage reported since the previous one
+	records := readCdrs(t, p, dir)
+	require.Len(t, records, 3)
+	for i, record := range records {
+		require.Len(t, record.ListOfMultipleUnitUsage, 1)
+		containers := record.ListOfMultipleUnitUsage[0].UsedUnitContainers
+		require.Len(t, containers, 1)
+		require.Equal(t, int64(100*(i+1)), containers[0].DataTotalVolume.Value)
+		require.NotNil(t, record.RecordSequenceNumber)
+		require.Equal(t, int64(i+1), *record.RecordSequenceNumber)
+	}
+	require.Equal(t, int64(causePartialRecord), records[1].CauseForRecClosing.Value)
+	require.Equal(t, int64(causeNormalRelease), records[2].CauseForRecClosing.Value)
+}
@@ -0,0 +1,357 @@
+package processor
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/fiorix/go-diameter/diam"
+	"github.com/fiorix/go-diameter/diam/datatype"
+	"github.com/gin-gonic/gin"
+
+	charging_datatype "github.com/free5gc/chf/ccs_diameter/datatype"
+	"github.com/free5gc/chf/cdr/cdrType"
+	"github.com/free5gc/chf/internal/abmf"
+	chf_context "github.com/free5gc/chf/internal/context"
+	"github.com/free5gc/chf/internal/logger"
+	"github.com/free5gc/openapi/models"
+)
+
+// ChargingSession is the operator view of an open charging data resource of the UE pool
+type ChargingSession struct {
+	ChargingDataRef string         `json:"chargingDataRef"`
+	Supi            string         `json:"supi"`
+	Dnn             string         `json:"dnn,omitempty"`
+	Snssai          *models.Snssai `json:"snssai,omitempty"`
+	ConsumerNfName  string         `json:"consumerNfName,omitempty"`
+	ConsumerNfType  string         `json:"consumerNfType,omitempty"`
+	NotifyUri       string         `json:"notifyUri,omitempty"`
+	OpenedAt        *time.Time     `json:"openedAt,omitempty"`
+	// Quota is held per UE, so the rating groups are shared by all the sessions of the UE
+	RatingGroups []RatingGroupState `json:"ratingGroups,omitempty"`
+	Cdr          *CdrState          `json:"cdr,omitempty"`
+}
+
+type RatingGroupState struct {
+	RatingGroup int32 `json:"ratingGroup"`
+	// RESERVE while quota is granted from a reservation, DEBIT once the final units were granted
+	Mode string `json:"mode"`
+	// ReservedQuota and UsedQuota in currency units
+	ReservedQuota   int64  `json:"reservedQuota"`
+	UsedQuota       int64  `json:"usedQuota"`
+	UnitCost        uint32 `json:"unitCost"`
+	LowBalance      bool   `json:"lowBalance"`
+	CcRequestNumber uint32 `json:"ccRequestNumber"`
+}
+
+// CdrState is the state of the CDR still open for a session
+type CdrState struct {
+	LocalRecordSequenceNumber int64  `json:"localRecordSequenceNumber"`
+	RecordSequenceNumber      *int64 `json:"recordSequenceNumber,omitempty"`
+	MultipleUnitUsages        int    `json:"multipleUnitUsages"`
+	Triggers                  int    `json:"triggers"`
+}
+
+// SessionFilter selects charging sessions, an empty field matches any session
+type SessionFilter struct {
+	Supi   string
+	Dnn    string
+	Snssai *models.Snssai
+	// ConsumerNf matches the name or the type of the consumer NF
+	ConsumerNf string
+}
+
+func (f *SessionFilter) match(session *ChargingSession) bool {
+	switch {
+	case f.Supi != "" && f.Supi != session.Supi:
+		return false
+	case f.Dnn != "" && f.Dnn != session.Dnn:
+		return false
+	case f.ConsumerNf != "" && f.ConsumerNf != session.ConsumerNfName && f.ConsumerNf != session.ConsumerNfType:
+		return false
+	case f.Snssai != nil:
+		if session.Snssai == nil || f.Snssai.Sst != session.Snssai.Sst {
+			return false
+		}
+		return f.Snssai.Sd == "" || f.Snssai.Sd == session.Snssai.Sd
+	}
+	return true
+}
+
+var networkFunctionalityNames = map[int64]string{
+	int64(cdrType.NetworkFunctionalityPresentCHF):         "CHF",
+	int64(cdrType.NetworkFunctionalityPresentSMF):         "SMF",
+	int64(cdrType.NetworkFunctionalityPresentAMF):         "AMF",
+	int64(cdrType.NetworkFunctionalityPresentSMSF):        "SMSF",
+	int64(cdrType.NetworkFunctionalityPresentSGW):         "SGW",
+	int64(cdrType.NetworkFunctionalityPresentISMF):        "I_SMF",
+	int64(cdrType.NetworkFunctionalityPresentEPDG):        "ePDG",
+	int64(cdrType.NetworkFunctionalityPresentCEF):         "CEF",
+	int64(cdrType.NetworkFunctionalityPresentNEF):         "NEF",
+	int64(cdrType.NetworkFunctionalityPresentPGWCSMF):     "PGW_C_SMF",
+	int64(cdrType.NetworkFunctionalityPresentMnSProducer): "MnS_Producer",
+}
+
+// recordOpeningTime decodes the BCD time stamp YYMMDDhhmmss with its UTC offset in whole hours
+func recordOpeningTime(ts cdrType.TimeStamp) *time.Time {
+	if len(ts.Value) < 9 {
+		return nil
+	}
+	bcd := func(b byte) int {
+		return int(b>>4)*10 + int(b&0x0f)
+	}
+
+	offset := bcd(ts.Value[7]) * 3600
+	if ts.Value[6] == '-' {
+		offset = -offset
+	}
+	t := time.Date(2000+bcd(ts.Value[0]), time.Month(bcd(ts.Value[1])), bcd(ts.Value[2]),
+		bcd(ts.Value[3]), bcd(ts.Value[4]), bcd(ts.Value[5]), 0, time.FixedZone("", offset))
+	return &t
+}
+
+// chargingSession returns the view of a session, with the detail of its rating groups and CDR if asked.
+// The UE lock must be held.
+func chargingSession(ue *chf_context.ChfUe, chargingDataRef string, detail bool) *ChargingSession {
+	cdr := ue.Cdr[chargingDataRef]
+	session := &ChargingSession{
+		ChargingDataRef: chargingDataRef,
+		Supi:            ue.Supi,
+		NotifyUri:       ue.NotifyUri[chargingDataRef],
+	}
+	if cdr == nil || cdr.ChargingFunctionRecord == nil {
+		return session
+	}
+
+	record := cdr.ChargingFunctionRecord
+	session.OpenedAt = recordOpeningTime(record.RecordOpeningTime)
+	consumerInfo := record.NFunctionConsumerInformation
+	if consumerInfo.NetworkFunctionName != nil {
+		session.ConsumerNfName = string(consumerInfo.NetworkFunctionName.Value)
+	}
+	session.ConsumerNfType = networkFunctionalityNames[int64(consumerInfo.NetworkFunctionality.Value)]
+	if pduSessionInfo := record.PDUSessionChargingInformation; pduSessionInfo != nil {
+		if dnn := pduSessionInfo.DataNetworkNameIdentifier; dnn != nil {
+			session.Dnn = string(dnn.Value)
+		}
+		if slice := pduSessionInfo.NetworkSliceInstanceID; slice != nil {
+			session.Snssai = &models.Snssai{Sst: int32(slice.SST.Value)}
+			if slice.SD != nil {
+				// the SD is kept as its hexadecimal string
+				session.Snssai.Sd = string(slice.SD.Value)
+			}
+		}
+	}
+
+	if !detail {
+		return session
+	}
+
+	for _, rg := range ue.RatingGroups {
+		mode := "RESERVE"
+		if ue.RatingType[rg] == charging_datatype.REQ_SUBTYPE_DEBIT {
+			mode = "DEBIT"
+		}
+		session.RatingGroups = append(session.RatingGroups, RatingGroupState{
+			RatingGroup:     rg,
+			Mode:            mode,
+			ReservedQuota:   ue.ReservedQuota[rg],
+			UsedQuota:       ue.UsedQuota[rg],
+			UnitCost:        ue.UnitCost[rg],
+			LowBalance:      ue.LowBalance[rg],
+			CcRequestNumber: ue.AcctRequestNum[rg],
+		})
+	}
+
+	session.Cdr = &CdrState{
+		RecordSequenceNumber: record.RecordSequenceNumber,
+		MultipleUnitUsages:   len(record.ListOfMultipleUnitUsage),
+		Triggers:             len(record.Triggers),
+	}
+	if record.LocalRecordSequenceNumber != nil {
+		session.Cdr.LocalRecordSequenceNumber = record.LocalRecordSequenceNumber.Value
+	}
+	return session
+}
+
+// findSession returns the UE owning the charging session, locked, or nil if there is no such session
+func findSession(chargingDataRef string) *chf_context.ChfUe {
+	var found *chf_context.ChfUe
+
+	chf_context.GetSelf().UePool.Range(func(key, value interface{}) bool {
+		ue := value.(*chf_context.ChfUe)
+		ue.CULock.Lock()
+		if _, ok := ue.Cdr[chargingDataRef]; ok {
+			found = ue
+			return false
+		}
+		ue.CULock.Unlock()
+		return true
+	})
+	return found
+}
+
+func (p *Processor) HandleSessionsGet(c *gin.Context, filter *SessionFilter) {
+	sessions := []*ChargingSession{}
+
+	chf_context.GetSelf().UePool.Range(func(key, value interface{}) bool {
+		ue := value.(*chf_context.ChfUe)
+		if filter.Supi != "" && filter.Supi != ue.Supi {
+			return true
+		}
+
+		ue.CULock.Lock()
+		for chargingDataRef := range ue.Cdr {
+			// one-time events have no charging data resource
+			if chargingDataRef == "" {
+				continue
+			}
+			if session := chargingSession(ue, chargingDataRef, false); filter.match(session) {
+				sessions = append(sessions, session)
+			}
+		}
+		ue.CULock.Unlock()
+		return true
+	})
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].ChargingDataRef < sessions[j].ChargingDataRef
+	})
+	c.JSON(http.StatusOK, sessions)
+}
+
+func (p *Processor) HandleSessionGet(c *gin.Context, chargingDataRef string) {
+	ue := findSession(chargingDataRef)
+	if ue == nil {
+		c.JSON(http.StatusNotFound, contextNotFound(chargingDataRef))
+		return
+	}
+	session := chargingSession(ue, chargingDataRef, true)
+	ue.CULock.Unlock()
+
+	c.JSON(http.StatusOK, session)
+}
+
+// HandleSessionClose terminates the session in the CHF without waiting for the consumer: the CDR is closed
+// for management intervention and, if it was the last session of the UE, its reservations are released.
+// The consumer is then asked to abort charging, its further requests on the session are rejected.
+func (p *Processor) HandleSessionClose(c *gin.Context, chargingDataRef string) {
+	ue := findSession(chargingDataRef)
+	if ue == nil {
+		c.JSON(http.StatusNotFound, contextNotFound(chargingDataRef))
+		return
+	}
+
+	supi := ue.Supi
+	notification := newChargingNotification(ue, chargingDataRef, models.ChargingNotifyRequest{
+		NotificationType: models.ChfConvergedChargingNotificationType_ABORT_CHARGING,
+	})
+	err := p.closeSession(c.Request.Context(), ue, chargingDataRef, causeManagementIntervention)
+	ue.CULock.Unlock()
+
+	if err != nil {
+		logger.MgmtLog.Errorf("Write CDR of session [%s] err: %+v", chargingDataRef, err)
+		c.JSON(http.StatusInternalServerError, systemFailure(err))
+		return
+	}
+	logger.MgmtLog.Infof("Charging session [%s] of UE[%s] closed by management intervention", chargingDataRef, supi)
+
+	if notification.NotifyUri != "" {
+		p.SendChargingNotification(notification)
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// HandleSessionAbort asks the consumer to abort charging, the session is closed by its release
+func (p *Processor) HandleSessionAbort(c *gin.Context, chargingDataRef string) {
+	ue := findSession(chargingDataRef)
+	if ue == nil {
+		c.JSON(http.StatusNotFound, contextNotFound(chargingDataRef))
+		return
+	}
+	supi := ue.Supi
+	notification := newChargingNotification(ue, chargingDataRef, models.ChargingNotifyRequest{
+		NotificationType: models.ChfConvergedChargingNotificationType_ABORT_CHARGING,
+	})
+	ue.CULock.Unlock()
+
+	if notification.NotifyUri == "" {
+		c.JSON(http.StatusConflict, &models.ProblemDetails{
+			Title:  "No notification URI",
+			Status: http.StatusConflict,
+			Detail: "The consumer of session " + chargingDataRef + " can not be notified",
+			Cause:  "NO_NOTIFY_URI",
+		})
+		return
+	}
+
+	logger.MgmtLog.Infof("Abort charging session [%s] of UE[%s]", chargingDataRef, supi)
+	p.SendChargingNotification(notification)
+	c.Status(http.StatusAccepted)
+}
+
+// closeSession terminates the charging session in the CHF: its CDR is closed for cause and written, and if it
+// was the last session of the UE, the reservations of the UE are released. The UE lock must be held.
+func (p *Processor) closeSession(
+	ctx context.Context, ue *chf_context.ChfUe, chargingDataRef string, cause int64,
+) error {
+	cdr := ue.Cdr[chargingDataRef]
+	if len(ue.Cdr) == 1 {
+		releaseReservations(ctx, ue)
+	}
+
+	if err := closeCdr(cdr, cause); err != nil {
+		logger.ChargingdataPostLog.Errorf("Close CDR of session [%s] err: %+v", chargingDataRef, err)
+	}
+	err := p.writeCdrs(ctx, cdr)
+	delete(ue.Cdr, chargingDataRef)
+	delete(ue.NotifyUri, chargingDataRef)
+	chf_context.GetSelf().ReleaseRecordSequenceNumber(chargingDataRef)
+	return err
+}
+
+// releaseReservations commits the uncommitted usage of every rating group of the UE and releases
+// what is left of its reservations. The UE lock must be held.
+func releaseReservations(ctx context.Context, ue *chf_context.ChfUe) {
+	self := chf_context.GetSelf()
+
+	for _, rg := range ue.RatingGroups {
+		if ue.ReservedQuota[rg] <= 0 && ue.UsedQuota[rg] <= 0 {
+			continue
+		}
+
+		ccr := &charging_datatype.AccountDebitRequest{
+			SessionId:       datatype.UTF8String(strconv.Itoa(int(ue.AcctSessionId))),
+			OriginHost:      datatype.DiameterIdentity(self.AbmfCfg.OriginHost),
+			OriginRealm:     datatype.DiameterIdentity(self.AbmfCfg.OriginRealm),
+			EventTimestamp:  datatype.Time(time.Now()),
+			SubscriptionId:  buildSubscriptionId(ue.Supi),
+			UserName:        datatype.OctetString(self.Name),
+			CcRequestType:   charging_datatype.TERMINATION_REQUEST,
+			CcRequestNumber: datatype.Unsigned32(ue.AcctRequestNum[rg]),
+			MultipleServicesCreditControl: &charging_datatype.MultipleServicesCreditControl{
+				RatingGroup: datatype.Unsigned32(rg),
+				UsedServiceUnit: &charging_datatype.UsedServiceUnit{
+					CCTotalOctets: datatype.Unsigned64(ue.UsedQuota[rg]),
+				},
+			},
+		}
+		ue.AcctRequestNum[rg]++
+
+		acctDebitRsp, err := abmf.SendAccountDebitRequest(ctx, ue, ccr)
+		if err != nil {
+			// the reservation is released by the ABMF once it expires
+			logger.MgmtLog.Errorf("SendAccountDebitRequest err: %+v", err)
+			continue
+		}
+		if acctDebitRsp.ResultCode != diam.Success {
+			logger.MgmtLog.Warnf("Release of UE[%s] rating group %d: result code %d", ue.Supi, rg, acctDebitRsp.ResultCode)
+			continue
+		}
+		ue.ReservedQuota[rg] = 0
+		ue.UsedQuota[rg] = 0
+		ue.RatingType[rg] = charging_datatype.REQ_SUBTYPE_RESERVE
+	}
+}
@@ -0,0 +1,60 @@
+package processor
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/free5gc/chf/cdr/cdrConvert"
+	"github.com/free5gc/chf/cdr/cdrType"
+	chf_context "github.com/free5gc/chf/internal/context"
+	"github.com/free5gc/openapi/models"
+)
+
+func TestRecordOpeningTime(t *testing.T) {
+	t.Parallel()
+
+	opened := time.Date(2024, time.March, 9, 17, 4, 59, 0, time.FixedZone("", 8*3600))
+	decoded := recordOpeningTime(cdrConvert.TimeStampToCdr(&opened))
+	require.NotNil(t, decoded)
+	require.True(t, opened.Equal(*decoded))
+}
+
+func TestSessionFilterMatch(t *testing.T) {
+	t.Parallel()
+
+	session := &ChargingSession{
+		Supi:           "imsi-208930000000001",
+		Dnn:            "internet",
+		Snssai:         &models.Snssai{Sst: 1, Sd: "010203"},
+		ConsumerNfName: "SMF1",
+		ConsumerNfType: "SMF",
+	}
+	require.True(t, (&SessionFilter{}).match(session))
+	require.True(t, (&SessionFilter{Dnn: "internet", ConsumerNf: "SMF"}).match(session))
+	require.True(t, (&SessionFilter{ConsumerNf: "SMF1", Snssai: &models.Snssai{Sst: 1}}).match(session))
+	require.False(t, (&SessionFilter{Snssai: &models.Snssai{Sst: 1, Sd: "112233"}}).match(session))
+	require.False(t, (&SessionFilter{Supi: "imsi-208930000000002"}).match(session))
+}
+
+func TestCloseSession(t *testing.T) {
+	t.Parallel()
+
+	p, _ := newTestProcessor(t)
+	cdr := &cdrType.CHFRecord{
+		Present:                1,
+		ChargingFunctionRecord: &cdrType.ChargingRecord{},
+	}
+	ue := &chf_context.ChfUe{
+		Cdr:       map[string]*cdrType.CHFRecord{"1": cdr},
+		NotifyUri: map[string]string{"1": "http://smf/notify"},
+	}
+
+	// the cause is given at close time, before the CDR is written
+	require.NoError(t, p.closeSession(context.Background(), ue, "1", causeManagementIntervention))
+	require.Equal(t, int64(causeManagementIntervention), cdr.ChargingFunctionRecord.CauseForRecClosing.Value)
+	require.Empty(t, ue.Cdr)
+	require.Empty(t, ue.NotifyUri)
+}
@@ -0,0 +1,257 @@
+package processor
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	chf_context "github.com/free5gc/chf/internal/context"
+	"github.com/free5gc/chf/internal/logger"
+	"github.com/free5gc/openapi"
+	Nchf_ConvergedCharging "github.com/free5gc/openapi/chf/ConvergedCharging"
+	"github.com/free5gc/openapi/models"
+	Nnrf_NFDiscovery "github.com/free5gc/openapi/nrf/NFDiscovery"
+)
+
+// Delivery of a notification to its notify URI
+const (
+	notifyAttempts       = 4
+	notifyInitialBackoff = time.Second
+	notifyMaxBackoff     = 8 * time.Second
+)
+
+// ChargingNotification is a notification to the consumer of a charging session
+type ChargingNotification struct {
+	Supi            string
+	ChargingDataRef string
+	NotifyUri       string
+	// Consumer NF instance and type, to find alternate notification endpoints
+	ConsumerNfName string
+	ConsumerNfType string
+	Request        models.ChargingNotifyRequest
+}
+
+// newChargingNotification builds the notification of the request to the consumer of the session.
+// The UE lock must be held.
+func newChargingNotification(
+	ue *chf_context.ChfUe, chargingDataRef string, request models.ChargingNotifyRequest,
+) *ChargingNotification {
+	session := chargingSession(ue, chargingDataRef, false)
+	return &ChargingNotification{
+		Supi:            ue.Supi,
+		ChargingDataRef: chargingDataRef,
+		NotifyUri:       session.NotifyUri,
+		ConsumerNfName:  session.ConsumerNfName,
+		ConsumerNfType:  session.ConsumerNfType,
+		Request:         request,
+	}
+}
+
+// notificationQueue delivers the notifications in order for each notify URI, and the notify URIs in parallel
+// so that a consumer slow to answer does not delay the others
+type notificationQueue struct {
+	mu      sync.Mutex
+	pending map[string][]*ChargingNotification
+	deliver func(*ChargingNotification)
+}
+
+func newNotificationQueue(deliver func(*ChargingNotification)) *notificationQueue {
+	return &notificationQueue{
+		pending: make(map[string][]*ChargingNotification),
+		deliver: deliver,
+	}
+}
+
+func (q *notificationQueue) push(notification *ChargingNotification) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	pending, running := q.pending[notification.NotifyUri]
+	q.pending[notification.NotifyUri] = append(pending, notification)
+	if !running {
+		go q.run(notification.NotifyUri)
+	}
+}
+
+// pop returns the next notification to the notify URI, or nil once there is none left
+func (q *notificationQueue) pop(notifyUri string) *ChargingNotification {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	pending := q.pending[notifyUri]
+	if len(pending) == 0 {
+		delete(q.pending, notifyUri)
+		return nil
+	}
+	q.pending[notifyUri] = pending[1:]
+	return pending[0]
+}
+
+func (q *notificationQueue) run(notifyUri string) {
+	for notification := q.pop(notifyUri); notification != nil; notification = q.pop(notifyUri) {
+		q.deliver(notification)
+	}
+}
+
+// SendChargingNotification queues the notification for delivery to the consumer
+func (p *Processor) SendChargingNotification(notification *ChargingNotification) {
+	logger.NotifyEventLog.Infof("Send %s notification of session [%s] to %s",
+		notification.Request.NotificationType, notification.ChargingDataRef, notification.NotifyUri)
+	p.notifications.push(notification)
+}
+
+// deliverChargingNotification posts the notification, retrying with backoff while the consumer can not be
+// reached, then through the alternate endpoints of the consumer. A consumer answering 404 does not know the
+// session anymore, the orphaned session is closed.
+func (p *Processor) deliverChargingNotification(notification *ChargingNotification) {
+	ctx := p.CancelContext()
+
+	status, err := postChargingNotificationWithRetries(ctx, notification.NotifyUri, notification.Request)
+	if retryable(status, err) {
+		for _, notifyUri := range p.alternateNotifyUris(notification) {
+			logger.NotifyEventLog.Infof("Send notification of session [%s] to alternate %s",
+				notification.ChargingDataRef, notifyUri)
+			if status, err = postChargingNotification(ctx, notifyUri, notification.Request); !retryable(status, err) {
+				break
+			}
+		}
+	}
+
+	switch {
+	case err != nil:
+		logger.NotifyEventLog.Errorf("Charging Notification of session [%s] Failed[%+v]",
+			notification.ChargingDataRef, err)
+	case status == http.StatusNotFound:
+		p.closeOrphanSession(ctx, notification)
+	case status >= http.StatusMultipleChoices:
+		logger.NotifyEventLog.Errorf("Charging Notification of session [%s] rejected with status %d",
+			notification.ChargingDataRef, status)
+	default:
+		logger.NotifyEventLog.Tracef("Charging Notification Success")
+	}
+}
+
+// retryable tells whether the consumer could not be reached or was not able to process the notification
+func retryable(status int, err error) bool {
+	return err != nil || status == http.StatusTooManyRequests || status >= http.StatusInternalServerError
+}
+
+func postChargingNotificationWithRetries(
+	ctx context.Context, notifyUri string, request models.ChargingNotifyRequest,
+) (int, error) {
+	backoff := notifyInitialBackoff
+	for attempt := 1; ; attempt++ {
+		status, err := postChargingNotification(ctx, notifyUri, request)
+		if !retryable(status, err) || attempt == notifyAttempts {
+			return status, err
+		}
+		logger.NotifyEventLog.Warnf("Charging Notification to %s failed (status %d, err %v), retry in %s",
+			notifyUri, status, err, backoff)
+
+		select {
+		case <-ctx.Done():
+			return status, err
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, notifyMaxBackoff)
+	}
+}
+
+// postChargingNotification returns the HTTP status of the answer of the consumer. The generated client is not
+// used as it reports the statuses other than 400 and the redirections as a success.
+func postChargingNotification(
+	ctx context.Context, notifyUri string, request models.ChargingNotifyRequest,
+) (int, error) {
+	cfg := Nchf_ConvergedCharging.NewConfiguration()
+	headers := map[string]string{
+		"Content-Type": "application/json",
+		"Accept":       "application/json, application/problem+json",
+	}
+	req, err := openapi.PrepareRequest(ctx, cfg, notifyUri, http.MethodPost, request, headers,
+		url.Values{}, url.Values{}, "", "", nil)
+	if err != nil {
+		return 0, err
+	}
+
+	rsp, err := openapi.CallAPI(cfg, req)
+	if err != nil {
+		return 0, err
+	}
+	if err = rsp.Body.Close(); err != nil {
+		logger.NotifyEventLog.Warnf("Close body of notification response err: %+v", err)
+	}
+	return rsp.StatusCode, nil
+}
+
+// alternateNotifyUris returns the notify URI on the other instances of the NF set of the consumer,
+// as discovered from the NRF
+func (p *Processor) alternateNotifyUris(notification *ChargingNotification) []string {
+	if notification.ConsumerNfName == "" || notification.ConsumerNfType == "" {
+		return nil
+	}
+	notifyUri, err := url.Parse(notification.NotifyUri)
+	if err != nil {
+		return nil
+	}
+
+	nrfUri := p.Context().NrfUri
+	targetNfType := models.NrfNfManagementNfType(notification.ConsumerNfType)
+	requesterNfType := models.NrfNfManagementNfType_CHF
+	search := func(param Nnrf_NFDiscovery.SearchNFInstancesRequest) []models.NrfNfDiscoveryNfProfile {
+		param.TargetNfType = &targetNfType
+		param.RequesterNfType = &requesterNfType
+		result, errSearch := p.Consumer().SendSearchNFInstances(nrfUri, targetNfType, requesterNfType, param)
+		if errSearch != nil {
+			return nil
+		}
+		return result.NfInstances
+	}
+
+	consumers := search(Nnrf_NFDiscovery.SearchNFInstancesRequest{TargetNfInstanceId: &notification.ConsumerNfName})
+	if len(consumers) == 0 || len(consumers[0].NfSetIdList) == 0 {
+		return nil
+	}
+
+	var notifyUris []string
+	for _, profile := range search(Nnrf_NFDiscovery.SearchNFInstancesRequest{
+		TargetNfSetId: &consumers[0].NfSetIdList[0],
+	}) {
+		if profile.NfInstanceId == notification.ConsumerNfName {
+			continue
+		}
+		host := profile.Fqdn
+		if len(profile.Ipv4Addresses) > 0 {
+			host = profile.Ipv4Addresses[0]
+		}
+		if host == "" {
+			continue
+		}
+		alternate := *notifyUri
+		alternate.Host = host
+		if port := notifyUri.Port(); port != "" {
+			alternate.Host = net.JoinHostPort(host, port)
+		}
+		notifyUris = append(notifyUris, alternate.String())
+	}
+	return notifyUris
+}
+
+// closeOrphanSession closes the session its consumer does not know anymore
+func (p *Processor) closeOrphanSession(ctx context.Context, notification *ChargingNotification) {
+	ue := findSession(notification.ChargingDataRef)
+	if ue == nil {
+		// released in the meantime
+		return
+	}
+	logger.NotifyEventLog.Warnf("Charging session [%s] of UE[%s] is orphaned, unknown to its consumer: close it",
+		notification.ChargingDataRef, notification.Supi)
+	err := p.closeSession(ctx, ue, notification.ChargingDataRef, causeAbnormalRelease)
+	ue.CULock.Unlock()
+
+	if err != nil {
+		logger.NotifyEventLog.Errorf("Write CDR of session [%s] err: %+v", notification.ChargingDataRef, err)
+	}
+}
@@ -0,0 +1,58 @@
+package processor
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/h2non/gock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/free5gc/openapi"
+	"github.com/free5gc/openapi/models"
+)
+
+func TestNotificationQueue(t *testing.T) {
+	t.Parallel()
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	delivered := make(map[string][]string)
+	queue := newNotificationQueue(func(notification *ChargingNotification) {
+		mu.Lock()
+		delivered[notification.NotifyUri] = append(delivered[notification.NotifyUri], notification.ChargingDataRef)
+		mu.Unlock()
+		wg.Done()
+	})
+
+	refs := []string{"ref1", "ref2", "ref3", "ref4"}
+	for _, notifyUri := range []string{"http://smf1/notify", "http://smf2/notify"} {
+		for _, ref := range refs {
+			wg.Add(1)
+			queue.push(&ChargingNotification{ChargingDataRef: ref, NotifyUri: notifyUri})
+		}
+	}
+	wg.Wait()
+
+	// in order for each notify URI
+	require.Equal(t, refs, delivered["http://smf1/notify"])
+	require.Equal(t, refs, delivered["http://smf2/notify"])
+}
+
+func TestPostChargingNotification(t *testing.T) {
+	defer gock.Off()
+
+	openapi.InterceptH2CClient()
+	defer openapi.RestoreH2CClient()
+
+	gock.New("http://127.0.0.2:8000").
+		Post("/notify/gone").
+		Reply(http.StatusNotFound)
+
+	status, err := postChargingNotification(context.Background(), "http://127.0.0.2:8000/notify/gone",
+		models.ChargingNotifyRequest{NotificationType: models.ChfConvergedChargingNotificationType_ABORT_CHARGING})
+	require.NoError(t, err)
+	require.Equal(t, http.StatusNotFound, status)
+	require.False(t, retryable(status, err))
+}
@@ -1,13 +1,43 @@
 package processor
 
-import "github.com/free5gc/chf/pkg/app"
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/free5gc/chf/cdr/cdrFile"
+	"github.com/free5gc/chf/internal/cdrwriter"
+	"github.com/free5gc/chf/internal/cgf"
+	"github.com/free5gc/chf/internal/logger"
+	"github.com/free5gc/chf/internal/sbi/consumer"
+	"github.com/free5gc/chf/pkg/app"
+)
 
 type ProcessorChf interface {
 	app.App
+
+	Consumer() *consumer.Consumer
+	CancelContext() context.Context
 }
 
 type Processor struct {
 	ProcessorChf
+
+	notifications *notificationQueue
+	cdrWriter     *cdrwriter.Writer
+	cdrFormat     cdrFile.DataRecordFormatType
+	// transfers of the closed CDR files in progress
+	transfers sync.WaitGroup
+}
+
+// cdrFormats are the data record formats of the CDR file encodings
+var cdrFormats = map[string]cdrFile.DataRecordFormatType{
+	"ber":  cdrFile.BasicEncodingRules,
+	"uper": cdrFile.UnalignedPackedEncodingRules,
+	"aper": cdrFile.AlignedPackedEncodingRules1,
+	"xer":  cdrFile.XMLEncodingRules,
+	"json": cdrFile.JSONEncodingRules,
 }
 
 type HandlerResponse struct {
@@ -20,5 +50,37 @@ func NewProcessor(chf ProcessorChf) (*Processor, error) {
 	p := &Processor{
 		ProcessorChf: chf,
 	}
+	p.notifications = newNotificationQueue(p.deliverChargingNotification)
+
+	cfg := chf.Config()
+	limits := cfg.GetCdrFile()
+	dirs := cfg.GetCdrDirs()
+	cdrWriter, err := cdrwriter.New(cdrwriter.Dirs{
+		Working: dirs.Working,
+		Ready:   dirs.Ready,
+	}, cdrwriter.Node{
+		Id:      cfg.GetCdrNodeId(),
+		Address: net.ParseIP(chf.Context().RegisterIPv4),
+	}, cdrwriter.Limits{
+		MaxSize:     limits.MaxSize,
+		MaxOpenTime: time.Duration(limits.MaxOpenTime) * time.Second,
+		MaxCdrs:     limits.MaxCdrs,
+	}, p.transferCdrFile)
+	if err != nil {
+		return nil, err
+	}
+	p.cdrWriter = cdrWriter
+	p.cdrFormat = cdrFormats[limits.Encoding]
 	return p, nil
 }
+
+// transferCdrFile sends the closed CDR file to the billing domain
+func (p *Processor) transferCdrFile(path string) {
+	p.transfers.Add(1)
+	go func() {
+		defer p.transfers.Done()
+		if err := cgf.SendCDR(context.Background(), path); err != nil {
+			logger.CdrLog.Errorf("Charging gateway fail to send CDR file %s to billing domain %v", path, err)
+		}
+	}()
+}
@@ -11,10 +11,14 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
 
+	"github.com/free5gc/chf/internal/health"
 	"github.com/free5gc/chf/internal/logger"
+	"github.com/free5gc/chf/internal/metrics"
 	"github.com/free5gc/chf/internal/sbi/consumer"
 	"github.com/free5gc/chf/internal/sbi/processor"
+	"github.com/free5gc/chf/internal/tracing"
 	"github.com/free5gc/chf/internal/util"
 	"github.com/free5gc/chf/pkg/app"
 	"github.com/free5gc/chf/pkg/factory"
@@ -29,6 +33,7 @@ type ServerChf interface {
 	Consumer() *consumer.Consumer
 	Processor() *processor.Processor
 	CancelContext() context.Context
+	ReloadConfig() (*factory.ReloadReport, error)
 }
 
 type Server struct {
@@ -60,6 +65,10 @@ func NewServer(chf ServerChf, tlsKeyLogPath string) (*Server, error) {
 
 func newRouter(s *Server) *gin.Engine {
 	router := logger_util.NewGinWithLogrus(logger.GinLog)
+	// spans of the SBI requests, children of the trace context of the consumer
+	router.Use(otelgin.Middleware(tracing.ServiceName, otelgin.WithFilter(func(r *http.Request) bool {
+		return r.URL.Path != "/metrics"
+	})))
 
 	for _, serviceName := range s.Config().Configuration.ServiceNameList {
 		switch models.ServiceName(serviceName) {
@@ -68,7 +77,7 @@ func newRouter(s *Server) *gin.Engine {
 			chfConvergedChargingGroup.Use(func(c *gin.Context) {
 				// oauth middleware
 				util.NewRouterAuthorizationCheck(models.ServiceName(serviceName)).Check(c, s.Context())
-			})
+			}, readinessGate)
 			chfConvergedChargingRoutes := s.getConvergenChargingRoutes()
 			applyRoutes(chfConvergedChargingGroup, chfConvergedChargingRoutes)
 
@@ -77,7 +86,7 @@ func newRouter(s *Server) *gin.Engine {
 			chfOfflineOnlyChargingGroup.Use(func(c *gin.Context) {
 				// oauth middleware
 				util.NewRouterAuthorizationCheck(models.ServiceName(serviceName)).Check(c, s.Context())
-			})
+			}, readinessGate)
 
 			chfOfflineOnlyChargingGroupRoutes := s.getOfflineOnlyChargingRoutes()
 			applyRoutes(chfOfflineOnlyChargingGroup, chfOfflineOnlyChargingGroupRoutes)
@@ -87,7 +96,7 @@ func newRouter(s *Server) *gin.Engine {
 			chfSpendingLimitControlGroup.Use(func(c *gin.Context) {
 				// oauth middleware
 				util.NewRouterAuthorizationCheck(models.ServiceName(serviceName)).Check(c, s.Context())
-			})
+			}, readinessGate)
 			chfSpendingLimitControlRoutes := s.getSpendingLimitControlRoutes()
 			applyRoutes(chfSpendingLimitControlGroup, chfSpendingLimitControlRoutes)
 
@@ -96,18 +105,50 @@ func newRouter(s *Server) *gin.Engine {
 		}
 	}
 
+	// The ABMF balance management and the management API are not Nchf services: only the operator is authorized
+	operatorAuthorization := util.NewOperatorAuthorizationCheck(func() string {
+		return s.Config().GetOperatorApiToken()
+	})
+	if s.Config().GetOperatorApiToken() == "" {
+		logger.SBILog.Warnf("No operator API credential configured, the operator APIs are refused")
+	}
+
+	// Balance management of the ABMF
+	abmfGroup := router.Group(factory.AbmfResUriPrefix)
+	abmfGroup.Use(operatorAuthorization.Check)
+	applyRoutes(abmfGroup, s.getAbmfRoutes())
+
+	// Liveness and readiness probes, left open like the metrics
+	healthGroup := router.Group(factory.HealthResUriPrefix)
+	applyRoutes(healthGroup, s.getHealthRoutes())
+
+	// Prometheus scrape endpoint
+	router.GET("/metrics", gin.WrapH(metrics.Handler()))
+
+	// Operator management of the charging sessions and configuration
+	managementGroup := router.Group(factory.ManagementResUriPrefix)
+	managementGroup.Use(operatorAuthorization.Check)
+	applyRoutes(managementGroup, s.getManagementRoutes())
+
 	return router
 }
 
 func (s *Server) Run(traceCtx context.Context, wg *sync.WaitGroup) error {
-	var err error
-	_, s.Context().NfId, err = s.Consumer().RegisterNFInstance(s.CancelContext())
+	// serve the health probes while registering
+	wg.Add(1)
+	go s.startServer(wg)
+
+	_, nfId, err := s.Consumer().RegisterNFInstance(s.CancelContext())
 	if err != nil {
 		logger.InitLog.Errorf("CHF register to NRF Error[%s]", err.Error())
+		health.Down(health.Nrf, err)
+		return nil
 	}
-
-	wg.Add(1)
-	go s.startServer(wg)
+	if nfId != "" {
+		s.Context().SetNfId(nfId)
+	}
+	health.Up(health.Nrf)
+	go s.Consumer().RunHeartbeat(s.CancelContext())
 
 	return nil
 }
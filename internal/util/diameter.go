@@ -0,0 +1,24 @@
+package util
+
+import (
+	"crypto/tls"
+
+	"github.com/fiorix/go-diameter/diam"
+)
+
+// ListenAndServeDiameterTLS serves Diameter over TLS on addr like diam.ListenAndServeTLS,
+// calling listening once the server accepts connections
+func ListenAndServeDiameterTLS(addr, certFile, keyFile string, handler diam.Handler, listening func()) error {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return err
+	}
+	l, err := diam.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	listening()
+	server := &diam.Server{Addr: addr, Handler: handler}
+	return server.Serve(tls.NewListener(l, &tls.Config{Certificates: []tls.Certificate{cert}}))
+}
@@ -0,0 +1,42 @@
+package util
+
+import (
+	"crypto/subtle"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/free5gc/chf/internal/logger"
+)
+
+// OperatorAuthorizationCheck authorizes the operator APIs with the bearer token of the configuration,
+// they are refused altogether while no token is configured
+type OperatorAuthorizationCheck struct {
+	token func() string
+}
+
+func NewOperatorAuthorizationCheck(token func() string) *OperatorAuthorizationCheck {
+	return &OperatorAuthorizationCheck{
+		token: token,
+	}
+}
+
+func (oac *OperatorAuthorizationCheck) Check(c *gin.Context) {
+	token := oac.token()
+	if token == "" {
+		logger.UtilLog.Debugf("OperatorAuthorizationCheck::Check Forbidden: no operator credential configured")
+		c.JSON(http.StatusForbidden, gin.H{"error": "operator API disabled: no credential configured"})
+		c.Abort()
+		return
+	}
+
+	authorization := c.Request.Header.Get("Authorization")
+	if subtle.ConstantTimeCompare([]byte(authorization), []byte("Bearer "+token)) != 1 {
+		logger.UtilLog.Debugf("OperatorAuthorizationCheck::Check Unauthorized")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid operator credential"})
+		c.Abort()
+		return
+	}
+
+	logger.UtilLog.Debugf("OperatorAuthorizationCheck::Check Authorized")
+}
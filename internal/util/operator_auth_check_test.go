@@ -0,0 +1,39 @@
+package util
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOperatorAuthorizationCheck_Check(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name          string
+		token         string
+		authorization string
+		statusCode    int
+	}{
+		{"Valid Token", "secret", "Bearer secret", http.StatusOK},
+		{"Invalid Token", "secret", "Bearer other", http.StatusUnauthorized},
+		{"Missing Token", "secret", "", http.StatusUnauthorized},
+		{"No Credential Configured", "", "Bearer ", http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		var err error
+		c.Request, err = http.NewRequest("GET", "/", nil)
+		require.NoError(t, err)
+		c.Request.Header.Set("Authorization", tt.authorization)
+
+		token := tt.token
+		NewOperatorAuthorizationCheck(func() string { return token }).Check(c)
+		require.Equal(t, tt.statusCode, w.Code, tt.name)
+	}
+}
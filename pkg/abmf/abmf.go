@@ -18,7 +18,7 @@ package abmf
 import (
 	"bytes"
 	"context"
-	"math"
+	"errors"
 	_ "net/http/pprof"
 	"strconv"
 	"sync"
@@ -28,11 +28,16 @@ import (
 	"github.com/fiorix/go-diameter/diam/datatype"
 	"github.com/fiorix/go-diameter/diam/dict"
 	"github.com/fiorix/go-diameter/diam/sm"
-	"go.mongodb.org/mongo-driver/bson"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 
+	charging_code "github.com/free5gc/chf/ccs_diameter/code"
 	charging_datatype "github.com/free5gc/chf/ccs_diameter/datatype"
 	charging_dict "github.com/free5gc/chf/ccs_diameter/dict"
+	"github.com/free5gc/chf/internal/health"
 	"github.com/free5gc/chf/internal/logger"
+	"github.com/free5gc/chf/internal/tracing"
+	"github.com/free5gc/chf/internal/util"
 	"github.com/free5gc/chf/pkg/factory"
 	"github.com/free5gc/util/mongoapi"
 )
@@ -49,6 +54,17 @@ func OpenServer(ctx context.Context, wg *sync.WaitGroup) {
 	// Connect to MongoDB
 	if err := mongoapi.SetMongoDB(mongodb.Name, mongodb.Url); err != nil {
 		logger.InitLog.Errorf("InitpcfContext err: %+v", err)
+		health.Down(health.Abmf, err)
+		return
+	}
+	if err := createLedgerIndexes(); err != nil {
+		logger.AcctLog.Errorf("Create ledger indexes err: %+v", err)
+		health.Down(health.Abmf, err)
+		return
+	}
+	if err := createVoucherIndexes(); err != nil {
+		logger.AcctLog.Errorf("Create voucher indexes err: %+v", err)
+		health.Down(health.Abmf, err)
 		return
 	}
 
@@ -71,6 +87,12 @@ func OpenServer(ctx context.Context, wg *sync.WaitGroup) {
 
 	// Print error reports.
 	go printErrors(mux.ErrorReports())
+
+	expiryInterval := factory.ChfConfig.Configuration.AbmfExpiryInterval
+	if expiryInterval <= 0 {
+		expiryInterval = factory.AbmfDefaultExpiryInterval
+	}
+	go runExpiryScheduler(ctx, time.Duration(expiryInterval)*time.Second)
 	go func() {
 		defer func() {
 			logger.AcctLog.Infoln("ABMF server stopped")
@@ -81,13 +103,23 @@ func OpenServer(ctx context.Context, wg *sync.WaitGroup) {
 	abmfDiameter := factory.ChfConfig.Configuration.AbmfDiameter
 	addr := abmfDiameter.HostIPv4 + ":" + strconv.Itoa(abmfDiameter.Port)
 	go func() {
-		errListen := diam.ListenAndServeTLS(addr, abmfDiameter.Tls.Pem, abmfDiameter.Tls.Key, mux, nil)
-		if errListen != nil {
-			logger.AcctLog.Errorf("ABMF server fail to listen: %V", errListen)
-		}
+		errListen := util.ListenAndServeDiameterTLS(addr, abmfDiameter.Tls.Pem, abmfDiameter.Tls.Key, mux, func() {
+			health.Up(health.Abmf)
+		})
+		health.Down(health.Abmf, errListen)
+		logger.AcctLog.Errorf("ABMF server fail to listen: %+v", errListen)
 	}()
 }
 
+// reservationValidity is how long a reservation is held without being committed by its session
+func reservationValidity() time.Duration {
+	validity := factory.ChfConfig.GetAbmfReservationValidity()
+	if validity <= 0 {
+		validity = factory.AbmfDefaultReservationValidity
+	}
+	return time.Duration(validity) * time.Second
+}
+
 func printErrors(ec <-chan *diam.ErrorReport) {
 	for err := range ec {
 		logger.AcctLog.Errorf("Diam Error Report: %v", err)
@@ -110,13 +142,25 @@ func printErrors(ec <-chan *diam.ErrorReport) {
 func handleCCR() diam.HandlerFunc {
 	return func(c diam.Conn, m *diam.Message) {
 		var ccr charging_datatype.AccountDebitRequest
-		var cca charging_datatype.AccountDebitResponse
+		var cca *charging_datatype.AccountDebitResponse
 		var subscriberId string
 		var creditControl *charging_datatype.MultipleServicesCreditControl
 
+		// the CHF does not send the trace context over Diameter, the session id ties the spans to its request
+		ctx, span := tracing.StartKind(context.Background(), "ABMF CCR", trace.SpanKindServer)
+		defer span.End()
+
 		if err := m.Unmarshal(&ccr); err != nil {
 			logger.AcctLog.Errorf("Failed to parse message from %s: %s\n%s",
 				c.RemoteAddr(), err, m)
+			writeCCA(c, m, newCCA(&ccr, diam.UnableToComply))
+			return
+		}
+
+		mscc := ccr.MultipleServicesCreditControl
+		if ccr.SubscriptionId == nil || mscc == nil {
+			logger.AcctLog.Errorf("CCR from %s without subscriber or credit control", c.RemoteAddr())
+			writeCCA(c, m, newCCA(&ccr, diam.MissingAVP))
 			return
 		}
 
@@ -124,114 +168,216 @@ func handleCCR() diam.HandlerFunc {
 		case charging_datatype.END_USER_IMSI:
 			subscriberId = "imsi-" + string(ccr.SubscriptionId.SubscriptionIdData)
 		}
+		rg := uint32(mscc.RatingGroup)
+		span.SetAttributes(
+			attribute.String("chf.supi", subscriberId),
+			attribute.String("diameter.session_id", string(ccr.SessionId)),
+			attribute.Int64("diameter.cc_request_number", int64(ccr.CcRequestNumber)),
+			attribute.Int64("chf.rating_group", int64(rg)))
+
+		var w *wallet
+		err := tracing.Trace(ctx, "MongoDB open wallet", func() (errOpen error) {
+			w, errOpen = openWallet(subscriberId, rg)
+			return errOpen
+		})
+		if err != nil {
+			if errors.Is(err, ErrUserUnknown) {
+				logger.AcctLog.Warnf("UE[%s] unknown: %+v", subscriberId, err)
+				writeCCA(c, m, newCCA(&ccr, charging_code.UserUnknown))
+				return
+			}
+			logger.AcctLog.Errorf("Load account of UE[%s] failed: %+v", subscriberId, err)
+			writeCCA(c, m, newCCA(&ccr, diam.UnableToComply))
+			return
+		}
+		defer w.close()
+		resultCode := uint32(diam.Success)
+		// reservation of the session before and after the request
+		var previous, next *Reservation
+		var sessionBased bool
+		unitCost := getUnitCost(subscriberId, rg)
+		sessionId := string(ccr.SessionId)
+		ref := ledgerRef{
+			UeId:            subscriberId,
+			SessionId:       sessionId,
+			CcRequestNumber: uint32(ccr.CcRequestNumber),
+			RatingGroup:     rg,
+			UnitCost:        unitCost,
+		}
 
-		mscc := ccr.MultipleServicesCreditControl
-		rg := mscc.RatingGroup
+		switch ccr.CcRequestType {
+		case charging_datatype.INITIAL_REQUEST, charging_datatype.UPDATE_REQUEST,
+			charging_datatype.TERMINATION_REQUEST:
+			// Session based charging: commit the usage against the current reservation,
+			// then hold the requested amount until the next request of the session
+			var reservation *Reservation
+			errLoad := tracing.Trace(ctx, "MongoDB load reservation", func() (errFind error) {
+				reservation, errFind = loadReservation(sessionId, rg)
+				return errFind
+			})
+			if errLoad != nil {
+				logger.AcctLog.Errorf("Load reservation of UE[%s] failed: %+v", subscriberId, errLoad)
+				writeCCA(c, m, newCCA(&ccr, diam.UnableToComply))
+				return
+			}
 
-		// Retrieve quota into mongoDB
-		filter := bson.M{"ueId": subscriberId, "ratingGroup": rg}
-		queryStrength := 2
-		chargingInterface, err := mongoapi.RestfulAPIGetOne(chargingDatasColl, filter, queryStrength)
+			var usedQuota int64
+			if mscc.UsedServiceUnit != nil {
+				usedQuota = int64(mscc.UsedServiceUnit.CCTotalOctets)
+			}
+			var holds []balanceDebit
+			holdUnitCost := unitCost
+			if reservation != nil {
+				// data buckets were held with the unit cost of the time
+				holds, holdUnitCost = reservation.Holds, reservation.UnitCost
+			}
+			if reservation != nil || usedQuota > 0 {
+				w.settle(rg, holds, usedQuota, holdUnitCost)
+				ref.Operation = LedgerOperationDebit
+				w.record(ref)
+			}
 
-		if chargingInterface == nil {
-			logger.AcctLog.Errorf("chargingInterface is nil, err: %v", err)
-			return
-		}
+			previous, sessionBased = reservation, true
+			if ccr.CcRequestType == charging_datatype.TERMINATION_REQUEST {
+				break
+			}
 
-		quotaStr := chargingInterface["quota"].(string)
-		quota, err := strconv.ParseInt(quotaStr, 10, 64)
-		if err != nil {
-			logger.AcctLog.Errorf("srtconv ParseInt error: %+v", err)
-			return
-		}
+			var requestQuota int64
+			if mscc.RequestedServiceUnit != nil {
+				requestQuota = int64(mscc.RequestedServiceUnit.CCTotalOctets)
+			}
+			reservation = &Reservation{
+				SessionId:   sessionId,
+				RatingGroup: rg,
+				UeId:        subscriberId,
+				UnitCost:    unitCost,
+				Holds:       w.reserve(rg, requestQuota, unitCost),
+				ExpiresAt:   time.Now().Add(reservationValidity()),
+			}
+			var finalUnitIndication *charging_datatype.FinalUnitIndication
+			if missing := requestQuota - reservation.amount(); missing > 0 {
+				var overrun []balanceDebit
+				overrun, finalUnitIndication = w.limitReached(rg, missing)
+				reservation.Holds = append(reservation.Holds, overrun...)
+				if reservation.amount() == 0 {
+					resultCode = charging_code.CreditLimitReached
+				}
+			}
+			ref.Operation = LedgerOperationReservation
+			w.record(ref)
+			next = reservation
+
+			creditControl = &charging_datatype.MultipleServicesCreditControl{
+				RatingGroup: datatype.Unsigned32(rg),
+				GrantedServiceUnit: &charging_datatype.GrantedServiceUnit{
+					CCTotalOctets: datatype.Unsigned64(reservation.amount()),
+				},
+				FinalUnitIndication: finalUnitIndication,
+			}
+		case charging_datatype.EVENT_REQUEST:
+			switch ccr.RequestedAction {
+			case charging_datatype.CHECK_BALANCE:
+				logger.AcctLog.Errorf("CHECK_BALANCE not supported")
+				resultCode = diam.UnableToComply
+			case charging_datatype.PRICE_ENQUIRY:
+				logger.AcctLog.Errorf("Should use rating function for PRICE_ENQUIRY")
+				resultCode = diam.UnableToComply
+			case charging_datatype.REFUND_ACCOUNT, charging_datatype.DIRECT_DEBITING:
+				if mscc.RequestedServiceUnit == nil {
+					logger.AcctLog.Errorf("%v of UE[%s] without Requested-Service-Unit", ccr.RequestedAction, subscriberId)
+					writeCCA(c, m, newCCA(&ccr, diam.MissingAVP))
+					return
+				}
+			}
 
-		switch ccr.RequestedAction {
-		case charging_datatype.CHECK_BALANCE:
-			logger.AcctLog.Errorf("CHECK_BALANCE not supported")
-		case charging_datatype.PRICE_ENQUIRY:
-			logger.AcctLog.Errorf("Should use rating function for PRICE_ENQUIRY")
-		case charging_datatype.REFUND_ACCOUNT:
-			logger.AcctLog.Infof("Refund Account")
-			refundQuota := int64(mscc.RequestedServiceUnit.CCTotalOctets)
-			quota += refundQuota
-		case charging_datatype.DIRECT_DEBITING:
-			switch ccr.CcRequestType {
-			case charging_datatype.INITIAL_REQUEST, charging_datatype.UPDATE_REQUEST:
-				var finalUnitIndication *charging_datatype.FinalUnitIndication
+			switch ccr.RequestedAction {
+			case charging_datatype.REFUND_ACCOUNT:
+				logger.AcctLog.Infof("Refund Account")
+				w.credit(rg, int64(mscc.RequestedServiceUnit.CCTotalOctets))
+				ref.Operation = LedgerOperationRefund
+				w.record(ref)
+			case charging_datatype.DIRECT_DEBITING:
 				requestQuota := int64(mscc.RequestedServiceUnit.CCTotalOctets)
-				if requestQuota > quota {
-					finalUnitIndication = &charging_datatype.FinalUnitIndication{
-						FinalUnitAction: charging_datatype.TERMINATE,
-					}
-
-					requestQuota = quota
+				grantedQuota := sumDebits(w.debit(rg, requestQuota, unitCost))
+				ref.Operation = LedgerOperationDebit
+				w.record(ref)
+				if requestQuota > 0 && grantedQuota == 0 {
+					resultCode = charging_code.CreditLimitReached
 				}
 
 				creditControl = &charging_datatype.MultipleServicesCreditControl{
-					RatingGroup: rg,
+					RatingGroup: datatype.Unsigned32(rg),
 					GrantedServiceUnit: &charging_datatype.GrantedServiceUnit{
-						CCTotalOctets: datatype.Unsigned64(requestQuota),
+						CCTotalOctets: datatype.Unsigned64(grantedQuota),
 					},
-					FinalUnitIndication: finalUnitIndication,
 				}
-
-				quota -= requestQuota
-			case charging_datatype.TERMINATION_REQUEST:
-				usedQuota := int64(mscc.UsedServiceUnit.CCTotalOctets)
-				quota -= usedQuota
 			}
+		}
+
+		cca = newCCA(&ccr, resultCode)
+		cca.RemainingBalance = &charging_datatype.RemainingBalance{
+			UnitValue: unitValue(w.remaining(rg, unitCost)),
+		}
+		cca.ABResponse = w.abResponses()
+		cca.MultipleServicesCreditControl = creditControl
+		if w.isLowBalance(rg, unitCost) {
+			logger.AcctLog.Infof("UE[%s] balance is low for rating group %d", subscriberId, rg)
+			cca.LowBalanceIndication = charging_datatype.YES
+		}
 
-			// Convert quota into value digits and exponential expression
-			quotaStr = strconv.FormatInt(quota, 10)
-			quotaInt, err1 := strconv.ParseInt(quotaStr, 10, 64)
-			if err1 != nil {
-				logger.AcctLog.Errorf("srtconv ParseInt error: %+v", err1)
+		w.logBalances(rg)
+
+		// the reservation is stored first: were the accounts saved without it, its holds could never be released
+		if sessionBased {
+			err = tracing.Trace(ctx, "MongoDB store reservation", func() error {
+				return storeReservation(previous, next)
+			})
+			if err != nil {
+				logger.AcctLog.Errorf("Store reservation of UE[%s] err: %+v", subscriberId, err)
+				writeCCA(c, m, newCCA(&ccr, diam.UnableToComply))
 				return
 			}
+		}
 
-			quotaLen := len(quotaStr)
-			quotaExp := quotaLen - 1
-			quotaVal := quotaInt / int64(math.Pow10(quotaExp))
-
-			cca = charging_datatype.AccountDebitResponse{
-				SessionId:       ccr.SessionId,
-				OriginHost:      ccr.DestinationHost,
-				OriginRealm:     ccr.DestinationRealm,
-				CcRequestType:   ccr.CcRequestType,
-				CcRequestNumber: ccr.CcRequestNumber,
-				EventTimestamp:  datatype.Time(time.Now()),
-				RemainingBalance: &charging_datatype.RemainingBalance{
-					UnitValue: &charging_datatype.UnitValue{
-						ValueDigits: datatype.Integer64(quotaVal),
-						Exponent:    datatype.Integer32(quotaExp),
-					},
-				},
-				MultipleServicesCreditControl: creditControl,
+		if err = tracing.Trace(ctx, "MongoDB commit wallet", w.commit); err != nil {
+			logger.AcctLog.Errorf("Save account of UE[%s] err: %+v", subscriberId, err)
+			if sessionBased && !errors.Is(err, errLedgerRecord) {
+				if errRestore := storeReservation(next, previous); errRestore != nil {
+					logger.AcctLog.Errorf("Restore reservation of UE[%s] err: %+v", subscriberId, errRestore)
+				}
 			}
+			writeCCA(c, m, newCCA(&ccr, diam.UnableToComply))
+			return
 		}
 
-		logger.AcctLog.Infof("UE [%s], Rating group [%d], quota [%d]", subscriberId, rg, quota)
-
-		chargingBsonM := make(bson.M)
-		chargingBsonM["quota"] = strconv.FormatInt(quota, 10)
-		logger.AcctLog.Warnln("quota:", quota)
-		if _, err1 := mongoapi.RestfulAPIPutOne(chargingDatasColl, filter, chargingBsonM); err1 != nil {
-			logger.AcctLog.Errorf("RestfulAPIPutOne err: %+v", err1)
-		}
+		writeCCA(c, m, cca)
+	}
+}
 
-		a := m.Answer(diam.Success)
+// newCCA builds the answer to ccr with the mandatory AVPs only
+func newCCA(
+	ccr *charging_datatype.AccountDebitRequest, resultCode uint32,
+) *charging_datatype.AccountDebitResponse {
+	return &charging_datatype.AccountDebitResponse{
+		SessionId:       ccr.SessionId,
+		ResultCode:      datatype.Unsigned32(resultCode),
+		OriginHost:      ccr.DestinationHost,
+		OriginRealm:     ccr.DestinationRealm,
+		CcRequestType:   ccr.CcRequestType,
+		CcRequestNumber: ccr.CcRequestNumber,
+		EventTimestamp:  datatype.Time(time.Now()),
+	}
+}
 
-		err = a.Marshal(&cca)
-		if err != nil {
-			logger.AcctLog.Errorf("Marshal CCA Err: %+v:", err)
-		}
+func writeCCA(c diam.Conn, m *diam.Message, cca *charging_datatype.AccountDebitResponse) {
+	a := m.Answer(uint32(cca.ResultCode))
+	if err := a.Marshal(cca); err != nil {
+		logger.AcctLog.Errorf("Marshal CCA Err: %+v:", err)
+	}
 
-		_, err = a.WriteTo(c)
-		if err != nil {
-			logger.AcctLog.Errorf("Failed to write message to %s: %s\n%s\n",
-				c.RemoteAddr(), err, a)
-			return
-		}
+	if _, err := a.WriteTo(c); err != nil {
+		logger.AcctLog.Errorf("Failed to write message to %s: %s\n%s\n",
+			c.RemoteAddr(), err, a)
 	}
 }
 
@@ -0,0 +1,216 @@
+package abmf
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/fiorix/go-diameter/diam"
+	"github.com/fiorix/go-diameter/diam/datatype"
+	"github.com/fiorix/go-diameter/diam/dict"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+
+	charging_code "github.com/free5gc/chf/ccs_diameter/code"
+	charging_datatype "github.com/free5gc/chf/ccs_diameter/datatype"
+	charging_dict "github.com/free5gc/chf/ccs_diameter/dict"
+	"github.com/free5gc/chf/pkg/factory"
+)
+
+var loadDictionary sync.Once
+
+// unknownApplication has the Credit-Control command without the AVPs of the credit control application
+const unknownApplication = 999
+
+const unknownApplicationDictionary = `<diameter>
+	<application id="999" type="auth" name="Unknown">
+		<command code="272" short="CC" name="Credit-Control">
+			<request><rule avp="Session-Id" required="true" max="1"/></request>
+			<answer><rule avp="Result-Code" required="true" max="1"/></answer>
+		</command>
+	</application>
+</diameter>`
+
+// memStore stands for MongoDB, it matches the documents on the equality of the filter fields
+type memStore map[string][]bson.M
+
+// useMemStore replaces the mongoapi operations of the package with s until the end of the test,
+// the tests using it can not run in parallel
+func useMemStore(t *testing.T, s memStore) {
+	get, many, put, post, del := getOne, getMany, putOne, postMany, deleteOne
+	t.Cleanup(func() {
+		getOne, getMany, putOne, postMany, deleteOne = get, many, put, post, del
+	})
+
+	getOne = func(coll string, filter bson.M, _ ...interface{}) (map[string]interface{}, error) {
+		if i := s.find(coll, filter); i >= 0 {
+			return s[coll][i], nil
+		}
+		return nil, nil
+	}
+	getMany = func(coll string, filter bson.M, _ ...interface{}) ([]map[string]interface{}, error) {
+		var docs []map[string]interface{}
+		for _, doc := range s[coll] {
+			if matchDocument(doc, filter) {
+				docs = append(docs, doc)
+			}
+		}
+		return docs, nil
+	}
+	putOne = func(coll string, filter bson.M, data map[string]interface{}, _ ...interface{}) (bool, error) {
+		doc := toBsonM(t, data)
+		if i := s.find(coll, filter); i >= 0 {
+			for k, v := range doc {
+				s[coll][i][k] = v
+			}
+			return true, nil
+		}
+		s[coll] = append(s[coll], doc)
+		return false, nil
+	}
+	postMany = func(coll string, _ bson.M, data []interface{}) error {
+		for _, d := range data {
+			s[coll] = append(s[coll], toBsonM(t, d))
+		}
+		return nil
+	}
+	deleteOne = func(coll string, filter bson.M, _ ...interface{}) error {
+		if i := s.find(coll, filter); i >= 0 {
+			s[coll] = append(s[coll][:i], s[coll][i+1:]...)
+		}
+		return nil
+	}
+}
+
+func (s memStore) find(coll string, filter bson.M) int {
+	for i, doc := range s[coll] {
+		if matchDocument(doc, filter) {
+			return i
+		}
+	}
+	return -1
+}
+
+func matchDocument(doc bson.M, filter bson.M) bool {
+	for key, want := range filter {
+		if !matchPath(doc, strings.Split(key, "."), want) {
+			return false
+		}
+	}
+	return true
+}
+
+func matchPath(value interface{}, path []string, want interface{}) bool {
+	if len(path) == 0 {
+		return fmt.Sprint(value) == fmt.Sprint(want)
+	}
+	switch v := value.(type) {
+	case bson.M:
+		next, ok := v[path[0]]
+		return ok && matchPath(next, path[1:], want)
+	case bson.A:
+		for _, elem := range v {
+			if matchPath(elem, path, want) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func toBsonM(t *testing.T, in interface{}) bson.M {
+	raw, err := bson.Marshal(in)
+	require.NoError(t, err)
+	var doc bson.M
+	require.NoError(t, bson.Unmarshal(raw, &doc))
+	return doc
+}
+
+// answerConn keeps the answers written by the handlers
+type answerConn struct {
+	bytes.Buffer
+}
+
+func (c *answerConn) Close()                         {}
+func (c *answerConn) LocalAddr() net.Addr            { return &net.TCPAddr{} }
+func (c *answerConn) RemoteAddr() net.Addr           { return &net.TCPAddr{} }
+func (c *answerConn) TLS() *tls.ConnectionState      { return nil }
+func (c *answerConn) Dictionary() *dict.Parser       { return dict.Default }
+func (c *answerConn) Context() context.Context       { return context.Background() }
+func (c *answerConn) SetContext(ctx context.Context) {}
+func (c *answerConn) Connection() net.Conn           { return nil }
+
+// serveCCR hands m to the CCR handler and returns the Result-Code of its answer
+func serveCCR(t *testing.T, m *diam.Message) uint32 {
+	var c answerConn
+	handleCCR()(&c, m)
+
+	a, err := diam.ReadMessage(&c, dict.Default)
+	require.NoError(t, err)
+	resultCode, err := a.FindAVP("Result-Code", 0)
+	require.NoError(t, err)
+	return uint32(resultCode.Data.(datatype.Unsigned32))
+}
+
+func newTestCCR(t *testing.T, ccr *charging_datatype.AccountDebitRequest) *diam.Message {
+	loadDictionary.Do(func() {
+		require.NoError(t, dict.Default.Load(bytes.NewReader([]byte(charging_dict.AbmfDictionary))))
+		require.NoError(t, dict.Default.Load(bytes.NewReader([]byte(unknownApplicationDictionary))))
+	})
+	m := diam.NewRequest(charging_code.ABMF_CreditControl, charging_code.Re_interface, dict.Default)
+	require.NoError(t, m.Marshal(ccr))
+	return m
+}
+
+func initialCCR(imsi string, rg uint32, requested uint64) *charging_datatype.AccountDebitRequest {
+	return &charging_datatype.AccountDebitRequest{
+		SessionId:       datatype.UTF8String("session-" + imsi),
+		CcRequestType:   charging_datatype.INITIAL_REQUEST,
+		CcRequestNumber: 0,
+		SubscriptionId: &charging_datatype.SubscriptionId{
+			SubscriptionIdType: charging_datatype.END_USER_IMSI,
+			SubscriptionIdData: datatype.UTF8String(imsi),
+		},
+		MultipleServicesCreditControl: &charging_datatype.MultipleServicesCreditControl{
+			RatingGroup: datatype.Unsigned32(rg),
+			RequestedServiceUnit: &charging_datatype.RequestedServiceUnit{
+				CCTotalOctets: datatype.Unsigned64(requested),
+			},
+		},
+	}
+}
+
+func TestHandleCCR(t *testing.T) {
+	store := memStore{
+		chargingDatasColl: {
+			{"ueId": "imsi-208930000000001", "ratingGroup": 1, "quota": "1000", "unitCost": "1"},
+			{"ueId": "imsi-208930000000002", "ratingGroup": 1, "quota": "0", "unitCost": "1"},
+		},
+	}
+	useMemStore(t, store)
+	config := factory.ChfConfig
+	factory.ChfConfig = &factory.Config{Configuration: &factory.Configuration{}}
+	t.Cleanup(func() { factory.ChfConfig = config })
+
+	require.Equal(t, uint32(diam.Success), serveCCR(t, newTestCCR(t, initialCCR("208930000000001", 1, 400))))
+	require.Len(t, store[reservationsColl], 1)
+
+	require.Equal(t, uint32(charging_code.CreditLimitReached),
+		serveCCR(t, newTestCCR(t, initialCCR("208930000000002", 1, 400))))
+
+	require.Equal(t, uint32(charging_code.UserUnknown),
+		serveCCR(t, newTestCCR(t, initialCCR("208930000000003", 1, 400))))
+
+	ccr := initialCCR("208930000000001", 1, 400)
+	ccr.MultipleServicesCreditControl = nil
+	require.Equal(t, uint32(diam.MissingAVP), serveCCR(t, newTestCCR(t, ccr)))
+
+	m := newTestCCR(t, initialCCR("208930000000001", 1, 400))
+	m.Header.ApplicationID = unknownApplication
+	require.Equal(t, uint32(diam.UnableToComply), serveCCR(t, m))
+}
@@ -0,0 +1,553 @@
+package abmf
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/fiorix/go-diameter/diam/datatype"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	charging_datatype "github.com/free5gc/chf/ccs_diameter/datatype"
+	"github.com/free5gc/chf/internal/logger"
+	"github.com/free5gc/chf/pkg/factory"
+	"github.com/free5gc/util/mongoapi"
+)
+
+const accountsColl = "abmf.accounts"
+
+// legacyBalanceId is the AcctBalanceId reported for the quota stored
+// in policyData.ues.chargingData when the UE has no account document.
+const legacyBalanceId uint64 = 0
+
+// ErrUserUnknown is returned when the UE has neither an account nor charging data
+var ErrUserUnknown = errors.New("no account or charging data")
+
+type BalanceType string
+
+const (
+	// Money balances, consumed in currency units
+	BalanceTypeMain  BalanceType = "MAIN"
+	BalanceTypeBonus BalanceType = "BONUS"
+	// Data bucket, consumed in octets and converted with the unit cost of the rating group
+	BalanceTypeData BalanceType = "DATA"
+)
+
+type AccountType string
+
+const (
+	AccountTypePrepaid AccountType = "PREPAID"
+	// Postpaid accounts accumulate charges on their last monetary balance, down to minus the credit limit
+	AccountTypePostpaid AccountType = "POSTPAID"
+)
+
+// CreditLimitPolicy is what a postpaid account does once its credit limit is reached
+type CreditLimitPolicy string
+
+const (
+	CreditLimitPolicyAllowOverrun   CreditLimitPolicy = "ALLOW_OVERRUN"
+	CreditLimitPolicyRedirect       CreditLimitPolicy = "REDIRECT"
+	CreditLimitPolicyRestrictAccess CreditLimitPolicy = "RESTRICT_ACCESS"
+	CreditLimitPolicyTerminate      CreditLimitPolicy = "TERMINATE"
+)
+
+type Balance struct {
+	BalanceId uint64      `bson:"balanceId" json:"balanceId"`
+	Type      BalanceType `bson:"type" json:"type"`
+	Value     int64       `bson:"value" json:"value"`
+	// Part of the value held by reservations of ongoing sessions
+	Reserved int64 `bson:"reserved,omitempty" json:"reserved,omitempty"`
+	// Lower value is consumed first when no consumption rule is configured for the rating group
+	Priority int32 `bson:"priority" json:"priority"`
+	// Rating groups allowed to draw from this balance, empty means all
+	RatingGroups []uint32 `bson:"ratingGroups,omitempty" json:"ratingGroups,omitempty"`
+	// Validity period of the balance, a zero value means unbounded
+	ValidFrom  time.Time `bson:"validFrom,omitempty" json:"validFrom,omitempty"`
+	ValidUntil time.Time `bson:"validUntil,omitempty" json:"validUntil,omitempty"`
+	// Renewal starts a new validity period of the same length when the balance expires,
+	// without it the balance is removed on expiry
+	Renewal *Renewal `bson:"renewal,omitempty" json:"renewal,omitempty"`
+}
+
+// Renewal describes how a balance is refilled at the start of each validity period
+type Renewal struct {
+	Value int64 `bson:"value" json:"value"`
+	// Rollover carries the unused value over to the next period, up to RolloverMax (0 means no limit)
+	Rollover    bool  `bson:"rollover,omitempty" json:"rollover,omitempty"`
+	RolloverMax int64 `bson:"rolloverMax,omitempty" json:"rolloverMax,omitempty"`
+}
+
+// ConsumptionRule lists the balances a rating group may draw from, in consumption order
+type ConsumptionRule struct {
+	RatingGroup uint32   `bson:"ratingGroup" json:"ratingGroup"`
+	BalanceIds  []uint64 `bson:"balanceIds" json:"balanceIds"`
+}
+
+// Member is a subscriber drawing from a shared account
+type Member struct {
+	UeId string `bson:"ueId" json:"ueId"`
+	// Maximum amount, in currency units, the member may consume from the shared account, 0 means no limit
+	Cap      int64 `bson:"cap,omitempty" json:"cap,omitempty"`
+	Consumed int64 `bson:"consumed" json:"consumed"`
+	Reserved int64 `bson:"reserved,omitempty" json:"reserved,omitempty"`
+}
+
+// Account is either the account of a subscriber (identified by ueId) or an account
+// shared by several subscribers (identified by accountId and listing its members)
+type Account struct {
+	UeId      string            `bson:"ueId,omitempty" json:"ueId,omitempty"`
+	AccountId string            `bson:"accountId,omitempty" json:"accountId,omitempty"`
+	Balances  []*Balance        `bson:"balances" json:"balances"`
+	Rules     []ConsumptionRule `bson:"rules,omitempty" json:"rules,omitempty"`
+	Members   []*Member         `bson:"members,omitempty" json:"members,omitempty"`
+	// ISO 4217 alphabetic code of the monetary balances, empty accepts top-ups in any currency
+	Currency string `bson:"currency,omitempty" json:"currency,omitempty"`
+	// LowBalanceIndication is set in the CCA when the remaining value, in currency units,
+	// falls below this threshold, 0 disables it
+	LowBalanceThreshold int64 `bson:"lowBalanceThreshold,omitempty" json:"lowBalanceThreshold,omitempty"`
+
+	// Empty means prepaid
+	Type              AccountType       `bson:"type,omitempty" json:"type,omitempty"`
+	CreditLimit       int64             `bson:"creditLimit,omitempty" json:"creditLimit,omitempty"`
+	CreditLimitPolicy CreditLimitPolicy `bson:"creditLimitPolicy,omitempty" json:"creditLimitPolicy,omitempty"`
+	// Redirect-Server-Address (URL) of the REDIRECT policy
+	RedirectAddress string `bson:"redirectAddress,omitempty" json:"redirectAddress,omitempty"`
+	// Filter-Id of the RESTRICT_ACCESS policy
+	RestrictionFilterId string `bson:"restrictionFilterId,omitempty" json:"restrictionFilterId,omitempty"`
+
+	// legacy accounts are backed by the quota field of policyData.ues.chargingData
+	legacy            bool
+	legacyRatingGroup uint32
+}
+
+// balanceDebit records how much of a balance was taken or held by a session
+type balanceDebit struct {
+	AccountKey string `bson:"accountKey"`
+	BalanceId  uint64 `bson:"balanceId"`
+	// Amount in currency units
+	Amount int64 `bson:"amount"`
+	// ValidUntil of the balance when it was debited, the debit is void if the balance has expired since
+	ValidUntil time.Time `bson:"validUntil,omitempty"`
+}
+
+func (m *Member) available() int64 {
+	if m.Cap == 0 {
+		return math.MaxInt64
+	}
+	return max(m.Cap-m.Consumed-m.Reserved, 0)
+}
+
+func (a *Account) key() string {
+	if a.AccountId != "" {
+		return a.AccountId
+	}
+	return a.UeId
+}
+
+func (a *Account) isShared() bool {
+	return a.AccountId != ""
+}
+
+func (a *Account) isPostpaid() bool {
+	return a.Type == AccountTypePostpaid
+}
+
+func (a *Account) member(ueId string) *Member {
+	for _, m := range a.Members {
+		if m.UeId == ueId {
+			return m
+		}
+	}
+	return nil
+}
+
+func (b *Balance) isMonetary() bool {
+	return b.Type != BalanceTypeData
+}
+
+// available returns the part of the value not held by reservations, in the unit of the balance
+func (b *Balance) available() int64 {
+	return b.Value - b.Reserved
+}
+
+// units converts amount in currency units into the unit of the balance
+func (b *Balance) units(amount int64, unitCost int64) int64 {
+	if b.isMonetary() || unitCost <= 0 {
+		return amount
+	}
+	return amount / unitCost
+}
+
+func (b *Balance) isActive(now time.Time) bool {
+	if !b.ValidFrom.IsZero() && now.Before(b.ValidFrom) {
+		return false
+	}
+	return b.ValidUntil.IsZero() || now.Before(b.ValidUntil)
+}
+
+// expiresBefore orders expiring balances first, earliest expiry first
+func (b *Balance) expiresBefore(other *Balance) bool {
+	if b.ValidUntil.IsZero() {
+		return false
+	}
+	return other.ValidUntil.IsZero() || b.ValidUntil.Before(other.ValidUntil)
+}
+
+func (b *Balance) allowRatingGroup(rg uint32) bool {
+	if len(b.RatingGroups) == 0 {
+		return true
+	}
+	for _, allowed := range b.RatingGroups {
+		if allowed == rg {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *Account) findBalance(balanceId uint64) *Balance {
+	for _, b := range a.Balances {
+		if b.BalanceId == balanceId {
+			return b
+		}
+	}
+	return nil
+}
+
+// balancesForRatingGroup returns the balances currently valid the rating group may draw from, in consumption
+// order. Without a consumption rule, expiring balances come first, then the balances are sorted by priority.
+func (a *Account) balancesForRatingGroup(rg uint32) []*Balance {
+	var balances []*Balance
+	now := time.Now()
+
+	for _, rule := range a.Rules {
+		if rule.RatingGroup != rg {
+			continue
+		}
+		for _, balanceId := range rule.BalanceIds {
+			if b := a.findBalance(balanceId); b != nil {
+				if b.isActive(now) {
+					balances = append(balances, b)
+				}
+			} else {
+				logger.AcctLog.Warnf("UE[%s] rule of rating group %d refers to unknown balance %d",
+					a.UeId, rg, balanceId)
+			}
+		}
+		return balances
+	}
+
+	for _, b := range a.Balances {
+		if b.allowRatingGroup(rg) && b.isActive(now) {
+			balances = append(balances, b)
+		}
+	}
+	sort.SliceStable(balances, func(i, j int) bool {
+		if balances[i].expiresBefore(balances[j]) {
+			return true
+		}
+		if balances[j].expiresBefore(balances[i]) {
+			return false
+		}
+		return balances[i].Priority < balances[j].Priority
+	})
+	return balances
+}
+
+// debit takes up to amount (in currency units) from the balances of the rating group,
+// and returns the debits per balance
+func (a *Account) debit(rg uint32, amount int64, unitCost int64) []balanceDebit {
+	return a.take(rg, amount, unitCost, false)
+}
+
+// reserve holds up to amount (in currency units) of the balances of the rating group,
+// and returns the holds per balance
+func (a *Account) reserve(rg uint32, amount int64, unitCost int64) []balanceDebit {
+	return a.take(rg, amount, unitCost, true)
+}
+
+func (a *Account) take(rg uint32, amount int64, unitCost int64, hold bool) []balanceDebit {
+	var debits []balanceDebit
+
+	if unitCost <= 0 {
+		unitCost = 1
+	}
+
+	for _, b := range a.balancesForRatingGroup(rg) {
+		if amount <= 0 {
+			break
+		}
+		if b.available() <= 0 {
+			continue
+		}
+
+		units := min(b.units(amount, unitCost), b.available())
+		if units == 0 {
+			continue
+		}
+		taken := units
+		if !b.isMonetary() {
+			taken = units * unitCost
+		}
+		if hold {
+			b.Reserved += units
+		} else {
+			b.Value -= units
+		}
+
+		amount -= taken
+		debits = append(debits, balanceDebit{
+			AccountKey: a.key(),
+			BalanceId:  b.BalanceId,
+			Amount:     taken,
+			ValidUntil: b.ValidUntil,
+		})
+	}
+
+	if amount > 0 && a.isPostpaid() {
+		if debit := a.takeCredit(rg, amount, hold, false); debit != nil {
+			debits = append(debits, *debit)
+		}
+	}
+
+	return debits
+}
+
+// creditAvailable returns the part of the credit limit of a postpaid account not used yet, in currency units
+func (a *Account) creditAvailable(rg uint32) int64 {
+	b := a.lastMonetaryBalance(rg)
+	if !a.isPostpaid() || b == nil {
+		return 0
+	}
+	return max(a.CreditLimit+min(b.available(), 0), 0)
+}
+
+// takeCredit charges amount (in currency units) to the last monetary balance of a postpaid account
+// beyond its value, within the credit limit unless overrun is set
+func (a *Account) takeCredit(rg uint32, amount int64, hold bool, overrun bool) *balanceDebit {
+	b := a.lastMonetaryBalance(rg)
+	if b == nil {
+		return nil
+	}
+	if !overrun {
+		amount = min(amount, a.creditAvailable(rg))
+	}
+	if amount <= 0 {
+		return nil
+	}
+
+	if hold {
+		b.Reserved += amount
+	} else {
+		b.Value -= amount
+	}
+	return &balanceDebit{
+		AccountKey: a.key(),
+		BalanceId:  b.BalanceId,
+		Amount:     amount,
+		ValidUntil: b.ValidUntil,
+	}
+}
+
+// credit returns amount (in currency units) to the balance
+func (b *Balance) credit(amount int64, unitCost int64) {
+	b.Value += b.units(amount, unitCost)
+}
+
+// settle releases a hold of the balance and debits used (at most held), both in currency units
+func (b *Balance) settle(held, used int64, unitCost int64) {
+	b.Reserved = max(b.Reserved-b.units(held, unitCost), 0)
+	b.Value -= b.units(used, unitCost)
+}
+
+// firstMonetaryBalance returns the first monetary balance the rating group may draw from
+func (a *Account) firstMonetaryBalance(rg uint32) *Balance {
+	for _, b := range a.balancesForRatingGroup(rg) {
+		if b.isMonetary() {
+			return b
+		}
+	}
+	return nil
+}
+
+// lastMonetaryBalance returns the last monetary balance the rating group may draw from
+func (a *Account) lastMonetaryBalance(rg uint32) *Balance {
+	balances := a.balancesForRatingGroup(rg)
+	for i := len(balances) - 1; i >= 0; i-- {
+		if balances[i].isMonetary() {
+			return balances[i]
+		}
+	}
+	return nil
+}
+
+// remaining returns the total value, in currency units, available to the rating group
+// including the credit left to a postpaid account
+func (a *Account) remaining(rg uint32, unitCost int64) int64 {
+	total := a.creditAvailable(rg)
+	for _, b := range a.balancesForRatingGroup(rg) {
+		available := b.available()
+		if a.isPostpaid() {
+			// the charges beyond the value are already deducted from the credit available
+			available = max(available, 0)
+		}
+		if b.isMonetary() {
+			total += available
+		} else {
+			total += available * unitCost
+		}
+	}
+	return total
+}
+
+// loadAccount retrieves the account of the UE, it returns nil if there is none
+func loadAccount(ueId string) (*Account, error) {
+	return findAccount(bson.M{"ueId": ueId})
+}
+
+// loadSharedAccount retrieves the shared account identified by accountId
+func loadSharedAccount(accountId string) (*Account, error) {
+	return findAccount(bson.M{"accountId": accountId})
+}
+
+// findSharedAccountId returns the id of the shared account the UE is a member of, or "" if none
+func findSharedAccountId(ueId string) (string, error) {
+	account, err := findAccount(bson.M{"members.ueId": ueId})
+	if err != nil || account == nil {
+		return "", err
+	}
+	return account.AccountId, nil
+}
+
+func findAccount(filter bson.M) (*Account, error) {
+	accountInterface, err := getOne(accountsColl, filter)
+	if err != nil || accountInterface == nil {
+		return nil, err
+	}
+
+	var account Account
+	if err = decodeBson(accountInterface, &account); err != nil {
+		return nil, err
+	}
+	return &account, nil
+}
+
+// loadLegacyAccount builds an account from the quota of the rating group in policyData.ues.chargingData
+func loadLegacyAccount(ueId string, rg uint32) (*Account, error) {
+	filter := bson.M{"ueId": ueId, "ratingGroup": rg}
+	chargingInterface, err := getOne(chargingDatasColl, filter, 2)
+	if err != nil {
+		return nil, err
+	}
+	if chargingInterface == nil {
+		return nil, fmt.Errorf("%w for UE[%s] rating group %d", ErrUserUnknown, ueId, rg)
+	}
+
+	quotaStr, ok := chargingInterface["quota"].(string)
+	if !ok {
+		return nil, fmt.Errorf("quota of UE[%s] rating group %d is not a string", ueId, rg)
+	}
+	quota, err := strconv.ParseInt(quotaStr, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	var reserved int64
+	if reservedStr, ok := chargingInterface["reserved"].(string); ok {
+		if reserved, err = strconv.ParseInt(reservedStr, 10, 64); err != nil {
+			return nil, err
+		}
+	}
+
+	return &Account{
+		UeId: ueId,
+		Balances: []*Balance{
+			{
+				BalanceId: legacyBalanceId,
+				Type:      BalanceTypeMain,
+				Value:     quota,
+				Reserved:  reserved,
+			},
+		},
+		legacy:            true,
+		legacyRatingGroup: rg,
+	}, nil
+}
+
+func saveAccount(account *Account) error {
+	if account.legacy {
+		filter := bson.M{"ueId": account.UeId, "ratingGroup": account.legacyRatingGroup}
+		chargingBsonM := bson.M{
+			"quota":    strconv.FormatInt(account.Balances[0].Value, 10),
+			"reserved": strconv.FormatInt(account.Balances[0].Reserved, 10),
+		}
+		_, err := putOne(chargingDatasColl, filter, chargingBsonM)
+		return err
+	}
+
+	if account.isShared() {
+		_, err := putOne(accountsColl, bson.M{"accountId": account.AccountId},
+			bson.M{"balances": account.Balances, "members": account.Members})
+		return err
+	}
+
+	_, err := putOne(accountsColl, bson.M{"ueId": account.UeId},
+		bson.M{"balances": account.Balances})
+	return err
+}
+
+// getUnitCost retrieves the unit cost of the rating group as the rating function does
+func getUnitCost(ueId string, rg uint32) int64 {
+	filter := bson.M{"ueId": ueId, "ratingGroup": rg}
+	chargingInterface, err := getOne(chargingDatasColl, filter, 2)
+	if err != nil || chargingInterface == nil {
+		return 1
+	}
+	unitCostStr, ok := chargingInterface["unitCost"].(string)
+	if !ok {
+		return 1
+	}
+	unitCost, err := strconv.ParseInt(unitCostStr, 10, 64)
+	if err != nil || unitCost <= 0 {
+		return 1
+	}
+	return unitCost
+}
+
+// mongoapi operations of the package, an in-memory store replaces them in the handler tests
+var (
+	getOne    = mongoapi.RestfulAPIGetOne
+	getMany   = mongoapi.RestfulAPIGetMany
+	putOne    = mongoapi.RestfulAPIPutOne
+	postMany  = mongoapi.RestfulAPIPostMany
+	deleteOne = mongoapi.RestfulAPIDeleteOne
+)
+
+// collection returns the collection for the operations mongoapi does not provide
+func collection(name string) *mongo.Collection {
+	return mongoapi.Client.Database(factory.ChfConfig.Configuration.Mongodb.Name).Collection(name)
+}
+
+func decodeBson(in map[string]interface{}, out interface{}) error {
+	raw, err := bson.Marshal(in)
+	if err != nil {
+		return err
+	}
+	return bson.Unmarshal(raw, out)
+}
+
+// unitValue converts value into the value digits and exponent of RFC 4006 Unit-Value
+func unitValue(value int64) *charging_datatype.UnitValue {
+	var exp int32
+	for value != 0 && value%10 == 0 {
+		value /= 10
+		exp++
+	}
+	return &charging_datatype.UnitValue{
+		ValueDigits: datatype.Integer64(value),
+		Exponent:    datatype.Integer32(exp),
+	}
+}
@@ -0,0 +1,305 @@
+package abmf
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	charging_datatype "github.com/free5gc/chf/ccs_diameter/datatype"
+)
+
+const testUeId = "imsi-208930000000001"
+
+func newTestAccount() *Account {
+	return &Account{
+		UeId: testUeId,
+		Balances: []*Balance{
+			{BalanceId: 1, Type: BalanceTypeMain, Value: 1000, Priority: 3},
+			{BalanceId: 2, Type: BalanceTypeBonus, Value: 200, Priority: 2},
+			{BalanceId: 3, Type: BalanceTypeData, Value: 50, Priority: 1, RatingGroups: []uint32{1}},
+		},
+	}
+}
+
+func TestAccountDebitPriority(t *testing.T) {
+	t.Parallel()
+
+	account := newTestAccount()
+
+	// unit cost 2: the data bucket covers 100, the bonus 200, the main balance the rest
+	debits := account.debit(1, 500, 2)
+	require.Equal(t, []balanceDebit{
+		{AccountKey: testUeId, BalanceId: 3, Amount: 100},
+		{AccountKey: testUeId, BalanceId: 2, Amount: 200},
+		{AccountKey: testUeId, BalanceId: 1, Amount: 200},
+	}, debits)
+	require.Equal(t, int64(0), account.findBalance(3).Value)
+	require.Equal(t, int64(0), account.findBalance(2).Value)
+	require.Equal(t, int64(800), account.findBalance(1).Value)
+
+	// rating group 2 is not allowed to use the data bucket
+	account = newTestAccount()
+	debits = account.debit(2, 300, 2)
+	require.Equal(t, []balanceDebit{
+		{AccountKey: testUeId, BalanceId: 2, Amount: 200},
+		{AccountKey: testUeId, BalanceId: 1, Amount: 100},
+	}, debits)
+	require.Equal(t, int64(50), account.findBalance(3).Value)
+}
+
+func TestAccountConsumptionRule(t *testing.T) {
+	t.Parallel()
+
+	account := newTestAccount()
+	account.Rules = []ConsumptionRule{
+		{RatingGroup: 1, BalanceIds: []uint64{1}},
+	}
+
+	debits := account.debit(1, 1500, 1)
+	require.Equal(t, []balanceDebit{{AccountKey: testUeId, BalanceId: 1, Amount: 1000}}, debits)
+	require.Equal(t, int64(200), account.findBalance(2).Value)
+	require.Equal(t, int64(50), account.findBalance(3).Value)
+}
+
+func TestWalletReservation(t *testing.T) {
+	t.Parallel()
+
+	w := &wallet{ueId: testUeId, personal: newTestAccount()}
+	holds := w.reserve(1, 500, 2)
+	require.Equal(t, int64(500), sumDebits(holds))
+	require.Equal(t, int64(50), w.personal.findBalance(3).Value)
+	require.Equal(t, int64(50), w.personal.findBalance(3).Reserved)
+	require.Equal(t, int64(800), w.remaining(1, 2))
+
+	// the usage is committed in the order the balances were held, the rest is released
+	w.settle(1, holds, 250, 2)
+	for _, b := range w.personal.Balances {
+		require.Equal(t, int64(0), b.Reserved)
+	}
+	require.Equal(t, int64(1000), w.personal.findBalance(1).Value)
+	require.Equal(t, int64(50), w.personal.findBalance(2).Value)
+	require.Equal(t, int64(0), w.personal.findBalance(3).Value)
+
+	// usage beyond the holds is debited anyway
+	w.settle(1, nil, 100, 2)
+	require.Equal(t, int64(0), w.personal.findBalance(2).Value)
+	require.Equal(t, int64(950), w.personal.findBalance(1).Value)
+}
+
+func TestWalletSharedAccount(t *testing.T) {
+	t.Parallel()
+
+	shared := &Account{
+		AccountId: "family-1",
+		Balances: []*Balance{
+			{BalanceId: 10, Type: BalanceTypeMain, Value: 1000},
+		},
+		Members: []*Member{
+			{UeId: testUeId, Cap: 300},
+			{UeId: "imsi-208930000000002"},
+		},
+	}
+	personal := &Account{
+		UeId: testUeId,
+		Balances: []*Balance{
+			{BalanceId: 1, Type: BalanceTypeMain, Value: 100},
+		},
+	}
+
+	w := &wallet{ueId: testUeId, personal: personal, shared: shared}
+	require.Equal(t, int64(400), w.remaining(1, 1))
+
+	// own balance first, then the shared account up to the member's cap
+	holds := w.reserve(1, 500, 1)
+	require.Equal(t, []balanceDebit{
+		{AccountKey: testUeId, BalanceId: 1, Amount: 100},
+		{AccountKey: "family-1", BalanceId: 10, Amount: 300},
+	}, holds)
+	require.Equal(t, int64(300), shared.findBalance(10).Reserved)
+	require.Equal(t, int64(300), shared.member(testUeId).Reserved)
+	require.Equal(t, int64(0), w.remaining(1, 1))
+
+	w.settle(1, holds, 300, 1)
+	require.Equal(t, int64(800), shared.findBalance(10).Value)
+	require.Equal(t, int64(0), shared.findBalance(10).Reserved)
+	require.Equal(t, int64(200), shared.member(testUeId).Consumed)
+	require.Equal(t, int64(0), shared.member(testUeId).Reserved)
+	require.Equal(t, int64(0), personal.findBalance(1).Value)
+
+	// a member without cap may use the whole shared balance
+	w2 := &wallet{ueId: "imsi-208930000000002", shared: shared}
+	debits := w2.debit(1, 2000, 1)
+	require.Equal(t, int64(800), sumDebits(debits))
+	require.Equal(t, int64(800), shared.member("imsi-208930000000002").Consumed)
+}
+
+func TestAccountPostpaid(t *testing.T) {
+	t.Parallel()
+
+	account := &Account{
+		UeId:        testUeId,
+		Type:        AccountTypePostpaid,
+		CreditLimit: 500,
+		Balances: []*Balance{
+			{BalanceId: 1, Type: BalanceTypeMain, Value: 100},
+		},
+	}
+	require.Equal(t, int64(600), account.remaining(1, 1))
+
+	// charges accumulate below zero up to the credit limit
+	debits := account.debit(1, 400, 1)
+	require.Equal(t, int64(400), sumDebits(debits))
+	require.Equal(t, int64(-300), account.findBalance(1).Value)
+	require.Equal(t, int64(200), account.remaining(1, 1))
+
+	holds := account.reserve(1, 300, 1)
+	require.Equal(t, int64(200), sumDebits(holds))
+	require.Equal(t, int64(0), account.remaining(1, 1))
+}
+
+func TestWalletLimitReached(t *testing.T) {
+	t.Parallel()
+
+	newWallet := func(policy CreditLimitPolicy) *wallet {
+		return &wallet{ueId: testUeId, personal: &Account{
+			UeId:                testUeId,
+			Type:                AccountTypePostpaid,
+			CreditLimit:         100,
+			CreditLimitPolicy:   policy,
+			RedirectAddress:     "http://topup.example.com",
+			RestrictionFilterId: "restricted",
+			Balances: []*Balance{
+				{BalanceId: 1, Type: BalanceTypeMain},
+			},
+		}}
+	}
+
+	w := newWallet(CreditLimitPolicyAllowOverrun)
+	holds, fui := w.limitReached(1, 50)
+	require.Nil(t, fui)
+	require.Equal(t, int64(50), sumDebits(holds))
+	require.Equal(t, int64(50), w.personal.findBalance(1).Reserved)
+
+	_, fui = newWallet(CreditLimitPolicyRedirect).limitReached(1, 50)
+	require.Equal(t, charging_datatype.REDIRECT, fui.FinalUnitAction)
+	require.Equal(t, "http://topup.example.com", string(fui.RedirectServer.RedirectServerAddress))
+
+	_, fui = newWallet(CreditLimitPolicyRestrictAccess).limitReached(1, 50)
+	require.Equal(t, charging_datatype.RESTRICT_ACCESS, fui.FinalUnitAction)
+	require.Equal(t, "restricted", string(fui.FilterId))
+
+	_, fui = newWallet(CreditLimitPolicyTerminate).limitReached(1, 50)
+	require.Equal(t, charging_datatype.TERMINATE, fui.FinalUnitAction)
+
+	// prepaid accounts are always terminated
+	_, fui = (&wallet{ueId: testUeId, personal: newTestAccount()}).limitReached(1, 50)
+	require.Equal(t, charging_datatype.TERMINATE, fui.FinalUnitAction)
+}
+
+func TestWalletLowBalance(t *testing.T) {
+	t.Parallel()
+
+	w := &wallet{ueId: testUeId, personal: newTestAccount()}
+	require.False(t, w.isLowBalance(2, 1))
+
+	w.personal.LowBalanceThreshold = 500
+	require.False(t, w.isLowBalance(2, 1))
+	w.debit(2, 800, 1)
+	require.True(t, w.isLowBalance(2, 1))
+}
+
+func TestAccountExpiringFirst(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	account := newTestAccount()
+	account.Balances = append(account.Balances,
+		&Balance{BalanceId: 4, Type: BalanceTypeMain, Value: 100, Priority: 9, ValidUntil: now.Add(time.Hour)},
+		&Balance{BalanceId: 5, Type: BalanceTypeMain, Value: 100, Priority: 9, ValidUntil: now.Add(-time.Hour)},
+		&Balance{BalanceId: 6, Type: BalanceTypeMain, Value: 100, Priority: 9, ValidFrom: now.Add(time.Hour)},
+	)
+
+	// balance 4 expires soonest, 5 has expired and 6 is not valid yet
+	debits := account.debit(2, 300, 1)
+	require.Equal(t, []balanceDebit{
+		{AccountKey: testUeId, BalanceId: 4, Amount: 100, ValidUntil: now.Add(time.Hour)},
+		{AccountKey: testUeId, BalanceId: 2, Amount: 200},
+	}, debits)
+	require.Equal(t, int64(1000), account.remaining(2, 1))
+}
+
+func TestAccountExpire(t *testing.T) {
+	t.Parallel()
+
+	start := time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+	account := newTestAccount()
+	account.Balances = append(account.Balances,
+		&Balance{
+			BalanceId: 4, Type: BalanceTypeMain, Value: 80,
+			ValidFrom: start, ValidUntil: start.AddDate(0, 0, 30),
+			Renewal: &Renewal{Value: 100, Rollover: true, RolloverMax: 50},
+		},
+		&Balance{
+			BalanceId: 5, Type: BalanceTypeMain, Value: 80,
+			ValidFrom: start, ValidUntil: start.AddDate(0, 0, 30),
+		},
+	)
+
+	require.False(t, account.expire(start.AddDate(0, 0, 10)))
+	require.Len(t, account.Balances, 5)
+
+	// two periods missed: the rollover is capped to 50 each time
+	require.True(t, account.expire(start.AddDate(0, 0, 65)))
+	require.Len(t, account.Balances, 4)
+	require.Nil(t, account.findBalance(5))
+	renewed := account.findBalance(4)
+	require.Equal(t, int64(150), renewed.Value)
+	require.Equal(t, start.AddDate(0, 0, 60), renewed.ValidFrom)
+	require.Equal(t, start.AddDate(0, 0, 90), renewed.ValidUntil)
+}
+
+func TestWalletSettleExpired(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	personal := newTestAccount()
+	personal.Balances = append(personal.Balances,
+		&Balance{BalanceId: 4, Type: BalanceTypeMain, Value: 100, ValidUntil: now.Add(time.Hour)})
+
+	w := &wallet{ueId: testUeId, personal: personal}
+	holds := w.reserve(2, 250, 1)
+
+	// the balance is renewed before the session commits its usage: the hold on it is void
+	// and the usage it covered is charged to the balances valid now
+	renewed := personal.findBalance(4)
+	renewed.ValidUntil = now.Add(2 * time.Hour)
+	renewed.Reserved = 0
+	renewed.Value = 300
+	w.settle(2, holds, 250, 1)
+	require.Equal(t, int64(200), renewed.Value)
+	require.Equal(t, int64(50), personal.findBalance(2).Value)
+	require.Equal(t, int64(0), personal.findBalance(2).Reserved)
+	require.Equal(t, int64(1000), personal.findBalance(1).Value)
+}
+
+func TestUnitValue(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		value    int64
+		digits   int64
+		exponent int32
+	}{
+		{0, 0, 0},
+		{12345, 12345, 0},
+		{12000, 12, 3},
+		{-500, -5, 2},
+	}
+
+	for _, tc := range testCases {
+		uv := unitValue(tc.value)
+		require.Equal(t, tc.digits, int64(uv.ValueDigits))
+		require.Equal(t, tc.exponent, int32(uv.Exponent))
+	}
+}
@@ -0,0 +1,152 @@
+package abmf
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+
+	"github.com/free5gc/chf/internal/logger"
+)
+
+// expire processes the balances whose validity period has ended before now. A balance with
+// a renewal starts its next period (rolling over the unused value if allowed), any other
+// balance is removed. It returns whether the account has changed.
+func (a *Account) expire(now time.Time) bool {
+	changed := false
+	balances := a.Balances[:0]
+
+	for _, b := range a.Balances {
+		if b.ValidUntil.IsZero() || now.Before(b.ValidUntil) {
+			balances = append(balances, b)
+			continue
+		}
+		changed = true
+
+		period := b.ValidUntil.Sub(b.ValidFrom)
+		if b.Renewal == nil || b.ValidFrom.IsZero() || period <= 0 {
+			logger.AcctLog.Infof("Account [%s] balance [%d] expired at %s with %d left",
+				a.key(), b.BalanceId, b.ValidUntil.Format(time.RFC3339), b.Value)
+			continue
+		}
+
+		// catch up on the periods missed while the ABMF was down
+		for !now.Before(b.ValidUntil) {
+			var rollover int64
+			if b.Renewal.Rollover && b.available() > 0 {
+				rollover = b.available()
+				if b.Renewal.RolloverMax > 0 {
+					rollover = min(rollover, b.Renewal.RolloverMax)
+				}
+			}
+			b.ValidFrom = b.ValidUntil
+			b.ValidUntil = b.ValidUntil.Add(period)
+			b.Value = b.Renewal.Value + rollover
+			// the holds on the previous period are void, see wallet.settle
+			b.Reserved = 0
+		}
+		logger.AcctLog.Infof("Account [%s] balance [%d] renewed until %s with %d",
+			a.key(), b.BalanceId, b.ValidUntil.Format(time.RFC3339), b.Value)
+		balances = append(balances, b)
+	}
+
+	a.Balances = balances
+	return changed
+}
+
+// runExpiryScheduler periodically expires and renews the balances of all accounts, releases
+// the expired reservations and expires the vouchers, until ctx is done
+func runExpiryScheduler(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			expireAccounts(now)
+			releaseExpiredReservations(now)
+			expireVouchers(now)
+		}
+	}
+}
+
+func expireAccounts(now time.Time) {
+	filter := bson.M{"balances.validUntil": bson.M{"$lte": now}}
+	accountsInterface, err := getMany(accountsColl, filter)
+	if err != nil {
+		logger.AcctLog.Errorf("Get expired accounts err: %+v", err)
+		return
+	}
+
+	for _, accountInterface := range accountsInterface {
+		var account Account
+		if err = decodeBson(accountInterface, &account); err != nil {
+			logger.AcctLog.Errorf("Decode account err: %+v", err)
+			continue
+		}
+		if err = expireAccount(account.key(), account.isShared(), now); err != nil {
+			logger.AcctLog.Errorf("Expire account [%s] err: %+v", account.key(), err)
+		}
+	}
+}
+
+// expireAccount reloads the account under its lock, so it does not race with the CCRs of its subscribers
+func expireAccount(key string, shared bool, now time.Time) error {
+	unlock := lockAccount(key)
+	defer unlock()
+
+	var account *Account
+	var err error
+	if shared {
+		account, err = loadSharedAccount(key)
+	} else {
+		account, err = loadAccount(key)
+	}
+	if err != nil || account == nil {
+		return err
+	}
+
+	before := account.values()
+	ref := ledgerRef{BalanceUnitCosts: account.dataUnitCosts(getUnitCost)}
+	if !account.expire(now) {
+		return nil
+	}
+	if err = saveAccount(account); err != nil {
+		return err
+	}
+
+	// a renewed balance is still in the account, an expired one has been removed
+	var entries []*LedgerEntry
+	for _, entry := range account.ledgerEntries(before, ref, now) {
+		entry.Operation = LedgerOperationExpiry
+		if account.findBalance(entry.BalanceId) != nil {
+			entry.Operation = LedgerOperationRenewal
+		}
+		entries = append(entries, entry)
+	}
+	return insertLedgerEntries(entries)
+}
+
+// dataUnitCosts retrieves with unitCost the unit cost of the first rating group of each data bucket, to value
+// its renewal or expiry in currency units. A shared account is valued with the charging data of its first member.
+func (a *Account) dataUnitCosts(unitCost func(ueId string, rg uint32) int64) map[uint64]int64 {
+	ueId := a.UeId
+	if ueId == "" && len(a.Members) > 0 {
+		ueId = a.Members[0].UeId
+	}
+
+	unitCosts := make(map[uint64]int64)
+	for _, b := range a.Balances {
+		if b.isMonetary() {
+			continue
+		}
+		var rg uint32
+		if len(b.RatingGroups) > 0 {
+			rg = b.RatingGroups[0]
+		}
+		unitCosts[b.BalanceId] = unitCost(ueId, rg)
+	}
+	return unitCosts
+}
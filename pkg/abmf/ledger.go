@@ -0,0 +1,283 @@
+package abmf
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/free5gc/chf/internal/logger"
+)
+
+const ledgerColl = "abmf.ledger"
+
+type LedgerOperation string
+
+const (
+	LedgerOperationReservation LedgerOperation = "RESERVATION"
+	LedgerOperationDebit       LedgerOperation = "DEBIT"
+	LedgerOperationRefund      LedgerOperation = "REFUND"
+	LedgerOperationRelease     LedgerOperation = "RELEASE"
+	LedgerOperationTopUp       LedgerOperation = "TOP_UP"
+	LedgerOperationExpiry      LedgerOperation = "EXPIRY"
+	LedgerOperationRenewal     LedgerOperation = "RENEWAL"
+)
+
+// LedgerEntry records one change of a balance. Entries are only ever inserted, never updated. The entry
+// of a top-up whose balance could not be credited is the only one ever deleted.
+type LedgerEntry struct {
+	// Subscriber the change was made for, empty for the expiry of a shared account
+	UeId            string          `bson:"ueId,omitempty" json:"ueId,omitempty"`
+	AccountKey      string          `bson:"accountKey" json:"accountKey"`
+	BalanceId       uint64          `bson:"balanceId" json:"balanceId"`
+	Operation       LedgerOperation `bson:"operation" json:"operation"`
+	SessionId       string          `bson:"sessionId,omitempty" json:"sessionId,omitempty"`
+	CcRequestNumber uint32          `bson:"ccRequestNumber" json:"ccRequestNumber"`
+	RatingGroup     uint32          `bson:"ratingGroup" json:"ratingGroup"`
+	// Key of the top-up request, the entry of a top-up is also its idempotency record
+	IdempotencyKey string `bson:"idempotencyKey,omitempty" json:"idempotencyKey,omitempty"`
+	// Amount in currency units, negative when taken from the balance
+	Amount int64 `bson:"amount" json:"amount"`
+	// Change of the part of the balance held by reservations, in currency units
+	Held int64 `bson:"held,omitempty" json:"held,omitempty"`
+	// Value of the balance in its own unit (octets for data buckets)
+	ValueBefore int64     `bson:"valueBefore" json:"valueBefore"`
+	ValueAfter  int64     `bson:"valueAfter" json:"valueAfter"`
+	Timestamp   time.Time `bson:"timestamp" json:"timestamp"`
+}
+
+// ledgerRef is what the entries of one operation have in common
+type ledgerRef struct {
+	Operation       LedgerOperation
+	UeId            string
+	SessionId       string
+	CcRequestNumber uint32
+	RatingGroup     uint32
+	UnitCost        int64
+	// Unit cost of data buckets overriding UnitCost, for operations not tied to a rating group such as expiry
+	BalanceUnitCosts map[uint64]int64
+	IdempotencyKey   string
+}
+
+// ReconciliationMismatch reports a balance whose value can not be explained by its ledger
+type ReconciliationMismatch struct {
+	AccountKey string `json:"accountKey"`
+	BalanceId  uint64 `json:"balanceId"`
+	// Value obtained by replaying the ledger, and value stored in the account
+	Expected int64  `json:"expected"`
+	Actual   int64  `json:"actual"`
+	Reason   string `json:"reason"`
+}
+
+type balanceValue struct {
+	Value    int64
+	Reserved int64
+	// Unit of the balance, kept to value the entry of a balance removed since
+	Monetary bool
+}
+
+func (b *Balance) value() balanceValue {
+	return balanceValue{Value: b.Value, Reserved: b.Reserved, Monetary: b.isMonetary()}
+}
+
+// values returns the value and reserved part of each balance of the account
+func (a *Account) values() map[uint64]balanceValue {
+	values := make(map[uint64]balanceValue, len(a.Balances))
+	for _, b := range a.Balances {
+		values[b.BalanceId] = b.value()
+	}
+	return values
+}
+
+// ledgerEntries returns an entry for each balance whose value or reserved part differs
+// from before, a balance missing on either side counts as 0
+func (a *Account) ledgerEntries(before map[uint64]balanceValue, ref ledgerRef, now time.Time) []*LedgerEntry {
+	var entries []*LedgerEntry
+
+	newEntry := func(balanceId uint64, valueBefore, valueAfter balanceValue, monetary bool) {
+		amount := valueAfter.Value - valueBefore.Value
+		held := valueAfter.Reserved - valueBefore.Reserved
+		if !monetary {
+			unitCost := ref.UnitCost
+			if balanceUnitCost, ok := ref.BalanceUnitCosts[balanceId]; ok {
+				unitCost = balanceUnitCost
+			}
+			amount *= max(unitCost, 1)
+			held *= max(unitCost, 1)
+		}
+		ueId := ref.UeId
+		if ueId == "" {
+			ueId = a.UeId
+		}
+		entries = append(entries, &LedgerEntry{
+			UeId:            ueId,
+			AccountKey:      a.key(),
+			BalanceId:       balanceId,
+			Operation:       ref.Operation,
+			SessionId:       ref.SessionId,
+			CcRequestNumber: ref.CcRequestNumber,
+			RatingGroup:     ref.RatingGroup,
+			IdempotencyKey:  ref.IdempotencyKey,
+			Amount:          amount,
+			Held:            held,
+			ValueBefore:     valueBefore.Value,
+			ValueAfter:      valueAfter.Value,
+			Timestamp:       now,
+		})
+	}
+
+	for _, b := range a.Balances {
+		valueAfter := b.value()
+		if valueBefore := before[b.BalanceId]; valueBefore != valueAfter {
+			newEntry(b.BalanceId, valueBefore, valueAfter, b.isMonetary())
+		}
+	}
+	for balanceId, valueBefore := range before {
+		if a.findBalance(balanceId) == nil && (valueBefore.Value != 0 || valueBefore.Reserved != 0) {
+			newEntry(balanceId, valueBefore, balanceValue{Monetary: valueBefore.Monetary}, valueBefore.Monetary)
+		}
+	}
+
+	return entries
+}
+
+func insertLedgerEntries(entries []*LedgerEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+
+	docs := make([]interface{}, 0, len(entries))
+	for _, entry := range entries {
+		docs = append(docs, entry)
+	}
+	return postMany(ledgerColl, nil, docs)
+}
+
+// createLedgerIndexes creates the unique index on the account and idempotency key, which makes the entry of a
+// top-up its idempotency record even across CHF instances
+func createLedgerIndexes() error {
+	_, err := collection(ledgerColl).Indexes().CreateOne(context.TODO(), mongo.IndexModel{
+		Keys: bson.D{{Key: "accountKey", Value: 1}, {Key: "idempotencyKey", Value: 1}},
+		Options: options.Index().SetUnique(true).
+			SetPartialFilterExpression(bson.M{"idempotencyKey": bson.M{"$exists": true}}),
+	})
+	return err
+}
+
+func findLedgerEntries(filter bson.M) ([]*LedgerEntry, error) {
+	entriesInterface, err := getMany(ledgerColl, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]*LedgerEntry, 0, len(entriesInterface))
+	for _, entryInterface := range entriesInterface {
+		var entry LedgerEntry
+		if err = decodeBson(entryInterface, &entry); err != nil {
+			return nil, err
+		}
+		entries = append(entries, &entry)
+	}
+
+	// entries of the same millisecond keep their insertion order
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Timestamp.Before(entries[j].Timestamp)
+	})
+	return entries, nil
+}
+
+// QueryLedger returns the ledger entries of the subscriber, including the ones of the shared
+// account it draws from, between from and to. A zero time leaves that end of the range open.
+func QueryLedger(supi string, from, to time.Time) ([]*LedgerEntry, error) {
+	filter := bson.M{"$or": []bson.M{{"ueId": supi}, {"accountKey": supi}}}
+
+	timestamp := bson.M{}
+	if !from.IsZero() {
+		timestamp["$gte"] = from
+	}
+	if !to.IsZero() {
+		timestamp["$lt"] = to
+	}
+	if len(timestamp) > 0 {
+		filter["timestamp"] = timestamp
+	}
+
+	return findLedgerEntries(filter)
+}
+
+// Reconcile replays the ledger of the account identified by accountKey (or of every
+// account if empty) and reports the balances which do not match their ledger
+func Reconcile(accountKey string) ([]*ReconciliationMismatch, error) {
+	filter := bson.M{}
+	if accountKey != "" {
+		filter = bson.M{"$or": []bson.M{{"ueId": accountKey}, {"accountId": accountKey}}}
+	}
+
+	accountsInterface, err := getMany(accountsColl, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	mismatches := []*ReconciliationMismatch{}
+	for _, accountInterface := range accountsInterface {
+		var account Account
+		if err = decodeBson(accountInterface, &account); err != nil {
+			return nil, err
+		}
+
+		entries, err := findLedgerEntries(bson.M{"accountKey": account.key()})
+		if err != nil {
+			return nil, err
+		}
+		mismatches = append(mismatches, account.reconcile(entries)...)
+	}
+
+	logger.AcctLog.Infof("Reconciled %d accounts, %d mismatches", len(accountsInterface), len(mismatches))
+	return mismatches, nil
+}
+
+// reconcile replays the entries, sorted by time, of each balance from the value before the first one
+func (a *Account) reconcile(entries []*LedgerEntry) []*ReconciliationMismatch {
+	var mismatches []*ReconciliationMismatch
+	replayed := make(map[uint64]int64)
+
+	for _, entry := range entries {
+		value, ok := replayed[entry.BalanceId]
+		if ok && value != entry.ValueBefore {
+			mismatches = append(mismatches, &ReconciliationMismatch{
+				AccountKey: a.key(),
+				BalanceId:  entry.BalanceId,
+				Expected:   value,
+				Actual:     entry.ValueBefore,
+				Reason: fmt.Sprintf("balance changed outside of the ledger before %s",
+					entry.Timestamp.Format(time.RFC3339)),
+			})
+		}
+		replayed[entry.BalanceId] = entry.ValueAfter
+	}
+
+	for balanceId, value := range replayed {
+		var actual int64
+		if b := a.findBalance(balanceId); b != nil {
+			actual = b.Value
+		}
+		if actual != value {
+			mismatches = append(mismatches, &ReconciliationMismatch{
+				AccountKey: a.key(),
+				BalanceId:  balanceId,
+				Expected:   value,
+				Actual:     actual,
+				Reason:     "balance differs from the last ledger entry",
+			})
+		}
+	}
+
+	sort.SliceStable(mismatches, func(i, j int) bool {
+		return mismatches[i].BalanceId < mismatches[j].BalanceId
+	})
+	return mismatches
+}
@@ -0,0 +1,128 @@
+package abmf
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWalletLedgerEntries(t *testing.T) {
+	t.Parallel()
+
+	w := &wallet{ueId: testUeId, personal: newTestAccount()}
+	w.snapshot()
+	w.debit(1, 300, 2)
+
+	w.record(ledgerRef{
+		Operation:       LedgerOperationDebit,
+		UeId:            testUeId,
+		SessionId:       "1",
+		CcRequestNumber: 2,
+		RatingGroup:     1,
+		UnitCost:        2,
+	})
+	entries := w.entries
+	require.Len(t, entries, 2)
+
+	// the data bucket is debited in octets, the amount is in currency units
+	data, bonus := entries[0], entries[1]
+	if data.BalanceId != 3 {
+		data, bonus = bonus, data
+	}
+	require.Equal(t, int64(-100), data.Amount)
+	require.Equal(t, int64(50), data.ValueBefore)
+	require.Equal(t, int64(0), data.ValueAfter)
+	require.Equal(t, int64(-200), bonus.Amount)
+	require.Equal(t, int64(0), bonus.ValueAfter)
+	require.Equal(t, LedgerOperationDebit, bonus.Operation)
+	require.Equal(t, "1", bonus.SessionId)
+	require.Equal(t, uint32(2), bonus.CcRequestNumber)
+
+	// a reservation holds part of the balance without changing its value
+	w.reserve(1, 100, 2)
+	w.record(ledgerRef{Operation: LedgerOperationReservation, UeId: testUeId, RatingGroup: 1, UnitCost: 2})
+	require.Len(t, w.entries, 3)
+	held := w.entries[2]
+	require.Equal(t, uint64(1), held.BalanceId)
+	require.Equal(t, int64(0), held.Amount)
+	require.Equal(t, int64(100), held.Held)
+	require.Equal(t, held.ValueBefore, held.ValueAfter)
+}
+
+func TestAccountReconcile(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	account := newTestAccount()
+	entries := []*LedgerEntry{
+		{BalanceId: 1, ValueBefore: 1500, ValueAfter: 1200, Timestamp: now},
+		{BalanceId: 1, ValueBefore: 1200, ValueAfter: 1000, Timestamp: now.Add(time.Second)},
+		{BalanceId: 2, ValueBefore: 300, ValueAfter: 250, Timestamp: now},
+	}
+	require.Empty(t, account.reconcile(entries[:2]))
+
+	// balance 2 was set to 200 outside of the ledger
+	mismatches := account.reconcile(entries)
+	require.Len(t, mismatches, 1)
+	require.Equal(t, uint64(2), mismatches[0].BalanceId)
+	require.Equal(t, int64(250), mismatches[0].Expected)
+	require.Equal(t, int64(200), mismatches[0].Actual)
+
+	// a gap in the chain of balance 1
+	entries[1].ValueBefore = 1100
+	mismatches = account.reconcile(entries[:2])
+	require.Len(t, mismatches, 1)
+	require.Equal(t, int64(1200), mismatches[0].Expected)
+	require.Equal(t, int64(1100), mismatches[0].Actual)
+}
+
+func TestAccountLedgerEntriesRemoved(t *testing.T) {
+	t.Parallel()
+
+	account := newTestAccount()
+	before := account.values()
+	account.Balances = account.Balances[:1]
+
+	// the removed data bucket is valued with its own unit cost, the bonus is already in currency units
+	entries := account.ledgerEntries(before, ledgerRef{BalanceUnitCosts: map[uint64]int64{3: 4}}, time.Now())
+	require.Len(t, entries, 2)
+	data, bonus := entries[0], entries[1]
+	if data.BalanceId != 3 {
+		data, bonus = bonus, data
+	}
+	require.Equal(t, int64(-200), data.Amount)
+	require.Equal(t, int64(50), data.ValueBefore)
+	require.Equal(t, int64(-200), bonus.Amount)
+	require.Equal(t, int64(200), bonus.ValueBefore)
+}
+
+func TestSharedAccountExpiryLedgerEntries(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	shared := &Account{
+		AccountId: "family",
+		Balances: []*Balance{
+			{BalanceId: 10, Type: BalanceTypeMain, Value: 1000},
+			{BalanceId: 11, Type: BalanceTypeData, Value: 50, RatingGroups: []uint32{2}, ValidUntil: now},
+		},
+		Members: []*Member{{UeId: testUeId}, {UeId: "imsi-208930000000002"}},
+	}
+
+	// the shared account has no subscriber of its own, its data buckets are valued for its first member
+	unitCosts := shared.dataUnitCosts(func(ueId string, rg uint32) int64 {
+		require.Equal(t, testUeId, ueId)
+		require.Equal(t, uint32(2), rg)
+		return 3
+	})
+	require.Equal(t, map[uint64]int64{11: 3}, unitCosts)
+
+	before := shared.values()
+	require.True(t, shared.expire(now))
+	entries := shared.ledgerEntries(before, ledgerRef{BalanceUnitCosts: unitCosts}, now)
+	require.Len(t, entries, 1)
+	require.Equal(t, uint64(11), entries[0].BalanceId)
+	require.Equal(t, int64(-150), entries[0].Amount)
+	require.Equal(t, "family", entries[0].AccountKey)
+}
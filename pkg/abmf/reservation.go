@@ -0,0 +1,120 @@
+package abmf
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+
+	"github.com/free5gc/chf/internal/logger"
+)
+
+const reservationsColl = "abmf.reservations"
+
+// Reservation holds part of the balances of a UE for a session and rating group, until the
+// usage is committed by the next CCR of the session or the reservation expires
+type Reservation struct {
+	SessionId   string         `bson:"sessionId"`
+	RatingGroup uint32         `bson:"ratingGroup"`
+	UeId        string         `bson:"ueId"`
+	UnitCost    int64          `bson:"unitCost"`
+	Holds       []balanceDebit `bson:"holds"`
+	ExpiresAt   time.Time      `bson:"expiresAt"`
+}
+
+func (r *Reservation) amount() int64 {
+	return sumDebits(r.Holds)
+}
+
+func reservationFilter(sessionId string, rg uint32) bson.M {
+	return bson.M{"sessionId": sessionId, "ratingGroup": rg}
+}
+
+// loadReservation retrieves the reservation of the session for the rating group, it returns nil if there is none
+func loadReservation(sessionId string, rg uint32) (*Reservation, error) {
+	reservationInterface, err := getOne(reservationsColl, reservationFilter(sessionId, rg))
+	if err != nil || reservationInterface == nil {
+		return nil, err
+	}
+
+	var reservation Reservation
+	if err = decodeBson(reservationInterface, &reservation); err != nil {
+		return nil, err
+	}
+	return &reservation, nil
+}
+
+func saveReservation(r *Reservation) error {
+	_, err := putOne(reservationsColl, reservationFilter(r.SessionId, r.RatingGroup), bson.M{
+		"ueId":      r.UeId,
+		"unitCost":  r.UnitCost,
+		"holds":     r.Holds,
+		"expiresAt": r.ExpiresAt,
+	})
+	return err
+}
+
+func deleteReservation(r *Reservation) error {
+	return deleteOne(reservationsColl, reservationFilter(r.SessionId, r.RatingGroup))
+}
+
+// storeReservation replaces the stored reservation of the session, previous, by next. A nil next deletes it.
+func storeReservation(previous, next *Reservation) error {
+	if next != nil {
+		return saveReservation(next)
+	}
+	if previous != nil {
+		return deleteReservation(previous)
+	}
+	return nil
+}
+
+// releaseExpiredReservations returns the holds of the reservations not committed in time to their balances
+func releaseExpiredReservations(now time.Time) {
+	reservationsInterface, err := getMany(reservationsColl,
+		bson.M{"expiresAt": bson.M{"$lte": now}})
+	if err != nil {
+		logger.AcctLog.Errorf("Get expired reservations err: %+v", err)
+		return
+	}
+
+	for _, reservationInterface := range reservationsInterface {
+		var reservation Reservation
+		if err = decodeBson(reservationInterface, &reservation); err != nil {
+			logger.AcctLog.Errorf("Decode reservation err: %+v", err)
+			continue
+		}
+		if err = releaseReservation(&reservation, now); err != nil {
+			logger.AcctLog.Errorf("Release reservation of UE[%s] session [%s] err: %+v",
+				reservation.UeId, reservation.SessionId, err)
+		}
+	}
+}
+
+func releaseReservation(expired *Reservation, now time.Time) error {
+	w, err := openWallet(expired.UeId, expired.RatingGroup)
+	if err != nil {
+		return err
+	}
+	defer w.close()
+
+	// the session may have committed it while the wallet was being opened
+	reservation, err := loadReservation(expired.SessionId, expired.RatingGroup)
+	if err != nil || reservation == nil || now.Before(reservation.ExpiresAt) {
+		return err
+	}
+
+	logger.AcctLog.Infof("UE[%s] reservation of session [%s] rating group %d expired, %d released",
+		reservation.UeId, reservation.SessionId, reservation.RatingGroup, reservation.amount())
+	w.settle(reservation.RatingGroup, reservation.Holds, 0, reservation.UnitCost)
+	w.record(ledgerRef{
+		Operation:   LedgerOperationRelease,
+		UeId:        reservation.UeId,
+		SessionId:   reservation.SessionId,
+		RatingGroup: reservation.RatingGroup,
+		UnitCost:    reservation.UnitCost,
+	})
+	if err = w.commit(); err != nil {
+		return err
+	}
+	return deleteReservation(reservation)
+}
@@ -0,0 +1,201 @@
+package abmf
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+
+	"github.com/free5gc/chf/internal/logger"
+)
+
+var (
+	ErrInvalidTopUp        = errors.New("invalid top-up")
+	ErrBalanceNotFound     = errors.New("no monetary balance to top up")
+	ErrCurrencyMismatch    = errors.New("currency does not match the account")
+	ErrIdempotencyConflict = errors.New("idempotency key already used by another top-up")
+)
+
+var currencyCode = regexp.MustCompile(`^[A-Z]{3}$`)
+
+// TopUp credits a monetary balance of a subscriber
+type TopUp struct {
+	Supi string `json:"supi"`
+	// Amount in currency units
+	Amount int64 `json:"amount"`
+	// ISO 4217 alphabetic code
+	Currency string `json:"currency"`
+	// Balance to credit, if absent the first monetary balance the rating group draws from
+	BalanceId   *uint64 `json:"balanceId,omitempty"`
+	RatingGroup uint32  `json:"ratingGroup,omitempty"`
+	// A top-up repeated with the same key is only applied once
+	IdempotencyKey string `json:"idempotencyKey"`
+	// Voucher redeemed by the top-up, which then takes the amount, currency and
+	// idempotency key from the voucher
+	VoucherCode string `json:"voucherCode,omitempty"`
+}
+
+type TopUpResult struct {
+	IdempotencyKey string `json:"idempotencyKey"`
+	Supi           string `json:"supi"`
+	AccountKey     string `json:"accountKey"`
+	BalanceId      uint64 `json:"balanceId"`
+	Amount         int64  `json:"amount"`
+	Currency       string `json:"currency"`
+	// Value of the balance after the top-up
+	Balance     int64     `json:"balance"`
+	Timestamp   time.Time `json:"timestamp"`
+	VoucherCode string    `json:"voucherCode,omitempty"`
+	// The top-up had already been applied by an earlier request with the same key
+	Replayed bool `json:"replayed"`
+}
+
+func (t *TopUp) validate() error {
+	switch {
+	case t.Supi == "":
+		return fmt.Errorf("%w: missing supi", ErrInvalidTopUp)
+	case t.Amount <= 0:
+		return fmt.Errorf("%w: amount %d is not positive", ErrInvalidTopUp, t.Amount)
+	case !currencyCode.MatchString(t.Currency):
+		return fmt.Errorf("%w: currency [%s] is not an ISO 4217 code", ErrInvalidTopUp, t.Currency)
+	case t.IdempotencyKey == "":
+		return fmt.Errorf("%w: missing idempotency key", ErrInvalidTopUp)
+	}
+	return nil
+}
+
+// target returns the balance credited by the top-up and its account, the UE's own account first
+func (w *wallet) target(t *TopUp) (*Account, *Balance) {
+	for _, account := range w.accounts() {
+		var b *Balance
+		if t.BalanceId != nil {
+			b = account.findBalance(*t.BalanceId)
+		} else {
+			b = account.firstMonetaryBalance(t.RatingGroup)
+		}
+		if b != nil && b.isMonetary() {
+			return account, b
+		}
+	}
+	return nil, nil
+}
+
+// TopUpAccount credits the balance targeted by the top-up and records it in the ledger. A top-up
+// whose key has already been applied is not applied again, its earlier result is returned instead.
+func TopUpAccount(t *TopUp) (*TopUpResult, error) {
+	if t.VoucherCode != "" {
+		return redeemVoucher(t)
+	}
+	return topUpAccount(t)
+}
+
+func topUpAccount(t *TopUp) (*TopUpResult, error) {
+	if err := t.validate(); err != nil {
+		return nil, err
+	}
+
+	w, err := openWallet(t.Supi, t.RatingGroup)
+	if err != nil {
+		return nil, err
+	}
+	defer w.close()
+
+	// looked up under the account lock, so a retry can not race with the original request
+	entries, err := findLedgerEntries(bson.M{"operation": LedgerOperationTopUp, "idempotencyKey": t.IdempotencyKey})
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) > 0 {
+		return t.replay(entries[0])
+	}
+
+	account, b := w.target(t)
+	if b == nil {
+		return nil, fmt.Errorf("%w: UE[%s]", ErrBalanceNotFound, t.Supi)
+	}
+	if account.Currency != "" && account.Currency != t.Currency {
+		return nil, fmt.Errorf("%w: account [%s] is in %s", ErrCurrencyMismatch, account.key(), account.Currency)
+	}
+
+	b.credit(t.Amount, 1)
+	w.record(ledgerRef{
+		Operation:      LedgerOperationTopUp,
+		UeId:           t.Supi,
+		RatingGroup:    t.RatingGroup,
+		IdempotencyKey: t.IdempotencyKey,
+	})
+	entry := w.entries[len(w.entries)-1]
+	w.entries = nil
+
+	// the entry is the idempotency record: it is inserted before the balance is credited, and the
+	// unique index refuses it when another CHF instance has already applied the top-up
+	if _, err = collection(ledgerColl).InsertOne(context.TODO(), entry); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return t.replayRecorded(account.key())
+		}
+		return nil, err
+	}
+	if err = w.save(); err != nil {
+		if errDelete := deleteOne(ledgerColl, idempotencyFilter(account.key(),
+			t.IdempotencyKey)); errDelete != nil {
+			logger.AcctLog.Errorf("Delete top-up [%s] of UE[%s] err: %+v", t.IdempotencyKey, t.Supi, errDelete)
+		}
+		return nil, err
+	}
+
+	logger.AcctLog.Infof("UE[%s] topped up balance [%d] of account [%s] with %d %s",
+		t.Supi, b.BalanceId, account.key(), t.Amount, t.Currency)
+	return &TopUpResult{
+		IdempotencyKey: t.IdempotencyKey,
+		Supi:           t.Supi,
+		AccountKey:     account.key(),
+		BalanceId:      b.BalanceId,
+		Amount:         t.Amount,
+		Currency:       t.Currency,
+		Balance:        b.Value,
+		Timestamp:      entry.Timestamp,
+		VoucherCode:    t.VoucherCode,
+	}, nil
+}
+
+func idempotencyFilter(accountKey, idempotencyKey string) bson.M {
+	return bson.M{"accountKey": accountKey, "idempotencyKey": idempotencyKey}
+}
+
+// replayRecorded replays the top-up recorded in the account by another request with the same key
+func (t *TopUp) replayRecorded(accountKey string) (*TopUpResult, error) {
+	entries, err := findLedgerEntries(idempotencyFilter(accountKey, t.IdempotencyKey))
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrIdempotencyConflict, t.IdempotencyKey)
+	}
+	return t.replay(entries[0])
+}
+
+// replay returns the result of the top-up recorded by entry, provided it is the same top-up
+func (t *TopUp) replay(entry *LedgerEntry) (*TopUpResult, error) {
+	if entry.UeId != t.Supi || entry.Amount != t.Amount ||
+		(t.BalanceId != nil && *t.BalanceId != entry.BalanceId) {
+		return nil, fmt.Errorf("%w: %s", ErrIdempotencyConflict, t.IdempotencyKey)
+	}
+
+	logger.AcctLog.Infof("UE[%s] top-up [%s] already applied", t.Supi, t.IdempotencyKey)
+	return &TopUpResult{
+		IdempotencyKey: t.IdempotencyKey,
+		Supi:           t.Supi,
+		AccountKey:     entry.AccountKey,
+		BalanceId:      entry.BalanceId,
+		Amount:         entry.Amount,
+		Currency:       t.Currency,
+		Balance:        entry.ValueAfter,
+		Timestamp:      entry.Timestamp,
+		VoucherCode:    t.VoucherCode,
+		Replayed:       true,
+	}, nil
+}
@@ -0,0 +1,53 @@
+package abmf
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTopUpValidate(t *testing.T) {
+	t.Parallel()
+
+	topUp := TopUp{Supi: testUeId, Amount: 500, Currency: "EUR", IdempotencyKey: "1"}
+	require.NoError(t, topUp.validate())
+
+	invalid := topUp
+	invalid.Amount = 0
+	require.ErrorIs(t, invalid.validate(), ErrInvalidTopUp)
+
+	invalid = topUp
+	invalid.Currency = "euro"
+	require.ErrorIs(t, invalid.validate(), ErrInvalidTopUp)
+
+	invalid = topUp
+	invalid.IdempotencyKey = ""
+	require.ErrorIs(t, invalid.validate(), ErrInvalidTopUp)
+}
+
+func TestWalletTopUpTarget(t *testing.T) {
+	t.Parallel()
+
+	w := &wallet{ueId: testUeId, personal: newTestAccount()}
+
+	// the first monetary balance drawn from, the data bucket of rating group 1 can not be topped up
+	_, b := w.target(&TopUp{RatingGroup: 1})
+	require.Equal(t, uint64(2), b.BalanceId)
+
+	balanceId := uint64(1)
+	account, b := w.target(&TopUp{BalanceId: &balanceId})
+	require.Equal(t, testUeId, account.key())
+	require.Equal(t, uint64(1), b.BalanceId)
+
+	balanceId = 3
+	_, b = w.target(&TopUp{BalanceId: &balanceId})
+	require.Nil(t, b)
+
+	// entries of the same top-up replay its result, another top-up with the key conflicts
+	topUp := &TopUp{Supi: testUeId, Amount: 500, Currency: "EUR", IdempotencyKey: "1"}
+	result, err := topUp.replay(&LedgerEntry{UeId: testUeId, AccountKey: testUeId, BalanceId: 1, Amount: 500})
+	require.NoError(t, err)
+	require.True(t, result.Replayed)
+	_, err = topUp.replay(&LedgerEntry{UeId: testUeId, BalanceId: 1, Amount: 300})
+	require.ErrorIs(t, err, ErrIdempotencyConflict)
+}
@@ -0,0 +1,359 @@
+package abmf
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+
+	"github.com/free5gc/chf/internal/logger"
+)
+
+const (
+	vouchersColl = "abmf.vouchers"
+
+	voucherCodeDigits = 16
+	maxVoucherBatch   = 10000
+	// attempts to draw codes not issued yet before giving up on a batch
+	maxVoucherCodeAttempts = 5
+)
+
+var (
+	ErrInvalidVoucherBatch = errors.New("invalid voucher batch")
+	ErrVoucherNotFound     = errors.New("voucher not found")
+	ErrVoucherUnavailable  = errors.New("voucher can not be redeemed")
+	ErrVoucherUsed         = errors.New("voucher already redeemed")
+	ErrInvalidVoucherState = errors.New("invalid voucher state change")
+)
+
+type VoucherState string
+
+const (
+	VoucherStateAvailable VoucherState = "AVAILABLE"
+	VoucherStateUsed      VoucherState = "USED"
+	VoucherStateExpired   VoucherState = "EXPIRED"
+	VoucherStateBlocked   VoucherState = "BLOCKED"
+)
+
+// Voucher is a prepaid code a subscriber redeems through the top-up API for its face value
+type Voucher struct {
+	Code    string `bson:"code" json:"code"`
+	BatchId string `bson:"batchId" json:"batchId"`
+	// FaceValue in currency units
+	FaceValue  int64        `bson:"faceValue" json:"faceValue"`
+	Currency   string       `bson:"currency" json:"currency"`
+	State      VoucherState `bson:"state" json:"state"`
+	CreatedAt  time.Time    `bson:"createdAt" json:"createdAt"`
+	ExpiresAt  time.Time    `bson:"expiresAt" json:"expiresAt"`
+	RedeemedBy string       `bson:"redeemedBy,omitempty" json:"redeemedBy,omitempty"`
+	RedeemedAt time.Time    `bson:"redeemedAt,omitempty" json:"redeemedAt,omitempty"`
+}
+
+// VoucherBatch describes vouchers to generate, all with the same face value and expiry
+type VoucherBatch struct {
+	Count     int       `json:"count"`
+	FaceValue int64     `json:"faceValue"`
+	Currency  string    `json:"currency"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+func (vb *VoucherBatch) validate(now time.Time) error {
+	switch {
+	case vb.Count <= 0 || vb.Count > maxVoucherBatch:
+		return fmt.Errorf("%w: count %d is not between 1 and %d", ErrInvalidVoucherBatch, vb.Count, maxVoucherBatch)
+	case vb.FaceValue <= 0:
+		return fmt.Errorf("%w: face value %d is not positive", ErrInvalidVoucherBatch, vb.FaceValue)
+	case !currencyCode.MatchString(vb.Currency):
+		return fmt.Errorf("%w: currency [%s] is not an ISO 4217 code", ErrInvalidVoucherBatch, vb.Currency)
+	case !vb.ExpiresAt.After(now):
+		return fmt.Errorf("%w: already expired at %s", ErrInvalidVoucherBatch, vb.ExpiresAt.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// isExpired reports whether the voucher can no longer be redeemed because of its expiry
+func (v *Voucher) isExpired(now time.Time) bool {
+	return v.State == VoucherStateExpired || (v.State == VoucherStateAvailable && !now.Before(v.ExpiresAt))
+}
+
+// idempotencyKey is the key of the top-up redeeming the voucher, so the ledger refuses to credit it twice
+func (v *Voucher) idempotencyKey() string {
+	return "voucher-" + v.Code
+}
+
+func newVoucherCode() (string, error) {
+	limit := new(big.Int).Exp(big.NewInt(10), big.NewInt(voucherCodeDigits), nil)
+	n, err := rand.Int(rand.Reader, limit)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%0*d", voucherCodeDigits, n), nil
+}
+
+// GenerateVouchers creates the vouchers of a new batch, available until the expiry of the batch
+func GenerateVouchers(vb *VoucherBatch) ([]*Voucher, error) {
+	now := time.Now()
+	if err := vb.validate(now); err != nil {
+		return nil, err
+	}
+
+	batchId := uuid.New().String()
+	vouchers := make([]*Voucher, 0, vb.Count)
+	for len(vouchers) < vb.Count {
+		vouchers = append(vouchers, &Voucher{
+			BatchId:   batchId,
+			FaceValue: vb.FaceValue,
+			Currency:  vb.Currency,
+			State:     VoucherStateAvailable,
+			CreatedAt: now,
+			ExpiresAt: vb.ExpiresAt,
+		})
+	}
+
+	// a code must never be issued twice, even in different batches: the unique index
+	// refuses the codes already issued, they are drawn again
+	pending := vouchers
+	for attempt := 0; len(pending) > 0; attempt++ {
+		if attempt == maxVoucherCodeAttempts {
+			return nil, fmt.Errorf("no unused code for %d vouchers after %d attempts", len(pending), attempt)
+		}
+		for _, voucher := range pending {
+			code, err := newVoucherCode()
+			if err != nil {
+				return nil, err
+			}
+			voucher.Code = code
+		}
+		var err error
+		if pending, err = insertVouchers(pending); err != nil {
+			return nil, err
+		}
+	}
+	logger.AcctLog.Infof("Generated voucher batch [%s]: %d vouchers of %d %s",
+		batchId, vb.Count, vb.FaceValue, vb.Currency)
+	return vouchers, nil
+}
+
+// insertVouchers inserts the vouchers and returns the ones refused because their code is already issued
+func insertVouchers(vouchers []*Voucher) ([]*Voucher, error) {
+	docs := make([]interface{}, 0, len(vouchers))
+	for _, voucher := range vouchers {
+		docs = append(docs, voucher)
+	}
+	_, err := collection(vouchersColl).InsertMany(context.TODO(), docs, options.InsertMany().SetOrdered(false))
+	var bulkErr mongo.BulkWriteException
+	if !errors.As(err, &bulkErr) || bulkErr.WriteConcernError != nil {
+		return nil, err
+	}
+
+	duplicates := make([]*Voucher, 0, len(bulkErr.WriteErrors))
+	for _, writeErr := range bulkErr.WriteErrors {
+		if !mongo.IsDuplicateKeyError(writeErr) {
+			return nil, err
+		}
+		duplicates = append(duplicates, vouchers[writeErr.Index])
+	}
+	return duplicates, nil
+}
+
+// createVoucherIndexes creates the unique index on the voucher code, so no code is issued twice
+func createVoucherIndexes() error {
+	_, err := collection(vouchersColl).Indexes().CreateOne(context.TODO(), mongo.IndexModel{
+		Keys:    bson.D{{Key: "code", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	return err
+}
+
+func findVouchers(filter bson.M) ([]*Voucher, error) {
+	vouchersInterface, err := getMany(vouchersColl, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	vouchers := make([]*Voucher, 0, len(vouchersInterface))
+	for _, voucherInterface := range vouchersInterface {
+		var voucher Voucher
+		if err = decodeBson(voucherInterface, &voucher); err != nil {
+			return nil, err
+		}
+		vouchers = append(vouchers, &voucher)
+	}
+	return vouchers, nil
+}
+
+// QueryVouchers returns the vouchers of the batch in the state, an empty batchId or state matches any
+func QueryVouchers(batchId string, state VoucherState) ([]*Voucher, error) {
+	filter := bson.M{}
+	if batchId != "" {
+		filter["batchId"] = batchId
+	}
+	if state != "" {
+		filter["state"] = state
+	}
+	return findVouchers(filter)
+}
+
+// GetVoucher returns the voucher with the code, or ErrVoucherNotFound
+func GetVoucher(code string) (*Voucher, error) {
+	vouchers, err := findVouchers(bson.M{"code": code})
+	if err != nil {
+		return nil, err
+	}
+	if len(vouchers) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrVoucherNotFound, code)
+	}
+	return vouchers[0], nil
+}
+
+// updateVoucher applies update to the voucher with the code provided it still matches filter. It returns
+// the updated voucher, or nil if the voucher no longer matches: the state of a voucher only changes this
+// way, so concurrent changes, even by different CHF instances, can not overwrite each other.
+func updateVoucher(code string, filter, update bson.M) (*Voucher, error) {
+	filter["code"] = code
+	result := collection(vouchersColl).FindOneAndUpdate(context.TODO(), filter, update,
+		options.FindOneAndUpdate().SetReturnDocument(options.After))
+
+	var voucher Voucher
+	if err := result.Decode(&voucher); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return &voucher, nil
+}
+
+// SetVoucherState blocks an available voucher or makes a blocked one available again
+func SetVoucherState(code string, state VoucherState) (*Voucher, error) {
+	voucher, err := GetVoucher(code)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	switch {
+	case voucher.State == state:
+		return voucher, nil
+	case voucher.isExpired(now):
+		return nil, fmt.Errorf("%w: voucher %s has expired", ErrInvalidVoucherState, code)
+	case state == VoucherStateBlocked && voucher.State == VoucherStateAvailable,
+		state == VoucherStateAvailable && voucher.State == VoucherStateBlocked:
+	default:
+		return nil, fmt.Errorf("%w: %s to %s", ErrInvalidVoucherState, voucher.State, state)
+	}
+
+	updated, err := updateVoucher(code, bson.M{"state": voucher.State, "expiresAt": bson.M{"$gt": now}},
+		bson.M{"$set": bson.M{"state": state}})
+	if err != nil {
+		return nil, err
+	}
+	if updated == nil {
+		return nil, fmt.Errorf("%w: voucher %s changed meanwhile", ErrInvalidVoucherState, code)
+	}
+	logger.AcctLog.Infof("Voucher [%s] is %s", code, state)
+	return updated, nil
+}
+
+// redeemVoucher claims the voucher for the subscriber, then tops it up with the face value of the voucher
+func redeemVoucher(t *TopUp) (*TopUpResult, error) {
+	voucher, err := GetVoucher(t.VoucherCode)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if voucher.State == VoucherStateAvailable {
+		// only the request whose claim matches the available voucher credits it
+		claimed, errClaim := updateVoucher(voucher.Code,
+			bson.M{"state": VoucherStateAvailable, "expiresAt": bson.M{"$gt": now}},
+			bson.M{"$set": bson.M{"state": VoucherStateUsed, "redeemedBy": t.Supi, "redeemedAt": now}})
+		if errClaim != nil {
+			return nil, errClaim
+		}
+		if claimed != nil {
+			result, errTopUp := topUpVoucher(t, claimed)
+			if errTopUp != nil {
+				releaseVoucher(claimed, t.Supi)
+				return nil, errTopUp
+			}
+			logger.AcctLog.Infof("Voucher [%s] redeemed by UE[%s]", claimed.Code, t.Supi)
+			return result, nil
+		}
+
+		// claimed by another request, or expired in the meantime
+		if voucher, err = GetVoucher(t.VoucherCode); err != nil {
+			return nil, err
+		}
+	}
+
+	switch {
+	case voucher.State == VoucherStateUsed && voucher.RedeemedBy == t.Supi:
+		// redeeming it again for the same subscriber replays the earlier top-up
+		return topUpVoucher(t, voucher)
+	case voucher.State == VoucherStateUsed:
+		return nil, fmt.Errorf("%w: %s", ErrVoucherUsed, voucher.Code)
+	case voucher.isExpired(now):
+		if voucher.State != VoucherStateExpired {
+			_, err = updateVoucher(voucher.Code, bson.M{"state": VoucherStateAvailable, "expiresAt": bson.M{"$lte": now}},
+				bson.M{"$set": bson.M{"state": VoucherStateExpired}})
+			if err != nil {
+				logger.AcctLog.Errorf("Expire voucher [%s] err: %+v", voucher.Code, err)
+			}
+		}
+		return nil, fmt.Errorf("%w: voucher %s has expired", ErrVoucherUnavailable, voucher.Code)
+	}
+	return nil, fmt.Errorf("%w: voucher %s is %s", ErrVoucherUnavailable, voucher.Code, voucher.State)
+}
+
+// topUpVoucher tops up the subscriber with the face value of the voucher it has claimed
+func topUpVoucher(t *TopUp, voucher *Voucher) (*TopUpResult, error) {
+	t.Amount = voucher.FaceValue
+	t.Currency = voucher.Currency
+	t.IdempotencyKey = voucher.idempotencyKey()
+	result, err := topUpAccount(t)
+	if errors.Is(err, ErrIdempotencyConflict) {
+		return nil, fmt.Errorf("%w: %s", ErrVoucherUsed, voucher.Code)
+	}
+	return result, err
+}
+
+// releaseVoucher makes the voucher claimed by the subscriber available again after its top-up failed,
+// unless the top-up has been credited nonetheless
+func releaseVoucher(voucher *Voucher, supi string) {
+	entries, err := findLedgerEntries(bson.M{
+		"operation":      LedgerOperationTopUp,
+		"idempotencyKey": voucher.idempotencyKey(),
+	})
+	if err == nil && len(entries) == 0 {
+		_, err = updateVoucher(voucher.Code, bson.M{"state": VoucherStateUsed, "redeemedBy": supi},
+			bson.M{
+				"$set":   bson.M{"state": VoucherStateAvailable},
+				"$unset": bson.M{"redeemedBy": "", "redeemedAt": ""},
+			})
+	}
+	if err != nil {
+		logger.AcctLog.Errorf("Release voucher [%s] err: %+v", voucher.Code, err)
+	}
+}
+
+// expireVouchers marks the available vouchers past their expiry as expired
+func expireVouchers(now time.Time) {
+	result, err := collection(vouchersColl).UpdateMany(context.TODO(),
+		bson.M{"state": VoucherStateAvailable, "expiresAt": bson.M{"$lte": now}},
+		bson.M{"$set": bson.M{"state": VoucherStateExpired}})
+	if err != nil {
+		logger.AcctLog.Errorf("Expire vouchers err: %+v", err)
+		return
+	}
+	if result.ModifiedCount > 0 {
+		logger.AcctLog.Infof("Expired %d vouchers", result.ModifiedCount)
+	}
+}
@@ -0,0 +1,42 @@
+package abmf
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestVoucherBatchValidate(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	batch := VoucherBatch{Count: 10, FaceValue: 1000, Currency: "EUR", ExpiresAt: now.Add(time.Hour)}
+	require.NoError(t, batch.validate(now))
+
+	invalid := batch
+	invalid.Count = maxVoucherBatch + 1
+	require.ErrorIs(t, invalid.validate(now), ErrInvalidVoucherBatch)
+
+	invalid = batch
+	invalid.ExpiresAt = now
+	require.ErrorIs(t, invalid.validate(now), ErrInvalidVoucherBatch)
+}
+
+func TestVoucherExpired(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	voucher := &Voucher{Code: "1", State: VoucherStateAvailable, ExpiresAt: now.Add(time.Minute)}
+	require.False(t, voucher.isExpired(now))
+	require.True(t, voucher.isExpired(now.Add(time.Minute)))
+
+	// a redeemed voucher keeps its state after the expiry
+	voucher.State = VoucherStateUsed
+	require.False(t, voucher.isExpired(now.Add(time.Hour)))
+	require.Equal(t, "voucher-1", voucher.idempotencyKey())
+
+	code, err := newVoucherCode()
+	require.NoError(t, err)
+	require.Regexp(t, `^[0-9]{16}$`, code)
+}
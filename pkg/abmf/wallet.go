@@ -0,0 +1,370 @@
+package abmf
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/fiorix/go-diameter/diam/datatype"
+
+	charging_datatype "github.com/free5gc/chf/ccs_diameter/datatype"
+	"github.com/free5gc/chf/internal/logger"
+)
+
+// errLedgerRecord is returned when the accounts are saved but not their ledger entries
+var errLedgerRecord = errors.New("record ledger failed")
+
+// accountLocks serializes the CCRs touching the same account, so concurrent
+// reservations of the members of a shared account do not overwrite each other
+var accountLocks sync.Map
+
+func lockAccount(key string) func() {
+	value, _ := accountLocks.LoadOrStore(key, new(sync.Mutex))
+	mu := value.(*sync.Mutex)
+	mu.Lock()
+	return mu.Unlock
+}
+
+// wallet is the set of accounts a UE draws from: its own account first,
+// then the shared account it is a member of
+type wallet struct {
+	ueId     string
+	personal *Account
+	shared   *Account
+
+	// values of the balances of each account since the last recorded change
+	snapshots map[string]map[uint64]balanceValue
+	// ledger entries recorded and not committed yet
+	entries []*LedgerEntry
+	unlocks []func()
+}
+
+// openWallet locks and loads the accounts of the UE. The UE's own account is always
+// locked before the shared one, so wallets of different members can not deadlock.
+// The wallet must be closed once it has been saved.
+func openWallet(ueId string, rg uint32) (*wallet, error) {
+	w := &wallet{ueId: ueId}
+	w.unlocks = append(w.unlocks, lockAccount(ueId))
+
+	var err error
+	if w.personal, err = loadAccount(ueId); err != nil {
+		w.close()
+		return nil, err
+	}
+
+	sharedAccountId, err := findSharedAccountId(ueId)
+	if err != nil {
+		w.close()
+		return nil, err
+	}
+	if sharedAccountId != "" {
+		w.unlocks = append(w.unlocks, lockAccount(sharedAccountId))
+		// reload under lock, the document found above may already be stale
+		if w.shared, err = loadSharedAccount(sharedAccountId); err != nil {
+			w.close()
+			return nil, err
+		}
+	}
+
+	if w.personal == nil && w.shared == nil {
+		if w.personal, err = loadLegacyAccount(ueId, rg); err != nil {
+			w.close()
+			return nil, err
+		}
+	}
+
+	w.snapshot()
+	return w, nil
+}
+
+func (w *wallet) snapshot() {
+	w.snapshots = make(map[string]map[uint64]balanceValue)
+	for _, account := range w.accounts() {
+		w.snapshots[account.key()] = account.values()
+	}
+}
+
+func (w *wallet) close() {
+	for i := len(w.unlocks) - 1; i >= 0; i-- {
+		w.unlocks[i]()
+	}
+	w.unlocks = nil
+}
+
+func (w *wallet) accounts() []*Account {
+	var accounts []*Account
+	if w.personal != nil {
+		accounts = append(accounts, w.personal)
+	}
+	if w.shared != nil {
+		accounts = append(accounts, w.shared)
+	}
+	return accounts
+}
+
+func (w *wallet) account(key string) *Account {
+	for _, account := range w.accounts() {
+		if account.key() == key {
+			return account
+		}
+	}
+	return nil
+}
+
+func (w *wallet) member() *Member {
+	if w.shared == nil {
+		return nil
+	}
+	return w.shared.member(w.ueId)
+}
+
+// debit takes up to amount (in currency units) from the UE's own account, then
+// from the shared account within the member's cap
+func (w *wallet) debit(rg uint32, amount int64, unitCost int64) []balanceDebit {
+	return w.take(rg, amount, unitCost, false)
+}
+
+// reserve holds up to amount (in currency units) of the UE's own account, then
+// of the shared account within the member's cap
+func (w *wallet) reserve(rg uint32, amount int64, unitCost int64) []balanceDebit {
+	return w.take(rg, amount, unitCost, true)
+}
+
+func (w *wallet) take(rg uint32, amount int64, unitCost int64, hold bool) []balanceDebit {
+	var debits []balanceDebit
+
+	if w.personal != nil {
+		debits = w.personal.take(rg, amount, unitCost, hold)
+		amount -= sumDebits(debits)
+	}
+
+	if member := w.member(); member != nil && amount > 0 {
+		sharedDebits := w.shared.take(rg, min(amount, member.available()), unitCost, hold)
+		if hold {
+			member.Reserved += sumDebits(sharedDebits)
+		} else {
+			member.Consumed += sumDebits(sharedDebits)
+		}
+		debits = append(debits, sharedDebits...)
+	}
+
+	return debits
+}
+
+// settle releases the holds of a reservation and debits used (in currency units) from the
+// held balances, usage beyond the holds is force debited. A hold on a balance which has
+// expired since is void, the usage it covered is charged to the other balances.
+func (w *wallet) settle(rg uint32, holds []balanceDebit, used int64, unitCost int64) {
+	for _, hold := range holds {
+		account := w.account(hold.AccountKey)
+		if account == nil {
+			continue
+		}
+		shared := account.isShared()
+		member := w.member()
+		if shared && member != nil {
+			member.Reserved = max(member.Reserved-hold.Amount, 0)
+		}
+
+		b := account.findBalance(hold.BalanceId)
+		if b == nil || !b.ValidUntil.Equal(hold.ValidUntil) {
+			logger.AcctLog.Infof("UE[%s] balance [%d] of account [%s] expired, hold of %d released",
+				w.ueId, hold.BalanceId, hold.AccountKey, hold.Amount)
+			continue
+		}
+
+		committed := max(min(used, hold.Amount), 0)
+		b.settle(hold.Amount, committed, unitCost)
+		if shared && member != nil {
+			member.Consumed += committed
+		}
+		used -= committed
+	}
+
+	if used > 0 {
+		w.forceDebit(rg, used, unitCost)
+	}
+}
+
+// postpaidAccount returns the postpaid account whose credit limit applies to the UE, its own account first
+func (w *wallet) postpaidAccount() *Account {
+	for _, account := range w.accounts() {
+		if account.isPostpaid() {
+			return account
+		}
+	}
+	return nil
+}
+
+// limitReached applies the credit limit policy when missing (in currency units) of a reservation
+// could not be held. It returns the holds of the overrun, or the final unit indication to send.
+func (w *wallet) limitReached(rg uint32, missing int64) ([]balanceDebit, *charging_datatype.FinalUnitIndication) {
+	account := w.postpaidAccount()
+	if account == nil {
+		return nil, &charging_datatype.FinalUnitIndication{
+			FinalUnitAction: charging_datatype.TERMINATE,
+		}
+	}
+
+	logger.AcctLog.Infof("UE[%s] reached the credit limit of account [%s], policy %s",
+		w.ueId, account.key(), account.CreditLimitPolicy)
+
+	switch account.CreditLimitPolicy {
+	case CreditLimitPolicyAllowOverrun:
+		debit := account.takeCredit(rg, missing, true, true)
+		if debit == nil {
+			break
+		}
+		if account.isShared() {
+			if member := w.member(); member != nil {
+				member.Reserved += debit.Amount
+			}
+		}
+		return []balanceDebit{*debit}, nil
+	case CreditLimitPolicyRedirect:
+		return nil, &charging_datatype.FinalUnitIndication{
+			FinalUnitAction: charging_datatype.REDIRECT,
+			RedirectServer: &charging_datatype.RedirectServer{
+				RedirectAddressType:   charging_datatype.URL,
+				RedirectServerAddress: datatype.UTF8String(account.RedirectAddress),
+			},
+		}
+	case CreditLimitPolicyRestrictAccess:
+		return nil, &charging_datatype.FinalUnitIndication{
+			FinalUnitAction: charging_datatype.RESTRICT_ACCESS,
+			FilterId:        datatype.UTF8String(account.RestrictionFilterId),
+		}
+	}
+
+	return nil, &charging_datatype.FinalUnitIndication{
+		FinalUnitAction: charging_datatype.TERMINATE,
+	}
+}
+
+// forceDebit debits amount, charging the part that can not be covered to the last
+// monetary balance even if it becomes negative
+func (w *wallet) forceDebit(rg uint32, amount int64, unitCost int64) []balanceDebit {
+	debits := w.debit(rg, amount, unitCost)
+	amount -= sumDebits(debits)
+	if amount <= 0 {
+		return debits
+	}
+
+	for _, account := range w.accounts() {
+		if b := account.lastMonetaryBalance(rg); b != nil {
+			b.Value -= amount
+			if account.isShared() {
+				w.member().Consumed += amount
+			}
+			return append(debits, balanceDebit{AccountKey: account.key(), BalanceId: b.BalanceId, Amount: amount})
+		}
+	}
+
+	logger.AcctLog.Warnf("UE[%s] has no monetary balance for rating group %d, %d not charged", w.ueId, rg, amount)
+	return debits
+}
+
+// credit returns amount (in currency units) to the first monetary balance of the rating group
+func (w *wallet) credit(rg uint32, amount int64) {
+	for _, account := range w.accounts() {
+		if b := account.firstMonetaryBalance(rg); b != nil {
+			b.Value += amount
+			return
+		}
+	}
+	logger.AcctLog.Warnf("UE[%s] has no monetary balance for rating group %d, %d not refunded", w.ueId, rg, amount)
+}
+
+// remaining returns the total value, in currency units, available to the UE for the rating group
+func (w *wallet) remaining(rg uint32, unitCost int64) int64 {
+	var total int64
+	if w.personal != nil {
+		total += w.personal.remaining(rg, unitCost)
+	}
+	if member := w.member(); member != nil {
+		total += min(w.shared.remaining(rg, unitCost), member.available())
+	}
+	return total
+}
+
+// isLowBalance reports whether the value available to the rating group is below the low balance
+// threshold, the threshold of the UE's own account takes precedence over the shared one
+func (w *wallet) isLowBalance(rg uint32, unitCost int64) bool {
+	for _, account := range w.accounts() {
+		if account.LowBalanceThreshold > 0 {
+			return w.remaining(rg, unitCost) < account.LowBalanceThreshold
+		}
+	}
+	return false
+}
+
+// abResponses reports every balance of the wallet, the balance ids of a shared
+// account are expected not to overlap with those of its members' own accounts
+func (w *wallet) abResponses() []*charging_datatype.ABResponse {
+	var responses []*charging_datatype.ABResponse
+	for _, account := range w.accounts() {
+		for _, b := range account.Balances {
+			responses = append(responses, &charging_datatype.ABResponse{
+				AcctBalance: &charging_datatype.AcctBalance{
+					AcctBalanceId: datatype.Unsigned64(b.BalanceId),
+					UnitValue:     unitValue(b.Value),
+				},
+			})
+		}
+	}
+	return responses
+}
+
+func (w *wallet) save() error {
+	for _, account := range w.accounts() {
+		if err := saveAccount(account); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// record turns the changes of the balances since the last record into ledger entries of ref
+func (w *wallet) record(ref ledgerRef) {
+	now := time.Now()
+	for _, account := range w.accounts() {
+		w.entries = append(w.entries, account.ledgerEntries(w.snapshots[account.key()], ref, now)...)
+	}
+	w.snapshot()
+}
+
+// commit saves the accounts, then inserts the recorded entries in the ledger. It fails with
+// errLedgerRecord once the accounts are saved.
+func (w *wallet) commit() error {
+	if err := w.save(); err != nil {
+		return err
+	}
+	entries := w.entries
+	w.entries = nil
+	if err := insertLedgerEntries(entries); err != nil {
+		return fmt.Errorf("%w of UE[%s]: %+v", errLedgerRecord, w.ueId, err)
+	}
+	return nil
+}
+
+func (w *wallet) logBalances(rg uint32) {
+	for _, account := range w.accounts() {
+		for _, b := range account.Balances {
+			logger.AcctLog.Infof("UE [%s], Rating group [%d], account [%s] balance [%d] %s [%d]",
+				w.ueId, rg, account.key(), b.BalanceId, b.Type, b.Value)
+		}
+	}
+	if member := w.member(); member != nil {
+		logger.AcctLog.Infof("UE [%s] consumed [%d] of shared account [%s], cap [%d]",
+			w.ueId, member.Consumed, w.shared.AccountId, member.Cap)
+	}
+}
+
+func sumDebits(debits []balanceDebit) int64 {
+	var total int64
+	for _, d := range debits {
+		total += d.Amount
+	}
+	return total
+}
@@ -8,6 +8,8 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
+	"regexp"
 	"strconv"
 	"sync"
 
@@ -26,9 +28,25 @@ const (
 	ChfSbiDefaultScheme              = "https"
 	ChfDefaultNrfUri                 = "https://127.0.0.10:8000"
 	CgfDefaultCdrFilePath            = "/tmp"
+	CgfDefaultNodeId                 = "CHF"
+	CgfDefaultWorkingDir             = "working"
+	CgfDefaultReadyDir               = "ready"
+	CgfDefaultArchiveDir             = "archive"
+	CgfDefaultRetryInterval          = 60
+	AbmfDefaultExpiryInterval        = 60
+	AbmfDefaultReservationValidity   = 3600
+	TracingDefaultEndpoint           = "localhost:4318"
+	NrfDefaultHeartbeatTimer         = 60
+	CdrFileDefaultMaxSize            = 1048576
+	CdrFileDefaultMaxOpenTime        = 3600
+	CdrFileDefaultMaxCdrs            = 1000
+	CdrFileDefaultEncoding           = "ber"
 	ConvergedChargingResUriPrefix    = "/nchf-convergedcharging/v3"
 	OfflineOnlyChargingResUriPrefix  = "/nchf-offlineonlycharging/v1"
 	SpendingLimitControlResUriPrefix = "/nchf-spendinglimitcontrol/v1"
+	AbmfResUriPrefix                 = "/abmf/v1"
+	ManagementResUriPrefix           = "/management/v1"
+	HealthResUriPrefix               = "/health"
 )
 
 type Config struct {
@@ -36,6 +54,9 @@ type Config struct {
 	Configuration *Configuration `yaml:"configuration" valid:"required"`
 	Logger        *Logger        `yaml:"logger" valid:"required"`
 	sync.RWMutex
+
+	// path of the file the configuration is read from
+	path string
 }
 
 func (c *Config) Validate() (bool, error) {
@@ -55,20 +76,28 @@ type Info struct {
 }
 
 type Configuration struct {
-	ChfName             string    `yaml:"chfName,omitempty" valid:"required, type(string)"`
-	Sbi                 *Sbi      `yaml:"sbi,omitempty" valid:"required"`
-	ServiceNameList     []string  `yaml:"serviceNameList,omitempty" valid:"required"`
-	NrfUri              string    `yaml:"nrfUri,omitempty" valid:"required, url"`
-	NrfCertPem          string    `yaml:"nrfCertPem,omitempty" valid:"optional"`
-	Mongodb             *Mongodb  `yaml:"mongodb" valid:"required"`
-	VolumeLimit         int32     `yaml:"volumeLimit,omitempty" valid:"optional"`
-	VolumeLimitPDU      int32     `yaml:"volumeLimitPDU,omitempty" valid:"optional"`
-	ReserveQuotaRatio   int32     `yaml:"reserveQuotaRatio,omitempty" valid:"optional"`
-	VolumeThresholdRate float32   `yaml:"volumeThresholdRate,omitempty" valid:"optional"`
-	QuotaValidityTime   int32     `yaml:"quotaValidityTime,omitempty" valid:"optional"`
-	RfDiameter          *Diameter `yaml:"rfDiameter,omitempty" valid:"required"`
-	AbmfDiameter        *Diameter `yaml:"abmfDiameter,omitempty" valid:"required"`
-	Cgf                 *Cgf      `yaml:"cgf,omitempty" valid:"required"`
+	ChfName                 string       `yaml:"chfName,omitempty" valid:"required, type(string)"`
+	Sbi                     *Sbi         `yaml:"sbi,omitempty" valid:"required"`
+	ServiceNameList         []string     `yaml:"serviceNameList,omitempty" valid:"required"`
+	NrfUri                  string       `yaml:"nrfUri,omitempty" valid:"required, url"`
+	NrfCertPem              string       `yaml:"nrfCertPem,omitempty" valid:"optional"`
+	Mongodb                 *Mongodb     `yaml:"mongodb" valid:"required"`
+	VolumeLimit             int32        `yaml:"volumeLimit,omitempty" valid:"optional"`
+	VolumeLimitPDU          int32        `yaml:"volumeLimitPDU,omitempty" valid:"optional"`
+	ReserveQuotaRatio       int32        `yaml:"reserveQuotaRatio,omitempty" valid:"optional"`
+	VolumeThresholdRate     float32      `yaml:"volumeThresholdRate,omitempty" valid:"optional"`
+	QuotaValidityTime       int32        `yaml:"quotaValidityTime,omitempty" valid:"optional"`
+	RfDiameter              *Diameter    `yaml:"rfDiameter,omitempty" valid:"required"`
+	AbmfDiameter            *Diameter    `yaml:"abmfDiameter,omitempty" valid:"required"`
+	AbmfExpiryInterval      int32        `yaml:"abmfExpiryInterval,omitempty" valid:"optional"`
+	AbmfReservationValidity int32        `yaml:"abmfReservationValidity,omitempty" valid:"optional"`
+	Cgf                     *Cgf         `yaml:"cgf,omitempty" valid:"required"`
+	NotificationWebhook     *Webhook     `yaml:"notificationWebhook,omitempty" valid:"optional"`
+	Tracing                 *Tracing     `yaml:"tracing,omitempty" valid:"optional"`
+	ChfInfo                 *ChfInfo     `yaml:"chfInfo,omitempty" valid:"optional"`
+	NfProfile               *NfProfile   `yaml:"nfProfile,omitempty" valid:"optional"`
+	CdrFile                 *CdrFile     `yaml:"cdrFile,omitempty" valid:"optional"`
+	OperatorApi             *OperatorApi `yaml:"operatorApi,omitempty" valid:"optional"`
 }
 
 type Logger struct {
@@ -84,6 +113,18 @@ func (c *Configuration) validate() (bool, error) {
 		}
 	}
 
+	if tracing := c.Tracing; tracing != nil {
+		if result, err := tracing.validate(); err != nil {
+			return result, err
+		}
+	}
+
+	if chfInfo := c.ChfInfo; chfInfo != nil {
+		if result, err := chfInfo.validate(); err != nil {
+			return result, err
+		}
+	}
+
 	for index, serviceName := range c.ServiceNameList {
 		switch {
 		case serviceName == "nchf-convergedcharging":
@@ -123,7 +164,37 @@ type Cgf struct {
 	} `yaml:"passiveTransferPortRange,omitempty" valid:"optional"`
 	Tls         *Tls   `yaml:"tls,omitempty" valid:"optional"`
 	CdrFilePath string `yaml:"cdrFilePath,omitempty" valid:"optional"`
+	// NodeId of the CHF in the CDR file names
+	NodeId string `yaml:"nodeId,omitempty" valid:"optional,alphanum"`
+	// Directories under CdrFilePath of the files being written, of the closed ones waiting for their transfer,
+	// and of those transferred or left at shutdown
+	WorkingDir string `yaml:"workingDir,omitempty" valid:"optional"`
+	ReadyDir   string `yaml:"readyDir,omitempty" valid:"optional"`
+	ArchiveDir string `yaml:"archiveDir,omitempty" valid:"optional"`
+	// RetryInterval in seconds between the transfers of the files left in the ready directory
+	RetryInterval int32 `yaml:"retryInterval,omitempty" valid:"optional"`
+}
+
+// CdrDirs are the directories of the CDR files
+type CdrDirs struct {
+	Working string
+	Ready   string
+	Archive string
+}
+
+// Webhook is the HTTP endpoint the subscriber notification events (e.g. low balance) are posted to
+type Webhook struct {
+	Uri string `yaml:"uri" valid:"required, url"`
+	// Timeout of a request in seconds
+	Timeout int32 `yaml:"timeout,omitempty" valid:"optional"`
+}
+
+// OperatorApi is the credential of the operator APIs, which are refused when it is not configured
+type OperatorApi struct {
+	// Bearer token of the Authorization header of the operator requests
+	Token string `yaml:"token" valid:"required"`
 }
+
 type Sbi struct {
 	Scheme       string `yaml:"scheme" valid:"required,scheme"`
 	RegisterIPv4 string `yaml:"registerIPv4,omitempty" valid:"required,host"` // IP that is registered at NRF.
@@ -157,6 +228,108 @@ func (t *Tls) validate() (bool, error) {
 	return result, err
 }
 
+// Tracing exports the spans of the CHF to an OpenTelemetry collector over OTLP/HTTP
+type Tracing struct {
+	Enable bool `yaml:"enable,omitempty" valid:"type(bool)"`
+	// Endpoint host:port of the collector, localhost:4318 if empty
+	Endpoint string `yaml:"endpoint,omitempty" valid:"optional"`
+	// Insecure exports over plain HTTP
+	Insecure bool `yaml:"insecure,omitempty" valid:"type(bool)"`
+	// SampleRatio of the traces started by the CHF, all of them if unset
+	SampleRatio float64 `yaml:"sampleRatio,omitempty" valid:"optional"`
+}
+
+func (t *Tracing) validate() (bool, error) {
+	if t.SampleRatio < 0 || t.SampleRatio > 1 {
+		return false, fmt.Errorf("Invalid tracing sampleRatio: %v, should be between 0 and 1", t.SampleRatio)
+	}
+
+	result, err := govalidator.ValidateStruct(t)
+	return result, appendInvalid(err)
+}
+
+// ChfInfo is published in the NRF profile, for the consumers to select the CHF serving their subscribers
+type ChfInfo struct {
+	SupiRanges []IdentityRange `yaml:"supiRanges,omitempty" valid:"optional"`
+	GpsiRanges []IdentityRange `yaml:"gpsiRanges,omitempty" valid:"optional"`
+	// PlmnRanges of MCC+MNC, e.g. 20893
+	PlmnRanges []IdentityRange `yaml:"plmnRanges,omitempty" valid:"optional"`
+	GroupId    string          `yaml:"groupId,omitempty" valid:"optional"`
+	// Role of the CHF in a primary/secondary pair, the other one being PeerInstance
+	Role         string `yaml:"role,omitempty" valid:"optional,in(primary|secondary)"`
+	PeerInstance string `yaml:"peerInstance,omitempty" valid:"optional,uuid"`
+}
+
+// NfProfile tunes the profile of the CHF maintained in the NRF
+type NfProfile struct {
+	// HeartbeatTimer in seconds proposed to the NRF, which may impose another one
+	HeartbeatTimer int32 `yaml:"heartbeatTimer,omitempty" valid:"optional,range(1|86400)"`
+	// Capacity of the CHF relative to the other CHFs
+	Capacity int32 `yaml:"capacity,omitempty" valid:"optional,range(0|65535)"`
+	// MaxSessions the CHF is sized for, the load reported is the share of them active
+	MaxSessions int32 `yaml:"maxSessions,omitempty" valid:"optional"`
+}
+
+// CdrFile sets when the CDR file being written is closed, the first limit reached closes it
+type CdrFile struct {
+	// MaxSize of the file in bytes
+	MaxSize uint32 `yaml:"maxSize,omitempty" valid:"optional"`
+	// MaxOpenTime in seconds since the file was opened
+	MaxOpenTime int32 `yaml:"maxOpenTime,omitempty" valid:"optional,range(1|86400)"`
+	// MaxCdrs in the file
+	MaxCdrs uint32 `yaml:"maxCdrs,omitempty" valid:"optional"`
+	// Encoding of the CDRs: ber, uper (unaligned PER), aper (aligned PER), xer or json
+	Encoding string `yaml:"encoding,omitempty" valid:"optional,in(ber|uper|aper|xer|json)"`
+}
+
+// IdentityRange is either the numeric range from Start to End, or the identities matching Pattern
+type IdentityRange struct {
+	Start   string `yaml:"start,omitempty" valid:"optional,numeric"`
+	End     string `yaml:"end,omitempty" valid:"optional,numeric"`
+	Pattern string `yaml:"pattern,omitempty" valid:"optional"`
+}
+
+func (i *ChfInfo) validate() (bool, error) {
+	ranges := map[string][]IdentityRange{
+		"supiRanges": i.SupiRanges,
+		"gpsiRanges": i.GpsiRanges,
+		"plmnRanges": i.PlmnRanges,
+	}
+	for name, identityRanges := range ranges {
+		for index, identityRange := range identityRanges {
+			if err := identityRange.validate(); err != nil {
+				return false, fmt.Errorf("Invalid chfInfo %s[%d]: %w", name, index, err)
+			}
+		}
+	}
+
+	if (i.Role == "") != (i.PeerInstance == "") {
+		return false, errors.New("Invalid chfInfo: role and peerInstance should be set together")
+	}
+
+	result, err := govalidator.ValidateStruct(i)
+	return result, appendInvalid(err)
+}
+
+func (r *IdentityRange) validate() error {
+	if r.Pattern != "" {
+		if r.Start != "" || r.End != "" {
+			return errors.New("pattern should not be set with start and end")
+		}
+		_, err := regexp.Compile(r.Pattern)
+		return err
+	}
+
+	if r.Start == "" || r.End == "" {
+		return errors.New("start and end, or pattern, should be set")
+	}
+	// identities of the same length are ordered as strings
+	if len(r.Start) != len(r.End) || r.Start > r.End {
+		return fmt.Errorf("start %s is not before end %s", r.Start, r.End)
+	}
+	return nil
+}
+
 type Mongodb struct {
 	Name string `yaml:"name" valid:"required, type(string)"`
 	Url  string `yaml:"url" valid:"required"`
@@ -188,6 +361,12 @@ func appendInvalid(err error) error {
 	return error(errs)
 }
 
+func (c *Config) GetConfigPath() string {
+	c.RLock()
+	defer c.RUnlock()
+	return c.path
+}
+
 func (c *Config) GetVersion() string {
 	c.RLock()
 	defer c.RUnlock()
@@ -324,3 +503,131 @@ func (c *Config) GetCertKeyPath() string {
 	defer c.RUnlock()
 	return c.Configuration.Sbi.Tls.Key
 }
+
+func (c *Config) GetVolumeLimit() int32 {
+	c.RLock()
+	defer c.RUnlock()
+	return c.Configuration.VolumeLimit
+}
+
+func (c *Config) GetVolumeLimitPDU() int32 {
+	c.RLock()
+	defer c.RUnlock()
+	return c.Configuration.VolumeLimitPDU
+}
+
+func (c *Config) GetVolumeThresholdRate() float32 {
+	c.RLock()
+	defer c.RUnlock()
+	return c.Configuration.VolumeThresholdRate
+}
+
+func (c *Config) GetQuotaValidityTime() int32 {
+	c.RLock()
+	defer c.RUnlock()
+	return c.Configuration.QuotaValidityTime
+}
+
+func (c *Config) GetAbmfReservationValidity() int32 {
+	c.RLock()
+	defer c.RUnlock()
+	return c.Configuration.AbmfReservationValidity
+}
+
+func (c *Config) GetNotificationWebhook() *Webhook {
+	c.RLock()
+	defer c.RUnlock()
+	return c.Configuration.NotificationWebhook
+}
+
+// GetOperatorApiToken returns the bearer token of the operator APIs, empty when none is configured
+func (c *Config) GetOperatorApiToken() string {
+	c.RLock()
+	defer c.RUnlock()
+	if c.Configuration.OperatorApi == nil {
+		return ""
+	}
+	return c.Configuration.OperatorApi.Token
+}
+
+func (c *Config) GetChfInfo() *ChfInfo {
+	c.RLock()
+	defer c.RUnlock()
+	return c.Configuration.ChfInfo
+}
+
+// GetCdrDirs returns the directories of the CDR files, the default ones for those not configured
+func (c *Config) GetCdrDirs() CdrDirs {
+	c.RLock()
+	defer c.RUnlock()
+	cdrFilePath := CgfDefaultCdrFilePath
+	dirs := CdrDirs{
+		Working: CgfDefaultWorkingDir,
+		Ready:   CgfDefaultReadyDir,
+		Archive: CgfDefaultArchiveDir,
+	}
+	if cgf := c.Configuration.Cgf; cgf != nil {
+		if cgf.CdrFilePath != "" {
+			cdrFilePath = cgf.CdrFilePath
+		}
+		if cgf.WorkingDir != "" {
+			dirs.Working = cgf.WorkingDir
+		}
+		if cgf.ReadyDir != "" {
+			dirs.Ready = cgf.ReadyDir
+		}
+		if cgf.ArchiveDir != "" {
+			dirs.Archive = cgf.ArchiveDir
+		}
+	}
+	dirs.Working = filepath.Join(cdrFilePath, dirs.Working)
+	dirs.Ready = filepath.Join(cdrFilePath, dirs.Ready)
+	dirs.Archive = filepath.Join(cdrFilePath, dirs.Archive)
+	return dirs
+}
+
+func (c *Config) GetCdrNodeId() string {
+	c.RLock()
+	defer c.RUnlock()
+	if c.Configuration.Cgf == nil || c.Configuration.Cgf.NodeId == "" {
+		return CgfDefaultNodeId
+	}
+	return c.Configuration.Cgf.NodeId
+}
+
+// GetCgfRetryInterval returns the interval in seconds between the transfers of the ready CDR files
+func (c *Config) GetCgfRetryInterval() int32 {
+	c.RLock()
+	defer c.RUnlock()
+	if c.Configuration.Cgf == nil || c.Configuration.Cgf.RetryInterval <= 0 {
+		return CgfDefaultRetryInterval
+	}
+	return c.Configuration.Cgf.RetryInterval
+}
+
+// GetCdrFile returns the closure limits and the encoding of the CDR files, the default ones for those not configured
+func (c *Config) GetCdrFile() CdrFile {
+	c.RLock()
+	defer c.RUnlock()
+	cdrFile := CdrFile{
+		MaxSize:     CdrFileDefaultMaxSize,
+		MaxOpenTime: CdrFileDefaultMaxOpenTime,
+		MaxCdrs:     CdrFileDefaultMaxCdrs,
+		Encoding:    CdrFileDefaultEncoding,
+	}
+	if cfg := c.Configuration.CdrFile; cfg != nil {
+		if cfg.MaxSize != 0 {
+			cdrFile.MaxSize = cfg.MaxSize
+		}
+		if cfg.MaxOpenTime != 0 {
+			cdrFile.MaxOpenTime = cfg.MaxOpenTime
+		}
+		if cfg.MaxCdrs != 0 {
+			cdrFile.MaxCdrs = cfg.MaxCdrs
+		}
+		if cfg.Encoding != "" {
+			cdrFile.Encoding = cfg.Encoding
+		}
+	}
+	return cdrFile
+}
@@ -16,7 +16,6 @@ import (
 
 var ChfConfig *Config
 
-// TODO: Support configuration update from REST api
 func InitConfigFactory(f string, cfg *Config) error {
 	if f == "" {
 		// Use default config path
@@ -31,6 +30,7 @@ func InitConfigFactory(f string, cfg *Config) error {
 			return fmt.Errorf("[Factory] %+v", yamlErr)
 		}
 	}
+	cfg.path = f
 
 	return nil
 }
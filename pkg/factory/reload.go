@@ -0,0 +1,63 @@
+package factory
+
+import (
+	"reflect"
+	"strings"
+)
+
+// reloadableSettings are the settings of the configuration read on use, applied without restarting the CHF
+var reloadableSettings = map[string]bool{
+	"volumeLimit":             true,
+	"volumeLimitPDU":          true,
+	"volumeThresholdRate":     true,
+	"quotaValidityTime":       true,
+	"abmfReservationValidity": true,
+	"notificationWebhook":     true,
+	"chfInfo":                 true,
+}
+
+// ReloadReport lists the settings changed by a configuration reload
+type ReloadReport struct {
+	// Applied settings are in use by the CHF
+	Applied []string `json:"applied"`
+	// RestartRequired settings are kept at their current value until the CHF restarts
+	RestartRequired []string `json:"restartRequired"`
+}
+
+// Reload swaps the reloadable settings of c, and the logger ones, with those of the validated cfg
+func (c *Config) Reload(cfg *Config) *ReloadReport {
+	c.Lock()
+	defer c.Unlock()
+
+	report := &ReloadReport{
+		Applied:         []string{},
+		RestartRequired: []string{},
+	}
+
+	configuration := *c.Configuration
+	current := reflect.ValueOf(c.Configuration).Elem()
+	reloaded := reflect.ValueOf(cfg.Configuration).Elem()
+	for i := 0; i < current.NumField(); i++ {
+		if reflect.DeepEqual(current.Field(i).Interface(), reloaded.Field(i).Interface()) {
+			continue
+		}
+		name := strings.Split(current.Type().Field(i).Tag.Get("yaml"), ",")[0]
+		if reloadableSettings[name] {
+			reflect.ValueOf(&configuration).Elem().Field(i).Set(reloaded.Field(i))
+			report.Applied = append(report.Applied, "configuration."+name)
+		} else {
+			report.RestartRequired = append(report.RestartRequired, "configuration."+name)
+		}
+	}
+	// readers get either the former settings or the reloaded ones
+	c.Configuration = &configuration
+
+	// a reloaded configuration without logger settings keeps the current ones
+	if cfg.Logger != nil && (c.Logger == nil || *c.Logger != *cfg.Logger) {
+		loggerCfg := *cfg.Logger
+		c.Logger = &loggerCfg
+		report.Applied = append(report.Applied, "logger")
+	}
+
+	return report
+}
@@ -0,0 +1,52 @@
+package factory
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReload(t *testing.T) {
+	t.Parallel()
+
+	newConfig := func() *Config {
+		return &Config{
+			Configuration: &Configuration{
+				Sbi:         &Sbi{Port: 8000},
+				VolumeLimit: 50000,
+			},
+			Logger: &Logger{Enable: true, Level: "info"},
+		}
+	}
+
+	cfg := newConfig()
+	reloaded := newConfig()
+	reloaded.Configuration.Sbi = &Sbi{Port: 8001}
+	reloaded.Configuration.VolumeLimit = 80000
+	reloaded.Configuration.QuotaValidityTime = 600
+	reloaded.Logger.Level = "debug"
+
+	report := cfg.Reload(reloaded)
+	require.ElementsMatch(t, []string{
+		"configuration.volumeLimit", "configuration.quotaValidityTime", "logger",
+	}, report.Applied)
+	require.Equal(t, []string{"configuration.sbi"}, report.RestartRequired)
+
+	require.Equal(t, int32(80000), cfg.GetVolumeLimit())
+	require.Equal(t, int32(600), cfg.GetQuotaValidityTime())
+	require.Equal(t, "debug", cfg.GetLogLevel())
+	require.Equal(t, 8000, cfg.GetSbiPort())
+
+	// nothing left to apply
+	report = cfg.Reload(reloaded)
+	require.Empty(t, report.Applied)
+	require.Equal(t, []string{"configuration.sbi"}, report.RestartRequired)
+
+	// the logger settings are kept when the reloaded configuration has none, the quota ratio is not read on use
+	reloaded.Logger = nil
+	reloaded.Configuration.ReserveQuotaRatio = 50
+	report = cfg.Reload(reloaded)
+	require.Empty(t, report.Applied)
+	require.ElementsMatch(t, []string{"configuration.sbi", "configuration.reserveQuotaRatio"}, report.RestartRequired)
+	require.Equal(t, "debug", cfg.GetLogLevel())
+}
@@ -18,7 +18,6 @@ package rf
 import (
 	"bytes"
 	"context"
-	"log"
 	"math"
 	_ "net/http/pprof"
 	"strconv"
@@ -31,16 +30,25 @@ import (
 	"github.com/fiorix/go-diameter/diam/dict"
 	"github.com/fiorix/go-diameter/diam/sm"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 
+	charging_code "github.com/free5gc/chf/ccs_diameter/code"
 	charging_datatype "github.com/free5gc/chf/ccs_diameter/datatype"
 	charging_dict "github.com/free5gc/chf/ccs_diameter/dict"
+	"github.com/free5gc/chf/internal/health"
 	"github.com/free5gc/chf/internal/logger"
+	"github.com/free5gc/chf/internal/tracing"
+	"github.com/free5gc/chf/internal/util"
 	"github.com/free5gc/chf/pkg/factory"
 	"github.com/free5gc/util/mongoapi"
 )
 
 const chargingDatasColl = "policyData.ues.chargingData"
 
+// getOne reads the tariffs, an in-memory store replaces it in the handler tests
+var getOne = mongoapi.RestfulAPIGetOne
+
 func OpenServer(ctx context.Context, wg *sync.WaitGroup) {
 	// Load our custom dictionary on top of the default one, which
 	// always have the Base Protocol (RFC6733) and Credit Control
@@ -49,6 +57,7 @@ func OpenServer(ctx context.Context, wg *sync.WaitGroup) {
 	// Connect to MongoDB
 	if err := mongoapi.SetMongoDB(mongodb.Name, mongodb.Url); err != nil {
 		logger.InitLog.Errorf("InitpcfContext err: %+v", err)
+		health.Down(health.RatingFunction, err)
 		return
 	}
 
@@ -81,10 +90,11 @@ func OpenServer(ctx context.Context, wg *sync.WaitGroup) {
 	rfDiameter := factory.ChfConfig.Configuration.RfDiameter
 	addr := rfDiameter.HostIPv4 + ":" + strconv.Itoa(rfDiameter.Port)
 	go func() {
-		errListen := diam.ListenAndServeTLS(addr, rfDiameter.Tls.Pem, rfDiameter.Tls.Key, mux, nil)
-		if err != nil {
-			log.Fatal(errListen)
-		}
+		errListen := util.ListenAndServeDiameterTLS(addr, rfDiameter.Tls.Pem, rfDiameter.Tls.Key, mux, func() {
+			health.Up(health.RatingFunction)
+		})
+		health.Down(health.RatingFunction, errListen)
+		logger.RatingLog.Errorf("Rating Function server fail to listen: %+v", errListen)
 	}()
 }
 
@@ -142,41 +152,69 @@ func handleSUR() diam.HandlerFunc {
 		var monetaryCost datatype.Unsigned32
 		var subscriberId string
 
+		// the CHF does not send the trace context over Diameter, the session id ties the spans to its request
+		ctx, span := tracing.StartKind(context.Background(), "Rating SUR", trace.SpanKindServer)
+		defer span.End()
+
 		if err := m.Unmarshal(&sur); err != nil {
 			logger.RatingLog.Errorf("Failed to parse message from %s: %s\n%s",
 				c.RemoteAddr(), err, m)
+			writeSUA(c, m, newSUA(&sur, diam.UnableToComply))
 			return
 		}
 
 		sr := sur.ServiceRating
+		if sr == nil || sur.SubscriptionId == nil {
+			logger.RatingLog.Errorf("SUR from %s without subscriber or service rating", c.RemoteAddr())
+			writeSUA(c, m, newSUA(&sur, diam.MissingAVP))
+			return
+		}
 		rg := uint32(sr.ServiceIdentifier)
 
 		switch sur.SubscriptionId.SubscriptionIdType {
 		case charging_datatype.END_USER_IMSI:
 			subscriberId = "imsi-" + string(sur.SubscriptionId.SubscriptionIdData)
 		}
-		// // Retrieve tarrif information from database
+		span.SetAttributes(
+			attribute.String("chf.supi", subscriberId),
+			attribute.String("diameter.session_id", string(sur.SessionId)),
+			attribute.Int64("chf.rating_group", int64(rg)))
 
-		filter := bson.M{"ueId": subscriberId, "ratingGroup": rg}
-		chargingInterface, err := mongoapi.RestfulAPIGetOne(chargingDatasColl, filter)
+		// Retrieve tarrif information from database
+		var chargingInterface map[string]interface{}
+		err := tracing.Trace(ctx, "MongoDB get tariff", func() (errGet error) {
+			filter := bson.M{"ueId": subscriberId, "ratingGroup": rg}
+			chargingInterface, errGet = getOne(chargingDatasColl, filter)
+			return errGet
+		})
 		if err != nil {
 			logger.ChargingdataPostLog.Errorf("Get tarrif error: %+v", err)
+			writeSUA(c, m, newSUA(&sur, diam.UnableToComply))
+			return
 		}
 		if chargingInterface == nil {
 			logger.ChargingdataPostLog.Warningf(
 				"No ChargingData found for UE:[%+v] for RG:[%+v]", subscriberId, rg)
+			writeSUA(c, m, newSUA(&sur, unknownRatingResult(subscriberId)))
+			return
+		}
+		unitCostStr, ok := chargingInterface["unitCost"].(string)
+		if !ok {
+			logger.RatingLog.Errorf("Tarrif of UE[%s] for RG[%d] has no unit cost", subscriberId, rg)
+			writeSUA(c, m, newSUA(&sur, charging_code.RatingFailed))
 			return
 		}
-		unitCostStr := chargingInterface["unitCost"].(string)
 		monetaryTariff := buildTaffif(unitCostStr)
 		unitCost := datatype.Unsigned32(monetaryTariff.RateElement.UnitCost.ValueDigits) *
 			datatype.Unsigned32(math.Pow10(int(monetaryTariff.RateElement.UnitCost.Exponent)))
-		sua := charging_datatype.ServiceUsageResponse{
-			SessionId:      sur.SessionId,
-			EventTimestamp: datatype.Time(time.Now()),
-			ServiceRating: &charging_datatype.ServiceRating{
-				MonetaryTariff: monetaryTariff,
-			},
+		if unitCost == 0 {
+			logger.RatingLog.Errorf("Invalid unit cost [%s] of UE[%s] for RG[%d]", unitCostStr, subscriberId, rg)
+			writeSUA(c, m, newSUA(&sur, charging_code.RatingFailed))
+			return
+		}
+		sua := newSUA(&sur, diam.Success)
+		sua.ServiceRating = &charging_datatype.ServiceRating{
+			MonetaryTariff: monetaryTariff,
 		}
 
 		switch sr.RequestSubType {
@@ -195,18 +233,44 @@ func handleSUR() diam.HandlerFunc {
 			sua.ServiceRating.Price = datatype.Unsigned32(0)
 		}
 
-		a := m.Answer(diam.Success)
-		err = a.Marshal(&sua)
-		if err != nil {
-			logger.RatingLog.Errorf("Marshal SUA Err: %+v:", err)
-		}
+		writeSUA(c, m, sua)
+	}
+}
 
-		_, err = a.WriteTo(c)
-		if err != nil {
-			logger.RatingLog.Errorf("Failed to write message to %s: %s\n%s\n",
-				c.RemoteAddr(), err, a)
-			return
-		}
+// unknownRatingResult tells a subscriber without any charging data from a rating group without a tariff
+func unknownRatingResult(subscriberId string) uint32 {
+	chargingInterface, err := getOne(chargingDatasColl, bson.M{"ueId": subscriberId})
+	if err != nil {
+		return diam.UnableToComply
+	}
+	if chargingInterface == nil {
+		return charging_code.UserUnknown
+	}
+	return charging_code.RatingFailed
+}
+
+// newSUA builds the answer to sur with the mandatory AVPs only
+func newSUA(
+	sur *charging_datatype.ServiceUsageRequest, resultCode uint32,
+) *charging_datatype.ServiceUsageResponse {
+	return &charging_datatype.ServiceUsageResponse{
+		SessionId:      sur.SessionId,
+		ResultCode:     datatype.Unsigned32(resultCode),
+		OriginHost:     sur.DestinationHost,
+		OriginRealm:    sur.DestinationRealm,
+		EventTimestamp: datatype.Time(time.Now()),
+	}
+}
+
+func writeSUA(c diam.Conn, m *diam.Message, sua *charging_datatype.ServiceUsageResponse) {
+	a := m.Answer(uint32(sua.ResultCode))
+	if err := a.Marshal(sua); err != nil {
+		logger.RatingLog.Errorf("Marshal SUA Err: %+v:", err)
+	}
+
+	if _, err := a.WriteTo(c); err != nil {
+		logger.RatingLog.Errorf("Failed to write message to %s: %s\n%s\n",
+			c.RemoteAddr(), err, a)
 	}
 }
 
@@ -0,0 +1,143 @@
+package rf
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/fiorix/go-diameter/diam"
+	"github.com/fiorix/go-diameter/diam/datatype"
+	"github.com/fiorix/go-diameter/diam/dict"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+
+	charging_code "github.com/free5gc/chf/ccs_diameter/code"
+	charging_datatype "github.com/free5gc/chf/ccs_diameter/datatype"
+	charging_dict "github.com/free5gc/chf/ccs_diameter/dict"
+)
+
+var loadDictionary sync.Once
+
+// unknownApplication has the Service-Usage command without the AVPs of the rating
+const unknownApplication = 999
+
+const unknownApplicationDictionary = `<diameter>
+	<application id="999" type="auth" name="Unknown">
+		<command code="111" short="SU" name="Service-Usage">
+			<request><rule avp="Session-Id" required="true" max="1"/></request>
+			<answer><rule avp="Result-Code" required="true" max="1"/></answer>
+		</command>
+	</application>
+</diameter>`
+
+// useTariffs replaces the tariffs of MongoDB with the documents until the end of the test,
+// the tests using it can not run in parallel
+func useTariffs(t *testing.T, tariffs ...bson.M) {
+	origGetOne := getOne
+	t.Cleanup(func() { getOne = origGetOne })
+
+	getOne = func(_ string, filter bson.M, _ ...interface{}) (map[string]interface{}, error) {
+		for _, tariff := range tariffs {
+			match := true
+			for key, value := range filter {
+				match = match && fmt.Sprint(tariff[key]) == fmt.Sprint(value)
+			}
+			if match {
+				return tariff, nil
+			}
+		}
+		return nil, nil
+	}
+}
+
+// answerConn keeps the answers written by the handler
+type answerConn struct {
+	bytes.Buffer
+}
+
+func (c *answerConn) Close()                         {}
+func (c *answerConn) LocalAddr() net.Addr            { return &net.TCPAddr{} }
+func (c *answerConn) RemoteAddr() net.Addr           { return &net.TCPAddr{} }
+func (c *answerConn) TLS() *tls.ConnectionState      { return nil }
+func (c *answerConn) Dictionary() *dict.Parser       { return dict.Default }
+func (c *answerConn) Context() context.Context       { return context.Background() }
+func (c *answerConn) SetContext(ctx context.Context) {}
+func (c *answerConn) Connection() net.Conn           { return nil }
+
+// serveSUR hands m to the SUR handler and returns its answer
+func serveSUR(t *testing.T, m *diam.Message) *charging_datatype.ServiceUsageResponse {
+	var c answerConn
+	handleSUR()(&c, m)
+
+	a, err := diam.ReadMessage(&c, dict.Default)
+	require.NoError(t, err)
+	var sua charging_datatype.ServiceUsageResponse
+	if m.Header.ApplicationID == unknownApplication {
+		resultCode, errFind := a.FindAVP("Result-Code", 0)
+		require.NoError(t, errFind)
+		sua.ResultCode = resultCode.Data.(datatype.Unsigned32)
+		return &sua
+	}
+	require.NoError(t, a.Unmarshal(&sua))
+	return &sua
+}
+
+func newTestSUR(t *testing.T, sur *charging_datatype.ServiceUsageRequest) *diam.Message {
+	loadDictionary.Do(func() {
+		require.NoError(t, dict.Default.Load(bytes.NewReader([]byte(charging_dict.RateDictionary))))
+		require.NoError(t, dict.Default.Load(bytes.NewReader([]byte(unknownApplicationDictionary))))
+	})
+	m := diam.NewRequest(charging_code.ServiceUsageMessage, charging_code.Re_interface, dict.Default)
+	require.NoError(t, m.Marshal(sur))
+	return m
+}
+
+func debitSUR(imsi string, rg uint32, consumed uint32) *charging_datatype.ServiceUsageRequest {
+	return &charging_datatype.ServiceUsageRequest{
+		SessionId: datatype.UTF8String("session-" + imsi),
+		SubscriptionId: &charging_datatype.SubscriptionId{
+			SubscriptionIdType: charging_datatype.END_USER_IMSI,
+			SubscriptionIdData: datatype.UTF8String(imsi),
+		},
+		ServiceRating: &charging_datatype.ServiceRating{
+			ServiceIdentifier: datatype.Unsigned32(rg),
+			RequestSubType:    charging_datatype.REQ_SUBTYPE_DEBIT,
+			ConsumedUnits:     datatype.Unsigned32(consumed),
+		},
+	}
+}
+
+func TestHandleSUR(t *testing.T) {
+	useTariffs(t,
+		bson.M{"ueId": "imsi-208930000000001", "ratingGroup": 1, "unitCost": "3"},
+		bson.M{"ueId": "imsi-208930000000001", "ratingGroup": 2, "unitCost": "0"},
+	)
+
+	sua := serveSUR(t, newTestSUR(t, debitSUR("208930000000001", 1, 10)))
+	require.Equal(t, datatype.Unsigned32(diam.Success), sua.ResultCode)
+	require.NotNil(t, sua.ServiceRating)
+	require.Equal(t, datatype.Unsigned32(30), sua.ServiceRating.Price)
+
+	// a zero unit cost, and a rating group without a tariff of a known UE
+	sua = serveSUR(t, newTestSUR(t, debitSUR("208930000000001", 2, 10)))
+	require.Equal(t, datatype.Unsigned32(charging_code.RatingFailed), sua.ResultCode)
+	sua = serveSUR(t, newTestSUR(t, debitSUR("208930000000001", 3, 10)))
+	require.Equal(t, datatype.Unsigned32(charging_code.RatingFailed), sua.ResultCode)
+
+	sua = serveSUR(t, newTestSUR(t, debitSUR("208930000000002", 1, 10)))
+	require.Equal(t, datatype.Unsigned32(charging_code.UserUnknown), sua.ResultCode)
+
+	sur := debitSUR("208930000000001", 1, 10)
+	sur.ServiceRating = nil
+	sua = serveSUR(t, newTestSUR(t, sur))
+	require.Equal(t, datatype.Unsigned32(diam.MissingAVP), sua.ResultCode)
+
+	m := newTestSUR(t, debitSUR("208930000000001", 1, 10))
+	m.Header.ApplicationID = unknownApplication
+	sua = serveSUR(t, m)
+	require.Equal(t, datatype.Unsigned32(diam.UnableToComply), sua.ResultCode)
+}
@@ -2,27 +2,36 @@ package service
 
 import (
 	"context"
+	"errors"
 	"io"
 	"os"
 	"runtime/debug"
+	"slices"
 	"sync"
+	"time"
 
 	"github.com/sirupsen/logrus"
 
 	"github.com/free5gc/chf/internal/cgf"
 	chf_context "github.com/free5gc/chf/internal/context"
+	"github.com/free5gc/chf/internal/health"
 	"github.com/free5gc/chf/internal/logger"
+	"github.com/free5gc/chf/internal/metrics"
 	"github.com/free5gc/chf/internal/sbi"
 	"github.com/free5gc/chf/internal/sbi/consumer"
 	"github.com/free5gc/chf/internal/sbi/processor"
+	"github.com/free5gc/chf/internal/tracing"
 	"github.com/free5gc/chf/pkg/abmf"
 	"github.com/free5gc/chf/pkg/app"
 	"github.com/free5gc/chf/pkg/factory"
 	"github.com/free5gc/chf/pkg/rf"
+	"github.com/free5gc/util/mongoapi"
 )
 
 var CHF *ChfApp
 
+const healthProbeInterval = 5 * time.Second
+
 var _ app.App = &ChfApp{}
 
 type ChfApp struct {
@@ -32,10 +41,15 @@ type ChfApp struct {
 	ctx    context.Context
 	cancel context.CancelFunc
 	wg     sync.WaitGroup
+	// stops the CGF, once the last CDR file is closed
+	cancelCgf context.CancelFunc
 
 	sbiServer *sbi.Server
 	consumer  *consumer.Consumer
 	processor *processor.Processor
+
+	// flushes the spans not exported yet
+	shutdownTracing func(context.Context) error
 }
 
 func NewApp(ctx context.Context, cfg *factory.Config, tlsKeyLogPath string) (*ChfApp, error) {
@@ -47,22 +61,27 @@ func NewApp(ctx context.Context, cfg *factory.Config, tlsKeyLogPath string) (*Ch
 	chf.SetLogLevel(cfg.GetLogLevel())
 	chf.SetReportCaller(cfg.GetLogReportCaller())
 	chf_context.Init()
+	metrics.RegisterActiveSessions(chf_context.GetSelf().ActiveSessions)
 
-	processor, err_p := processor.NewProcessor(chf)
-	if err_p != nil {
-		return chf, err_p
+	processor, err := processor.NewProcessor(chf)
+	if err != nil {
+		return nil, err
 	}
 	chf.processor = processor
 
 	consumer, err := consumer.NewConsumer(chf)
 	if err != nil {
-		return chf, err
+		return nil, err
 	}
 	chf.consumer = consumer
 
 	chf.ctx, chf.cancel = context.WithCancel(ctx)
 	chf.chfCtx = chf_context.GetSelf()
 
+	if chf.shutdownTracing, err = tracing.Init(chf.ctx, cfg.Configuration.Tracing, chf.chfCtx.NfId); err != nil {
+		return nil, err
+	}
+
 	if chf.sbiServer, err = sbi.NewServer(chf, tlsKeyLogPath); err != nil {
 		return nil, err
 	}
@@ -132,13 +151,46 @@ func (c *ChfApp) SetReportCaller(reportCaller bool) {
 	logger.Log.SetReportCaller(reportCaller)
 }
 
+// ReloadConfig reads the configuration file again and applies the settings which do not need a restart
+func (a *ChfApp) ReloadConfig() (*factory.ReloadReport, error) {
+	cfg, err := factory.ReadConfig(a.cfg.GetConfigPath())
+	if err != nil {
+		logger.CfgLog.Errorf("Reload configuration failed: %+v", err)
+		return nil, err
+	}
+
+	report := a.cfg.Reload(cfg)
+	a.SetLogEnable(a.cfg.GetLogEnable())
+	a.SetLogLevel(a.cfg.GetLogLevel())
+	a.SetReportCaller(a.cfg.GetLogReportCaller())
+
+	logger.CfgLog.Infof("Configuration reloaded, applied: %v", report.Applied)
+	if slices.Contains(report.Applied, "configuration.chfInfo") {
+		a.chfCtx.SetChfInfo(cfg.GetChfInfo())
+		if problemDetails, errUpdate := a.consumer.SendUpdateChfInfo(); problemDetails != nil {
+			logger.CfgLog.Errorf("Update ChfInfo in NRF Failed Problem[%+v]", problemDetails)
+		} else if errUpdate != nil {
+			logger.CfgLog.Errorf("Update ChfInfo in NRF Error[%+v]", errUpdate)
+		}
+	}
+	if len(report.RestartRequired) > 0 {
+		logger.CfgLog.Warnf("Restart the CHF to apply: %v", report.RestartRequired)
+	}
+	return report, nil
+}
+
 func (a *ChfApp) Start() {
 	logger.InitLog.Infoln("Server started")
 
+	a.registerHealthChecks()
+	go health.Run(a.ctx, healthProbeInterval)
+
 	if a.cfg.Configuration.Cgf.Enable {
 		cgf.CGFEnable = true
+		var cgfCtx context.Context
+		cgfCtx, a.cancelCgf = context.WithCancel(context.Background())
 		a.wg.Add(1)
-		cgf.OpenServer(a.ctx, &a.wg)
+		cgf.OpenServer(cgfCtx, &a.wg)
 	}
 
 	a.wg.Add(1)
@@ -157,6 +209,24 @@ func (a *ChfApp) Start() {
 	a.WaitRoutineStopped()
 }
 
+// registerHealthChecks lists the dependencies the CHF waits for before serving the Nchf services
+func (a *ChfApp) registerHealthChecks() {
+	health.Register(health.Nrf, true, nil)
+	health.Register(health.MongoDB, true, func(ctx context.Context) error {
+		if mongoapi.Client == nil {
+			return errors.New("not connected")
+		}
+		return mongoapi.Client.Ping(ctx, nil)
+	})
+	health.Register(health.RatingFunction, true, nil)
+	health.Register(health.Abmf, true, nil)
+	health.Register(health.RatingPeer, false, nil)
+	health.Register(health.AbmfPeer, false, nil)
+	if a.cfg.Configuration.Cgf.Enable {
+		health.Register(health.Cgf, false, nil)
+	}
+}
+
 func (a *ChfApp) listenShutdownEvent() {
 	defer func() {
 		if p := recover(); p != nil {
@@ -177,6 +247,11 @@ func (c *ChfApp) Terminate() {
 func (c *ChfApp) terminateProcedure() {
 	logger.MainLog.Infof("Terminating CHF...")
 	c.CallServerStop()
+	// no CDR is written once the SBI server is stopped, the last file is transferred before the CGF stops
+	c.processor.CloseCdrFile()
+	if c.cancelCgf != nil {
+		c.cancelCgf()
+	}
 
 	// deregister with NRF
 	problemDetails, err := c.Consumer().SendDeregisterNFInstance()
@@ -187,6 +262,12 @@ func (c *ChfApp) terminateProcedure() {
 	} else {
 		logger.MainLog.Infof("Deregister from NRF successfully")
 	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err = c.shutdownTracing(ctx); err != nil {
+		logger.MainLog.Errorf("Flush traces Error[%+v]", err)
+	}
 	logger.MainLog.Infof("CHF SBI Server terminated")
 }
 